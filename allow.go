@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// importUse is a package imported by an evaluated source.
+type importUse struct {
+	path         string
+	line, column int    // 0 when not imported by the source itself
+	via          string // how it is imported then
+}
+
+// sourceImports returns the imports declared by src, a source accepted by
+// parseFragment, or nil if it does not parse.
+func sourceImports(src string) []importUse {
+	f, err := parseFragment(src)
+	if err != nil {
+		return nil
+	}
+	var list []importUse
+	for _, spec := range f.file.Imports {
+		path, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		}
+		// Imports come before the statements, so only the head shifts them.
+		o := f.offset(spec.Pos())
+		list = append(list, importUse{
+			path:   path,
+			line:   strings.Count(src[:o], "\n") + 1,
+			column: o - strings.LastIndex(src[:o], "\n"),
+		})
+	}
+	return list
+}
+
+// checkImports returns the failed result of an eval of src whose imports
+// are not all in opts.allowImports, or nil if they are. With autoImport, the
+// packages evalImporting would add count as well, and with strictImports
+// those the session imported before.
+func (s *session) checkImports(src string, opts evalOptions) map[string]interface{} {
+	used := sourceImports(src)
+	if opts.autoImport {
+		if fix, err := s.importFixes(src); err == nil {
+			for _, c := range fix.add {
+				used = append(used, importUse{path: c.path, via: "added by autoImport"})
+			}
+		}
+	}
+	if opts.strictImports {
+		used = append(used, s.importsSeen()...)
+	}
+
+	var diags []interface{}
+	seen := map[string]bool{}
+	for _, u := range used {
+		if slices.Contains(opts.allowImports, u.path) || seen[u.path] {
+			continue
+		}
+		seen[u.path] = true
+		msg := fmt.Sprintf("import %q not allowed for this exercise", u.path)
+		if u.via != "" {
+			msg += " (" + u.via + ")"
+		}
+		diags = append(diags, diagnostic{line: u.line, column: u.column, message: msg, severity: "error"}.toJS())
+	}
+	if diags == nil {
+		return nil
+	}
+
+	first := diags[0].(map[string]interface{})
+	msg := first["message"].(string)
+	if line := first["line"].(int); line > 0 {
+		msg = fmt.Sprintf("%d:%d: %s", line, first["column"], msg)
+	}
+	return map[string]interface{}{
+		"success":     false,
+		"error":       msg,
+		"errorCode":   codePolicy,
+		"diagnostics": diags,
+		"output":      "",
+		"stderr":      "",
+	}
+}
+
+// importsSeen returns the packages imported by the successful evals of s,
+// and those autoImport added.
+func (s *session) importsSeen() []importUse {
+	s.mu.Lock()
+	history := slices.Clone(s.history)
+	var list []importUse
+	for path := range s.autoImports {
+		list = append(list, importUse{path: path, via: "imported earlier in the session"})
+	}
+	s.mu.Unlock()
+
+	for _, e := range history {
+		for _, u := range sourceImports(e.Source) {
+			list = append(list, importUse{path: u.path, via: "imported earlier in the session"})
+		}
+	}
+	return list
+}
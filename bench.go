@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"runtime"
+	"sync"
+	"syscall/js"
+	"time"
+)
+
+const (
+	// defaultBenchtime is the time each benchmark runs for by default, as
+	// with go test -benchtime.
+	defaultBenchtime = time.Second
+
+	// benchtimeCap bounds the total running time of a benchmark, as a
+	// multiple of the benchtime but no less than minBenchCap. A benchmark
+	// still running past it is stopped and fails.
+	benchtimeCap = 10
+	minBenchCap  = 5 * time.Second
+
+	// maxBenchN bounds the iterations of a benchmark run.
+	maxBenchN = 1e9
+)
+
+// testB stands for testing.B in the code run by yaegi.bench, with the
+// logging, failing and skipping methods of testT.
+type testB struct {
+	*testT
+	N int
+
+	bench    *benchReport
+	benchmem bool
+	bytes    int64
+	metrics  map[string]float64
+	hasSub   bool
+	loopN    int
+
+	timerOn     bool
+	start       time.Time
+	duration    time.Duration
+	startAllocs uint64
+	startBytes  uint64
+	netAllocs   uint64
+	netBytes    uint64
+}
+
+func (b *testB) StartTimer() {
+	if b.timerOn {
+		return
+	}
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	b.startAllocs = ms.Mallocs
+	b.startBytes = ms.TotalAlloc
+	b.start = time.Now()
+	b.timerOn = true
+}
+
+func (b *testB) StopTimer() {
+	if !b.timerOn {
+		return
+	}
+	b.duration += time.Since(b.start)
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	b.netAllocs += ms.Mallocs - b.startAllocs
+	b.netBytes += ms.TotalAlloc - b.startBytes
+	b.timerOn = false
+}
+
+func (b *testB) ResetTimer() {
+	if b.timerOn {
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+		b.startAllocs = ms.Mallocs
+		b.startBytes = ms.TotalAlloc
+		b.start = time.Now()
+	}
+	b.duration = 0
+	b.netAllocs = 0
+	b.netBytes = 0
+}
+
+func (b *testB) Elapsed() time.Duration {
+	d := b.duration
+	if b.timerOn {
+		d += time.Since(b.start)
+	}
+	return d
+}
+
+func (b *testB) ReportAllocs()      { b.benchmem = true }
+func (b *testB) SetBytes(n int64)   { b.bytes = n }
+func (b *testB) SetParallelism(int) {}
+
+// ReportMetric adds the metric n of unit to the result of the benchmark.
+func (b *testB) ReportMetric(n float64, unit string) {
+	if b.metrics == nil {
+		b.metrics = map[string]float64{}
+	}
+	b.metrics[unit] = n
+}
+
+// Loop reports whether the benchmark loop should run once more, for
+// benchmarks iterating with b.Loop rather than over b.N.
+func (b *testB) Loop() bool {
+	if b.loopN == 0 {
+		b.ResetTimer()
+	}
+	if b.loopN < b.N {
+		b.loopN++
+		return true
+	}
+	b.StopTimer()
+	return false
+}
+
+// Run runs f as the sub-benchmark name of b and reports whether it passed.
+// A benchmark with sub-benchmarks is not measured itself.
+func (b *testB) Run(name string, f func(*testB)) bool {
+	b.hasSub = true
+	sub := b.bench.run(b.name+"/"+name, b.session, f)
+	if sub.Failed() {
+		b.Fail()
+	}
+	return !sub.Failed()
+}
+
+// runN runs the benchmark for n iterations on its own goroutine.
+func (b *testB) runN(f func(*testB), n int) {
+	runtime.GC()
+	b.N = n
+	b.loopN = 0
+	b.ResetTimer()
+	b.StartTimer()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer func() {
+			if p := recover(); p != nil {
+				b.Log("panic:", p)
+				b.Fail()
+			}
+		}()
+		f(b)
+	}()
+	<-done
+	b.StopTimer()
+}
+
+// benchReport collects the results of the benchmarks run by yaegi.bench,
+// in the order they start.
+type benchReport struct {
+	ctx       context.Context // done once the running benchmark must stop
+	benchtime time.Duration
+	benchmem  bool
+
+	mu      sync.Mutex
+	results []map[string]interface{}
+}
+
+// run runs f as the benchmark name, growing b.N until it runs for the
+// benchtime like the testing package, and records its result. Running
+// stops early once the benchmark fails or the eval is done.
+func (r *benchReport) run(name string, s *session, f func(*testB)) *testB {
+	b := &testB{
+		testT:    &testT{name: name, session: s},
+		bench:    r,
+		benchmem: r.benchmem,
+	}
+	result := map[string]interface{}{"name": name}
+	r.mu.Lock()
+	r.results = append(r.results, result)
+	r.mu.Unlock()
+
+	begin := time.Now()
+	n := 1
+	b.runN(f, n)
+	for !b.hasSub && !b.Failed() && !b.Skipped() && r.ctx.Err() == nil &&
+		b.duration < r.benchtime && n < maxBenchN && time.Since(begin) < r.limit() {
+		// Predict the iterations reaching the benchtime from the last run,
+		// with some margin, growing at most a hundredfold.
+		last := n
+		ns := max(b.duration.Nanoseconds(), 1)
+		n = int(min(r.benchtime.Nanoseconds()*int64(last)/ns*6/5, 100*int64(last), maxBenchN))
+		n = max(n, last+1)
+		b.runN(f, n)
+	}
+	b.runCleanups()
+	stopped := context.Cause(r.ctx)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if b.hasSub {
+		// Only the sub-benchmarks are reported, as by go test.
+		for i, res := range r.results {
+			if res["name"] == name {
+				r.results = append(r.results[:i], r.results[i+1:]...)
+				break
+			}
+		}
+		return b
+	}
+	b.fillResult(result)
+	if stopped != nil {
+		// The measures of an interrupted benchmark are meaningless.
+		for key := range result {
+			if key != "name" && key != "log" {
+				delete(result, key)
+			}
+		}
+		b.Fail()
+		result["passed"] = false
+		result["skipped"] = false
+		result["error"] = r.stopError(stopped, time.Since(begin))
+	}
+	return b
+}
+
+// limit returns the time a benchmark may run for in total.
+func (r *benchReport) limit() time.Duration {
+	return max(benchtimeCap*r.benchtime, minBenchCap)
+}
+
+// stopError describes the interruption by err of a benchmark that ran for
+// elapsed: past its limit, or with the eval on timeout or cancellation.
+func (r *benchReport) stopError(err error, elapsed time.Duration) string {
+	switch {
+	case elapsed >= r.limit():
+		return fmt.Sprintf("benchmark stopped after %v", r.limit())
+	case err == context.DeadlineExceeded:
+		return "timeout"
+	default:
+		return "cancelled"
+	}
+}
+
+// fillResult sets the outcome of b in result: {passed, skipped, log} and,
+// when measured, {iterations, nsPerOp}, MB/s for SetBytes as mbPerSec,
+// {allocsPerOp, bytesPerOp} with benchmem or ReportAllocs, and the
+// reported metrics.
+func (b *testB) fillResult(result map[string]interface{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	result["passed"] = !b.failed
+	result["skipped"] = b.skipped && !b.failed
+	result["log"] = b.log.String()
+	if b.failed || b.skipped || b.N <= 0 {
+		return
+	}
+
+	n := float64(b.N)
+	result["iterations"] = b.N
+	result["nsPerOp"] = float64(b.duration.Nanoseconds()) / n
+	if b.bytes > 0 && b.duration > 0 {
+		result["mbPerSec"] = float64(b.bytes) * n / 1e6 / b.duration.Seconds()
+	}
+	if b.benchmem {
+		result["allocsPerOp"] = float64(b.netAllocs) / n
+		result["bytesPerOp"] = float64(b.netBytes) / n
+	}
+	if len(b.metrics) > 0 {
+		metrics := make(map[string]interface{}, len(b.metrics))
+		for unit, v := range b.metrics {
+			metrics[unit] = v
+		}
+		result["metrics"] = metrics
+	}
+}
+
+// runBenchmarks evaluates a package of test files like runTests and runs
+// its BenchmarkXxx functions. Besides the eval options, the options set
+// benchtimeMs, the time each benchmark runs for, and benchmem to report
+// allocations. The result carries the benchmarks in the order they ran,
+// {name, passed, skipped, log, iterations, nsPerOp, allocsPerOp,
+// bytesPerOp}. A benchmark still running after ten times its benchtime, or
+// five seconds if longer, is stopped and fails, and cancellation or the
+// timeoutMs option stop them all.
+func runBenchmarks(this js.Value, args []js.Value) interface{} {
+	src, excluded, opts, invalid := testSetup("bench", args)
+	if invalid != nil {
+		return invalid
+	}
+	report := &benchReport{benchtime: defaultBenchtime}
+	if v := optionArg(args, 1); v.Type() == js.TypeObject {
+		if ms := optionInt(v, "benchtimeMs"); ms > 0 {
+			report.benchtime = time.Duration(ms) * time.Millisecond
+		}
+		report.benchmem = v.Get("benchmem").Truthy()
+	}
+
+	s := defaultSession()
+	result := runEvalFunc(s, "", opts, func(ctx context.Context) (reflect.Value, error) {
+		syms, d, err := loadTests(s.interpreterFor(src, opts.buildTags))
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		for _, name := range testNames(src, "Benchmark") {
+			f, ok := syms[name].Interface().(func(*testB))
+			if !ok {
+				continue
+			}
+			limit, cancel := context.WithTimeout(ctx, report.limit())
+			report.ctx = limit
+			d.call(limit, func() { report.run(name, s, f) })
+			cancel()
+			if err := ctx.Err(); err != nil {
+				return reflect.Value{}, err
+			}
+		}
+		return reflect.Value{}, nil
+	})
+	result = testResult(result)
+	result["excluded"] = excluded
+
+	report.mu.Lock()
+	defer report.mu.Unlock()
+
+	benchmarks := make([]interface{}, len(report.results))
+	for i, r := range report.results {
+		benchmarks[i] = r
+		if r["passed"] != true {
+			result["success"] = false
+		}
+	}
+	result["benchmarks"] = benchmarks
+	return result
+}
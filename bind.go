@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"go/constant"
+	"go/token"
+	"math"
+	"path"
+	"reflect"
+	"syscall/js"
+
+	"github.com/traefik/yaegi/interp"
+)
+
+// hostFunc is the Go signature of JS functions registered with bind.
+type hostFunc = func(args ...interface{}) (interface{}, error)
+
+// bindFunc registers a JS function as pkg.name in the default session, so
+// that interpreted code can import pkg and call it. Bindings last until the
+// session is reset without keepBindings. It fails as busy while an eval
+// uses the interpreter.
+func bindFunc(this js.Value, args []js.Value) interface{} {
+	if len(args) != 3 || args[0].Type() != js.TypeString || args[1].Type() != js.TypeString || args[2].Type() != js.TypeFunction {
+		return map[string]interface{}{
+			"success": false,
+			"error":   "bind requires a package path, a function name and a function",
+		}
+	}
+	pkg, name, fn := args[0].String(), args[1].String(), args[2]
+	if !token.IsExported(name) {
+		return map[string]interface{}{
+			"success": false,
+			"error":   fmt.Sprintf("bind: %q is not an exported Go identifier", name),
+		}
+	}
+	if pkg == "" || !token.IsIdentifier(path.Base(pkg)) {
+		return map[string]interface{}{
+			"success": false,
+			"error":   fmt.Sprintf("bind: invalid package path %q", pkg),
+		}
+	}
+
+	s := defaultSession()
+	if err := s.acquireEval(context.Background(), false); err != nil {
+		return map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+			"busy":    true,
+		}
+	}
+	defer s.releaseEval()
+	err := s.bind(pkg, map[string]reflect.Value{
+		name: reflect.ValueOf(hostFunc(jsHostFunc(fn))),
+	})
+	if err != nil {
+		return map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+		}
+	}
+	return map[string]interface{}{"success": true}
+}
+
+// usePackage registers the properties of a JS object as the package pkg in
+// the default session. Functions become funcs called like those of bind,
+// strings, numbers and booleans constants, and other values interface{}
+// vars converted with jsToGo. pkg may not be a package of the stdlib, nor
+// one registered before, until the session is reset without keepBindings.
+// It fails as busy while an eval uses the interpreter.
+func usePackage(this js.Value, args []js.Value) interface{} {
+	if len(args) != 2 || args[0].Type() != js.TypeString || args[1].Type() != js.TypeObject {
+		return map[string]interface{}{
+			"success": false,
+			"error":   "usePackage requires a package path and an object",
+		}
+	}
+	pkg, obj := args[0].String(), args[1]
+	if pkg == "" || !token.IsIdentifier(path.Base(pkg)) {
+		return map[string]interface{}{
+			"success": false,
+			"error":   fmt.Sprintf("usePackage: invalid package path %q", pkg),
+		}
+	}
+	s := defaultSession()
+	if symbolPackage(pkg) {
+		return map[string]interface{}{
+			"success": false,
+			"error":   fmt.Sprintf("usePackage: %q is a package of the stdlib", pkg),
+		}
+	}
+	s.mu.Lock()
+	_, bound := s.bound[pkg]
+	s.mu.Unlock()
+	if bound {
+		return map[string]interface{}{
+			"success": false,
+			"error":   fmt.Sprintf("usePackage: package %q is already registered, reset first", pkg),
+		}
+	}
+
+	if err := s.acquireEval(context.Background(), false); err != nil {
+		return map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+			"busy":    true,
+		}
+	}
+	defer s.releaseEval()
+
+	keys := js.Global().Get("Object").Call("keys", obj)
+	syms := make(map[string]reflect.Value, keys.Length())
+	for i := 0; i < keys.Length(); i++ {
+		name := keys.Index(i).String()
+		if !token.IsExported(name) {
+			return map[string]interface{}{
+				"success": false,
+				"error":   fmt.Sprintf("usePackage: %q is not an exported Go identifier", name),
+			}
+		}
+		syms[name] = jsSymbol(obj.Get(name))
+	}
+
+	if err := s.bind(pkg, syms); err != nil {
+		return map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+		}
+	}
+	return map[string]interface{}{"success": true}
+}
+
+// symbolPackage reports whether pkg is provided by the symbols built into
+// the module, whether or not sessions may import it.
+func symbolPackage(pkg string) bool {
+	if pkg == hostioPackage || pkg == playgroundPackage {
+		return true
+	}
+	for _, set := range (sessionConfig{unrestricted: true}).symbols() {
+		for key := range set {
+			if path.Dir(key) == pkg {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// jsSymbol converts a property of an object given to usePackage to a
+// symbol.
+func jsSymbol(v js.Value) reflect.Value {
+	switch v.Type() {
+	case js.TypeFunction:
+		return reflect.ValueOf(hostFunc(jsHostFunc(v)))
+	case js.TypeString:
+		return reflect.ValueOf(constant.MakeString(v.String()))
+	case js.TypeBoolean:
+		return reflect.ValueOf(constant.MakeBool(v.Bool()))
+	case js.TypeNumber:
+		f := v.Float()
+		switch {
+		case f == math.Trunc(f) && math.Abs(f) <= 1<<53:
+			return reflect.ValueOf(constant.MakeInt64(int64(f)))
+		case !math.IsInf(f, 0) && !math.IsNaN(f):
+			return reflect.ValueOf(constant.MakeFloat64(f))
+		}
+	}
+	x := jsToGo(v)
+	return reflect.ValueOf(&x).Elem()
+}
+
+// bind registers syms as symbols of the package pkg in the interpreter of
+// s and records them, to be replayed by rebind.
+func (s *session) bind(pkg string, syms map[string]reflect.Value) error {
+	err := s.interpreter.Use(boundExports(pkg, syms))
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.bound == nil {
+		s.bound = map[string]map[string]reflect.Value{}
+	}
+	if s.bound[pkg] == nil {
+		s.bound[pkg] = map[string]reflect.Value{}
+	}
+	for name, v := range syms {
+		s.bound[pkg][name] = v
+	}
+	return nil
+}
+
+// boundExports returns syms as the exports of the package pkg.
+func boundExports(pkg string, syms map[string]reflect.Value) interp.Exports {
+	// Symbols are keyed by "import/path/name", like the stdlib ones.
+	return interp.Exports{pkg + "/" + path.Base(pkg): syms}
+}
+
+// rebind registers again in the interpreter of s the symbols bound before
+// a reset, as returned by takeBindings.
+func (s *session) rebind(bound map[string]map[string]reflect.Value) {
+	for pkg, syms := range bound {
+		s.bind(pkg, syms)
+	}
+}
+
+// takeBindings returns the symbols bound in s and forgets them.
+func (s *session) takeBindings() map[string]map[string]reflect.Value {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bound := s.bound
+	s.bound = nil
+	return bound
+}
+
+// jsHostFunc wraps fn as a Go function. Arguments are converted to JS with
+// goValueToJS, the result back with jsToGo, and a JS exception becomes the
+// error.
+func jsHostFunc(fn js.Value) hostFunc {
+	return func(args ...interface{}) (result interface{}, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				jsErr, ok := r.(js.Error)
+				if !ok {
+					panic(r)
+				}
+				result, err = nil, jsErr
+			}
+		}()
+
+		jsArgs := make([]interface{}, len(args))
+		for i, a := range args {
+			jsArgs[i] = goValueToJS(reflect.ValueOf(a))
+		}
+		return jsToGo(fn.Invoke(jsArgs...)), nil
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"syscall/js"
+	"testing"
+	"time"
+)
+
+func TestBindingsInOtherInterpreters(t *testing.T) {
+	t.Cleanup(func() { callAPI(t, "reset") })
+	double := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		return args[0].Int() * 2
+	})
+	defer double.Release()
+	mustSucceed(t, callAPI(t, "bind", "hostmath", "Double", double))
+
+	const testSource = `package main
+
+import (
+	"hostmath"
+	"testing"
+)
+
+func TestDouble(t *testing.T) {
+	v, err := hostmath.Double(21)
+	if err != nil || v != 42.0 {
+		t.Fatal(v, err)
+	}
+}
+
+func BenchmarkDouble(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		hostmath.Double(i)
+	}
+}
+`
+	res := callAPI(t, "check", testSource)
+	mustSucceed(t, res)
+	res = callAPI(t, "test", testSource)
+	mustSucceed(t, res)
+	if tests := res.Get("tests"); tests.Length() != 1 || !tests.Index(0).Get("passed").Bool() {
+		t.Errorf("test = %s, want TestDouble passed", jsonString(res))
+	}
+	res = callAPI(t, "bench", testSource, map[string]interface{}{"benchtimeMs": 10})
+	mustSucceed(t, res)
+	if benchmarks := res.Get("benchmarks"); benchmarks.Length() != 1 {
+		t.Errorf("bench = %s, want BenchmarkDouble run", jsonString(res))
+	}
+
+	// A reset drops the bindings, for the other interpreters too.
+	callAPI(t, "reset")
+	if res := callAPI(t, "check", testSource); res.Get("success").Bool() {
+		t.Errorf("check after a reset = %s, want hostmath missing", jsonString(res))
+	}
+}
+
+func TestInterpreterCallsWhileBusy(t *testing.T) {
+	t.Cleanup(func() {
+		callAPI(t, "setPrelude", "")
+		callAPI(t, "reset")
+	})
+	mustSucceed(t, callAPI(t, "setPrelude", "var preludeKept = 1"))
+	mustSucceed(t, callAPI(t, "eval", `import (
+	"syscall/js"
+	"time"
+)`))
+	done := make(chan js.Value, 1)
+	onComplete := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		done <- args[0]
+		return nil
+	})
+	defer onComplete.Release()
+	js.Global().Set("busyRelease", false)
+	defer js.Global().Delete("busyRelease")
+	callAPI(t, "eval", `for !js.Global().Get("busyRelease").Bool() {
+	time.Sleep(time.Millisecond)
+}`, map[string]interface{}{"onComplete": onComplete})
+	for !callAPI(t, "status").Get("busy").Bool() {
+		time.Sleep(time.Millisecond)
+	}
+
+	for _, c := range []struct {
+		name string
+		args []interface{}
+	}{
+		{"complete", []interface{}{"x", 1}},
+		{"doc", []interface{}{"x"}},
+		{"globals", nil},
+		{"bind", []interface{}{"hostbusy", "F", js.Global().Get("Function").New("return 1")}},
+		{"usePackage", []interface{}{"hostbusy2", map[string]interface{}{"N": 1}}},
+		{"setPrelude", []interface{}{"var preludeOther = 2"}},
+		{"setPrelude", []interface{}{""}},
+		{"reset", nil},
+	} {
+		if res := callAPI(t, c.name, c.args...); !res.Get("busy").Truthy() {
+			t.Errorf("%s during an eval = %s, want busy", c.name, jsonString(res))
+		}
+	}
+	js.Global().Set("busyRelease", true)
+	mustSucceed(t, <-done)
+	if res := callAPI(t, "globals"); res.Get("busy").Truthy() {
+		t.Errorf("globals after the eval = %s", jsonString(res))
+	}
+	// The calls refused changed nothing.
+	mustSucceed(t, callAPI(t, "eval", "preludeKept"))
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go/ast"
+	"math"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/traefik/yaegi/interp"
+)
+
+const (
+	// budgetPackage is the package of the tick instrumented code calls.
+	budgetPackage = "yaegiwasm/budget"
+	// tickCall is inserted by instrumentSteps with the id of a budget, on
+	// the line of the statement it precedes so that line numbers are kept.
+	tickCall = "_yaegibudget.Tick(%d);"
+)
+
+var (
+	// errStepBudget fails the evals that ran out of steps.
+	errStepBudget = errors.New("step budget exceeded")
+	// budgetIDs numbers the budgets, for the ticks of their code.
+	budgetIDs atomic.Int64
+)
+
+// stepBudget caps the steps of an eval: the loop iterations and function
+// calls of its code, as counted by the ticks instrumentSteps inserts.
+// Interpreted code is not preempted under js/wasm, so a loop that never
+// yields would never let the cancellation of its eval run. The ticks also
+// check the call depth of the code against maxDepth, see checkDepth.
+type stepBudget struct {
+	id       int64
+	max      int64
+	maxDepth int
+	steps    atomic.Int64
+	exceeded atomic.Bool
+	state    atomic.Int32       // budgetRunning, budgetStopped or budgetReturned
+	cancel   context.CancelFunc // cancels the eval
+	// goroutines is set for code with go statements, whose goroutines
+	// keep the budget past the eval.
+	goroutines bool
+}
+
+// The states of the eval of a budget.
+const (
+	budgetRunning  = iota
+	budgetStopped  // cancelled, yaegi ends its code at the next statement
+	budgetReturned // returned, goroutines it started may run on
+)
+
+// tick counts a step. Past the budget, it cancels the eval and yields, so
+// that yaegi stops the interpreted code, goroutines included. Unlike a
+// panic, this can't be recovered by the code, nor crash the module from a
+// goroutine it started. Goroutines running on after their eval returned
+// are not stopped by the cancellation, and exit instead: unlike the eval,
+// whose frame a goroutine exiting from a deferred call would leave locked,
+// they have frames of their own.
+func (b *stepBudget) tick() {
+	n := b.steps.Add(1)
+	if b.maxDepth > 0 && n%depthCheckInterval == 0 {
+		checkDepth(b.maxDepth)
+	}
+	if n <= b.max {
+		return
+	}
+	b.exceeded.Store(true)
+	switch b.state.Load() {
+	case budgetRunning:
+		b.cancel()
+		runtime.Gosched()
+	case budgetReturned:
+		// Not the host, calling a function the code declared.
+		if g := currentGoroutine(); g.interpreted() && g.createdBy.fn != evalFrame {
+			runtime.Goexit()
+		}
+	}
+}
+
+// newStepBudget returns a budget of max steps, unlimited for 0, which then
+// only serves the ticks checking a heapWatch or the call depth, capped at
+// maxDepth unless 0.
+func newStepBudget(max, maxDepth int) *stepBudget {
+	id := budgetIDs.Add(1)
+	if max == 0 {
+		return &stepBudget{id: id, max: math.MaxInt64, maxDepth: maxDepth}
+	}
+	return &stepBudget{id: id, max: int64(max), maxDepth: maxDepth}
+}
+
+// used returns the steps taken within the budget.
+func (b *stepBudget) used() int64 {
+	return min(b.steps.Load(), b.max)
+}
+
+// budgetSymbols returns the package of the tick, counting the steps of the
+// code of a budget, see tickBudget, and checking the heap of the running
+// eval.
+func (s *session) budgetSymbols() interp.Exports {
+	return interp.Exports{budgetPackage + "/budget": {
+		"Tick": reflect.ValueOf(func(id int64) {
+			s.tickBudget(id)
+			if w := s.heap.Load(); w != nil {
+				w.tick()
+			}
+		}),
+	}}
+}
+
+// tickBudget counts a step of the code instrumented for the budget id.
+// Instrumented code may run past its eval, as functions it declares or
+// goroutines it starts. The code of an eval that started goroutines counts
+// against its budget, for them to exit once it is exceeded; other code,
+// and the host calling the former past its budget, against the budgeted
+// eval of s running, if any.
+func (s *session) tickBudget(id int64) {
+	if b, ok := s.budgets.Load(id); ok {
+		b := b.(*stepBudget)
+		b.tick()
+		if !b.exceeded.Load() {
+			return
+		}
+	}
+	if b := s.budget.Load(); b != nil {
+		b.tick()
+	}
+}
+
+// evalSteps evaluates src, instrumented by instrumentSteps for b, in s
+// within the budget b, like evalImporting. Code ended by b returns
+// errStepBudget, even from a goroutine it started.
+func (s *session) evalSteps(ctx context.Context, src, name string, autoImport bool, moved insertMap, b *stepBudget) (reflect.Value, error) {
+	// Interpreters import the package on their first budgeted eval. It
+	// can't be detected with imported after an eval leaves a value, as
+	// yaegi then returns it for the package name.
+	if err := s.importOnce(&s.budgetInterp, "_yaegibudget", budgetPackage, name); err != nil {
+		return reflect.Value{}, err
+	}
+
+	ctx, b.cancel = context.WithCancel(ctx)
+	defer b.cancel()
+	if b.goroutines {
+		s.budgets.Store(b.id, b)
+	}
+	s.budget.Store(b)
+	v, err := s.evalImporting(ctx, src, name, autoImport, moved)
+	if err != nil && err == ctx.Err() {
+		b.state.Store(budgetStopped)
+	} else {
+		b.state.Store(budgetReturned)
+	}
+	s.budget.CompareAndSwap(b, nil)
+	if b.exceeded.Load() {
+		return reflect.Value{}, errStepBudget
+	}
+	return v, err
+}
+
+// instrumentSteps inserts tickCall for the budget b at the top of the
+// bodies of the functions and loops of src, before their first statement,
+// and returns it with the map of the text inserted. A source that does not
+// parse is returned as is, for the eval to report its errors.
+func instrumentSteps(src string, b *stepBudget) (string, insertMap) {
+	f, err := parseFragment(src)
+	if err != nil {
+		return src, nil
+	}
+
+	var offsets []int
+	ast.Inspect(f.file, func(n ast.Node) bool {
+		var body *ast.BlockStmt
+		switch n := n.(type) {
+		case *ast.FuncDecl:
+			body = n.Body
+		case *ast.FuncLit:
+			body = n.Body
+		case *ast.ForStmt:
+			body = n.Body
+		case *ast.RangeStmt:
+			body = n.Body
+		}
+		if body == nil {
+			return true
+		}
+		// The braces of the function wrapping statements are not in src.
+		o := f.fset.Position(body.Lbrace).Offset - f.head
+		if f.body >= 0 && o >= f.body && o < f.body+len(funcHeader) {
+			return true
+		}
+		// Before the first statement, which yaegi reports the position of
+		// for the frames of a function.
+		at := f.offset(body.Lbrace) + 1
+		if len(body.List) > 0 {
+			at = f.offset(body.List[0].Pos())
+		}
+		offsets = append(offsets, at)
+		return true
+	})
+	sort.Ints(offsets)
+
+	call := fmt.Sprintf(tickCall, b.id)
+	var out strings.Builder
+	m := insertMap{}
+	last := 0
+	for _, o := range offsets {
+		out.WriteString(src[last:o])
+		out.WriteString(call)
+		line := strings.Count(src[:o], "\n") + 1
+		m[line] = append(m[line], [2]int{o - strings.LastIndex(src[:o], "\n"), len(call)})
+		last = o
+	}
+	out.WriteString(src[last:])
+	return out.String(), m
+}
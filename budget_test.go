@@ -0,0 +1,122 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStepBudgetPastEval(t *testing.T) {
+	t.Cleanup(func() { callAPI(t, "reset") })
+	res := callAPI(t, "eval", `
+import "time"
+
+var ticks int
+
+func spin(n int) int {
+	s := 0
+	for i := 0; i < n; i++ {
+		s += i
+	}
+	return s
+}
+
+func count() int { return ticks }
+`, map[string]interface{}{"maxSteps": 100})
+	mustSucceed(t, res)
+	res = callAPI(t, "eval", `
+go func() {
+	for {
+		ticks++
+		time.Sleep(time.Millisecond)
+	}
+}()
+`, map[string]interface{}{"maxSteps": 100})
+	mustSucceed(t, res)
+
+	// The goroutine runs on past the eval, within its budget, which does
+	// not hold for the host calls that follow.
+	count := func() int {
+		res := callAPI(t, "call", "count")
+		mustSucceed(t, res)
+		return res.Get("value").Int()
+	}
+	ticks := count()
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); {
+		time.Sleep(100 * time.Millisecond)
+		n := count()
+		if n == ticks && n >= 90 {
+			break
+		}
+		ticks = n
+	}
+	if ticks < 90 || ticks > 100 {
+		t.Errorf("the goroutine ticked %d times, want it to exit at its budget of 100 steps", ticks)
+	}
+	res = callAPI(t, "call", "spin", 100)
+	mustSucceed(t, res)
+	if got := res.Get("value").Int(); got != 4950 {
+		t.Errorf("call(spin, 100) = %d, want 4950", got)
+	}
+
+	// What the evals declared runs in full for later evals.
+	res = callAPI(t, "eval", "spin(100000)")
+	mustSucceed(t, res)
+	if got := res.Get("value").Float(); got != 4999950000 {
+		t.Errorf("spin(100000) = %v, want 4999950000", got)
+	}
+	res = callAPI(t, "eval", "spin(1000)", map[string]interface{}{"maxSteps": 100})
+	if errorCodeOf(res) != codeLimit {
+		t.Errorf("spin(1000) within 100 steps = %s, want code %s", jsonString(res), codeLimit)
+	}
+	res = callAPI(t, "eval", "spin(10)")
+	mustSucceed(t, res)
+	if got := res.Get("value").Int(); got != 45 {
+		t.Errorf("spin(10) after an exceeded budget = %d, want 45", got)
+	}
+}
+
+func TestHeapHeldElsewhere(t *testing.T) {
+	a := newTestSession(t, nil)
+	b := newTestSession(t, nil)
+	mustSucceed(t, callAPI(t, "evalIn", b, "var held = make([]byte, 64<<20)"))
+	mustSucceed(t, callAPI(t, "evalIn", a, "var kept = 1"))
+	mustSucceed(t, callAPI(t, "configure", map[string]interface{}{"maxHeapBytes": 48 << 20}))
+	t.Cleanup(func() { callAPI(t, "configure", map[string]interface{}{"maxHeapBytes": 0}) })
+
+	res := callAPI(t, "evalIn", a, "for i := 0; i < 1000000; i++ { _ = make([]byte, 64) }")
+	if errorCodeOf(res) != codeLimit {
+		t.Fatalf("eval past the heap held by another session = %s, want code %s", jsonString(res), codeLimit)
+	}
+	if res.Get("recovered").Truthy() {
+		t.Errorf("the session was rebuilt for the heap of another: %s", jsonString(res))
+	}
+	mustSucceed(t, callAPI(t, "configure", map[string]interface{}{"maxHeapBytes": 0}))
+	res = callAPI(t, "evalIn", a, "kept")
+	mustSucceed(t, res)
+	if got := res.Get("value").Int(); got != 1 {
+		t.Errorf("kept = %d after the eval, want 1", got)
+	}
+}
+
+func TestHeapLimitOption(t *testing.T) {
+	t.Cleanup(func() { callAPI(t, "reset") })
+	res := callAPI(t, "eval", "1", map[string]interface{}{"maxHeapBytes": 1 << 30})
+	mustSucceed(t, res)
+	if got := res.Get("stats").Get("limits").Get("maxHeapBytes").Int(); got != 1<<30 {
+		t.Errorf("stats.limits.maxHeapBytes = %d, want %d", got, 1<<30)
+	}
+	res = callAPI(t, "eval", "1", map[string]interface{}{
+		"maxHeapBytes": 1 << 30,
+		"limits":       map[string]interface{}{"maxHeapBytes": 1 << 20},
+	})
+	if errorCodeOf(res) != codeBadRequest {
+		t.Errorf("eval with two heap limits = %s, want code %s", jsonString(res), codeBadRequest)
+	}
+}
+
+func TestAllowImportsCode(t *testing.T) {
+	res := callAPI(t, "eval", "import \"os\"", map[string]interface{}{"allowImports": []interface{}{"fmt"}})
+	if errorCodeOf(res) != codePolicy {
+		t.Errorf("eval importing outside allowImports = %s, want code %s", jsonString(res), codePolicy)
+	}
+}
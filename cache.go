@@ -0,0 +1,233 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"slices"
+	"strconv"
+	"sync"
+	"syscall/js"
+	"time"
+)
+
+// defaultMaxCacheEntries is the default cap on the results of evals kept
+// by the option cache.
+const defaultMaxCacheEntries = 256
+
+// impurePackages are the packages of effects outside the interpreter, left
+// out of the cached evals.
+var impurePackages = []string{"crypto/rand", "math/rand/v2", "net", "net/http", "syscall/js"}
+
+// cacheClock is the time of the cached evals, that of the Go playground.
+var cacheClock = time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+
+// cachedCodes are the codes of the failures of cached evals: those of the
+// source, and not of the time it took.
+var cachedCodes = []string{codeParse, codeType, codePanic, codeExit}
+
+// evalCache holds the results of the evals with the option cache, the least
+// recently used first evicted past the setting maxCacheEntries.
+var evalCache = struct {
+	sync.Mutex
+	order *list.List               // of *cacheEntry, most recent first
+	byKey map[string]*list.Element // by cacheKey
+}{order: list.New(), byKey: map[string]*list.Element{}}
+
+type cacheEntry struct {
+	key         string
+	result      map[string]interface{}
+	outputBytes int // written by the eval, counted again on each hit
+}
+
+// pureConfig returns c made deterministic for a cached eval: restricted,
+// without impurePackages and with a randSeed, 0 if c has none.
+func pureConfig(c sessionConfig) sessionConfig {
+	pure := c
+	pure.unrestricted = false
+	pure.replMode = false
+	pure.packages = slices.DeleteFunc(c.effectivePackages(), func(p string) bool {
+		return slices.Contains(impurePackages, p)
+	})
+	if pure.randSeed == nil {
+		pure.randSeed = new(int64)
+	}
+	return pure
+}
+
+// cacheKey returns the SHA-256, in hex, of sourceCode and of what else
+// decides the result of a cached eval of it under config, after prelude.
+func cacheKey(sourceCode string, opts evalOptions, config sessionConfig, prelude string) string {
+	h := sha256.New()
+	field := func(s string) {
+		h.Write(binary.AppendUvarint(nil, uint64(len(s))))
+		h.Write([]byte(s))
+	}
+	list := func(l []string) {
+		field(strconv.Itoa(len(l)))
+		for _, s := range l {
+			field(s)
+		}
+	}
+	field(sourceCode)
+	field(prelude)
+	list(opts.args)
+	list(config.env)
+	list(opts.env)
+	list(config.packages)
+	field(strconv.FormatInt(*config.randSeed, 10))
+	field(opts.stdin)
+	input, _ := json.Marshal(opts.input)
+	field(string(input))
+	field(opts.filename)
+	field(opts.echo)
+	field(opts.profile)
+	// No allowImports allows any, an empty one none.
+	field(strconv.FormatBool(opts.allowImports == nil))
+	list(opts.allowImports)
+	field(strconv.Itoa(opts.maxSteps))
+	field(strconv.Itoa(opts.maxCallDepth))
+	field(strconv.Itoa(opts.maxOutput))
+	field(opts.timeout.String())
+	for _, n := range []int{opts.render.depth, opts.render.maxItems, opts.render.maxString} {
+		field(strconv.Itoa(n))
+	}
+	for _, b := range []bool{opts.snippet, opts.autoImport, opts.warnings, opts.trace, opts.binaryOutput, opts.orderedMaps,
+		opts.abortOnOutputLimit, opts.events, opts.stats, opts.strictImports} {
+		field(strconv.FormatBool(b))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// cachedEval is runEval for the option cache: it returns the result of the
+// earlier eval of sourceCode with the same options, with cached set, or else
+// evaluates it in a throwaway session built from the configuration of s,
+// made deterministic. That session has no filesystem, network, JS or real
+// time, its math/rand draws from a fixed seed, and it sees none of the
+// declarations of s but those of its prelude; output is captured, not
+// streamed. The results of evals that failed for a timeout, a cancellation
+// or a limit are not kept. Hits and misses alike count in the usage of s.
+func cachedEval(s *session, sourceCode string, opts evalOptions) map[string]interface{} {
+	if opts.interactive {
+		return map[string]interface{}{
+			"success":   false,
+			"error":     "cache requires stdin given as the option stdin",
+			"errorCode": codeBadRequest,
+		}
+	}
+	if res := checkOptions(opts, true); res != nil {
+		return res
+	}
+	config := pureConfig(s.config)
+	s.mu.Lock()
+	p := s.prelude
+	s.mu.Unlock()
+	prelude := ""
+	if p != nil {
+		prelude = p.src
+	}
+	// The scratch session has a quota of its own, unused.
+	if res := s.admit(); res != nil {
+		return res
+	}
+	key := cacheKey(sourceCode, opts, config, prelude)
+	if res, outputBytes := cachedResult(key); res != nil {
+		s.recordEval(res, outputBytes, 0)
+		return res
+	}
+
+	scratch := buildSession(-1, config, true)
+	scratch.clock.Store(&clock{fixed: cacheClock, sleepScale: 1})
+	scratch.prelude = p
+	scratch.applyPrelude()
+	opts.onStdout, opts.onStderr = js.Undefined(), js.Undefined()
+	opts.captureOutput = true
+	opts.outputHandle = 0
+	started := time.Now()
+	res := runEval(scratch, sourceCode, opts)
+	wall := time.Since(started)
+	delete(res, "mode")
+	scratch.mu.Lock()
+	outputBytes := scratch.counters.outputBytes
+	scratch.mu.Unlock()
+	s.recordEval(res, outputBytes, wall)
+
+	code, _ := res["errorCode"].(string)
+	if res["success"] == true || slices.Contains(cachedCodes, code) {
+		storeResult(key, res, outputBytes)
+	}
+	res["cached"] = false
+	return res
+}
+
+// cachedResult returns a copy of the result kept under key, with cached
+// set and without the stats of the eval it came from, and the output bytes
+// that eval wrote, or nil.
+func cachedResult(key string) (map[string]interface{}, int) {
+	evalCache.Lock()
+	defer evalCache.Unlock()
+
+	e := evalCache.byKey[key]
+	if e == nil {
+		return nil, 0
+	}
+	evalCache.order.MoveToFront(e)
+	entry := e.Value.(*cacheEntry)
+	res := copyResult(entry.result)
+	delete(res, "stats")
+	res["cached"] = true
+	return res, entry.outputBytes
+}
+
+// storeResult keeps res under key, with the outputBytes its eval wrote,
+// evicting the least recently used results past maxCacheEntries.
+func storeResult(key string, res map[string]interface{}, outputBytes int) {
+	kept := copyResult(res)
+
+	evalCache.Lock()
+	defer evalCache.Unlock()
+
+	limit := maxCacheEntries()
+	if limit == 0 {
+		return
+	}
+	if e := evalCache.byKey[key]; e != nil {
+		evalCache.order.Remove(e)
+	}
+	evalCache.byKey[key] = evalCache.order.PushFront(&cacheEntry{key, kept, outputBytes})
+	for evalCache.order.Len() > limit {
+		last := evalCache.order.Back()
+		evalCache.order.Remove(last)
+		delete(evalCache.byKey, last.Value.(*cacheEntry).key)
+	}
+}
+
+// copyResult returns a copy of res, and of its binary output, which the
+// host may change.
+func copyResult(res map[string]interface{}) map[string]interface{} {
+	c := make(map[string]interface{}, len(res))
+	for k, v := range res {
+		c[k] = v
+	}
+	if b, ok := c["output"].(js.Value); ok {
+		c["output"] = b.Call("slice")
+	}
+	return c
+}
+
+// clearCache drops the results kept by the option cache: {success,
+// cleared}, the count dropped.
+func clearCache(this js.Value, args []js.Value) interface{} {
+	evalCache.Lock()
+	defer evalCache.Unlock()
+
+	n := evalCache.order.Len()
+	evalCache.order.Init()
+	evalCache.byKey = map[string]*list.Element{}
+	return map[string]interface{}{
+		"success": true,
+		"cleared": n,
+	}
+}
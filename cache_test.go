@@ -0,0 +1,49 @@
+package main
+
+import "testing"
+
+func TestCacheKeyOutputLimit(t *testing.T) {
+	t.Cleanup(func() { callAPI(t, "clearCache") })
+	const src = "package main\n\nimport \"fmt\"\n\nfunc main() { fmt.Print(\"0123456789\") }\n"
+	limited := callAPI(t, "eval", src, map[string]interface{}{"cache": true, "maxOutputBytes": 4})
+	full := callAPI(t, "eval", src, map[string]interface{}{"cache": true})
+	mustSucceed(t, full)
+	if full.Get("cached").Bool() {
+		t.Errorf("eval without maxOutputBytes hit the result of %s", jsonString(limited))
+	}
+	if got := full.Get("output").String(); got != "0123456789" {
+		t.Errorf("output = %q, want it whole", got)
+	}
+}
+
+func TestCacheHitsCountInStats(t *testing.T) {
+	t.Cleanup(func() { callAPI(t, "clearCache") })
+	id := newTestSession(t, nil)
+	const src = `println("counted")`
+	for i := 0; i < 2; i++ {
+		res := callAPI(t, "evalIn", id, src, map[string]interface{}{"cache": true})
+		mustSucceed(t, res)
+		if got := res.Get("cached").Bool(); got != (i == 1) {
+			t.Errorf("eval %d: cached = %v", i, got)
+		}
+	}
+	res := callAPI(t, "stats", map[string]interface{}{"session": id})
+	mustSucceed(t, res)
+	st := res.Get("sessions").Index(0)
+	if st.Get("evals").Int() != 2 || st.Get("successes").Int() != 2 {
+		t.Errorf("%d evals, %d successes, want 2 and 2", st.Get("evals").Int(), st.Get("successes").Int())
+	}
+	if got, want := st.Get("outputBytes").Int(), 2*len("counted\n"); got != want {
+		t.Errorf("outputBytes = %d, want %d", got, want)
+	}
+}
+
+func TestCacheKeyAllowImports(t *testing.T) {
+	t.Cleanup(func() { callAPI(t, "clearCache") })
+	const src = "package main\n\nimport \"strings\"\n\nfunc main() { _ = strings.ToUpper(\"a\") }\n"
+	mustSucceed(t, callAPI(t, "eval", src, map[string]interface{}{"cache": true}))
+	res := callAPI(t, "eval", src, map[string]interface{}{"cache": true, "allowImports": []interface{}{"fmt"}})
+	if errorCodeOf(res) != codePolicy {
+		t.Errorf("eval importing outside allowImports = %s, want code %s", jsonString(res), codePolicy)
+	}
+}
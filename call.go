@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"go/token"
+	"reflect"
+	"strings"
+	"syscall/js"
+	"time"
+)
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// callFunc calls a function defined by evaluated code in the default
+// session: yaegi.call("Add", 1, 2). Arguments are converted to the
+// parameter types, and the results reported as by resultFields. The call
+// counts in the usage of the session as an eval.
+func callFunc(this js.Value, args []js.Value) (res interface{}) {
+	if len(args) < 1 || args[0].Type() != js.TypeString {
+		return map[string]interface{}{
+			"success": false,
+			"error":   "call requires a function name",
+		}
+	}
+	name := args[0].String()
+	for _, part := range strings.Split(name, ".") {
+		if !token.IsIdentifier(part) {
+			return map[string]interface{}{
+				"success": false,
+				"error":   fmt.Sprintf("call: invalid function name %q", name),
+			}
+		}
+	}
+
+	s := defaultSession()
+	if res := s.admit(); res != nil {
+		return res
+	}
+	if err := s.acquireEval(context.Background(), false); err != nil {
+		return map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+			"busy":    true,
+		}
+	}
+	defer s.releaseEval()
+	s.describeEval("call " + name)
+
+	var outputBytes int
+	var wall time.Duration
+	defer func() { s.recordEval(res.(map[string]interface{}), outputBytes, wall) }()
+	fn, err := s.interpreter.Eval(name)
+	if err != nil {
+		return map[string]interface{}{
+			"success":   false,
+			"error":     err.Error(),
+			"errorCode": errorCode(err),
+		}
+	}
+	if fn.Kind() != reflect.Func {
+		return map[string]interface{}{
+			"success": false,
+			"error":   fmt.Sprintf("%s is not a function", name),
+		}
+	}
+
+	in, err := callArgs(fn.Type(), args[1:])
+	if err != nil {
+		return map[string]interface{}{
+			"success": false,
+			"error":   fmt.Sprintf("call %s: %v", name, err),
+		}
+	}
+
+	s.stdout.start(true, nil, nil)
+	s.stderr.start(true, nil, nil)
+	var out []reflect.Value
+	started := time.Now()
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("panic: %v", r)
+				if e, ok := r.(exitStatus); ok {
+					err = e
+				}
+			}
+		}()
+		out = fn.Call(in)
+	}()
+	wall = time.Since(started)
+	output := s.stdout.stop()
+	stderr := s.stderr.stop()
+	outputBytes = len(output) + len(stderr)
+	if code, ok := exitCode(err); ok {
+		return exitResult(code, output, stderr)
+	}
+
+	if err != nil {
+		return map[string]interface{}{
+			"success":   false,
+			"error":     err.Error(),
+			"errorCode": errorCode(err),
+			"output":    output,
+			"stderr":    stderr,
+		}
+	}
+
+	fields := resultFields(out, resultTypes(fn))
+	fields["success"] = true
+	fields["output"] = output
+	fields["stderr"] = stderr
+	fields["error"] = nil
+	return fields
+}
+
+// resultFields returns the fields of a result reporting the values
+// returned by a function whose result types are types: value for a single
+// one, values for several, in order, and goError for a trailing error, nil
+// or its message, with errorChain and errorIs when not nil. A returned
+// error is the outcome of the operation, not a failure of the call, which
+// stays successful. Without types, as for the values of an expression
+// yaegi does not tell, a trailing error is only recognized when not nil.
+func resultFields(values []reflect.Value, types []reflect.Type) map[string]interface{} {
+	res := map[string]interface{}{}
+	n := len(values)
+	if n > 0 {
+		last := values[n-1]
+		switch {
+		case len(types) == n && types[n-1] == errorType:
+			res["goError"] = nil
+			if last.IsValid() && !last.IsNil() {
+				setGoError(res, last.Interface().(error))
+			}
+			values = values[:n-1]
+		case len(types) != n && last.IsValid() && last.Type().Implements(errorType):
+			setGoError(res, last.Interface().(error))
+			values = values[:n-1]
+		}
+	}
+
+	switch len(values) {
+	case 0:
+		res["value"] = nil
+	case 1:
+		res["value"] = goValueToJS(values[0])
+	default:
+		items := make([]interface{}, len(values))
+		for i, v := range values {
+			items[i] = goValueToJS(v)
+		}
+		res["values"] = items
+	}
+	return res
+}
+
+// setGoError sets in res the fields of the returned error err: goError,
+// its message, and those of errorFields.
+func setGoError(res map[string]interface{}, err error) {
+	res["goError"] = err.Error()
+	for key, value := range errorFields(err) {
+		res[key] = value
+	}
+}
+
+// resultTypes returns the result types of the function fn, or nil if it
+// is not one.
+func resultTypes(fn reflect.Value) []reflect.Type {
+	if !fn.IsValid() || fn.Kind() != reflect.Func {
+		return nil
+	}
+	t := fn.Type()
+	types := make([]reflect.Type, t.NumOut())
+	for i := range types {
+		types[i] = t.Out(i)
+	}
+	return types
+}
+
+// callArgs converts JS arguments to the parameters of a function of type t.
+func callArgs(t reflect.Type, args []js.Value) ([]reflect.Value, error) {
+	n := t.NumIn()
+	if t.IsVariadic() {
+		if len(args) < n-1 {
+			return nil, fmt.Errorf("want at least %d arguments, got %d", n-1, len(args))
+		}
+	} else if len(args) != n {
+		return nil, fmt.Errorf("want %d arguments, got %d", n, len(args))
+	}
+
+	in := make([]reflect.Value, len(args))
+	for i, a := range args {
+		pt := t.In(min(i, n-1))
+		if t.IsVariadic() && i >= n-1 {
+			pt = pt.Elem()
+		}
+		v, err := jsToValue(a, pt)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d: %v", i+1, err)
+		}
+		in[i] = v
+	}
+	return in, nil
+}
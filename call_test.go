@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMultipleResults(t *testing.T) {
+	t.Cleanup(func() { callAPI(t, "reset") })
+	mustSucceed(t, callAPI(t, "eval", `package main
+
+import "strconv"
+
+func atoi(s string) (int, error) { return strconv.Atoi(s) }
+
+func parts() (int, string, bool) { return 1, "a", true }
+
+func main() {}
+`))
+
+	for _, c := range []struct {
+		name    string
+		command string
+		args    []interface{}
+		value   string // JSON
+		goError string // a part of it, "" for null
+	}{
+		{"call ok", "call", []interface{}{"atoi", "42"}, "42", ""},
+		{"call error", "call", []interface{}{"atoi", "x"}, "0", `parsing "x": invalid syntax`},
+		{"call strconv ok", "call", []interface{}{"strconv.Atoi", "9"}, "9", ""},
+		{"call strconv error", "call", []interface{}{"strconv.Atoi", "x"}, "0", `parsing "x": invalid syntax`},
+		{"expr ok", "evalExpr", []interface{}{`atoi("42")`}, "42", ""},
+		{"expr error", "evalExpr", []interface{}{`atoi("x")`}, "0", `parsing "x": invalid syntax`},
+		{"expr strconv ok", "evalExpr", []interface{}{`strconv.Atoi("7")`}, "7", ""},
+		{"expr strconv error", "evalExpr", []interface{}{`strconv.Atoi("x")`}, "0", `parsing "x": invalid syntax`},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			res := callAPI(t, c.command, c.args...)
+			mustSucceed(t, res)
+			if got := jsonString(res.Get("value")); got != c.value {
+				t.Errorf("value = %s, want %s in %s", got, c.value, jsonString(res))
+			}
+			goError := res.Get("goError")
+			switch {
+			case c.goError == "" && !goError.IsNull():
+				t.Errorf("goError = %s, want null", jsonString(goError))
+			case c.goError != "" && !strings.Contains(goError.String(), c.goError):
+				t.Errorf("goError = %s, want it to have %q", jsonString(goError), c.goError)
+			}
+		})
+	}
+
+	res := callAPI(t, "call", "parts")
+	mustSucceed(t, res)
+	if got, want := jsonString(res.Get("values")), `[1,"a",true]`; got != want {
+		t.Errorf("values of parts() = %s, want %s", got, want)
+	}
+}
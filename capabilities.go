@@ -0,0 +1,119 @@
+package main
+
+import (
+	"path"
+	"sort"
+	"syscall/js"
+)
+
+// evalOptionCommands are the commands reading eval options, parsed by
+// parseEvalOptions, by the index of their options argument.
+var evalOptionCommands = map[string]int{
+	"eval":      1,
+	"evalAsync": 1,
+	"evalFiles": 1,
+	"evalURL":   1,
+	"evalIn":    2,
+	"test":      1,
+	"bench":     1,
+	"examples":  1,
+	"check":     1,
+	"submit":    1,
+	"start":     1,
+	"compile":   1,
+	"run":       1,
+	"debug":     1,
+}
+
+// installedCommands are the names of the commands installed by main,
+// sorted.
+var installedCommands []string
+
+// optionKeys returns the keys of the options object that parse reads, sorted:
+// it is handed a Proxy recording them.
+func optionKeys(parse func(v js.Value)) []string {
+	seen := map[string]bool{}
+	get := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		if args[1].Type() == js.TypeString {
+			seen[args[1].String()] = true
+		}
+		return js.Undefined()
+	})
+	defer get.Release()
+	handler := js.Global().Get("Object").New()
+	handler.Set("get", get)
+	parse(js.Global().Get("Proxy").New(js.Global().Get("Object").New(), handler))
+
+	keys := make([]string, 0, len(seen))
+	for k := range seen {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// capabilities describes what this build supports, for hosts pinned to
+// other versions: {version, commands, options, limits, modes,
+// symbolBundles, worker}, beside the apiVersion of the envelope. commands
+// lists the commands installed, options the option keys of the eval
+// commands, as read by parseEvalOptions, of createSession and of
+// configure, limits the settings bounding evals, modes the values of the
+// options taking one of a few, and symbolBundles the import paths of the
+// third-party packages compiled in.
+func capabilities(this js.Value, args []js.Value) interface{} {
+	evalKeys := stringsToJS(optionKeys(func(v js.Value) { parseEvalOptions(v) }))
+	options := map[string]interface{}{}
+	for _, name := range installedCommands {
+		if _, ok := evalOptionCommands[name]; ok {
+			options[name] = evalKeys
+		}
+	}
+	options["createSession"] = stringsToJS(optionKeys(func(v js.Value) { parseSessionConfig(v, sessionConfig{}) }))
+	var configKeys []string
+	for k := range currentConfig() {
+		configKeys = append(configKeys, k)
+	}
+	sort.Strings(configKeys)
+	options["configure"] = stringsToJS(configKeys)
+
+	var bundles []string
+	seen := map[string]bool{}
+	for _, syms := range extraSymbols {
+		for key := range syms {
+			if p := path.Dir(key); !seen[p] {
+				seen[p] = true
+				bundles = append(bundles, p)
+			}
+		}
+	}
+	sort.Strings(bundles)
+
+	maxOutput, abort := outputLimits()
+	return map[string]interface{}{
+		"success":  true,
+		"version":  versionInfo(),
+		"commands": stringsToJS(installedCommands),
+		"options":  options,
+		"limits": map[string]interface{}{
+			"maxOutputBytes":     maxOutput,
+			"abortOnOutputLimit": abort,
+			"maxHeapBytes":       maxHeapBytes(),
+			"maxRuns":            maxRuns(),
+			"maxCallDepth":       maxCallDepth(),
+			"defaultTimeoutMs":   nil, // evals have no deadline but their timeoutMs
+			"maxDecodedBytes":    maxDecodedBytes,
+			"maxURLBytes":        defaultURLBytes,
+			"maxUploadBytes":     maxTransferBytes,
+			"maxTransfersBytes":  maxTransfersBytes,
+		},
+		"modes": map[string]interface{}{
+			"fs":       stringsToJS(fsModes),
+			"output":   stringsToJS(outputModes),
+			"echo":     stringsToJS(echoModes),
+			"encoding": stringsToJS(sourceEncodings),
+		},
+		"symbolBundles": stringsToJS(bundles),
+		"worker":        workerMode(),
+		"tzdata":        tzdataAvailable(),
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strings"
+	"syscall/js"
+)
+
+// checkSource parses and type-checks Go source code without running it and
+// reports its errors as diagnostics: {success, error, diagnostics}. The
+// code is compiled by a throwaway interpreter built from the configuration
+// of the default session, so it sees the session files and packages but
+// none of its globals, and the session is left untouched. With the option
+// mode set to "snippet", the source is checked as in snippet mode. It does
+// not use the session interpreter, so it can run while an evaluation is in
+// flight.
+func checkSource(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 || len(args) > 2 || args[0].Type() != js.TypeString {
+		return map[string]interface{}{
+			"success": false,
+			"error":   "check requires the Go source code and an optional options object",
+		}
+	}
+	sourceCode := args[0].String()
+	opts := parseEvalOptions(optionArg(args, 1))
+
+	code := sourceCode
+	var sn *snippet
+	if opts.snippet {
+		if sn = wrapSnippet(sourceCode); sn != nil {
+			code = strings.Join(sn.lines, "\n")
+		}
+	}
+
+	s := defaultSession()
+	var err error
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				err = internalPanic{r}
+			}
+		}()
+		_, err = s.interpreterFor(s.files, nil).Compile(code)
+	}()
+	res := map[string]interface{}{
+		"success":     true,
+		"error":       nil,
+		"diagnostics": []interface{}{},
+	}
+	if err = s.config.sandboxError(err); err != nil {
+		res["success"] = false
+		res["error"] = err.Error()
+		res["errorCode"] = errorCode(err)
+		res["diagnostics"] = errorDiagnostics(err, code, "")
+	}
+	if sn != nil {
+		res = positionChain{sn.srcMap}.remap(res)
+	}
+	return res
+}
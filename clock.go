@@ -0,0 +1,99 @@
+package main
+
+import (
+	"reflect"
+	"syscall/js"
+	"time"
+
+	"github.com/traefik/yaegi/interp"
+)
+
+// clock is the time of interpreted code, set by setClock.
+type clock struct {
+	fixed      time.Time     // time of time.Now, zero to follow the real time
+	offset     time.Duration // added to the real time
+	sleepScale float64       // factor of the durations of time.Sleep
+}
+
+func (c *clock) now() time.Time {
+	if !c.fixed.IsZero() {
+		return c.fixed
+	}
+	return time.Now().Add(c.offset)
+}
+
+// clockSymbols returns the time functions of interpreted code reading the
+// clock of s. The session itself, e.g. for the stats of evals, keeps the
+// real time.
+func (s *session) clockSymbols() interp.Exports {
+	now := func() time.Time {
+		if c := s.clock.Load(); c != nil {
+			return c.now()
+		}
+		return time.Now()
+	}
+	return interp.Exports{"time/time": {
+		"Now":   reflect.ValueOf(now),
+		"Since": reflect.ValueOf(func(t time.Time) time.Duration { return now().Sub(t) }),
+		"Until": reflect.ValueOf(func(t time.Time) time.Duration { return t.Sub(now()) }),
+		"Sleep": reflect.ValueOf(func(d time.Duration) {
+			if c := s.clock.Load(); c != nil {
+				d = time.Duration(float64(d) * c.sleepScale)
+			}
+			if d > 0 {
+				time.Sleep(d)
+			}
+		}),
+	}}
+}
+
+// setClock sets the clock of interpreted code in the default session from
+// an options object: fixed, an RFC 3339 time returned by every time.Now,
+// or offsetMs, added to the real time, and scaleSleep, the factor of the
+// durations of time.Sleep, 0 returning at once. null restores the real
+// time. It applies to the running code as well.
+func setClock(this js.Value, args []js.Value) interface{} {
+	opts := optionArg(args, 0)
+	if opts.Type() == js.TypeNull || opts.IsUndefined() {
+		defaultSession().clock.Store(nil)
+		return map[string]interface{}{"success": true}
+	}
+	if opts.Type() != js.TypeObject {
+		return map[string]interface{}{
+			"success": false,
+			"error":   "setClock requires an options object or null",
+		}
+	}
+
+	c := &clock{sleepScale: 1}
+	fixed, offset := opts.Get("fixed"), opts.Get("offsetMs")
+	switch {
+	case !fixed.IsUndefined() && !offset.IsUndefined():
+		return map[string]interface{}{
+			"success": false,
+			"error":   "setClock takes fixed or offsetMs, not both",
+		}
+	case fixed.Type() == js.TypeString:
+		t, err := time.Parse(time.RFC3339Nano, fixed.String())
+		if err != nil {
+			return map[string]interface{}{
+				"success": false,
+				"error":   "fixed: " + err.Error(),
+			}
+		}
+		c.fixed = t
+	case !fixed.IsUndefined():
+		return map[string]interface{}{
+			"success": false,
+			"error":   "fixed must be an RFC 3339 time string",
+		}
+	case offset.Type() == js.TypeNumber:
+		c.offset = time.Duration(offset.Float() * float64(time.Millisecond))
+	}
+	if scale := opts.Get("scaleSleep"); scale.Type() == js.TypeNumber {
+		c.sleepScale = max(scale.Float(), 0)
+	}
+
+	defaultSession().clock.Store(c)
+	return map[string]interface{}{"success": true}
+}
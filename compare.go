@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go/token"
+	"io"
+	"math"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"syscall/js"
+	"time"
+)
+
+const (
+	// defaultCompareRounds and defaultCompareWarmup are the measured and
+	// unmeasured calls of each side of compare unless set.
+	defaultCompareRounds = 5
+	defaultCompareWarmup = 1
+	// maxCompareRounds bounds the rounds and the warm-up calls.
+	maxCompareRounds = 1000
+	// defaultCompareTimeout is the time each side of compare may take
+	// unless set by timeoutMs.
+	defaultCompareTimeout = 30 * time.Second
+)
+
+// compareSettings are the options of compare.
+type compareSettings struct {
+	args    []js.Value
+	rounds  int
+	warmup  int
+	timeout time.Duration
+}
+
+// compareFuncs benchmarks the function named by its third argument in the
+// two Go sources given first, each evaluated in a throwaway interpreter of
+// the default session: yaegi.compare(srcA, srcB, "Fib", {args: [30],
+// rounds: 5}). The function is called with args, converted as by call,
+// warmup times (1 by default) and then rounds times (5) measured. The
+// option timeoutMs bounds each side, 30 seconds by default. The result is
+// {success, a, b, verdict}, each side {meanNs, medianNs, stddevNs, minNs,
+// rounds, result} with result the value or values returned, and verdict
+// {faster, ratio, resultsMatch}: faster is "a", "b" or "tie" when the means
+// are within a standard deviation, ratio the mean of the slower side over
+// that of the faster, and resultsMatch false when the sides returned
+// different results, so that the code compared is likely not equivalent.
+// A side failing to evaluate, call or finish in time fails the comparison,
+// its error reported on both the result and the side.
+func compareFuncs(this js.Value, args []js.Value) interface{} {
+	if len(args) < 3 || len(args) > 4 || args[0].Type() != js.TypeString ||
+		args[1].Type() != js.TypeString || args[2].Type() != js.TypeString {
+		return map[string]interface{}{
+			"success":   false,
+			"error":     "compare requires two Go sources, a function name and an optional options object",
+			"errorCode": codeBadRequest,
+		}
+	}
+	name := args[2].String()
+	for _, part := range strings.Split(name, ".") {
+		if !token.IsIdentifier(part) {
+			return map[string]interface{}{
+				"success":   false,
+				"error":     fmt.Sprintf("compare: invalid function name %q", name),
+				"errorCode": codeBadRequest,
+			}
+		}
+	}
+	settings, err := parseCompareSettings(optionArg(args, 3))
+	if err != nil {
+		return map[string]interface{}{
+			"success":   false,
+			"error":     "compare: " + err.Error(),
+			"errorCode": codeBadRequest,
+		}
+	}
+
+	s := defaultSession()
+	if res := s.admit(); res != nil {
+		return res
+	}
+	started := time.Now()
+	a, resA, errA := compareSide(s, args[0].String(), name, settings)
+	b, resB, errB := compareSide(s, args[1].String(), name, settings)
+	s.chargeCPU(time.Since(started))
+	res := map[string]interface{}{
+		"success": true,
+		"a":       a,
+		"b":       b,
+	}
+	for _, side := range []struct {
+		name   string
+		result map[string]interface{}
+		err    error
+	}{{"a", a, errA}, {"b", b, errB}} {
+		if side.err != nil {
+			res["success"] = false
+			res["error"] = fmt.Sprintf("compare: side %s: %v", side.name, side.err)
+			res["errorCode"] = side.result["errorCode"]
+			return res
+		}
+	}
+
+	meanA, meanB := a["meanNs"].(float64), b["meanNs"].(float64)
+	verdict := map[string]interface{}{
+		"faster":       "tie",
+		"ratio":        1.0,
+		"resultsMatch": sameResult(resA, resB),
+	}
+	if math.Abs(meanA-meanB) > math.Max(a["stddevNs"].(float64), b["stddevNs"].(float64)) {
+		verdict["faster"] = "a"
+		if meanB < meanA {
+			verdict["faster"] = "b"
+		}
+	}
+	if fast := math.Min(meanA, meanB); fast > 0 {
+		verdict["ratio"] = math.Max(meanA, meanB) / fast
+	}
+	res["verdict"] = verdict
+	return res
+}
+
+// parseCompareSettings reads the options of compare.
+func parseCompareSettings(o js.Value) (compareSettings, error) {
+	c := compareSettings{
+		rounds:  defaultCompareRounds,
+		warmup:  defaultCompareWarmup,
+		timeout: defaultCompareTimeout,
+	}
+	if o.Type() != js.TypeObject {
+		return c, nil
+	}
+	if a := o.Get("args"); !a.IsUndefined() && !a.IsNull() {
+		if !js.Global().Get("Array").Call("isArray", a).Bool() {
+			return c, fmt.Errorf("args must be an array")
+		}
+		c.args = make([]js.Value, a.Length())
+		for i := range c.args {
+			c.args[i] = a.Index(i)
+		}
+	}
+	if r := o.Get("rounds"); r.Type() == js.TypeNumber {
+		c.rounds = r.Int()
+		if c.rounds < 1 || c.rounds > maxCompareRounds {
+			return c, fmt.Errorf("rounds must be between 1 and %d", maxCompareRounds)
+		}
+	}
+	if w := o.Get("warmup"); w.Type() == js.TypeNumber {
+		c.warmup = w.Int()
+		if c.warmup < 0 || c.warmup > maxCompareRounds {
+			return c, fmt.Errorf("warmup must be between 0 and %d", maxCompareRounds)
+		}
+	}
+	if ms := optionInt(o, "timeoutMs"); ms > 0 {
+		c.timeout = time.Duration(ms) * time.Millisecond
+	}
+	return c, nil
+}
+
+// compareSide evaluates src in an interpreter of its own and times the
+// calls of its function name. It returns the side reported by compare,
+// the result fields of the last call, as by resultFields, and the error
+// failing the side.
+func compareSide(s *session, src, name string, c compareSettings) (map[string]interface{}, map[string]interface{}, error) {
+	side := map[string]interface{}{}
+	fail := func(err error) (map[string]interface{}, map[string]interface{}, error) {
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = fmt.Errorf("timed out after %v: %w", c.timeout, err)
+		}
+		side["success"] = false
+		side["error"] = err.Error()
+		side["errorCode"] = errorCode(err)
+		return side, nil, err
+	}
+
+	i := s.interpreterWith(s.files, nil, strings.NewReader(""), io.Discard, io.Discard)
+	d, err := newDriver(i)
+	if err != nil {
+		return fail(err)
+	}
+	// The timeout starts once the interpreter is built, which takes a while.
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	if _, err := i.EvalWithContext(ctx, src); err != nil {
+		return fail(s.config.sandboxError(err))
+	}
+	fn, err := i.Eval(name)
+	if err != nil {
+		return fail(err)
+	}
+	if fn.Kind() != reflect.Func {
+		return fail(fmt.Errorf("%s is not a function", name))
+	}
+
+	var out []reflect.Value
+	times := make([]float64, 0, c.rounds)
+	for round := 0; round < c.warmup+c.rounds; round++ {
+		// Converted for every call, as the function may change them.
+		in, err := callArgs(fn.Type(), c.args)
+		if err != nil {
+			side, _, err := fail(fmt.Errorf("call %s: %v", name, err))
+			side["errorCode"] = codeBadRequest
+			return side, nil, err
+		}
+		runtime.GC()
+		var elapsed time.Duration
+		var callErr error
+		err = d.call(ctx, func() {
+			defer func() {
+				if r := recover(); r != nil {
+					callErr = fmt.Errorf("panic: %v", r)
+					if e, ok := r.(exitStatus); ok {
+						callErr = e
+					}
+				}
+			}()
+			started := time.Now()
+			out = fn.Call(in)
+			elapsed = time.Since(started)
+		})
+		if err == nil {
+			err = callErr
+		}
+		if err != nil {
+			return fail(err)
+		}
+		if round >= c.warmup {
+			times = append(times, float64(elapsed.Nanoseconds()))
+		}
+	}
+
+	fields := resultFields(out, resultTypes(fn))
+	mean, median, stddev := timeStats(times)
+	side["success"] = true
+	side["meanNs"] = mean
+	side["medianNs"] = median
+	side["stddevNs"] = stddev
+	side["minNs"] = times[0]
+	side["rounds"] = len(times)
+	side["result"] = fields["value"]
+	if values, ok := fields["values"]; ok {
+		side["result"] = values
+	}
+	if goErr, ok := fields["goError"]; ok {
+		side["goError"] = goErr
+	}
+	return side, fields, nil
+}
+
+// timeStats returns the mean, median and standard deviation of times,
+// which it sorts.
+func timeStats(times []float64) (mean, median, stddev float64) {
+	sort.Float64s(times)
+	for _, t := range times {
+		mean += t
+	}
+	mean /= float64(len(times))
+	n := len(times)
+	median = times[n/2]
+	if n%2 == 0 {
+		median = (times[n/2-1] + times[n/2]) / 2
+	}
+	for _, t := range times {
+		stddev += (t - mean) * (t - mean)
+	}
+	stddev = math.Sqrt(stddev / float64(n))
+	return mean, median, stddev
+}
+
+// sameResult reports whether the values a and b, marshalled by
+// goValueToJS, are deeply equal as JS would see them: numbers of any Go
+// type compare by value, and byte slices by their contents.
+func sameResult(a, b interface{}) bool {
+	if x, ok := jsNumber(a); ok {
+		y, ok := jsNumber(b)
+		return ok && x == y
+	}
+	switch a := a.(type) {
+	case map[string]interface{}:
+		b, ok := b.(map[string]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for k, v := range a {
+			w, ok := b[k]
+			if !ok || !sameResult(v, w) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		b, ok := b.([]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !sameResult(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	case js.Value:
+		b, ok := b.(js.Value)
+		if !ok {
+			return false
+		}
+		if a.Type() != js.TypeObject || b.Type() != js.TypeObject || a.Length() != b.Length() {
+			return a.Equal(b)
+		}
+		x, y := make([]byte, a.Length()), make([]byte, b.Length())
+		js.CopyBytesToGo(x, a)
+		js.CopyBytesToGo(y, b)
+		return string(x) == string(y)
+	}
+	return a == b
+}
+
+// jsNumber returns the number a marshalled number v stands for in JS.
+func jsNumber(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
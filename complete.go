@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"go/constant"
+	"go/scanner"
+	"go/token"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall/js"
+	"unicode"
+	"unicode/utf8"
+)
+
+// completion is a candidate offered by complete.
+type completion struct {
+	label string
+	kind  string // "func", "type", "var", "const" or "package"
+	typ   string
+	pkg   string // import path, "main" for session globals
+}
+
+func (c completion) toJS() map[string]interface{} {
+	return map[string]interface{}{
+		"label": c.label,
+		"kind":  c.kind,
+		"type":  c.typ,
+		"pkg":   c.pkg,
+	}
+}
+
+// complete returns the completion candidates for the identifier ending at
+// a byte offset of the source: the exported symbols of a package after its
+// qualifier, or else the default session globals and the package names.
+// The source is only scanned, so it does not have to parse. The globals
+// being those of the interpreter, it fails as busy while an eval uses it.
+func complete(this js.Value, args []js.Value) interface{} {
+	if len(args) != 2 || args[0].Type() != js.TypeString || args[1].Type() != js.TypeNumber {
+		return map[string]interface{}{
+			"success": false,
+			"error":   "complete requires the Go source code and a byte offset",
+		}
+	}
+	src := args[0].String()
+	offset := min(max(args[1].Int(), 0), len(src))
+
+	s := defaultSession()
+	if err := s.acquireEval(context.Background(), false); err != nil {
+		return map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+			"busy":    true,
+		}
+	}
+	defer s.releaseEval()
+
+	list := []interface{}{}
+	for _, c := range s.completions(src, offset) {
+		list = append(list, c.toJS())
+	}
+	return list
+}
+
+// completions returns the candidates for the identifier ending at offset in
+// src, sorted by label.
+func (s *session) completions(src string, offset int) (out []completion) {
+	defer func() {
+		// Listing the symbols of a broken declaration may panic.
+		if recover() != nil {
+			out = nil
+		}
+	}()
+
+	imports, ok := scanImports(src, offset)
+	if !ok {
+		// Strings and comments have no completions.
+		return nil
+	}
+
+	prefix := identBefore(src, offset)
+	start := offset - len(prefix)
+	var list []completion
+	if start > 0 && src[start-1] == '.' {
+		qualifier := identBefore(src, start-1)
+		if qualifier == "" {
+			return nil
+		}
+		p, ok := imports[qualifier]
+		if !ok {
+			c, found := chooseImport(qualifier, nil, s.importCandidates()[qualifier])
+			if !found {
+				return nil
+			}
+			p = c.path
+		}
+		for name, v := range s.packageSymbols(p) {
+			if token.IsExported(name) {
+				list = append(list, symbolCompletion(name, v, p))
+			}
+		}
+		if s.config.allows(p) {
+			for name, sig := range genericFuncs()[p] {
+				list = append(list, completion{label: name, kind: "func", typ: "func" + strings.TrimPrefix(sig, "func "+name), pkg: p})
+			}
+		}
+	} else {
+		for name, v := range s.interpreter.Globals() {
+			list = append(list, symbolCompletion(name, v, "main"))
+		}
+		for name, v := range s.interpreter.Symbols("main")["main"] {
+			if v.Kind() == reflect.Func || v.Kind() == reflect.Ptr && !v.CanAddr() {
+				list = append(list, symbolCompletion(name, v, "main"))
+			}
+		}
+		for name, cs := range s.importCandidates() {
+			for _, c := range cs {
+				list = append(list, completion{label: name, kind: "package", pkg: c.path})
+			}
+		}
+	}
+
+	for _, c := range list {
+		if strings.HasPrefix(c.label, prefix) {
+			out = append(out, c)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].label != out[j].label {
+			return out[i].label < out[j].label
+		}
+		return out[i].pkg < out[j].pkg
+	})
+	return out
+}
+
+// symbolCompletion describes the symbol name of package pkg.
+func symbolCompletion(name string, v reflect.Value, pkg string) completion {
+	c := completion{label: name, pkg: pkg}
+	switch {
+	case !v.IsValid():
+		c.kind = "var"
+	case v.Kind() == reflect.Func && !v.CanAddr():
+		c.kind, c.typ = "func", v.Type().String()
+	case v.Kind() == reflect.Ptr && !v.CanAddr():
+		// Types are registered as pointers to a value of the type.
+		c.kind, c.typ = "type", v.Type().Elem().Kind().String()
+	case v.CanAddr():
+		c.kind, c.typ = "var", v.Type().String()
+	default:
+		c.kind, c.typ = "const", v.Type().String()
+		if cv, ok := v.Interface().(constant.Value); ok {
+			c.typ = "untyped " + strings.ToLower(cv.Kind().String())
+		}
+	}
+	return c
+}
+
+// identBefore returns the identifier characters of src ending at offset.
+func identBefore(src string, offset int) string {
+	start := offset
+	for start > 0 {
+		r, size := utf8.DecodeLastRuneInString(src[:start])
+		if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			break
+		}
+		start -= size
+	}
+	return src[start:offset]
+}
+
+// scanImports returns the packages imported by src, by name, scanning it
+// to the end. It reports false if offset is within a string, a character
+// literal or a comment.
+func scanImports(src string, offset int) (map[string]string, bool) {
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(src))
+	var sc scanner.Scanner
+	sc.Init(file, []byte(src), nil, scanner.ScanComments)
+
+	imports := map[string]string{}
+	inImport, group, name := false, false, ""
+	for {
+		pos, tok, lit := sc.Scan()
+		if tok == token.EOF {
+			return imports, true
+		}
+		switch start := file.Offset(pos); tok {
+		case token.STRING, token.CHAR, token.COMMENT:
+			// The end of an unterminated literal or of a line comment is
+			// still inside.
+			end := start + len(lit)
+			open := len(lit) < 2 || lit[len(lit)-1] != lit[0] || strings.HasPrefix(lit, "//")
+			if start < offset && (offset < end || open && offset == end) {
+				return nil, false
+			}
+		}
+
+		switch {
+		case tok == token.IMPORT:
+			inImport, group, name = true, false, ""
+		case !inImport:
+		case tok == token.LPAREN:
+			group = true
+		case tok == token.IDENT || tok == token.PERIOD:
+			name = lit
+			if tok == token.PERIOD {
+				name = "."
+			}
+		case tok == token.STRING:
+			if p, err := strconv.Unquote(lit); err == nil && name != "_" && name != "." {
+				if name == "" {
+					name = importName([]scanned{{tok: tok, lit: lit}})
+				}
+				imports[name] = p
+			}
+			name = ""
+			inImport = group
+		case tok == token.RPAREN:
+			inImport = false
+		}
+	}
+}
@@ -0,0 +1,650 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall/js"
+	"time"
+
+	"github.com/traefik/yaegi/interp"
+	"github.com/traefik/yaegi/stdlib"
+	"github.com/traefik/yaegi/stdlib/unrestricted"
+)
+
+const (
+	// defaultMaxOutput is the default cap on the output of an eval.
+	defaultMaxOutput = 4 << 20
+	// defaultMaxRuns is the default cap on the active runs of a session.
+	defaultMaxRuns = 4
+	// defaultMaxJobResults is the default cap on the job results kept.
+	defaultMaxJobResults = 100
+	// defaultMaxCallDepth is the default cap on the interpreted frames of a
+	// goroutine, well below the depth at which the JS stack overflows.
+	defaultMaxCallDepth = 3000
+)
+
+// settings holds the options set through yaegi.configure.
+var settings = struct {
+	sync.Mutex
+	queueEvals         bool         // queue concurrent evals instead of failing with errBusy
+	maxOutputBytes     int          // cap on stdout and stderr bytes per eval, 0 for none
+	abortOnOutputLimit bool         // abort evals exceeding maxOutputBytes
+	autoRecover        bool         // rebuild interpreters after a fatal error, see handleFatal
+	maxRuns            int          // cap on the active runs of a session, 0 for none, see startRun
+	maxHeapBytes       int          // cap on the heap in use during an eval, 0 for none, see heapWatch
+	output             string       // where eval output goes, see outputModes
+	maxJobResults      int          // cap on the results of jobs not fetched, 0 for none, see submit
+	legacyCompat       bool         // return results in their flat shape, see envelope
+	fs                 string       // access of interpreted code to the filesystem, see fsModes
+	networkAllow       []string     // hosts interpreted code may reach, see hostAllowed
+	maxCacheEntries    int          // cap on the results kept by the option cache, see cachedEval
+	maxEvalDepth       int          // cap on the evals nesting in an eval, see nestedSession
+	maxCallDepth       int          // cap on the interpreted frames of a goroutine, 0 for none, see stackOverflow
+	importResolver     js.Value     // function resolving unknown imports, see resolveImports
+	render             renderLimits // of valueString and echoes, see renderValue
+	quota              quota        // bounds on the use of each session, see admit
+}{
+	maxOutputBytes:  defaultMaxOutput,
+	maxRuns:         defaultMaxRuns,
+	output:          "capture",
+	maxJobResults:   defaultMaxJobResults,
+	fs:              "none",
+	maxCacheEntries: defaultMaxCacheEntries,
+	maxEvalDepth:    defaultMaxEvalDepth,
+	maxCallDepth:    defaultMaxCallDepth,
+	render:          defaultRender,
+}
+
+// outputModes are the values of the option output of configure: the output
+// of evals is captured into their results, written to the browser console
+// line by line, or both.
+var outputModes = []string{"capture", "console", "both"}
+
+// fsModes are the values of the option fs of configure: interpreted code
+// may not use the session filesystem, may only read it, or may also write
+// it. Commands such as writeFile provision it in every mode.
+var fsModes = []string{"none", "virtual-readonly", "virtual"}
+
+// sessionConfig holds the options used to build a session interpreter.
+type sessionConfig struct {
+	env          []string // "KEY=value" entries
+	args         []string // os.Args of evaluated programs
+	unrestricted bool     // allow non sandboxed stdlib symbols
+	packages     []string // stdlib import paths to load, nil for all
+	randSeed     *int64   // seed of math/rand, nil for a time-based one
+	replMode     bool     // let evals declare again what earlier ones did, see replSource
+}
+
+// parseSessionConfig returns base updated with the session options set in v.
+func parseSessionConfig(v js.Value, base sessionConfig) sessionConfig {
+	c := base
+	if v.Type() != js.TypeObject {
+		return c
+	}
+
+	if env := v.Get("env"); !env.IsUndefined() {
+		c.env = envEntries(v)
+	}
+	if args := v.Get("args"); !args.IsUndefined() {
+		c.args = optionStrings(v, "args")
+	}
+	if u := v.Get("unrestricted"); u.Type() == js.TypeBoolean {
+		c.unrestricted = u.Bool()
+	}
+	if r := v.Get("replMode"); r.Type() == js.TypeBoolean {
+		c.replMode = r.Bool()
+	}
+	switch seed := v.Get("randSeed"); seed.Type() {
+	case js.TypeNumber:
+		n := int64(seed.Int())
+		c.randSeed = &n
+	case js.TypeNull:
+		c.randSeed = nil
+	}
+	// allowPackages is the sandbox spelling of stdlibPackages.
+	for _, key := range []string{"stdlibPackages", "allowPackages"} {
+		if pkgs := v.Get(key); !pkgs.IsUndefined() {
+			c.packages = nil
+			if !pkgs.IsNull() {
+				c.packages = optionStrings(v, key)
+			}
+		}
+	}
+	return c
+}
+
+func (c sessionConfig) toJS() map[string]interface{} {
+	var packages, randSeed interface{}
+	if c.packages != nil {
+		packages = stringsToJS(c.packages)
+	}
+	if c.randSeed != nil {
+		randSeed = *c.randSeed
+	}
+	return map[string]interface{}{
+		"env":            stringsToJS(c.env),
+		"args":           stringsToJS(c.args),
+		"unrestricted":   c.unrestricted,
+		"mode":           c.mode(),
+		"stdlibPackages": packages,
+		"randSeed":       randSeed,
+		"replMode":       c.replMode,
+	}
+}
+
+// mode names the sandboxing mode of sessions using c.
+func (c sessionConfig) mode() string {
+	if c.unrestricted {
+		return "unrestricted"
+	}
+	return "restricted"
+}
+
+// symbols returns the symbol sets loaded, in order, by sessions using c.
+func (c sessionConfig) symbols() []interp.Exports {
+	sets := append([]interp.Exports{stdlib.Symbols, jsSymbols}, extraSymbols...)
+	if c.unrestricted {
+		sets = append(sets, unrestrictedSymbols())
+	}
+	if c.packages == nil {
+		return sets
+	}
+
+	for i, set := range sets {
+		filtered := interp.Exports{}
+		for key, values := range set {
+			// Keys are of the form "import/path/name".
+			if c.allows(path.Dir(key)) {
+				filtered[key] = values
+			}
+		}
+		sets[i] = filtered
+	}
+	return sets
+}
+
+// allows reports whether sessions using c may import the package pkg.
+func (c sessionConfig) allows(pkg string) bool {
+	if c.packages == nil {
+		return true
+	}
+	for _, p := range c.packages {
+		if p == pkg {
+			return true
+		}
+	}
+	return false
+}
+
+// unrestrictedSymbols returns the yaegi unrestricted symbols, except for
+// those terminating the process: in wasm, exiting would kill the whole
+// module, so os.Exit and syscall.Exit end the eval instead and log.Fatal
+// keeps the version installed by exitSymbols.
+func unrestrictedSymbols() interp.Exports {
+	syms := interp.Exports{}
+	for key, values := range unrestricted.Symbols {
+		if key == "log/log" {
+			continue
+		}
+		m := make(map[string]reflect.Value, len(values))
+		for name, v := range values {
+			m[name] = v
+		}
+		if _, ok := m["Exit"]; ok {
+			m["Exit"] = reflect.ValueOf(interceptedExit)
+		}
+		syms[key] = m
+	}
+	return syms
+}
+
+// exitStatus is the panic value of interceptedExit, which evaluations
+// recover to report the exit code.
+type exitStatus int
+
+func (e exitStatus) Error() string {
+	return "exit status " + strconv.Itoa(int(e))
+}
+
+// interceptedExit stands for os.Exit in interpreted code. It unwinds the
+// evaluation rather than the module.
+func interceptedExit(code int) {
+	panic(exitStatus(code))
+}
+
+// exitCode returns the code passed to os.Exit if err results from it.
+func exitCode(err error) (int, bool) {
+	var p interp.Panic
+	if errors.As(err, &p) {
+		err, _ = p.Value.(error)
+	}
+	var e exitStatus
+	if errors.As(err, &e) {
+		return int(e), true
+	}
+	return 0, false
+}
+
+// exitSymbols returns the os.Exit and log.Fatal symbols of the interpreter
+// i, which end the eval with an exit status, for the packages i loaded.
+// log.Fatal writes through the logger yaegi set up for i, then exits with
+// status 1.
+func exitSymbols(i *interp.Interpreter) interp.Exports {
+	syms := interp.Exports{}
+	if _, ok := i.Symbols("os")["os"]["Exit"]; ok {
+		syms["os/os"] = map[string]reflect.Value{"Exit": reflect.ValueOf(interceptedExit)}
+	}
+	v, ok := i.Symbols("log")["log"]["Output"]
+	if !ok {
+		return syms
+	}
+	output := v.Interface().(func(int, string) error)
+	fatal := func(s string) {
+		output(3, s)
+		interceptedExit(1)
+	}
+	syms["log/log"] = map[string]reflect.Value{
+		"Fatal":   reflect.ValueOf(func(v ...interface{}) { fatal(fmt.Sprint(v...)) }),
+		"Fatalf":  reflect.ValueOf(func(format string, v ...interface{}) { fatal(fmt.Sprintf(format, v...)) }),
+		"Fatalln": reflect.ValueOf(func(v ...interface{}) { fatal(fmt.Sprintln(v...)) }),
+	}
+	return syms
+}
+
+// missingSource matches the error yaegi reports for an import that is
+// neither a registered symbol package nor found as source.
+var missingSource = regexp.MustCompile(`^(.*)import "([^"]+)" error: unable to find source related to: "[^"]+".*$`)
+
+// sandboxError rewrites errors caused by importing a package left out of
+// the allow-list into a plain "package not found" error.
+func (c sessionConfig) sandboxError(err error) error {
+	if c.packages == nil || err == nil {
+		return err
+	}
+	m := missingSource.FindStringSubmatch(err.Error())
+	if m == nil {
+		return err
+	}
+	return fmt.Errorf("%simport %q error: package not found (allowed packages: %s)", m[1], m[2], strings.Join(c.effectivePackages(), ", "))
+}
+
+// effectivePackages returns the import paths actually loaded for c.
+func (c sessionConfig) effectivePackages() []string {
+	seen := map[string]bool{}
+	var pkgs []string
+	for _, set := range c.symbols() {
+		for key := range set {
+			if p := path.Dir(key); !seen[p] {
+				seen[p] = true
+				pkgs = append(pkgs, p)
+			}
+		}
+	}
+	sort.Strings(pkgs)
+	return pkgs
+}
+
+// sessionOptions are the options read by parseSessionConfig.
+var sessionOptions = []string{"env", "args", "unrestricted", "stdlibPackages", "allowPackages", "randSeed", "replMode"}
+
+// hasSessionOptions reports whether v sets any of sessionOptions.
+func hasSessionOptions(v js.Value) bool {
+	for _, key := range sessionOptions {
+		if !v.Get(key).IsUndefined() {
+			return true
+		}
+	}
+	return false
+}
+
+// configure updates the module settings from an options object, and
+// rebuilds the default session interpreter if it sets sessionOptions. It
+// returns the effective configuration, and fails while an evaluation is
+// running.
+func configure(this js.Value, args []js.Value) interface{} {
+	opts := optionArg(args, 0)
+	if opts.Type() != js.TypeObject {
+		return map[string]interface{}{
+			"success": false,
+			"error":   "configure requires an options object",
+		}
+	}
+
+	output := opts.Get("output")
+	if !output.IsUndefined() && (output.Type() != js.TypeString || !slices.Contains(outputModes, output.String())) {
+		return map[string]interface{}{
+			"success": false,
+			"error":   `output must be one of "` + strings.Join(outputModes, `", "`) + `"`,
+		}
+	}
+	fsMode := opts.Get("fs")
+	if !fsMode.IsUndefined() && (fsMode.Type() != js.TypeString || !slices.Contains(fsModes, fsMode.String())) {
+		return map[string]interface{}{
+			"success": false,
+			"error":   `fs must be one of "` + strings.Join(fsModes, `", "`) + `"`,
+		}
+	}
+	var allow []string
+	if v := opts.Get("network"); !v.IsUndefined() {
+		var err error
+		if allow, err = networkOption(v); err != nil {
+			return map[string]interface{}{
+				"success": false,
+				"error":   err.Error(),
+			}
+		}
+	}
+	persist := opts.Get("fsPersistence")
+	if !persist.IsUndefined() && !validPersistence(persist) {
+		return map[string]interface{}{
+			"success": false,
+			"error":   errPersistence.Error(),
+		}
+	}
+	q := currentQuota()
+	if v := opts.Get("quota"); !v.IsUndefined() {
+		var err error
+		if q, err = parseQuota(v, q); err != nil {
+			return map[string]interface{}{
+				"success":   false,
+				"error":     err.Error(),
+				"errorCode": codeBadRequest,
+			}
+		}
+	}
+	resolver := opts.Get("importResolver")
+	if !resolver.IsUndefined() && resolver.Type() != js.TypeNull && resolver.Type() != js.TypeFunction {
+		return map[string]interface{}{
+			"success": false,
+			"error":   errResolver.Error(),
+		}
+	}
+
+	s := defaultSession()
+	if err := s.acquireEval(context.Background(), false); err != nil {
+		return map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+			"busy":    true,
+		}
+	}
+	defer s.releaseEval()
+
+	settings.Lock()
+	if v := opts.Get("queueEvals"); v.Type() == js.TypeBoolean {
+		settings.queueEvals = v.Bool()
+	}
+	if v := opts.Get("maxOutputBytes"); v.Type() == js.TypeNumber {
+		settings.maxOutputBytes = max(v.Int(), 0)
+	}
+	if v := opts.Get("abortOnOutputLimit"); v.Type() == js.TypeBoolean {
+		settings.abortOnOutputLimit = v.Bool()
+	}
+	if v := opts.Get("autoRecover"); v.Type() == js.TypeBoolean {
+		settings.autoRecover = v.Bool()
+	}
+	if v := opts.Get("maxRuns"); v.Type() == js.TypeNumber {
+		settings.maxRuns = max(v.Int(), 0)
+	}
+	if v := opts.Get("maxHeapBytes"); v.Type() == js.TypeNumber {
+		settings.maxHeapBytes = max(v.Int(), 0)
+	}
+	if output.Type() == js.TypeString {
+		settings.output = output.String()
+	}
+	if v := opts.Get("maxJobResults"); v.Type() == js.TypeNumber {
+		settings.maxJobResults = max(v.Int(), 0)
+	}
+	if v := opts.Get("legacyCompat"); v.Type() == js.TypeBoolean {
+		settings.legacyCompat = v.Bool()
+	}
+	if v := opts.Get("maxCacheEntries"); v.Type() == js.TypeNumber {
+		settings.maxCacheEntries = max(v.Int(), 0)
+	}
+	if v := opts.Get("maxEvalDepth"); v.Type() == js.TypeNumber {
+		settings.maxEvalDepth = max(v.Int(), 0)
+	}
+	if v := opts.Get("maxCallDepth"); v.Type() == js.TypeNumber {
+		settings.maxCallDepth = max(v.Int(), 0)
+	}
+	settings.render = parseRenderLimits(opts, settings.render)
+	settings.quota = q
+	if fsMode.Type() == js.TypeString {
+		settings.fs = fsMode.String()
+	}
+	if allow != nil {
+		settings.networkAllow = allow
+	}
+	if !resolver.IsUndefined() {
+		settings.importResolver = resolver
+	}
+	settings.Unlock()
+	if !resolver.IsUndefined() {
+		forgetResolved()
+	}
+
+	// Module settings alone apply from the next eval on.
+	if hasSessionOptions(opts) {
+		s := defaultSession()
+		s.config = parseSessionConfig(opts, s.config)
+		s.reset()
+		if !opts.Get("env").IsUndefined() {
+			s.resetEnv()
+		}
+	}
+
+	res := map[string]interface{}{"success": true}
+	if !persist.IsUndefined() {
+		res["fsRestored"] = configurePersistence(persist)
+	}
+	res["config"] = currentConfig()
+	return res
+}
+
+// currentConfig returns the effective settings, including the
+// configuration of the default session.
+func currentConfig() map[string]interface{} {
+	c := defaultSession().config
+	config := c.toJS()
+	if c.packages != nil {
+		config["stdlibPackages"] = stringsToJS(c.effectivePackages())
+	}
+	config["allowPackages"] = config["stdlibPackages"]
+
+	settings.Lock()
+	defer settings.Unlock()
+
+	config["queueEvals"] = settings.queueEvals
+	config["maxOutputBytes"] = settings.maxOutputBytes
+	config["abortOnOutputLimit"] = settings.abortOnOutputLimit
+	config["autoRecover"] = settings.autoRecover
+	config["maxRuns"] = settings.maxRuns
+	config["maxHeapBytes"] = settings.maxHeapBytes
+	config["output"] = settings.output
+	config["maxJobResults"] = settings.maxJobResults
+	config["legacyCompat"] = settings.legacyCompat
+	config["maxCacheEntries"] = settings.maxCacheEntries
+	config["maxEvalDepth"] = settings.maxEvalDepth
+	config["maxCallDepth"] = settings.maxCallDepth
+	config["importResolver"] = settings.importResolver.Type() == js.TypeFunction
+	config["renderDepth"] = settings.render.depth
+	config["renderMaxItems"] = settings.render.maxItems
+	config["renderMaxString"] = settings.render.maxString
+	config["fs"] = settings.fs
+	config["network"] = map[string]interface{}{"allow": stringsToJS(settings.networkAllow)}
+	config["quota"] = settings.quota.toJS()
+	config["fsPersistence"] = persistenceConfig()
+	return config
+}
+
+// fsMode returns the fs setting, one of fsModes.
+func fsMode() string {
+	settings.Lock()
+	defer settings.Unlock()
+
+	return settings.fs
+}
+
+// queueEvals reports whether concurrent evals should wait for their turn.
+func queueEvals() bool {
+	settings.Lock()
+	defer settings.Unlock()
+
+	return settings.queueEvals
+}
+
+// outputLimits returns the configured cap on the output of an eval and
+// whether exceeding it aborts the eval.
+func outputLimits() (int, bool) {
+	settings.Lock()
+	defer settings.Unlock()
+
+	return settings.maxOutputBytes, settings.abortOnOutputLimit
+}
+
+// autoRecover reports whether interpreters are rebuilt after a fatal error.
+func autoRecover() bool {
+	settings.Lock()
+	defer settings.Unlock()
+
+	return settings.autoRecover
+}
+
+// maxRuns returns the configured cap on the active runs of a session, 0
+// for none.
+func maxRuns() int {
+	settings.Lock()
+	defer settings.Unlock()
+
+	return settings.maxRuns
+}
+
+// outputMode returns where the output of evals goes, one of outputModes.
+func outputMode() string {
+	settings.Lock()
+	defer settings.Unlock()
+
+	return settings.output
+}
+
+// maxJobResults returns the configured cap on the job results kept, 0 for
+// none.
+func maxJobResults() int {
+	settings.Lock()
+	defer settings.Unlock()
+
+	return settings.maxJobResults
+}
+
+// maxCacheEntries returns the configured cap on the results kept by the
+// option cache, 0 keeping none.
+func maxCacheEntries() int {
+	settings.Lock()
+	defer settings.Unlock()
+
+	return settings.maxCacheEntries
+}
+
+// maxEvalDepth returns the configured cap on the evals nesting in an eval,
+// 0 allowing none.
+func maxEvalDepth() int {
+	settings.Lock()
+	defer settings.Unlock()
+
+	return settings.maxEvalDepth
+}
+
+// renderSettings returns the configured limits of renderValue.
+func renderSettings() renderLimits {
+	settings.Lock()
+	defer settings.Unlock()
+
+	return settings.render
+}
+
+// maxHeapBytes returns the configured cap on the heap in use during an
+// eval, 0 for none.
+func maxHeapBytes() int {
+	settings.Lock()
+	defer settings.Unlock()
+
+	return settings.maxHeapBytes
+}
+
+// maxCallDepth returns the configured cap on the interpreted frames of a
+// goroutine, 0 for none.
+func maxCallDepth() int {
+	settings.Lock()
+	defer settings.Unlock()
+
+	return settings.maxCallDepth
+}
+
+// status reports what the module is doing: {state, currentEval,
+// queueLength, sessions, fetches, config}. state is "evaluating" while
+// code holds the eval slot of a session or a program started by startRun
+// runs, currentEval then being {startedAtMs, source, elapsedMs, session}
+// for the former, the default session first, or the oldest run with its
+// id. queueLength counts the evals waiting in every session, and fetches
+// the requests of interpreted code in flight, to the end of their body.
+// state is "poisoned" while a session is broken by a fatal error, see
+// handleFatal, and "idle" otherwise.
+func status(this js.Value, args []js.Value) interface{} {
+	list := allSessions()
+	busy, queued := false, 0
+	var eval interface{}
+	for _, s := range list {
+		taken, current, n := s.evalState()
+		queued += n
+		if taken && !busy {
+			busy = true
+			info := evalInfoToJS(current)
+			info["session"] = s.id
+			eval = info
+		}
+	}
+	if r := oldestRun(); !busy && r != nil {
+		info := evalInfoToJS(evalInfo{started: r.started, source: r.source})
+		info["run"] = r.id
+		eval = info
+	}
+
+	state := "idle"
+	if eval != nil {
+		state = "evaluating"
+	}
+	for _, s := range list {
+		if s.isBroken() {
+			state = "poisoned"
+		}
+	}
+
+	return map[string]interface{}{
+		"success":     true,
+		"state":       state,
+		"currentEval": eval,
+		"busy":        busy,
+		"queueLength": queued,
+		"sessions":    len(list),
+		"fetches":     int(pendingFetches.Load()),
+		"config":      currentConfig(),
+	}
+}
+
+// evalInfoToJS returns the currentEval of status for e.
+func evalInfoToJS(e evalInfo) map[string]interface{} {
+	return map[string]interface{}{
+		"startedAtMs": e.started.UnixMilli(),
+		"source":      e.source,
+		"elapsedMs":   time.Since(e.started).Milliseconds(),
+	}
+}
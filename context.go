@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"runtime"
+
+	"github.com/traefik/yaegi/interp"
+)
+
+// contextPackage gives interpreted code the context of its eval.
+const contextPackage = "yaegictx"
+
+// contextSymbols returns the package yaegictx, whose Context and Done give
+// interpreted code the context returned by current: done once the eval is
+// cancelled, times out or returns. Code checking it, e.g. in a select of
+// its loops, stops even where timeouts can't interrupt it: both yield, for
+// the timer of the timeout to run.
+func contextSymbols(current func() context.Context) interp.Exports {
+	ctx := func() context.Context {
+		runtime.Gosched()
+		return current()
+	}
+	return interp.Exports{contextPackage + "/" + contextPackage: {
+		"Context": reflect.ValueOf(ctx),
+		"Done":    reflect.ValueOf(func() <-chan struct{} { return ctx().Done() }),
+	}}
+}
+
+// evalContext returns the context of the running eval of s, or of the last
+// one for code running past it, such as goroutines. Before any eval, it is
+// never done.
+func (s *session) evalContext() context.Context {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.evalCtx == nil {
+		return context.Background()
+	}
+	return s.evalCtx
+}
+
+// contextError returns the error of ctx, done: its cause if that is
+// context.Canceled or context.DeadlineExceeded, as for the timeout of an
+// eval, which is a cause, or its Err.
+func contextError(ctx context.Context) error {
+	if cause := context.Cause(ctx); errors.Is(cause, context.Canceled) || errors.Is(cause, context.DeadlineExceeded) {
+		return cause
+	}
+	return ctx.Err()
+}
+
+// setEvalContext sets the context returned by evalContext.
+func (s *session) setEvalContext(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.evalCtx = ctx
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"go/scanner"
+	"go/token"
+	"strings"
+	"syscall/js"
+)
+
+// isComplete reports whether a REPL input is complete, or needs more lines
+// before it can be evaluated: {complete, indent}, where indent is the number
+// of brackets left open. Malformed input counts as complete, so that
+// evaluating it reports the error.
+func isComplete(this js.Value, args []js.Value) interface{} {
+	if len(args) != 1 || args[0].Type() != js.TypeString {
+		return map[string]interface{}{
+			"success": false,
+			"error":   "isComplete requires the Go source code",
+		}
+	}
+
+	complete, indent := inputComplete(args[0].String())
+	return map[string]interface{}{
+		"complete": complete,
+		"indent":   indent,
+	}
+}
+
+// inputComplete scans src and reports whether it is complete, along with
+// the number of brackets left open.
+func inputComplete(src string) (bool, int) {
+	fset := token.NewFileSet()
+	var sc scanner.Scanner
+	malformed, unterminated := false, false
+	sc.Init(fset.AddFile("", fset.Base(), len(src)), []byte(src), func(pos token.Position, msg string) {
+		// Raw strings and general comments may span lines.
+		if strings.HasPrefix(msg, "raw string literal not terminated") || strings.HasPrefix(msg, "comment not terminated") {
+			unterminated = true
+		} else {
+			malformed = true
+		}
+	}, 0)
+
+	var open []token.Token
+	last := token.ILLEGAL
+	for {
+		_, tok, lit := sc.Scan()
+		if tok == token.EOF {
+			break
+		}
+		switch tok {
+		case token.LPAREN, token.LBRACK, token.LBRACE:
+			open = append(open, tok)
+		case token.RPAREN, token.RBRACK, token.RBRACE:
+			if len(open) == 0 || open[len(open)-1] != matchingBracket[tok] {
+				malformed = true
+			} else {
+				open = open[:len(open)-1]
+			}
+		}
+		if tok != token.SEMICOLON || lit != "\n" {
+			last = tok
+		}
+	}
+
+	switch {
+	case malformed:
+		return true, 0
+	case unterminated || len(open) > 0:
+		return false, len(open)
+	}
+	return !continuesLine(last), 0
+}
+
+var matchingBracket = map[token.Token]token.Token{
+	token.RPAREN: token.LPAREN,
+	token.RBRACK: token.LBRACK,
+	token.RBRACE: token.LBRACE,
+}
+
+// continuesLine reports whether a line ending with tok continues on the
+// next line, as after a binary operator or a comma.
+func continuesLine(tok token.Token) bool {
+	switch {
+	case tok == token.INC || tok == token.DEC:
+		return false
+	case tok == token.COMMA || tok == token.PERIOD:
+		return true
+	}
+	return tok.IsOperator() && tok != token.RPAREN && tok != token.RBRACK && tok != token.RBRACE && tok != token.SEMICOLON && tok != token.COLON && tok != token.ELLIPSIS
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"syscall/js"
+	"time"
+)
+
+// crashSampleInterval is how often the heap is mirrored while evals run.
+const crashSampleInterval = time.Second
+
+// crashShim is the body of the function installing the watch of the exit
+// of the module, given the handler of the Proxies of the API, the state
+// mirrored for onFatal and a Go function to ping: it wraps the exit of the
+// Go instance, found by the ping, and the stderr writes of the runtime,
+// whose tail it keeps, and makes the API answer module_dead once exited.
+// It runs in JS, as nothing of Go runs past the exit.
+const crashShim = `
+const stderrTail = 8192;
+const fire = (code) => {
+	if (!state.alive) return;
+	state.alive = false;
+	state.exitCode = code;
+	if (state.disposed) return;
+	const now = Date.now();
+	const evals = Object.values(state.evals).map((e) => Object.assign({}, e, { elapsedMs: now - e.startedAt }));
+	const info = { exitCode: code, message: state.message, evals, heap: state.heap, stderr: state.stderr };
+	for (const fn of state.callbacks) {
+		try { fn(info); } catch (e) { console.error(e); }
+	}
+};
+const dead = () => ({
+	apiVersion: state.apiVersion, success: false, output: "", stderr: "", value: null, stats: null,
+	error: { code: state.code, message: "the yaegi module has exited", diagnostics: [] },
+	exitCode: state.exitCode,
+});
+
+const proto = globalThis.Go && globalThis.Go.prototype;
+if (proto) {
+	const resume = proto._resume;
+	proto._resume = function () {
+		proto._resume = resume;
+		const exit = this.exit;
+		this.exit = function (code) {
+			fire(code);
+			if (typeof exit === "function") return exit.apply(this, arguments);
+		};
+		return resume.apply(this, arguments);
+	};
+	ping();
+	proto._resume = resume;
+}
+
+const fs = globalThis.fs;
+if (fs && typeof fs.writeSync === "function") {
+	const writeSync = fs.writeSync;
+	const decoder = new TextDecoder();
+	let line = "";
+	fs.writeSync = function (fd, buf) {
+		if (fd === 2 && state.alive) {
+			const text = decoder.decode(buf);
+			state.stderr = (state.stderr + text).slice(-stderrTail);
+			const lines = (line + text).split("\n");
+			line = lines.pop().slice(-stderrTail);
+			if (state.message === null) {
+				state.message = lines.find((l) => /^(fatal error|panic): /.test(l)) || null;
+			}
+		}
+		return writeSync.apply(this, arguments);
+	};
+}
+
+handler.apply = function (target, thisArg, args) {
+	if (!state.alive) return dead();
+	try {
+		const res = Reflect.apply(target, thisArg, args);
+		return state.alive ? res : dead();
+	} catch (e) {
+		if (state.alive && !(e instanceof WebAssembly.RuntimeError) && !/already exited/.test(e && e.message)) throw e;
+		fire(state.alive ? null : state.exitCode);
+		return dead();
+	}
+};
+`
+
+var (
+	// crashState is the state mirrored for onFatal, undefined until
+	// installCrashShim: {alive, disposed, apiVersion, code, exitCode,
+	// evals, heap, stderr, message, callbacks}, evals being the running
+	// evals by session.
+	crashState = js.Undefined()
+	// mirroredEvals counts the evals running, for which the heap is
+	// sampled.
+	mirroredEvals atomic.Int32
+)
+
+// installCrashShim installs crashShim on the handler of the API, for main,
+// once the API functions are made. A page that forbids the Function
+// constructor goes without it: the API then throws once the module exited.
+func installCrashShim() {
+	defer func() { recover() }()
+
+	state := js.Global().Get("Object").New()
+	state.Set("alive", true)
+	state.Set("disposed", false)
+	state.Set("apiVersion", apiVersion)
+	state.Set("code", codeModuleDead)
+	state.Set("exitCode", js.Null())
+	state.Set("evals", js.Global().Get("Object").New())
+	state.Set("heap", js.Null())
+	state.Set("stderr", "")
+	state.Set("message", js.Null())
+	state.Set("callbacks", js.Global().Get("Array").New())
+
+	ping := js.FuncOf(func(this js.Value, args []js.Value) interface{} { return nil })
+	defer ping.Release()
+	install := js.Global().Get("Function").New("handler", "state", "ping", crashShim)
+	install.Invoke(apiHandler, state, ping)
+	crashState = state
+	sampleHeap()
+}
+
+// sampleHeap mirrors the heap stats of the module in crashState, for
+// onFatal to report them once nothing of Go runs.
+func sampleHeap() {
+	if crashState.IsUndefined() {
+		return
+	}
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	crashState.Set("heap", map[string]interface{}{
+		"heapAlloc": safeNumber(m.HeapAlloc),
+		"heapSys":   safeNumber(m.HeapSys),
+		"sys":       safeNumber(m.Sys),
+		"numGC":     m.NumGC,
+		"sampledAt": time.Now().UnixMilli(),
+	})
+}
+
+// mirrorEval records the eval of s running source in crashState, and
+// returns the function removing it once done. The heap is sampled every
+// crashSampleInterval while evals run, when they yield.
+func (s *session) mirrorEval(source string) (done func()) {
+	if crashState.IsUndefined() {
+		return func() {}
+	}
+	sampleHeap()
+	crashState.Get("evals").Set(strconv.Itoa(s.id), map[string]interface{}{
+		"session":   s.id,
+		"source":    firstLine(source),
+		"startedAt": time.Now().UnixMilli(),
+	})
+	if mirroredEvals.Add(1) == 1 {
+		go func() {
+			t := time.NewTicker(crashSampleInterval)
+			defer t.Stop()
+			for range t.C {
+				if mirroredEvals.Load() == 0 {
+					return
+				}
+				sampleHeap()
+			}
+		}()
+	}
+	return func() {
+		mirroredEvals.Add(-1)
+		crashState.Get("evals").Delete(strconv.Itoa(s.id))
+		sampleHeap()
+	}
+}
+
+// onFatal registers the function given as argument to be called if the
+// module exits other than by dispose, as when the Go runtime runs out of
+// memory, with {exitCode, message, evals, heap, stderr}: message the
+// fatal error line the runtime wrote to stderr, stderr the tail of what it
+// wrote there, evals the evals running, {session, source, startedAt, elapsedMs}
+// with the first line of their source, and heap the last heap stats
+// sampled, {heapAlloc, heapSys, sys, numGC, sampledAt}. The functions of
+// the API then return the result of code module_dead instead of throwing.
+func onFatal(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 || args[0].Type() != js.TypeFunction {
+		return map[string]interface{}{
+			"success":   false,
+			"error":     "onFatal requires a function",
+			"errorCode": codeBadRequest,
+		}
+	}
+	if crashState.IsUndefined() {
+		return map[string]interface{}{
+			"success": false,
+			"error":   "onFatal is unavailable where the Function constructor is forbidden",
+		}
+	}
+	crashState.Get("callbacks").Call("push", args[0])
+	return map[string]interface{}{"success": true}
+}
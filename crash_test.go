@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"syscall/js"
+	"testing"
+)
+
+// fatalChildEnv is set for the test binary run by TestOnFatalOutOfMemory,
+// which then runs out of memory.
+const fatalChildEnv = "YAEGI_TEST_FATAL_CHILD"
+
+// TestOnFatalOutOfMemory runs the test binary again under Node, for it to
+// run out of memory, as the exit ends the process.
+func TestOnFatalOutOfMemory(t *testing.T) {
+	if os.Getenv(fatalChildEnv) != "" {
+		runOutOfMemory(t)
+		return
+	}
+	process := js.Global().Get("process")
+	// argv is node, wasm_exec_node.js, the test binary and its arguments.
+	argv := process.Get("argv")
+	args := process.Get("execArgv").Call("concat", argv.Call("slice", 1, 3), "-test.run=^TestOnFatalOutOfMemory$")
+	env := js.Global().Get("Object").Call("assign", js.Global().Get("Object").New(), process.Get("env"))
+	env.Set(fatalChildEnv, "1")
+	child := js.Global().Get("require").Invoke("child_process").Call("spawnSync", process.Get("execPath"), args, map[string]interface{}{
+		"env":       env,
+		"encoding":  "utf8",
+		"timeout":   120000,
+		"maxBuffer": 64 << 20,
+	})
+	stdout := child.Get("stdout").String()
+	if status := child.Get("status"); status.IsNull() || status.Int() != 2 {
+		t.Fatalf("the child exited with %s, want the status 2 of a fatal error:\n%s\n%s", jsonString(status), stdout, child.Get("stderr").String())
+	}
+
+	var fatal js.Value
+	for _, line := range strings.Split(stdout, "\n") {
+		if report, ok := strings.CutPrefix(line, "FATAL "); ok {
+			fatal = js.Global().Get("JSON").Call("parse", report)
+		}
+	}
+	if fatal.IsUndefined() {
+		t.Fatalf("onFatal was not called:\n%s", stdout)
+	}
+	info := fatal.Get("info")
+	if got := info.Get("exitCode"); got.Type() != js.TypeNumber || got.Int() != 2 {
+		t.Errorf("exitCode = %s, want 2", jsonString(got))
+	}
+	if got := info.Get("message"); got.Type() != js.TypeString || !strings.HasPrefix(got.String(), "fatal error: out of memory") {
+		t.Errorf("message = %s, want the fatal error line", jsonString(got))
+	}
+	if !strings.Contains(info.Get("stderr").String(), "goroutine ") {
+		t.Errorf("stderr = %s, want the tail of the stacks the runtime wrote", jsonString(info.Get("stderr")))
+	}
+	evals := info.Get("evals")
+	if evals.Length() != 1 || !strings.Contains(evals.Index(0).Get("source").String(), "package main") {
+		t.Errorf("evals = %s, want the eval running", jsonString(evals))
+	}
+	if heap := info.Get("heap"); heap.Type() != js.TypeObject || heap.Get("heapAlloc").Float() <= 0 {
+		t.Errorf("heap = %s, want the last heap stats", jsonString(heap))
+	}
+	if code := errorCodeOf(fatal.Get("after")); code != codeModuleDead {
+		t.Errorf("eval after the exit = %s, want code %s", jsonString(fatal.Get("after")), codeModuleDead)
+	}
+}
+
+// runOutOfMemory registers an onFatal function writing what it is called
+// with, and the result of an eval then, to stdout as "FATAL {info, after}",
+// and runs an eval out of memory.
+func runOutOfMemory(t *testing.T) {
+	report := js.Global().Get("Function").New("info", `
+		const after = globalThis.yaegi.eval("1");
+		globalThis.fs.writeSync(1, "FATAL " + JSON.stringify({ info, after }) + "\n");
+	`)
+	mustSucceed(t, callAPI(t, "onFatal", report))
+	res := callAPI(t, "eval", `package main
+
+func main() {
+	var keep [][]byte
+	for {
+		keep = append(keep, make([]byte, 64<<20))
+	}
+}
+`)
+	t.Fatalf("the eval returned: %s", jsonString(res))
+}
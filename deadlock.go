@@ -0,0 +1,189 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/traefik/yaegi/interp"
+)
+
+const (
+	// deadlockPoll is how often watchDeadlock looks at the goroutines of
+	// an eval, from when it has run that long.
+	deadlockPoll = 250 * time.Millisecond
+	// deadlockWait is how long the goroutines of a sync eval may all wait
+	// on channels or locks before it is aborted: nothing but a timer can
+	// wake them, the host being blocked until the eval returns.
+	deadlockWait = 5 * time.Second
+	// evalFrame is the function of yaegi starting the goroutine that runs
+	// an eval.
+	evalFrame = "github.com/traefik/yaegi/interp.(*Interpreter).EvalWithContext"
+	// abandonGrace is how long the code of a timed out or cancelled eval
+	// has to return before its interpreter is rebuilt.
+	abandonGrace = 50 * time.Millisecond
+)
+
+// errGoexit fails the evals whose code called runtime.Goexit from the
+// goroutine running the eval, which returned as if it had completed.
+var errGoexit = errors.New("runtime.Goexit called by the main goroutine of the eval")
+
+// deadlockError fails the sync evals aborted by watchDeadlock.
+type deadlockError struct {
+	state string // the wait state of the goroutine of the eval
+}
+
+func (e deadlockError) Error() string {
+	return fmt.Sprintf("deadlock: all goroutines of the eval waited %v on channels or locks (%s)", deadlockWait, e.state)
+}
+
+// goexitSymbols returns runtime.Goexit for the interpreted code of s,
+// recording a call from the goroutine of the eval, as a deferred recover
+// can't tell it apart from a return, with the goroutines then.
+func (s *session) goexitSymbols() interp.Exports {
+	return interp.Exports{"runtime/runtime": {
+		"Goexit": reflect.ValueOf(func() {
+			if g := currentGoroutine(); g.createdBy != nil && g.createdBy.fn == evalFrame {
+				stacks := append([]goroutine{g}, dumpGoroutines()...)
+				s.goexit.CompareAndSwap(nil, &stacks)
+			}
+			runtime.Goexit()
+		}),
+	}}
+}
+
+// currentGoroutine returns the calling goroutine, as in a stack dump.
+func currentGoroutine() goroutine {
+	buf := make([]byte, 16<<10)
+	for {
+		n := runtime.Stack(buf, false)
+		if n < len(buf) {
+			g, _ := parseGoroutine(string(buf[:n]))
+			return g
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
+// evalRunning reports whether a goroutine yaegi started from caller to run
+// an eval lives on, once grace is over: EvalWithContext returns as soon as
+// its context is done, while the code it runs only stops at its next step,
+// which a call of the host may hold off for good.
+func evalRunning(caller int, grace time.Duration) bool {
+	deadline := time.Now().Add(grace)
+	for {
+		running := false
+		for _, g := range dumpGoroutines() {
+			if g.createdBy != nil && g.createdBy.fn == evalFrame && g.parent == caller {
+				running = true
+			}
+		}
+		if !running || time.Now().After(deadline) {
+			return running
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// watchDeadlock aborts with a deadlockError a sync eval whose goroutines
+// have all waited on channels or locks for deadlockWait, calling abort
+// with it and the goroutines. Blocking the host, such an eval would wait
+// forever but for a timer, or end the module once the Go runtime finds no
+// goroutine left to run. yaegi waits on a channel, nil or not, and on a
+// select without cases alike, so that they can't be told apart. The eval
+// runs on a goroutine that yaegi starts from caller, the goroutines of the
+// eval being those it starts in turn. It returns the function stopping the
+// watch.
+func watchDeadlock(caller int, abort func(deadlockError, []goroutine)) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(deadlockPoll)
+		defer ticker.Stop()
+
+		var since time.Time
+		w := evalWatch{caller: caller, mine: map[int]bool{}}
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			list := dumpGoroutines()
+			state, waiting := w.waits(list)
+			switch {
+			case !waiting:
+				since = time.Time{}
+			case since.IsZero():
+				since = time.Now()
+			case time.Since(since) >= deadlockWait:
+				abort(deadlockError{state: state}, list)
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// evalWatch tells the goroutines of an eval in the dumps of watchDeadlock.
+type evalWatch struct {
+	caller int          // the goroutine running the eval from the host
+	eval   int          // the goroutine yaegi runs the eval on, 0 until seen
+	mine   map[int]bool // the goroutines of the eval seen, ended ones included
+}
+
+// waits returns the wait state of the goroutine running the eval, and
+// whether every goroutine of the eval in list waits on channels or locks.
+// Goroutines started by one of the eval, or newer than the eval and whose
+// creator ended unseen, are of the eval.
+func (w *evalWatch) waits(list []goroutine) (string, bool) {
+	alive := map[int]bool{}
+	for _, g := range list {
+		alive[g.id] = true
+		if w.eval == 0 && g.interpreted() && g.createdBy.fn == evalFrame && g.parent == w.caller {
+			w.eval = g.id
+			w.mine[g.id] = true
+		}
+	}
+	if w.eval == 0 {
+		return "", false
+	}
+	// Until no more is found, as the dump lists them in any order.
+	for found := true; found; {
+		found = false
+		for _, g := range list {
+			if w.mine[g.id] || !g.interpreted() || g.id < w.eval {
+				continue
+			}
+			if w.mine[g.parent] || !alive[g.parent] {
+				w.mine[g.id] = true
+				found = true
+			}
+		}
+	}
+
+	state, waiting := "", true
+	for _, g := range list {
+		if !w.mine[g.id] {
+			continue
+		}
+		if g.id == w.eval {
+			state = g.state
+		}
+		waiting = waiting && channelWait(g.state)
+	}
+	return state, alive[w.eval] && waiting
+}
+
+// channelWait reports whether a goroutine in the wait state waits on a
+// channel or a lock.
+func channelWait(state string) bool {
+	for _, prefix := range []string{"chan ", "select", "sync.", "semacquire"} {
+		if strings.HasPrefix(state, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,506 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall/js"
+	"time"
+
+	"github.com/traefik/yaegi/interp"
+)
+
+// debugTarget is the program started by debug, on the debugger of yaegi.
+// Its goroutines stop at the breakpoints, and on entry; the debug commands
+// resume them and inspect their frames while they are stopped.
+type debugTarget struct {
+	session *session
+	code    string
+	dbg     *interp.Debugger
+	cancel  context.CancelFunc
+	onEvent js.Value
+	events  chan map[string]interface{} // delivered in order by deliverEvents
+	output  atomic.Int64                // stdout and stderr bytes written
+
+	mu      sync.Mutex
+	paused  map[int]*interp.DebugEvent // stopped goroutines by id
+	current int                        // goroutine of the latest stop
+	resumed time.Time                  // when the last stopped goroutine resumed, zero while one is stopped
+	ran     time.Duration              // time run with no goroutine stopped, charged from the quota
+}
+
+// debugging holds the program being debugged, if any: one at a time.
+var debugging = struct {
+	sync.Mutex
+	target *debugTarget
+}{}
+
+// debugStepKinds are the kinds of debugStep.
+var debugStepKinds = map[string]interp.DebugEventReason{
+	"over": interp.DebugStepOver,
+	"into": interp.DebugStepInto,
+	"out":  interp.DebugStepOut,
+}
+
+// debugProgram compiles the Go program given as first argument and starts
+// it paused on entry under the debugger, returning {success, breakpoints}
+// at once, breakpoints telling for each requested line whether code stops
+// there. The options take breakpoints, an array of lines, onEvent, called
+// with {event, goroutine, function, line, column} as the program stops,
+// event being "entry", "breakpoint" or "step", and with {event:
+// "terminated", success, value or error} once it returns, onStdout,
+// onStderr, stdin and session. In worker mode, events without an onEvent
+// are posted as {event: "debug", data} messages. The program runs on an
+// interpreter of its own, as those of start, and being stopped blocks none
+// of the JS event loop.
+func debugProgram(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 || len(args) > 2 || args[0].Type() != js.TypeString {
+		return map[string]interface{}{
+			"success": false,
+			"error":   "debug requires the Go source code and an optional options object",
+		}
+	}
+	code := args[0].String()
+	o := optionArg(args, 1)
+	opts := parseEvalOptions(o)
+
+	var lines []int
+	if o.Type() == js.TypeObject && !o.Get("breakpoints").IsUndefined() {
+		bp := o.Get("breakpoints")
+		if !bp.InstanceOf(js.Global().Get("Array")) {
+			return map[string]interface{}{
+				"success": false,
+				"error":   "breakpoints must be an array of line numbers",
+			}
+		}
+		for i := 0; i < bp.Length(); i++ {
+			if bp.Index(i).Type() != js.TypeNumber || bp.Index(i).Int() < 1 {
+				return map[string]interface{}{
+					"success": false,
+					"error":   "breakpoints must be an array of line numbers",
+				}
+			}
+			lines = append(lines, bp.Index(i).Int())
+		}
+	}
+
+	s := defaultSession()
+	if o.Type() == js.TypeObject && o.Get("session").Type() == js.TypeNumber {
+		if s = lookupSession(o.Get("session").Int()); s == nil {
+			return map[string]interface{}{
+				"success": false,
+				"error":   "session not found",
+			}
+		}
+	}
+	if res := s.admit(); res != nil {
+		return res
+	}
+
+	t := &debugTarget{
+		session: s,
+		code:    code,
+		events:  make(chan map[string]interface{}, 16),
+		paused:  map[int]*interp.DebugEvent{},
+	}
+	if o.Type() == js.TypeObject && o.Get("onEvent").Type() == js.TypeFunction {
+		t.onEvent = o.Get("onEvent")
+	}
+	debugging.Lock()
+	defer debugging.Unlock()
+	if debugging.target != nil {
+		return map[string]interface{}{
+			"success": false,
+			"error":   "a program is already being debugged; call debugStop first",
+			"busy":    true,
+		}
+	}
+
+	stdout, stderr := &captureWriter{}, &captureWriter{}
+	stdout.start(false, jsStream(opts.onStdout), nil)
+	stderr.start(false, jsStream(opts.onStderr), nil)
+	i := s.interpreterWith(s.files, nil, strings.NewReader(opts.stdin), countingWriter{stdout, &t.output}, countingWriter{stderr, &t.output})
+	ctx, cancel := context.WithCancel(context.Background())
+	i.Use(contextSymbols(func() context.Context { return ctx }))
+	if s.config.allows("net/http") {
+		i.Use(httpSymbols(fetchTransport{detached: true}))
+	}
+
+	prog, err := compileDebug(i, code)
+	if err = s.config.sandboxError(err); err != nil {
+		cancel()
+		stdout.stop()
+		stderr.stop()
+		return map[string]interface{}{
+			"success":     false,
+			"error":       err.Error(),
+			"errorCode":   errorCode(err),
+			"diagnostics": errorDiagnostics(err, code, ""),
+		}
+	}
+
+	t.cancel = cancel
+	go t.deliverEvents(func() {
+		stdout.stop()
+		stderr.stop()
+	})
+	t.dbg = i.Debug(ctx, prog, t.event, nil)
+	requests := make([]interp.BreakpointRequest, len(lines))
+	for n, line := range lines {
+		requests[n] = interp.LineBreakpoint(line)
+	}
+	set := t.dbg.SetBreakpoints(interp.ProgramBreakpointTarget(prog), requests...)
+	breakpoints := make([]interface{}, len(set))
+	for n, b := range set {
+		breakpoints[n] = map[string]interface{}{
+			"line":     lines[n],
+			"verified": b.Valid,
+		}
+	}
+	debugging.target = t
+	t.resumed = time.Now()
+	t.dbg.Step(0, interp.DebugEntry)
+
+	return map[string]interface{}{
+		"success":     true,
+		"breakpoints": breakpoints,
+	}
+}
+
+// compileDebug compiles code in i.
+func compileDebug(i *interp.Interpreter, code string) (prog *interp.Program, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = internalPanic{r}
+		}
+	}()
+	return i.Compile(code)
+}
+
+// event records the stops of the goroutines of t, and queues the events
+// for the host. It runs on the goroutine stopping, which waits for it.
+func (t *debugTarget) event(e *interp.DebugEvent) {
+	var name string
+	switch e.Reason() {
+	case interp.DebugEntry:
+		name = "entry"
+	case interp.DebugBreak:
+		name = "breakpoint"
+	case interp.DebugStepInto, interp.DebugStepOver, interp.DebugStepOut:
+		name = "step"
+	case interp.DebugPause:
+		name = "pause"
+	case interp.DebugTerminate:
+		t.terminated()
+		return
+	default:
+		return
+	}
+
+	g := e.GoRoutine()
+	t.mu.Lock()
+	if len(t.paused) == 0 && !t.resumed.IsZero() {
+		t.ran += time.Since(t.resumed)
+		t.resumed = time.Time{}
+	}
+	t.paused[g] = e
+	t.current = g
+	t.mu.Unlock()
+
+	ev := map[string]interface{}{
+		"event":     name,
+		"goroutine": g,
+	}
+	if frames := e.Frames(0, 1); len(frames) > 0 {
+		for k, v := range debugFrame(frames[0]) {
+			ev[k] = v
+		}
+	}
+	t.events <- ev
+}
+
+// terminated queues the last event of t, with the result of the program,
+// and drops t.
+func (t *debugTarget) terminated() {
+	value, err := t.dbg.Wait()
+	debugging.Lock()
+	if debugging.target == t {
+		debugging.target = nil
+	}
+	debugging.Unlock()
+	t.mu.Lock()
+	if !t.resumed.IsZero() {
+		t.ran += time.Since(t.resumed)
+		t.resumed = time.Time{}
+	}
+	t.session.chargeCPU(t.ran)
+	t.mu.Unlock()
+
+	res := resultMap(t.code, value, err, "", "", renderSettings())
+	delete(res, "output")
+	delete(res, "stderr")
+	res["event"] = "terminated"
+	res["outputBytes"] = t.output.Load()
+	t.events <- res
+	close(t.events)
+}
+
+// resumeLocked starts timing t once none of its goroutines is stopped.
+// t.mu is held.
+func (t *debugTarget) resumeLocked() {
+	if len(t.paused) == 0 && t.resumed.IsZero() {
+		t.resumed = time.Now()
+	}
+}
+
+// deliverEvents calls onEvent, or posts a message, with the events of t in
+// order, calling done before the last, once output is over. Running apart from the goroutines stopping, it
+// lets the host resume them from onEvent.
+func (t *debugTarget) deliverEvents(done func()) {
+	for ev := range t.events {
+		if ev["event"] == "terminated" {
+			done()
+		}
+		switch {
+		case t.onEvent.Type() == js.TypeFunction:
+			t.onEvent.Invoke(envelope(ev))
+		case workerMode():
+			js.Global().Call("postMessage", map[string]interface{}{
+				"event": "debug",
+				"data":  envelope(ev),
+			})
+		}
+	}
+}
+
+// debugFrame describes frame for the debug events and debugStack: {function,
+// file, line, column}.
+func debugFrame(frame *interp.DebugFrame) map[string]interface{} {
+	pos := frame.Position()
+	return map[string]interface{}{
+		"function": frame.Name(),
+		"file":     pos.Filename,
+		"line":     pos.Line,
+		"column":   pos.Column,
+	}
+}
+
+var (
+	errNotDebugging = errors.New("no program is being debugged")
+	errNotStopped   = errors.New("the program is running; wait for it to stop")
+)
+
+// stoppedTarget returns the program being debugged and the latest event of its
+// goroutine given in the options args[0], the one that stopped last by
+// default, which must be stopped.
+func stoppedTarget(args []js.Value) (*debugTarget, *interp.DebugEvent, error) {
+	debugging.Lock()
+	t := debugging.target
+	debugging.Unlock()
+	if t == nil {
+		return nil, nil, errNotDebugging
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	g := t.current
+	if o := optionArg(args, 0); o.Type() == js.TypeObject && o.Get("goroutine").Type() == js.TypeNumber {
+		g = o.Get("goroutine").Int()
+	}
+	e := t.paused[g]
+	if e == nil {
+		return t, nil, errNotStopped
+	}
+	return t, e, nil
+}
+
+// debugContinue resumes every stopped goroutine of the program being
+// debugged, until the next breakpoint.
+func debugContinue(this js.Value, args []js.Value) interface{} {
+	debugging.Lock()
+	t := debugging.target
+	debugging.Unlock()
+	if t == nil {
+		return map[string]interface{}{
+			"success": false,
+			"error":   errNotDebugging.Error(),
+		}
+	}
+
+	t.mu.Lock()
+	ids := make([]int, 0, len(t.paused))
+	for g := range t.paused {
+		ids = append(ids, g)
+	}
+	t.paused = map[int]*interp.DebugEvent{}
+	t.resumeLocked()
+	t.mu.Unlock()
+	if len(ids) == 0 {
+		return map[string]interface{}{
+			"success": false,
+			"error":   errNotStopped.Error(),
+		}
+	}
+	sort.Ints(ids)
+	for _, g := range ids {
+		t.dbg.Continue(g)
+	}
+	return map[string]interface{}{"success": true}
+}
+
+// debugStep resumes the stopped goroutine of the options, the one that
+// stopped last by default, until the next statement: of the function it is
+// in for kind "over", the default, possibly in a call for "into", or of
+// its caller for "out".
+func debugStep(this js.Value, args []js.Value) interface{} {
+	t, e, err := stoppedTarget(args)
+	if err != nil {
+		return map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+		}
+	}
+	reason := interp.DebugStepOver
+	if o := optionArg(args, 0); o.Type() == js.TypeObject && o.Get("kind").Type() == js.TypeString {
+		var ok bool
+		if reason, ok = debugStepKinds[o.Get("kind").String()]; !ok {
+			return map[string]interface{}{
+				"success": false,
+				"error":   `kind must be "over", "into" or "out"`,
+			}
+		}
+	}
+
+	g := e.GoRoutine()
+	t.mu.Lock()
+	delete(t.paused, g)
+	t.resumeLocked()
+	t.mu.Unlock()
+	if err := t.dbg.Step(g, reason); err != nil {
+		return map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+		}
+	}
+	return map[string]interface{}{"success": true}
+}
+
+// debugLocals returns the variables of a frame of a stopped goroutine,
+// the innermost by default or the one at index frame of debugStack:
+// {success, variables: [{name, type, value, valueString}]}, sorted by
+// name, values converted as those of globals and rendered with the render
+// options. Variables captured by a closure are included.
+func debugLocals(this js.Value, args []js.Value) interface{} {
+	_, e, err := stoppedTarget(args)
+	if err != nil {
+		return map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+		}
+	}
+	index := 0
+	if o := optionArg(args, 0); o.Type() == js.TypeObject && o.Get("frame").Type() == js.TypeNumber {
+		index = o.Get("frame").Int()
+	}
+	limits := parseRenderLimits(optionArg(args, 0), renderSettings())
+	frames := e.Frames(0, e.FrameDepth())
+	if index < 0 || index >= len(frames) {
+		return map[string]interface{}{
+			"success": false,
+			"error":   "frame not found",
+		}
+	}
+
+	var vars []*interp.DebugVariable
+	for _, scope := range frames[index].Scopes() {
+		vars = append(vars, scope.Variables()...)
+	}
+	sort.SliceStable(vars, func(i, j int) bool { return vars[i].Name < vars[j].Name })
+	list := make([]interface{}, 0, len(vars))
+	for _, v := range vars {
+		entry := map[string]interface{}{
+			"name": v.Name,
+			"type": debugTypeName(v.Value),
+		}
+		value, truncated := truncatedToJS(v.Value)
+		entry["value"] = value
+		entry["valueString"] = renderValue(v.Value, limits)
+		if truncated {
+			entry["truncated"] = true
+		}
+		list = append(list, entry)
+	}
+	return map[string]interface{}{
+		"success":   true,
+		"variables": list,
+	}
+}
+
+// debugTypeName names the type of v, or of the value held by an interface.
+func debugTypeName(v reflect.Value) string {
+	if v.Kind() == reflect.Interface && !v.IsNil() {
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return "nil"
+	}
+	return v.Type().String()
+}
+
+// debugStack returns the frames of a stopped goroutine, innermost first:
+// {success, goroutine, frames: [{function, file, line, column}]}.
+func debugStack(this js.Value, args []js.Value) interface{} {
+	_, e, err := stoppedTarget(args)
+	if err != nil {
+		return map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+		}
+	}
+	frames := e.Frames(0, e.FrameDepth())
+	list := make([]interface{}, len(frames))
+	for i, frame := range frames {
+		list[i] = debugFrame(frame)
+	}
+	return map[string]interface{}{
+		"success":   true,
+		"goroutine": e.GoRoutine(),
+		"frames":    list,
+	}
+}
+
+// debugStop terminates the program being debugged. It returns at once; the
+// terminated event tells when it has returned.
+func debugStop(this js.Value, args []js.Value) interface{} {
+	if !stopDebug(nil) {
+		return map[string]interface{}{
+			"success": false,
+			"error":   errNotDebugging.Error(),
+		}
+	}
+	return map[string]interface{}{"success": true}
+}
+
+// stopDebug terminates the program being debugged, if it runs in s or s
+// is nil, reporting whether there was one.
+func stopDebug(s *session) bool {
+	debugging.Lock()
+	t := debugging.target
+	debugging.Unlock()
+	if t == nil || s != nil && t.session != s {
+		return false
+	}
+
+	t.mu.Lock()
+	t.paused = map[int]*interp.DebugEvent{}
+	t.resumeLocked()
+	t.mu.Unlock()
+	t.cancel()
+	t.dbg.Terminate()
+	return true
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall/js"
+)
+
+// The reasons of a missing import of the package graph, see depGraph.
+const (
+	missingStdlib  = "not in stdlib symbols"
+	missingFiles   = "not in provided files"
+	missingAllowed = "not allowed in this session"
+)
+
+// depPackage is a source package of the package graph.
+type depPackage struct {
+	path    string
+	files   []string
+	imports map[string]bool // of the source packages
+	stdlib  map[string]bool // of the packages of symbols
+	added   bool            // by addPackage rather than given
+}
+
+// depImport is an import of a package of the graph, where it is written.
+type depImport struct {
+	path       string
+	importedBy string
+	pos        token.Position
+}
+
+// depGraph returns the package graph of the files of v, as for evalFiles
+// with the build tags tags, among the packages s can import: {packages,
+// cycles, missing, excluded}. packages are the source packages, the main
+// one, of the top-level files, as "main", and those added with addPackage
+// among them: {path, files, imports, stdlib, added}, imports listing the
+// source packages each imports and stdlib the packages of symbols. cycles
+// lists the packages of each import cycle, in the order of a walk from the
+// first by path, and missing the imports of no package, {path, importedBy,
+// position, reason}, reason telling a path of the standard library, the
+// first element of which has no dot, from one that the files were to
+// provide, unless it is built in but not allowed.
+func depGraph(s *session, v js.Value, tags []string) (map[string]interface{}, error) {
+	pkgs := map[string]*depPackage{}
+	var imports []depImport
+	excluded := []interface{}{}
+	fset := token.NewFileSet()
+	add := func(dir, file, code string, added bool) error {
+		f, err := parser.ParseFile(fset, file, code, parser.ImportsOnly)
+		if err != nil {
+			return err
+		}
+		p := pkgs[dir]
+		if p == nil {
+			p = &depPackage{path: dir, imports: map[string]bool{}, stdlib: map[string]bool{}, added: added}
+			pkgs[dir] = p
+		}
+		p.files = append(p.files, file)
+		for _, spec := range f.Imports {
+			ip, err := strconv.Unquote(spec.Path.Value)
+			if err != nil {
+				continue
+			}
+			imports = append(imports, depImport{path: ip, importedBy: dir, pos: fset.Position(spec.Path.Pos())})
+		}
+		return nil
+	}
+
+	keys := js.Global().Get("Object").Call("keys", v)
+	for i := 0; i < keys.Length(); i++ {
+		name := keys.Index(i).String()
+		code := v.Get(name)
+		if code.Type() != js.TypeString {
+			return nil, fmt.Errorf("source of %s is not a string", name)
+		}
+		clean, err := treeFileName(name)
+		if err != nil {
+			return nil, err
+		}
+		if reason := excludedFile(clean, code.String(), tags, false); reason != "" {
+			excluded = append(excluded, map[string]interface{}{"file": name, "reason": reason})
+			continue
+		}
+		dir := path.Dir(clean)
+		if dir == "." {
+			dir = "main"
+		}
+		if err := add(dir, clean, code.String(), false); err != nil {
+			return nil, err
+		}
+	}
+
+	s.mu.Lock()
+	sources := map[string]map[string][]byte{}
+	for p, files := range s.packages {
+		if pkgs[p] == nil {
+			sources[p] = files
+		}
+	}
+	bound := map[string]bool{}
+	for p := range s.bound {
+		bound[p] = true
+	}
+	s.mu.Unlock()
+	for p, files := range sources {
+		for name, code := range files {
+			if err := add(p, path.Join(p, name), string(code), true); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	symbols := map[string]bool{}
+	for _, cs := range s.importCandidates() {
+		for _, c := range cs {
+			if c.symbols != nil {
+				symbols[c.path] = true
+			}
+		}
+	}
+	missing := []interface{}{}
+	for _, im := range imports {
+		p := pkgs[im.importedBy]
+		switch {
+		case pkgs[im.path] != nil:
+			p.imports[im.path] = true
+		case symbols[im.path] || bound[im.path] || im.path == hostioPackage || im.path == playgroundPackage:
+			p.stdlib[im.path] = true
+		default:
+			reason := missingFiles
+			if symbolPackage(im.path) {
+				reason = missingAllowed
+			} else if first, _, _ := strings.Cut(im.path, "/"); !strings.Contains(first, ".") && !providedRoot(pkgs, first) {
+				reason = missingStdlib
+			}
+			missing = append(missing, map[string]interface{}{
+				"path":       im.path,
+				"importedBy": im.importedBy,
+				"position": map[string]interface{}{
+					"file":   im.pos.Filename,
+					"line":   im.pos.Line,
+					"column": im.pos.Column,
+				},
+				"reason": reason,
+			})
+		}
+	}
+
+	paths := make([]string, 0, len(pkgs))
+	for p := range pkgs {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	list := make([]interface{}, len(paths))
+	for i, p := range paths {
+		pkg := pkgs[p]
+		sort.Strings(pkg.files)
+		list[i] = map[string]interface{}{
+			"path":    p,
+			"files":   stringsToJS(pkg.files),
+			"imports": stringsToJS(setKeys(pkg.imports)),
+			"stdlib":  stringsToJS(setKeys(pkg.stdlib)),
+			"added":   pkg.added,
+		}
+	}
+	cycles := []interface{}{}
+	for _, c := range importCycles(paths, pkgs) {
+		cycles = append(cycles, stringsToJS(c))
+	}
+	return map[string]interface{}{
+		"packages": list,
+		"cycles":   cycles,
+		"missing":  missing,
+		"excluded": excluded,
+	}, nil
+}
+
+// providedRoot reports whether a package of pkgs has the first path
+// element first, for an import below it to be one of the files.
+func providedRoot(pkgs map[string]*depPackage, first string) bool {
+	for p := range pkgs {
+		if p == first || strings.HasPrefix(p, first+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// setKeys returns the keys of set in order.
+func setKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// importCycles returns the import cycles of pkgs, whose paths are given in
+// order: the strongly connected components of the graph, by Tarjan's
+// algorithm, of more than one package or of one importing itself, each in
+// the order of a walk of its imports from its first package by path.
+func importCycles(paths []string, pkgs map[string]*depPackage) [][]string {
+	index := map[string]int{}
+	low := map[string]int{}
+	onStack := map[string]bool{}
+	var stack []string
+	var cycles [][]string
+
+	var connect func(p string)
+	connect = func(p string) {
+		index[p] = len(index)
+		low[p] = index[p]
+		stack = append(stack, p)
+		onStack[p] = true
+		for _, q := range setKeys(pkgs[p].imports) {
+			if _, seen := index[q]; !seen {
+				connect(q)
+				low[p] = min(low[p], low[q])
+			} else if onStack[q] {
+				low[p] = min(low[p], index[q])
+			}
+		}
+		if low[p] != index[p] {
+			return
+		}
+		members := map[string]bool{}
+		for {
+			q := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[q] = false
+			members[q] = true
+			if q == p {
+				break
+			}
+		}
+		if len(members) > 1 || pkgs[p].imports[p] {
+			cycles = append(cycles, cycleWalk(setKeys(members)[0], members, pkgs))
+		}
+	}
+	for _, p := range paths {
+		if _, seen := index[p]; !seen {
+			connect(p)
+		}
+	}
+	sort.Slice(cycles, func(i, j int) bool { return cycles[i][0] < cycles[j][0] })
+	return cycles
+}
+
+// cycleWalk returns the members of a cycle of pkgs in the order a walk of
+// their imports, in order, reaches them from first.
+func cycleWalk(first string, members map[string]bool, pkgs map[string]*depPackage) []string {
+	var order []string
+	seen := map[string]bool{}
+	var walk func(p string)
+	walk = func(p string) {
+		seen[p] = true
+		order = append(order, p)
+		for _, q := range setKeys(pkgs[p].imports) {
+			if members[q] && !seen[q] {
+				walk(q)
+			}
+		}
+	}
+	walk(first)
+	return order
+}
+
+// hasDepIssues reports whether the graph g of depGraph has cycles or
+// missing imports.
+func hasDepIssues(g map[string]interface{}) bool {
+	return len(g["cycles"].([]interface{})) > 0 || len(g["missing"].([]interface{})) > 0
+}
+
+// deps returns the package graph of the files given as argument, an object
+// mapping file names to source as for evalFiles, without evaluating them:
+// {success, packages, cycles, missing, excluded}, see depGraph. The options
+// are session and buildTags.
+func deps(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 || len(args) > 2 || args[0].Type() != js.TypeObject {
+		return map[string]interface{}{
+			"success":   false,
+			"error":     "deps requires an object mapping file names to source and an optional options object",
+			"errorCode": codeBadRequest,
+		}
+	}
+	o := optionArg(args, 1)
+	s, res := streamSession(o)
+	if res != nil {
+		return res
+	}
+	g, err := depGraph(s, args[0], parseEvalOptions(o).buildTags)
+	if err != nil {
+		code := codeBadRequest
+		if errors.As(err, &scanner.ErrorList{}) {
+			code = codeParse
+		}
+		return map[string]interface{}{
+			"success":   false,
+			"error":     "deps: " + err.Error(),
+			"errorCode": code,
+		}
+	}
+	g["success"] = true
+	return g
+}
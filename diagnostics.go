@@ -0,0 +1,252 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"regexp"
+	"strconv"
+
+	"github.com/traefik/yaegi/interp"
+)
+
+// diagnostic describes a problem located in the evaluated source.
+type diagnostic struct {
+	file     string // empty for the evaluated source
+	line     int
+	column   int
+	message  string
+	severity string
+	code     string // of a warning, see sourceWarnings
+}
+
+func (d diagnostic) toJS() map[string]interface{} {
+	m := map[string]interface{}{
+		"line":     d.line,
+		"column":   d.column,
+		"message":  d.message,
+		"severity": d.severity,
+	}
+	if d.file != "" {
+		m["file"] = d.file
+	}
+	if d.code != "" {
+		m["code"] = d.code
+	}
+	return m
+}
+
+var (
+	// positionedError matches yaegi compile errors such as
+	// "_.go:1:28: undefined: foo" or "1:28: undefined: foo".
+	positionedError = regexp.MustCompile(`^(?:([^\s:]+):)?(\d+):(\d+): (.*)$`)
+
+	// importedError matches the message of an error located in a source
+	// package imported by the evaluated code.
+	importedError = regexp.MustCompile(`^import "[^"]+" error: (.*)$`)
+
+	// panicFrame matches the frame lines yaegi writes to stderr while a
+	// panic unwinds, innermost first: "1:24: panic: main.f(...)".
+	panicFrame = regexp.MustCompile(`(?m)^(?:(.*?):)?(\d+):(\d+): panic: (.*)\(\.\.\.\)$`)
+
+	// panicFrameLine matches a frame line with its line break.
+	panicFrameLine = regexp.MustCompile(panicFrame.String() + `\n?`)
+)
+
+// errorDiagnostics converts an evaluation error into diagnostics, using the
+// captured stderr to locate runtime panics. Positions are reported in the
+// coordinates of sourceCode.
+func errorDiagnostics(err error, sourceCode, stderr string) []interface{} {
+	var diags []diagnostic
+
+	var list scanner.ErrorList
+	var p interp.Panic
+	switch {
+	case errors.As(err, &list):
+		for _, e := range list {
+			diags = append(diags, diagnostic{file: sourceName(e.Pos.Filename), line: e.Pos.Line, column: e.Pos.Column, message: e.Msg, severity: "error"})
+		}
+	case errors.As(err, &p):
+		d := diagnostic{message: "panic: " + p.Error(), severity: "error"}
+		for _, m := range panicFrame.FindAllStringSubmatch(stderr, -1) {
+			if m[1] == preludeFile {
+				continue
+			}
+			d.file = sourceName(m[1])
+			d.line, _ = strconv.Atoi(m[2])
+			d.column, _ = strconv.Atoi(m[3])
+			break
+		}
+		diags = append(diags, d)
+	default:
+		d := diagnostic{message: err.Error(), severity: "error"}
+		for m := positionedError.FindStringSubmatch(d.message); m != nil; m = positionedError.FindStringSubmatch(d.message) {
+			d.file = sourceName(m[1])
+			d.line, _ = strconv.Atoi(m[2])
+			d.column, _ = strconv.Atoi(m[3])
+			d.message = m[4]
+
+			// Report errors of imported packages where they happen.
+			im := importedError.FindStringSubmatch(d.message)
+			if im == nil || !positionedError.MatchString(im[1]) {
+				break
+			}
+			d.message = im[1]
+		}
+		diags = append(diags, d)
+	}
+
+	// Undo the shift introduced by the wrapper yaegi adds on the first line.
+	shift := wrapperShift(sourceCode)
+	out := make([]interface{}, len(diags))
+	for i, d := range diags {
+		if d.file == "" && d.line == 1 && d.column > shift {
+			d.column -= shift
+		}
+		out[i] = d.toJS()
+	}
+	return out
+}
+
+// sourceName returns the file name reported in a position, or "" for the
+// evaluated source.
+func sourceName(file string) string {
+	if file == interp.DefaultSourceName {
+		return ""
+	}
+	return file
+}
+
+// namedSource returns src placed in file by line directives, for the
+// positions yaegi reports, in errors and panics alike, to name it; src itself for no file. Declarations get the package clause
+// yaegi would add, for the file to be theirs, see sourceFile. Columns are
+// then those of src, with no wrapper shift.
+func namedSource(src, file string) string {
+	if file == "" {
+		return src
+	}
+	directive := "/*line " + file + ":1:1*/"
+	if wrapperShift(src) == 0 {
+		return directive + src
+	}
+	return directive + "package main;" + directive + src
+}
+
+// sourceFile returns file if namedSource can place src in it: for a
+// package or declarations. yaegi compiles statements in a function of
+// its default file, whose imports their package names resolve in, so
+// they stay there, named by nameSource after the fact.
+func sourceFile(src, file string) string {
+	switch wrapperShift(src) {
+	case 0:
+		return file
+	case len("package main;"):
+		// A function literal called is a statement.
+		if _, err := parser.ParseFile(token.NewFileSet(), "", "package main;"+src, parser.SkipObjectResolution); err == nil {
+			return file
+		}
+	}
+	return ""
+}
+
+// unnameSource undoes namedSource in the positions reported in result, for
+// remapPositions to see them as those of the evaluated source, until
+// nameSource names them again.
+func unnameSource(result map[string]interface{}, file string) map[string]interface{} {
+	if file == "" {
+		return result
+	}
+	if msg, ok := result["error"].(string); ok {
+		if p := positionedError.FindStringSubmatch(msg); p != nil && p[1] == file {
+			result["error"] = fmt.Sprintf("%s:%s: %s", p[2], p[3], p[4])
+		}
+	}
+	items, _ := result["diagnostics"].([]interface{})
+	for _, item := range items {
+		if e := item.(map[string]interface{}); e["file"] == file {
+			delete(e, "file")
+		}
+	}
+	items, _ = result["stack"].([]interface{})
+	for _, item := range items {
+		if e := item.(map[string]interface{}); e["file"] == file {
+			e["file"] = interp.DefaultSourceName
+		}
+	}
+	return result
+}
+
+// nameSource labels with file the positions in the evaluated source reported
+// in result, which yaegi leaves unnamed: those of its error message,
+// diagnostics, warnings and stack.
+func nameSource(result map[string]interface{}, file string) map[string]interface{} {
+	if msg, ok := result["error"].(string); ok {
+		if p := positionedError.FindStringSubmatch(msg); p != nil && sourceName(p[1]) == "" {
+			result["error"] = fmt.Sprintf("%s:%s:%s: %s", file, p[2], p[3], p[4])
+		}
+	}
+	for _, key := range []string{"diagnostics", "warnings", "stack"} {
+		items, _ := result[key].([]interface{})
+		for _, item := range items {
+			e := item.(map[string]interface{})
+			if name, _ := e["file"].(string); sourceName(name) == "" {
+				e["file"] = file
+			}
+		}
+	}
+	return result
+}
+
+// wrapperShift returns how many columns yaegi prepends to the first line of
+// an incremental source before parsing it.
+func wrapperShift(src string) int {
+	var s scanner.Scanner
+	fset := token.NewFileSet()
+	s.Init(fset.AddFile("", fset.Base(), len(src)), []byte(src), nil, 0)
+
+	_, tok, _ := s.Scan()
+	switch tok {
+	case token.PACKAGE:
+		return 0
+	case token.CONST, token.FUNC, token.IMPORT, token.TYPE, token.VAR:
+		return len("package main;")
+	}
+	return len("package main; func main() {")
+}
+
+// panicStack returns the interpreted frames of a recovered panic, innermost
+// first, as reported by yaegi on stderr. Only frames of interpreted code are
+// listed, so the trace starts at the user's code rather than in the host,
+// and those of a prelude are left out.
+func panicStack(err error, sourceCode, stderr string) []interface{} {
+	var p interp.Panic
+	if !errors.As(err, &p) {
+		return nil
+	}
+
+	shift := wrapperShift(sourceCode)
+	var frames []interface{}
+	for _, m := range panicFrame.FindAllStringSubmatch(stderr, -1) {
+		file := m[1]
+		if file == preludeFile {
+			continue
+		}
+		if file == "" {
+			file = interp.DefaultSourceName
+		}
+		line, _ := strconv.Atoi(m[2])
+		column, _ := strconv.Atoi(m[3])
+		if file == interp.DefaultSourceName && line == 1 && column > shift {
+			column -= shift
+		}
+		frames = append(frames, map[string]interface{}{
+			"function": m[4],
+			"file":     file,
+			"line":     line,
+			"column":   column,
+		})
+	}
+	return frames
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"syscall/js"
+	"time"
+)
+
+// disposeWait bounds the wait of dispose for the evals it cancelled, which
+// a loop that never yields does not end.
+const disposeWait = time.Second
+
+// disposeExitDelay is the time main outlives dispose.
+const disposeExitDelay = 10 * time.Millisecond
+
+var (
+	// keepAlive keeps main running until dispose closes it.
+	keepAlive = make(chan struct{})
+	// apiFuncs are the functions of globalThis.yaegi, called through
+	// Proxies sharing the handler apiHandler.
+	apiFuncs   []js.Func
+	apiHandler js.Value
+	// messageFunc listens to the messages of serveMessages, if it runs.
+	messageFunc js.Func
+	// disposed is set once dispose is called.
+	disposed atomic.Bool
+)
+
+// apiFunc returns the function of globalThis.yaegi calling fn: a Proxy of
+// it, which throws once the module is disposed.
+func apiFunc(fn func(this js.Value, args []js.Value) interface{}) js.Value {
+	if apiHandler.IsUndefined() {
+		apiHandler = js.Global().Get("Object").New()
+	}
+	f := js.FuncOf(fn)
+	apiFuncs = append(apiFuncs, f)
+	return js.Global().Get("Proxy").New(f.Value, apiHandler)
+}
+
+// disposedTrap returns the apply trap of the functions of the API once the
+// module is disposed, throwing an Error "yaegi disposed". A page that
+// forbids the Function constructor gets a trap that is not a function,
+// whose calls throw a TypeError naming it instead.
+func disposedTrap() (trap js.Value) {
+	defer func() {
+		if recover() != nil {
+			trap = js.ValueOf("yaegi disposed")
+		}
+	}()
+	return js.Global().Get("Function").New(`throw new Error("yaegi disposed")`)
+}
+
+// dispose tears the module down, for a page replacing it: it cancels the
+// evals, runs and jobs of every session, releases the functions of the API
+// and those made by interpreted code, removes globalThis.yaegi and lets
+// main return. The Promise it returns resolves once done; the functions of
+// the API throw "yaegi disposed" from then on.
+func dispose(this js.Value, args []js.Value) interface{} {
+	if disposed.Swap(true) {
+		return rejectedPromise(newJSError("yaegi disposed", nil))
+	}
+	return newPromise(func(resolve, reject func(interface{})) {
+		jobsMu.Lock()
+		for _, j := range jobs {
+			j.cancel()
+		}
+		jobsMu.Unlock()
+
+		list := allSessions()
+		for _, s := range list {
+			s.cancelEvals()
+			s.stopRuns()
+			stopDebug(s)
+			s.closeFeed()
+		}
+		// Keep the slots, so that no eval starts again.
+		ctx, cancel := context.WithTimeout(context.Background(), disposeWait)
+		defer cancel()
+		for _, s := range list {
+			s.acquireEval(ctx, true)
+			s.releaseFuncs()
+			s.releaseHostIO()
+			s.takeBindings()
+		}
+
+		releaseSubscribers()
+		if !crashState.IsUndefined() {
+			// main returning is no crash.
+			crashState.Set("disposed", true)
+		}
+		apiHandler.Set("apply", disposedTrap())
+		for _, f := range apiFuncs {
+			f.Release()
+		}
+		global := js.Global()
+		if !messageFunc.IsUndefined() {
+			global.Call("removeEventListener", "message", messageFunc)
+			messageFunc.Release()
+		}
+		if window := global.Get("window"); window.Type() == js.TypeObject && !window.Equal(global) {
+			window.Delete("yaegi")
+		}
+		global.Delete("yaegi")
+
+		resolve(nil)
+		// Let the callbacks running, dispose among them, return to JS
+		// before main does.
+		time.Sleep(disposeExitDelay)
+		close(keepAlive)
+	})
+}
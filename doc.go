@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"go/constant"
+	"go/token"
+	"reflect"
+	"sort"
+	"strings"
+	"syscall/js"
+)
+
+// doc describes a package symbol, "pkg.Name", or a symbol of the default
+// session, "Name": {found, name, kind, pkg, signature}, plus the method set
+// of types. Parameter names are not known, only their types. Unknown names
+// yield {found: false}. It fails as busy while an eval uses the
+// interpreter.
+func doc(this js.Value, args []js.Value) interface{} {
+	if len(args) != 1 || args[0].Type() != js.TypeString {
+		return map[string]interface{}{
+			"success": false,
+			"error":   "doc requires a symbol name",
+		}
+	}
+	name := args[0].String()
+
+	s := defaultSession()
+	if err := s.acquireEval(context.Background(), false); err != nil {
+		return map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+			"busy":    true,
+		}
+	}
+	defer s.releaseEval()
+	pkg, v, ok := s.lookupSymbol(name)
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		name = name[i+1:]
+	}
+	if sig, generic := genericFuncs()[pkg][name]; !ok && generic && s.config.allows(pkg) {
+		return map[string]interface{}{
+			"found":     true,
+			"name":      name,
+			"kind":      "func",
+			"pkg":       pkg,
+			"signature": sig,
+		}
+	}
+	if !ok {
+		return map[string]interface{}{"found": false}
+	}
+	c := symbolCompletion(name, v, pkg)
+
+	result := map[string]interface{}{
+		"found":     true,
+		"name":      name,
+		"kind":      c.kind,
+		"pkg":       pkg,
+		"signature": signature(c.kind, name, v),
+	}
+	if c.kind == "type" {
+		result["methods"] = stringsToJS(methodSet(name, v.Type().Elem()))
+	}
+	return result
+}
+
+// lookupSymbol finds the symbol name, qualified by a package name or import
+// path or else defined in the main package of s, and returns the import
+// path of its package.
+func (s *session) lookupSymbol(name string) (pkg string, v reflect.Value, ok bool) {
+	defer func() {
+		// Listing the symbols of a broken declaration may panic.
+		if recover() != nil {
+			ok = false
+		}
+	}()
+
+	i := strings.LastIndex(name, ".")
+	if i < 0 {
+		if v, ok := s.interpreter.Symbols("main")["main"][name]; ok {
+			return "main", v, true
+		}
+		v, ok := s.interpreter.Globals()[name]
+		return "main", v, ok
+	}
+
+	pkg, name = name[:i], name[i+1:]
+	if !strings.Contains(pkg, "/") {
+		candidates := s.importCandidates()[pkg]
+		c, found := chooseImport(pkg, map[string]bool{name: true}, candidates)
+		if !found {
+			c, found = chooseImport(pkg, nil, candidates)
+		}
+		if !found {
+			return "", reflect.Value{}, false
+		}
+		pkg = c.path
+	}
+	v, ok = s.packageSymbols(pkg)[name]
+	return pkg, v, ok && token.IsExported(name)
+}
+
+// signature renders the declaration of a symbol of the given kind.
+func signature(kind, name string, v reflect.Value) string {
+	switch kind {
+	case "func":
+		return "func " + name + strings.TrimPrefix(v.Type().String(), "func")
+	case "type":
+		return "type " + name + " " + underlying(v.Type().Elem())
+	case "const":
+		if cv, ok := v.Interface().(constant.Value); ok {
+			return "const " + name + " = " + cv.String()
+		}
+		return fmt.Sprintf("const %s %s = %v", name, v.Type(), v.Interface())
+	}
+	if !v.IsValid() {
+		return "var " + name
+	}
+	return "var " + name + " " + v.Type().String()
+}
+
+// underlying renders the underlying type of t, leaving out the fields of
+// structs and the methods of interfaces.
+func underlying(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Array:
+		return fmt.Sprintf("[%d]%s", t.Len(), t.Elem())
+	case reflect.Chan:
+		return "chan " + t.Elem().String()
+	case reflect.Func:
+		return "func" + funcParams(t, 0)
+	case reflect.Map:
+		return fmt.Sprintf("map[%s]%s", t.Key(), t.Elem())
+	case reflect.Ptr:
+		return "*" + t.Elem().String()
+	case reflect.Slice:
+		return "[]" + t.Elem().String()
+	}
+	return t.Kind().String()
+}
+
+// methodSet returns the signatures of the exported methods of the type
+// name, t, and of *t if t is not an interface, sorted by name.
+func methodSet(name string, t reflect.Type) []string {
+	methods := []string{}
+	if t.Kind() == reflect.Interface {
+		for i := 0; i < t.NumMethod(); i++ {
+			m := t.Method(i)
+			methods = append(methods, "func ("+name+") "+m.Name+funcParams(m.Type, 0))
+		}
+		return methods
+	}
+
+	// The receiver is the first parameter of methods.
+	value := map[string]bool{}
+	for i := 0; i < t.NumMethod(); i++ {
+		m := t.Method(i)
+		value[m.Name] = true
+		methods = append(methods, "func ("+name+") "+m.Name+funcParams(m.Type, 1))
+	}
+	ptr := reflect.PtrTo(t)
+	for i := 0; i < ptr.NumMethod(); i++ {
+		if m := ptr.Method(i); !value[m.Name] {
+			methods = append(methods, "func (*"+name+") "+m.Name+funcParams(m.Type, 1))
+		}
+	}
+	sort.Slice(methods, func(i, j int) bool {
+		return methods[i][strings.Index(methods[i], ") ")+2:] < methods[j][strings.Index(methods[j], ") ")+2:]
+	})
+	return methods
+}
+
+// funcParams renders the parameters of the function type t from the
+// first one on, and its results.
+func funcParams(t reflect.Type, first int) string {
+	var in []string
+	for i := first; i < t.NumIn(); i++ {
+		if t.IsVariadic() && i == t.NumIn()-1 {
+			in = append(in, "..."+t.In(i).Elem().String())
+		} else {
+			in = append(in, t.In(i).String())
+		}
+	}
+
+	var out []string
+	for i := 0; i < t.NumOut(); i++ {
+		out = append(out, t.Out(i).String())
+	}
+	s := "(" + strings.Join(in, ", ") + ")"
+	switch len(out) {
+	case 0:
+		return s
+	case 1:
+		return s + " " + out[0]
+	}
+	return s + " (" + strings.Join(out, ", ") + ")"
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// echoCall, closed by a parenthesis, wraps the trailing expression of a
+// source to collect its values, however many it has. The parentheses keep
+// a source starting with it a list of statements.
+const echoCall = "(func(v ...interface{}) []interface{} { return v })("
+
+// echoVar receives the values of the trailing expression of a snippet,
+// whose statements run in an init function.
+const echoVar = "yaegiEcho"
+
+// noValue matches the error of echoCall applied to a call returning
+// nothing, which is then evaluated as it is. yaegi names the type of the
+// function called, or nothing for builtins such as panic.
+var noValue = regexp.MustCompile(`cannot use (func\(.*\))? as type \(\.\.\.interface\{\}\)$`)
+
+// echoModes are the values of the option echo: the values of a trailing
+// expression are returned as the value of the result, written to stdout
+// like the native yaegi REPL does, or dropped.
+var echoModes = []string{"value", "output", "off"}
+
+// echoEdit is a source with its trailing expression wrapped in echoCall.
+type echoEdit struct {
+	src   string
+	moved insertMap // the text inserted
+	fun   string    // function called by the expression if named, see echoTypes
+}
+
+// echoSource wraps in echoCall the last statement of src, a list of
+// statements, if it is a call, or any expression with all, or returns nil.
+// The value yaegi returns for other expressions keeps their static type.
+func echoSource(src string, all bool) *echoEdit {
+	f, err := parseFragment(src)
+	if err != nil || f.body < 0 {
+		return nil
+	}
+	for _, d := range f.file.Decls {
+		if fn, ok := d.(*ast.FuncDecl); ok && fn.Name.Name == "_" {
+			return wrapEcho(src, fn.Body, f.offset, "", all)
+		}
+	}
+	return nil
+}
+
+// isProgram reports whether src is a whole Go file, led by a package
+// clause, which has no trailing expression: the value yaegi returns for it
+// is that of its main function.
+func isProgram(src string) bool {
+	_, err := parser.ParseFile(token.NewFileSet(), "", src, parser.PackageClauseOnly)
+	return err == nil
+}
+
+// echoProgram assigns to echoVar the last statement of the text of a
+// snippet if it is an expression, or returns nil.
+func echoProgram(text string) *echoEdit {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", text, 0)
+	if err != nil {
+		return nil
+	}
+	offset := func(p token.Pos) int { return fset.Position(p).Offset }
+	for _, d := range file.Decls {
+		if fn, ok := d.(*ast.FuncDecl); ok && fn.Name.Name == "init" && fn.Recv == nil {
+			if e := wrapEcho(text, fn.Body, offset, echoVar+" = ", true); e != nil {
+				e.src += "\nvar " + echoVar + " []interface{}"
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+// wrapEcho wraps in echoCall the last statement of body in src, after
+// head, if it is a call, or any expression with all.
+func wrapEcho(src string, body *ast.BlockStmt, offset func(token.Pos) int, head string, all bool) *echoEdit {
+	if len(body.List) == 0 {
+		return nil
+	}
+	x, ok := body.List[len(body.List)-1].(*ast.ExprStmt)
+	if _, call := unparen(x).(*ast.CallExpr); !ok || !call && !all {
+		return nil
+	}
+	start, end := offset(x.Pos()), offset(x.End())
+	line := strings.Count(src[:start], "\n") + 1
+	column := start - strings.LastIndex(src[:start], "\n")
+	inserted := head + echoCall
+	e := &echoEdit{
+		src:   src[:start] + inserted + src[start:end] + ")" + src[end:],
+		moved: insertMap{line: {{column, len(inserted)}}},
+	}
+	if call, ok := unparen(x).(*ast.CallExpr); ok && namedFunc(call.Fun) {
+		e.fun = src[offset(call.Fun.Pos()):offset(call.Fun.End())]
+	}
+	return e
+}
+
+// unparen returns the expression of x without its parentheses, or nil if x
+// is nil.
+func unparen(x *ast.ExprStmt) ast.Expr {
+	if x == nil {
+		return nil
+	}
+	return ast.Unparen(x.X)
+}
+
+// namedFunc reports whether x, the function of a call, is a name or a
+// selector of names, which can be evaluated again without side effects.
+func namedFunc(x ast.Expr) bool {
+	switch x := x.(type) {
+	case *ast.Ident:
+		return true
+	case *ast.SelectorExpr:
+		return namedFunc(x.X)
+	}
+	return false
+}
+
+// echoTypes returns the result types of the function fun of an echoEdit,
+// or nil if they are not known.
+func (s *session) echoTypes(fun string) []reflect.Type {
+	if fun == "" {
+		return nil
+	}
+	defer func() { recover() }()
+	fn, err := s.interpreter.Eval(fun)
+	if err != nil {
+		return nil
+	}
+	return resultTypes(fn)
+}
+
+// echoed returns the values collected by echoCall in v.
+func echoed(v reflect.Value) []interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	values, _ := v.Interface().([]interface{})
+	return values
+}
+
+// echoText renders the values of a trailing expression as a line of
+// output: their renderValue form, or the message of an error.
+func echoText(values []interface{}, l renderLimits) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		if err, ok := v.(error); ok {
+			parts[i] = err.Error()
+		} else {
+			parts[i] = renderValue(reflect.ValueOf(v), l)
+		}
+	}
+	return strings.Join(parts, " ") + "\n"
+}
+
+// echoFields returns the fields of a result for the values of a trailing
+// expression, of the given types if known: those of resultFields,
+// valueType, a tuple type such as "(int, string)" for several values, and
+// valueString, their renderValue forms, comma-separated, goError aside.
+func echoFields(values []interface{}, types []reflect.Type, l renderLimits) map[string]interface{} {
+	rvs := make([]reflect.Value, len(values))
+	names := make([]string, len(values))
+	for i, v := range values {
+		rvs[i] = reflect.ValueOf(v)
+		switch {
+		case len(types) == len(values):
+			names[i] = types[i].String()
+		case v == nil:
+			names[i] = "nil"
+		default:
+			names[i] = rvs[i].Type().String()
+		}
+	}
+	res := resultFields(rvs, types)
+	shown := rvs
+	if _, ok := res["goError"]; ok {
+		shown = rvs[:len(rvs)-1]
+	}
+	parts := make([]string, len(shown))
+	for i, v := range shown {
+		parts[i] = renderValue(v, l)
+	}
+	res["valueString"] = nil
+	if len(shown) > 0 {
+		res["valueString"] = strings.Join(parts, ", ")
+	}
+	switch {
+	case len(values) == 1:
+		res["valueType"] = valueTypeName(rvs[0])
+	case len(values) > 1:
+		res["valueType"] = "(" + strings.Join(names, ", ") + ")"
+	}
+	return res
+}
+
+// echo handles the values collected by echoCall in v, the value of a
+// successful eval, according to mode. It returns the value of the eval
+// and the values, for echoResult. The output is written while it is
+// captured.
+func (s *session) echo(v reflect.Value, mode string, l renderLimits) (reflect.Value, []interface{}) {
+	values := echoed(v)
+	if mode == "output" {
+		if values != nil {
+			s.stdout.Write([]byte(echoText(values, l)))
+		}
+		return reflect.Value{}, values
+	}
+	return v, values
+}
+
+// echoResult sets the value of result according to the echo mode, given
+// the values of its trailing expression, if any, and their types.
+func echoResult(result map[string]interface{}, mode string, values []interface{}, types []reflect.Type, l renderLimits) {
+	if result["success"] != true {
+		return
+	}
+	switch {
+	case mode == "off":
+		result["value"], result["valueType"], result["valueString"] = nil, nil, nil
+	case values != nil && mode != "output":
+		delete(result, "value")
+		for k, v := range echoFields(values, types, l) {
+			result[k] = v
+		}
+	}
+}
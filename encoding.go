@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"syscall/js"
+)
+
+// maxDecodedBytes caps a decoded source, which a small gzip payload could
+// make huge.
+const maxDecodedBytes = 8 << 20
+
+// sourceEncodings are the values of the option encoding of eval, and of
+// encodeSource: the source is base64, or gzip then base64, in the standard
+// or URL alphabet, padded or not.
+var sourceEncodings = []string{"base64", "gzip+base64"}
+
+// decodeError is a source payload that does not decode.
+type decodeError struct {
+	err error
+}
+
+func (e *decodeError) Error() string {
+	return "decode source: " + e.err.Error()
+}
+
+func (e *decodeError) Unwrap() error {
+	return e.err
+}
+
+// decodeSource returns the source encoded in payload with encoding, one of
+// sourceEncodings, or payload itself for "".
+func decodeSource(payload, encoding string) (string, error) {
+	switch encoding {
+	case "":
+		return payload, nil
+	case "base64", "gzip+base64":
+	default:
+		return "", fmt.Errorf("unknown encoding %q, expected one of %s", encoding, strings.Join(sourceEncodings, ", "))
+	}
+
+	// Accept both alphabets, with or without padding.
+	payload = strings.NewReplacer("-", "+", "_", "/", "=", "", "\n", "", "\r", "").Replace(strings.TrimSpace(payload))
+	b, err := base64.RawStdEncoding.DecodeString(payload)
+	if err != nil {
+		return "", &decodeError{err}
+	}
+	if encoding == "base64" {
+		return string(b), nil
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return "", &decodeError{err}
+	}
+	out, err := io.ReadAll(io.LimitReader(zr, maxDecodedBytes+1))
+	if err != nil {
+		return "", &decodeError{err}
+	}
+	if len(out) > maxDecodedBytes {
+		return "", &decodeError{fmt.Errorf("source larger than %d bytes", maxDecodedBytes)}
+	}
+	return string(out), nil
+}
+
+// decodeFailure returns the result of an eval whose source failed to
+// decode with err.
+func decodeFailure(err error) map[string]interface{} {
+	code := codeBadRequest
+	if errors.As(err, new(*decodeError)) {
+		code = codeDecode
+	}
+	return map[string]interface{}{
+		"success":   false,
+		"error":     err.Error(),
+		"errorCode": code,
+	}
+}
+
+// encodeSource encodes a source for the option encoding of eval, e.g. in a
+// share link: yaegi.encodeSource(src, {encoding: "base64"}), gzip+base64
+// by default. The base64 is in the URL alphabet, without padding.
+func encodeSource(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 || len(args) > 2 || args[0].Type() != js.TypeString {
+		return map[string]interface{}{
+			"success": false,
+			"error":   "encodeSource requires the Go source code and an optional options object",
+		}
+	}
+	encoding := "gzip+base64"
+	if opts := optionArg(args, 1); opts.Type() == js.TypeObject {
+		if e := opts.Get("encoding"); e.Type() == js.TypeString {
+			encoding = e.String()
+		}
+	}
+
+	b := []byte(args[0].String())
+	switch encoding {
+	case "base64":
+	case "gzip+base64":
+		var buf bytes.Buffer
+		zw, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+		zw.Write(b)
+		zw.Close()
+		b = buf.Bytes()
+	default:
+		return map[string]interface{}{
+			"success": false,
+			"error":   fmt.Sprintf("encodeSource: unknown encoding %q, expected one of %s", encoding, strings.Join(sourceEncodings, ", ")),
+		}
+	}
+	return map[string]interface{}{
+		"success": true,
+		"encoded": base64.RawURLEncoding.EncodeToString(b),
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"syscall/js"
+
+	"github.com/traefik/yaegi/interp"
+)
+
+// maxRandomChunk is the most bytes crypto.getRandomValues fills at once.
+const maxRandomChunk = 65536
+
+// errNoEntropy fails the reads of crypto/rand without a secure source.
+var errNoEntropy = errors.New("crypto/rand: no secure random source: the JS runtime has no crypto.getRandomValues (Node.js before 19 needs globalThis.crypto = require(\"crypto\").webcrypto)")
+
+// webCrypto returns the crypto object of the JS global, or undefined if it
+// has no getRandomValues.
+func webCrypto() js.Value {
+	c := js.Global().Get("crypto")
+	if c.Type() != js.TypeObject || c.Get("getRandomValues").Type() != js.TypeFunction {
+		return js.Undefined()
+	}
+	return c
+}
+
+// webCryptoReader reads random bytes from crypto.getRandomValues, looked
+// up at each read so that a host may install it after the module started.
+type webCryptoReader struct{}
+
+func (webCryptoReader) Read(p []byte) (n int, err error) {
+	c := webCrypto()
+	if c.IsUndefined() {
+		return 0, errNoEntropy
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("crypto/rand: crypto.getRandomValues failed: %v", r)
+		}
+	}()
+	for n < len(p) {
+		chunk := min(len(p)-n, maxRandomChunk)
+		buf := js.Global().Get("Uint8Array").New(chunk)
+		c.Call("getRandomValues", buf)
+		n += js.CopyBytesToGo(p[n:n+chunk], buf)
+	}
+	return n, nil
+}
+
+// cryptoRandSymbols returns crypto/rand.Reader and crypto/rand.Read of
+// interpreted code, drawing from crypto.getRandomValues: the runtime
+// source may be missing or unseeded depending on the JS runtime, and
+// reading it would then throw or return zeros. Reader is a variable of
+// the interpreter, which its code may replace.
+func cryptoRandSymbols() interp.Exports {
+	var reader io.Reader = webCryptoReader{}
+	return interp.Exports{"crypto/rand/rand": {
+		"Reader": reflect.ValueOf(&reader).Elem(),
+		"Read": reflect.ValueOf(func(b []byte) (int, error) {
+			return io.ReadFull(reader, b)
+		}),
+	}}
+}
+
+// entropyCheck reports whether a secure random source backs crypto/rand:
+// {success, available, source}, source being "crypto.getRandomValues" or
+// null, with the reason when unavailable.
+func entropyCheck(this js.Value, args []js.Value) interface{} {
+	var probe [16]byte
+	_, err := webCryptoReader{}.Read(probe[:])
+	if err == nil && probe == [16]byte{} {
+		err = errors.New("crypto/rand: crypto.getRandomValues returned zeros")
+	}
+	if err != nil {
+		return map[string]interface{}{
+			"success":   true,
+			"available": false,
+			"source":    nil,
+			"reason":    err.Error(),
+		}
+	}
+	return map[string]interface{}{
+		"success":   true,
+		"available": true,
+		"source":    "crypto.getRandomValues",
+	}
+}
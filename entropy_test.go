@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"testing"
+)
+
+func TestRandomBytes(t *testing.T) {
+	res := callAPI(t, "entropyCheck")
+	mustSucceed(t, res)
+	if !res.Get("available").Bool() {
+		t.Fatalf("entropyCheck = %s, want a source available", jsonString(res))
+	}
+
+	id := newTestSession(t, nil)
+	mustSucceed(t, callAPI(t, "evalIn", id, `import (
+	"crypto/rand"
+	"fmt"
+)`))
+	read := func() []byte {
+		t.Helper()
+		res := callAPI(t, "evalIn", id, `
+b := make([]byte, 32)
+if _, err := rand.Read(b); err != nil {
+	panic(err)
+}
+fmt.Printf("%x", b)
+`)
+		mustSucceed(t, res)
+		b, err := hex.DecodeString(res.Get("output").String())
+		if err != nil || len(b) != 32 {
+			t.Fatalf("output = %q, want 32 bytes in hex", res.Get("output").String())
+		}
+		return b
+	}
+	a, b := read(), read()
+	zero := make([]byte, 32)
+	if bytes.Equal(a, zero) || bytes.Equal(b, zero) {
+		t.Errorf("rand.Read gave zeros: %x, %x", a, b)
+	}
+	if bytes.Equal(a, b) {
+		t.Errorf("rand.Read gave %x twice", a)
+	}
+}
+
+func TestSeededRandInOtherInterpreters(t *testing.T) {
+	mustSucceed(t, callAPI(t, "configure", map[string]interface{}{"randSeed": 42}))
+	callAPI(t, "reset")
+	t.Cleanup(func() {
+		callAPI(t, "configure", map[string]interface{}{"randSeed": nil})
+		callAPI(t, "reset")
+	})
+
+	res := callAPI(t, "eval", "package main\n\nimport (\n\t\"fmt\"\n\t\"math/rand\"\n)\n\nfunc main() { fmt.Print(rand.Intn(1000000)) }\n")
+	mustSucceed(t, res)
+	want := res.Get("output").String()
+
+	const src = "package main\n\nimport \"math/rand\"\n\nfunc F() int { return rand.Intn(1000000) }\n"
+	res = callAPI(t, "compare", src, src, "F", map[string]interface{}{"rounds": 1, "warmup": 0})
+	mustSucceed(t, res)
+	for _, side := range []string{"a", "b"} {
+		if got := fmt.Sprint(res.Get(side).Get("result").Int()); got != want {
+			t.Errorf("rand.Intn of compare side %s = %s, want %s as in an eval", side, got, want)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"syscall/js"
+
+	"github.com/traefik/yaegi/interp"
+)
+
+// envEntries reads the env option of v: an array of "KEY=value" entries
+// or an object mapping keys to values.
+func envEntries(v js.Value) []string {
+	env := v.Get("env")
+	if env.Type() != js.TypeObject || env.InstanceOf(js.Global().Get("Array")) {
+		return optionStrings(v, "env")
+	}
+	keys := js.Global().Get("Object").Call("keys", env)
+	entries := make([]string, 0, keys.Length())
+	for i := 0; i < keys.Length(); i++ {
+		key := keys.Index(i).String()
+		entries = append(entries, key+"="+js.Global().Get("String").Invoke(env.Get(key)).String())
+	}
+	sort.Strings(entries)
+	return entries
+}
+
+// resetEnv sets the environment of s back to the one it was configured
+// with.
+func (s *session) resetEnv() {
+	env := make(map[string]string, len(s.config.env))
+	for _, entry := range s.config.env {
+		if key, value, ok := strings.Cut(entry, "="); ok {
+			env[key] = value
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.env = env
+}
+
+func (s *session) lookupEnv(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	value, ok := s.env[key]
+	return value, ok
+}
+
+func (s *session) setEnv(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.env[key] = value
+}
+
+func (s *session) unsetEnv(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.env, key)
+}
+
+// environ returns the environment of s as sorted "KEY=value" entries.
+func (s *session) environ() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := make([]string, 0, len(s.env))
+	for key, value := range s.env {
+		entries = append(entries, key+"="+value)
+	}
+	sort.Strings(entries)
+	return entries
+}
+
+// envSymbols returns the environment functions of the os package working
+// on the environment of s, so interpreted code sees exactly what was set
+// through configure, createSession and setEnv, in both sandboxing modes.
+func (s *session) envSymbols() interp.Exports {
+	getenv := func(key string) string {
+		value, _ := s.lookupEnv(key)
+		return value
+	}
+	return interp.Exports{"os/os": {
+		"Getenv":    reflect.ValueOf(getenv),
+		"LookupEnv": reflect.ValueOf(s.lookupEnv),
+		"Environ":   reflect.ValueOf(s.environ),
+		"ExpandEnv": reflect.ValueOf(func(v string) string { return os.Expand(v, getenv) }),
+		"Setenv": reflect.ValueOf(func(key, value string) error {
+			s.setEnv(key, value)
+			return nil
+		}),
+		"Unsetenv": reflect.ValueOf(func(key string) error {
+			s.unsetEnv(key)
+			return nil
+		}),
+		"Clearenv": reflect.ValueOf(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+
+			s.env = map[string]string{}
+		}),
+	}}
+}
+
+// setEnvVar sets the environment variable given as first argument to the
+// second in the default session. It applies from the next eval on.
+func setEnvVar(this js.Value, args []js.Value) interface{} {
+	if len(args) != 2 || args[0].Type() != js.TypeString || args[1].Type() != js.TypeString {
+		return map[string]interface{}{
+			"success": false,
+			"error":   "setEnv requires a key and a string value",
+		}
+	}
+	key := args[0].String()
+	if key == "" || strings.Contains(key, "=") {
+		return map[string]interface{}{
+			"success": false,
+			"error":   "invalid environment variable name " + key,
+		}
+	}
+
+	defaultSession().setEnv(key, args[1].String())
+	return map[string]interface{}{"success": true}
+}
+
+// unsetEnvVar removes the environment variable given as argument from the
+// default session.
+func unsetEnvVar(this js.Value, args []js.Value) interface{} {
+	if len(args) != 1 || args[0].Type() != js.TypeString {
+		return map[string]interface{}{
+			"success": false,
+			"error":   "unsetEnv requires a key",
+		}
+	}
+
+	defaultSession().unsetEnv(args[0].String())
+	return map[string]interface{}{"success": true}
+}
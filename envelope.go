@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"go/scanner"
+	"strings"
+	"syscall/js"
+
+	"github.com/traefik/yaegi/interp"
+)
+
+// apiVersion is the version of the result envelope, see envelope.
+const apiVersion = 2
+
+// The codes of the errors of results, see errorCode.
+const (
+	codeParse       = "parse_error"              // the source does not parse
+	codeType        = "type_error"               // it does not compile
+	codePanic       = "runtime_panic"            // the code panicked
+	codeExit        = "exit_status"              // it called os.Exit with a non-zero status
+	codeTimeout     = "timeout"                  // it ran past the timeout option
+	codeCancelled   = "cancelled"                // yaegi.cancel() interrupted it
+	codeBusy        = "busy"                     // another eval was running
+	codeLimit       = "limit_exceeded"           // it exceeded a step, memory, output or run limit
+	codeOverflow    = "stack_overflow"           // it recursed past maxCallDepth
+	codeGoexit      = "goexit"                   // it called runtime.Goexit from the main goroutine
+	codeDeadlock    = "deadlock_timeout"         // it waited forever, see watchDeadlock
+	codeInternal    = "internal"                 // yaegi itself failed
+	codeBadRequest  = "invalid_request"          // the command was called with bad arguments, or for nothing
+	codeFetch       = "fetch_error"              // evalURL could not fetch the source
+	codeDecode      = "decode_error"             // the source does not decode, see decodeSource
+	codeResolve     = "import_resolution_failed" // the importResolver failed, see resolveImports
+	codeModuleDead  = "module_dead"              // the module exited, see onFatal
+	codeRateLimited = "rate_limited"             // the session ran past its quota, see admit
+	codePolicy      = "policy_violation"         // it imports outside allowImports, see checkImports
+)
+
+// errorCode returns the code of err, an error of an eval: one of the codes
+// above. Compile errors of yaegi have no type of their own, but a position.
+func errorCode(err error) string {
+	if code, ok := exitCode(err); ok && code != 0 {
+		return codeExit
+	}
+	switch {
+	case errors.Is(err, errGoexit):
+		return codeGoexit
+	case errors.As(err, new(deadlockError)):
+		return codeDeadlock
+	case errors.Is(err, context.DeadlineExceeded):
+		return codeTimeout
+	case errors.Is(err, context.Canceled):
+		return codeCancelled
+	case errors.Is(err, errBusy):
+		return codeBusy
+	case errors.Is(err, errStepBudget), errors.Is(err, errMemoryLimit):
+		return codeLimit
+	case stackOverflowed(err) != nil:
+		return codeOverflow
+	case errors.Is(err, errFatal), isFatal(err):
+		return codeInternal
+	case errors.As(err, new(*fetchError)):
+		return codeFetch
+	case errors.As(err, &scanner.ErrorList{}):
+		return codeParse
+	case errors.As(err, &interp.Panic{}):
+		return codePanic
+	case strings.HasPrefix(err.Error(), "panic: "):
+		return codePanic
+	case positionedError.MatchString(err.Error()):
+		return codeType
+	}
+	return codeInternal
+}
+
+// legacyCompat reports whether results keep the flat shape they had before
+// the envelope, with the error a string beside its diagnostics.
+func legacyCompat() bool {
+	settings.Lock()
+	defer settings.Unlock()
+
+	return settings.legacyCompat
+}
+
+// enveloped wraps the command fn so that the results it returns, unless a
+// Promise, are put in the envelope.
+func enveloped(fn func(this js.Value, args []js.Value) interface{}) func(this js.Value, args []js.Value) interface{} {
+	return func(this js.Value, args []js.Value) interface{} {
+		return envelopeValue(fn(this, args))
+	}
+}
+
+// envelopeValue returns v put in the envelope if it is a result.
+func envelopeValue(v interface{}) interface{} {
+	if res, ok := v.(map[string]interface{}); ok {
+		return envelope(res)
+	}
+	return v
+}
+
+// resultCode returns the code of the failed result res: its errorCode
+// field, set where the error of an eval is known, or what its busy,
+// timedOut, cancelled and fatal fields tell.
+func resultCode(res map[string]interface{}) string {
+	if code, ok := res["errorCode"].(string); ok {
+		return code
+	}
+	switch {
+	case res["busy"] == true:
+		return codeBusy
+	case res["timedOut"] == true:
+		return codeTimeout
+	case res["cancelled"] == true:
+		return codeCancelled
+	case res["fatal"] == true:
+		return codeInternal
+	}
+	return codeBadRequest
+}
+
+// envelope returns the result res of a command in the shape common to all
+// commands: {apiVersion, success, output, stderr, value, error, stats},
+// error being null or {code, message, diagnostics} with the code of
+// resultCode, and the fields particular to the command beside them. A
+// result without success cannot fail. res is left unchanged; with
+// legacyCompat, only the errorCode field is dropped from a copy.
+func envelope(res map[string]interface{}) map[string]interface{} {
+	if _, done := res["apiVersion"]; done {
+		return res
+	}
+	out := make(map[string]interface{}, len(res)+4)
+	for k, v := range res {
+		out[k] = v
+	}
+	delete(out, "errorCode")
+	if r, ok := out["result"].(map[string]interface{}); ok {
+		// jobStatus holds the result of a job.
+		out["result"] = envelope(r)
+	}
+	if legacyCompat() {
+		return out
+	}
+
+	failed := out["success"] == false
+	var e interface{}
+	if failed {
+		diags, _ := out["diagnostics"].([]interface{})
+		if diags == nil {
+			diags = []interface{}{}
+		}
+		e = map[string]interface{}{
+			"code":        resultCode(res),
+			"message":     out["error"],
+			"diagnostics": diags,
+		}
+	}
+	delete(out, "diagnostics")
+	out["apiVersion"] = apiVersion
+	out["success"] = !failed
+	out["error"] = e
+	for _, k := range []string{"output", "stderr"} {
+		if _, ok := out[k]; !ok {
+			out[k] = ""
+		}
+	}
+	for _, k := range []string{"value", "stats"} {
+		if _, ok := out[k]; !ok {
+			out[k] = nil
+		}
+	}
+	return out
+}
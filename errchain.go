@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"reflect"
+)
+
+// maxErrorChain caps the entries of an errorChain, and its nesting, past
+// which an error unwrapping to itself would never end.
+const maxErrorChain = 32
+
+// errorSentinels are the errors of the errorIs field of a result, by name.
+var errorSentinels = []struct {
+	name string
+	err  error
+}{
+	{"notExist", fs.ErrNotExist},
+	{"permission", fs.ErrPermission},
+	{"eof", io.EOF},
+	{"deadlineExceeded", context.DeadlineExceeded},
+}
+
+// errorFields returns the fields of a result describing err, a returned
+// error, beside its goError message: errorChain, from errorChain, and
+// errorIs, whether errors.Is matches err with each of errorSentinels.
+func errorFields(err error) map[string]interface{} {
+	is := map[string]interface{}{}
+	for _, s := range errorSentinels {
+		is[s.name] = errors.Is(err, s.err)
+	}
+	return map[string]interface{}{
+		"errorChain": errorChain(err, 0),
+		"errorIs":    is,
+	}
+}
+
+// errorChain returns the errors err wraps, from err down by errors.Unwrap,
+// as {message, type} entries. An error joining several, as errors.Join
+// does, ends the chain, its entry listing the chain of each in joined. An
+// error of a type declared by interpreted code reaches it wrapped, as an
+// interp._error without its Unwrap method, and so ends the chain.
+func errorChain(err error, depth int) []interface{} {
+	var chain []interface{}
+	for err != nil && len(chain) < maxErrorChain {
+		entry := map[string]interface{}{
+			"message": err.Error(),
+			"type":    reflect.TypeOf(err).String(),
+		}
+		chain = append(chain, entry)
+		if j, ok := err.(interface{ Unwrap() []error }); ok {
+			joined := []interface{}{}
+			if depth < maxErrorChain {
+				for _, e := range j.Unwrap() {
+					joined = append(joined, errorChain(e, depth+1))
+				}
+			}
+			entry["joined"] = joined
+			break
+		}
+		err = errors.Unwrap(err)
+	}
+	return chain
+}
@@ -0,0 +1,686 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"syscall/js"
+	"time"
+)
+
+func evalGo(this js.Value, args []js.Value) interface{} {
+	return evalWith(defaultSession(), "eval", args)
+}
+
+// evalWith runs a synchronous eval of args (source and options) in s. name
+// is the JS command used in error messages.
+func evalWith(s *session, name string, args []js.Value) map[string]interface{} {
+	if len(args) < 1 || len(args) > 2 {
+		return map[string]interface{}{
+			"success": false,
+			"error":   name + " requires the Go source code and an optional options object",
+		}
+	}
+
+	opts := parseEvalOptions(optionArg(args, 1))
+	if opts.interactive && !opts.async {
+		// A blocking read would never see the input written from JS.
+		return map[string]interface{}{
+			"success": false,
+			"error":   "interactiveStdin requires evalAsync or the async option",
+		}
+	}
+
+	sourceCode, err := sourceArg(args[0], opts.encoding)
+	if err != nil {
+		return decodeFailure(err)
+	}
+	s, done, failure := nestedSession(s)
+	if failure != nil {
+		return failure
+	}
+	defer done()
+	if opts.cache {
+		return cachedEval(s, sourceCode, opts)
+	}
+	return runEval(s, sourceCode, opts)
+}
+
+// evalAsync evaluates the source on its own goroutine and returns a Promise
+// resolved with the eval result, or rejected with the resultError of a
+// failure.
+func evalAsync(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 || len(args) > 2 {
+		return rejectedPromise(resultError(map[string]interface{}{
+			"success": false,
+			"error":   "evalAsync requires the Go source code and an optional options object",
+		}))
+	}
+
+	opts := parseEvalOptions(optionArg(args, 1))
+	opts.async = true
+	sourceCode, err := sourceArg(args[0], opts.encoding)
+	if err != nil {
+		return rejectedPromise(resultError(decodeFailure(err)))
+	}
+	s, done, failure := nestedSession(defaultSession())
+	if failure != nil {
+		return rejectedPromise(resultError(failure))
+	}
+
+	return newPromise(func(resolve, reject func(interface{})) {
+		defer done()
+		var result map[string]interface{}
+		if opts.cache {
+			result = cachedEval(s, sourceCode, opts)
+		} else {
+			result = runEval(s, sourceCode, opts)
+		}
+		if result["success"] != true {
+			// Even with legacyCompat.
+			reject(resultError(result))
+			return
+		}
+		resolve(envelope(result))
+	})
+}
+
+// nonBlocking wraps the eval command fn, whose options are its argument of
+// index opt, to run it on its own goroutine when the options set async or
+// onComplete. The JS callback then returns at once, a Promise settled with
+// the result for async or else undefined, and onComplete is called with
+// the result, so that the event loop keeps running while the interpreted
+// code sleeps or waits.
+func nonBlocking(fn func(this js.Value, args []js.Value) interface{}, opt int) func(this js.Value, args []js.Value) interface{} {
+	return func(this js.Value, args []js.Value) interface{} {
+		opts := optionArg(args, opt)
+		if opts.Type() != js.TypeObject {
+			return fn(this, args)
+		}
+		onComplete := opts.Get("onComplete")
+		async := opts.Get("async").Truthy()
+		if !async && onComplete.Type() != js.TypeFunction {
+			return fn(this, args)
+		}
+
+		run := func() interface{} {
+			result := fn(this, args)
+			if onComplete.Type() == js.TypeFunction {
+				onComplete.Invoke(envelopeValue(result))
+			}
+			return result
+		}
+		if async {
+			return newPromise(func(resolve, reject func(interface{})) {
+				settle(run(), resolve, reject)
+			})
+		}
+		go run()
+		return nil
+	}
+}
+
+// runEval evaluates sourceCode in session s and returns the result map
+// handed back to JavaScript. Successful evals are added to the session
+// history.
+func runEval(s *session, sourceCode string, opts evalOptions) map[string]interface{} {
+	opts.source = sourceCode
+	var res map[string]interface{}
+	if opts.allowImports != nil {
+		res = s.checkImports(sourceCode, opts)
+	}
+	if res == nil {
+		res = s.resolveImports(sourceCode, opts)
+	}
+	ordered := mapEdit{src: sourceCode}
+	if res == nil && opts.orderedMaps {
+		ordered = orderMapRanges(sourceCode)
+	}
+	if res == nil && opts.snippet {
+		if sn := wrapSnippet(ordered.src); sn != nil {
+			if res = runSnippet(s, sn, opts); ordered.moved != nil {
+				res = positionChain{ordered.moved}.remap(res)
+			}
+		}
+	}
+	if res == nil {
+		edit := replEdit{src: ordered.src}
+		if s.config.replMode {
+			edit = s.replSource(ordered.src)
+		}
+		tr, trace := traceEdit{src: edit.src}, (*lineTrace)(nil)
+		if opts.trace {
+			trace = newLineTrace(strings.Count(edit.src, "\n") + 1)
+			tr = instrumentTrace(edit.src, trace)
+		}
+		guard := guardGoroutines(tr.src)
+		src, echo := guard.src, (*echoEdit)(nil)
+		if opts.echo != "off" {
+			if echo = echoSource(guard.src, opts.echo == "output"); echo != nil {
+				src = echo.src
+			}
+		}
+		code, ticks := src, insertMap(nil)
+		var budget *stepBudget
+		if opts.maxSteps > 0 || opts.maxHeap > 0 || opts.maxCallDepth > 0 {
+			budget = newStepBudget(opts.maxSteps, opts.maxCallDepth)
+			budget.goroutines = guard.guarded
+			code, ticks = instrumentSteps(src, budget)
+		}
+		var values []interface{}
+		var types []reflect.Type
+		imports := insertMap{}
+		opts.stepped = budget != nil
+		file := sourceFile(sourceCode, opts.filename)
+		res = runEvalFunc(s, code, opts, func(ctx context.Context) (reflect.Value, error) {
+			run := func(src string) (reflect.Value, error) {
+				if budget != nil {
+					code, ticks = instrumentSteps(src, budget)
+					return s.evalSteps(ctx, code, file, opts.autoImport, imports, budget)
+				}
+				return s.evalImporting(ctx, src, file, opts.autoImport, imports)
+			}
+			if guard.guarded {
+				if err := s.startGuard(file); err != nil {
+					return reflect.Value{}, err
+				}
+			}
+			if ordered.moved != nil {
+				if err := s.startMapOrder(file); err != nil {
+					return reflect.Value{}, err
+				}
+			}
+			if err := s.startTrace(trace, file); err != nil {
+				return reflect.Value{}, err
+			}
+			defer s.trace.Store(nil)
+			v, err := run(src)
+			if echo == nil {
+				if isProgram(sourceCode) {
+					v = reflect.Value{}
+				}
+				return v, err
+			}
+			if err != nil && noValue.MatchString(err.Error()) {
+				// A call returning nothing: nothing ran yet. yaegi gives
+				// it a stray value.
+				echo = nil
+				_, err = run(guard.src)
+				return reflect.Value{}, err
+			}
+			if err == nil {
+				types = s.echoTypes(echo.fun)
+			}
+			v, values = s.echo(v, opts.echo, opts.render)
+			return v, err
+		})
+		// The rewrites, from the last to the first, those instrumentTrace
+		// sees last.
+		var echoed positionMap
+		if echo != nil {
+			echoed = echo.moved
+		}
+		traced := positionChain{edit.moved, ordered.moved}
+		chain := append(positionChain{imports, ticks, echoed, guard.moved, tr.moved}, traced...)
+		res = guard.unname(chain.remap(unnameSource(res, file)))
+		if trace != nil {
+			res["coverage"] = trace.coverage(tr.lines, traced.position)
+		}
+		if opts.maxSteps > 0 {
+			res["steps"] = budget.used()
+		}
+		echoResult(res, opts.echo, values, types, opts.render)
+		if res["success"] == true {
+			s.recordReplTypes(edit.types)
+		}
+	}
+	if opts.warnings {
+		res["warnings"] = s.sourceWarnings(sourceCode, opts.snippet)
+	}
+	if opts.filename != "" {
+		res = nameSource(res, opts.filename)
+	}
+	if res["success"] == true {
+		s.record(historyEntry{Source: sourceCode, Snippet: opts.snippet, AutoImport: opts.autoImport})
+	}
+	return res
+}
+
+// runEvalFunc runs eval in session s with the standard streams, limits and
+// cancellation set up from opts, which checkOptions may reject first.
+// sourceCode is used to locate errors. The result of an eval that started
+// counts in the usage of s, whatever the outcome.
+func runEvalFunc(s *session, sourceCode string, opts evalOptions, eval func(context.Context) (reflect.Value, error)) (res map[string]interface{}) {
+	if res := checkOptions(opts, opts.stepped); res != nil {
+		return res
+	}
+	if res := s.admit(); res != nil {
+		return res
+	}
+	var outputBytes int
+	var wall time.Duration
+	defer func() { s.recordEval(res, outputBytes, wall) }()
+
+	parent := opts.parent
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+	id := s.trackEval(cancel)
+	defer s.untrackEval(id)
+
+	// Only one evaluation may use the interpreter at a time.
+	if err := s.acquireEval(ctx, opts.queue || queueEvals()); err != nil {
+		if err == errBusy {
+			return map[string]interface{}{
+				"success": false,
+				"error":   err.Error(),
+				"busy":    true,
+			}
+		}
+		// Evaluations cancelled while waiting for their turn never start.
+		return cancelledResult("", "")
+	}
+	defer s.releaseEval()
+	described := sourceCode
+	if opts.source != "" {
+		described = opts.source
+	}
+	s.describeEval(described)
+	defer s.mirrorEval(described)()
+
+	if s.isBroken() {
+		return map[string]interface{}{
+			"success": false,
+			"error":   errFatal.Error(),
+			"fatal":   true,
+		}
+	}
+
+	if opts.async {
+		s.async.Store(true)
+		asyncEvals.Add(1)
+		defer func() {
+			s.async.Store(false)
+			asyncEvals.Add(-1)
+		}()
+	} else {
+		s.syncEval.Store(true)
+		defer s.syncEval.Store(false)
+	}
+
+	// Each eval parses its args with a fresh flag.CommandLine, on which it
+	// may define again the flags of earlier evals.
+	if opts.args != nil {
+		s.setArgs(opts.args)
+	} else {
+		s.setArgs(s.config.args)
+	}
+	defer s.setArgs(s.config.args)
+	if opts.env != nil {
+		defer s.overrideEnv(opts.env)()
+	}
+	s.reseedRand()
+
+	// On timeout, the stacks show where the code was stuck, so they are
+	// taken before the eval is cancelled.
+	var stuck []goroutine
+	if opts.timeout > 0 {
+		var cancelTimeout context.CancelCauseFunc
+		ctx, cancelTimeout = context.WithCancelCause(ctx)
+		defer cancelTimeout(nil)
+		timer := time.AfterFunc(opts.timeout, func() {
+			stuck = dumpGoroutines()
+			cancelTimeout(context.DeadlineExceeded)
+		})
+		defer timer.Stop()
+	}
+
+	var stopProfile func() map[string]interface{}
+	if opts.profile != "" {
+		var err error
+		if !opts.async {
+			// The profile writer reads the memory mappings with the fs of
+			// the host, which may call back only once the event loop runs.
+			err = errors.New("requires evalAsync or the async option")
+		} else {
+			stopProfile, err = startProfile(opts.profile)
+		}
+		if err != nil {
+			return map[string]interface{}{
+				"success": false,
+				"error":   "profile: " + err.Error(),
+			}
+		}
+	}
+
+	// Feed the program input and capture the interpreter streams
+	if opts.interactive {
+		s.stdin.set(s.openFeed().reader(ctx))
+	} else {
+		s.stdin.set(strings.NewReader(opts.stdin))
+	}
+	defer s.stdin.set(nil)
+	var limit *outputLimit
+	if opts.maxOutput > 0 {
+		limit = &outputLimit{max: opts.maxOutput}
+		if opts.abortOnOutputLimit {
+			limit.exceed = cancel
+		}
+	}
+	stdoutStream := jsStream(opts.onStdout)
+	if opts.binaryOutput {
+		stdoutStream = jsByteStream(opts.onStdout)
+	}
+	stderrStream := jsStream(opts.onStderr)
+	capture := opts.captureOutput
+	consoleOut, consoleErr := &consoleLines{method: "log"}, &consoleLines{method: "error"}
+	switch outputMode() {
+	case "console":
+		capture = false
+		fallthrough
+	case "both":
+		stdoutStream = teeStreams(stdoutStream, consoleOut.write)
+		stderrStream = teeStreams(stderrStream, consoleErr.write)
+	}
+	var events *outputEvents
+	if opts.events {
+		events = newOutputEvents()
+		stdoutStream = teeStreams(stdoutStream, events.stream("stdout"))
+		stderrStream = teeStreams(stderrStream, events.stream("stderr"))
+	}
+	if opts.binaryOutput {
+		s.stdout.startBinary(capture, stdoutStream, limit)
+	} else {
+		s.stdout.start(capture, stdoutStream, limit)
+	}
+	s.stderr.start(capture, stderrStream, limit)
+
+	var evalError error
+	var result reflect.Value
+	var stats *evalStats
+	if opts.stats {
+		stats = startStats()
+	}
+	goroutinesBefore := s.goroutines.Load()
+	var heap *heapWatch
+	if opts.maxHeap > 0 {
+		heap = watchHeap(opts.maxHeap, cancel)
+		s.heap.Store(heap)
+	}
+
+	// A sync eval waiting forever is aborted, with the stacks then.
+	var blocked []goroutine
+	var cancelDeadlock context.CancelCauseFunc
+	ctx, cancelDeadlock = context.WithCancelCause(ctx)
+	defer cancelDeadlock(nil)
+	caller := currentGoroutine().id
+	stopWatch := func() {}
+	if !opts.async {
+		stopWatch = watchDeadlock(caller, func(e deadlockError, list []goroutine) {
+			blocked = list
+			cancelDeadlock(e)
+		})
+	}
+	s.goexit.Store(nil)
+
+	stopPlayground := s.startPlayground(opts)
+
+	// Execute the Go code
+	s.setEvalContext(ctx)
+	started := time.Now()
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				evalError = internalPanic{r}
+			}
+		}()
+		result, evalError = eval(ctx)
+	}()
+	wall = time.Since(started)
+	stopWatch()
+	data := stopPlayground()
+	if evalError == nil && ctx.Err() != nil {
+		// The code returned early, seeing yaegictx.Done.
+		evalError = ctx.Err()
+	}
+	if heap != nil {
+		heap.stop()
+		s.heap.Store(nil)
+		if heap.exceeded.Load() {
+			evalError = errMemoryLimit
+		}
+	}
+	var profile map[string]interface{}
+	if stopProfile != nil {
+		profile = stopProfile()
+	}
+	evalError = s.config.sandboxError(evalError)
+	if errors.Is(evalError, context.Canceled) && context.Cause(ctx) == context.DeadlineExceeded {
+		evalError = context.DeadlineExceeded
+	}
+	// The goroutine of an eval aborted waiting, running on past its
+	// timeout or cancellation, or exiting, may hold the state of the
+	// interpreter, which is then rebuilt.
+	abandoned := false
+	if errors.Is(evalError, context.DeadlineExceeded) || errors.Is(evalError, context.Canceled) {
+		abandoned = evalRunning(caller, abandonGrace)
+	}
+	if cause := context.Cause(ctx); errors.Is(evalError, context.Canceled) && errors.As(cause, new(deadlockError)) {
+		evalError, stuck, abandoned = cause, blocked, true
+	}
+	if stacks := s.goexit.Swap(nil); stacks != nil && evalError == nil {
+		evalError, stuck, abandoned = errGoexit, *stacks, true
+	}
+
+	output := s.stdout.stop()
+	stderr := s.stderr.stop()
+	consoleOut.flush()
+	consoleErr.flush()
+	outputBytes = len(output) + len(stderr)
+
+	res = resultMap(sourceCode, result, evalError, output, stderr, opts.render)
+	res["mode"] = s.config.mode()
+	if opts.binaryOutput {
+		res["output"] = bytesToJS([]byte(output))
+	}
+	if opts.outputHandle > 0 {
+		holdOutputs(res, output, stderr, opts.outputHandle)
+	}
+	if !data.IsUndefined() {
+		res["data"] = data
+	}
+	if isFatal(evalError) {
+		s.handleFatal(res)
+	}
+	if heap != nil && heap.exceeded.Load() {
+		res["heapBytes"] = heap.observed.Load()
+		// What the code kept in globals would fail the next evals.
+		if heap.retained() {
+			s.rebuild()
+			res["recovered"] = true
+		}
+	}
+	if res["success"] == true {
+		if watches := s.evalWatches(opts.render); watches != nil {
+			res["watches"] = watches
+		}
+	}
+	if stats != nil {
+		st := stats.stop()
+		st["limits"] = appliedLimits(opts)
+		res["stats"] = st
+	}
+	if events != nil {
+		res["events"] = events.list()
+	}
+	for key, value := range profile {
+		res[key] = value
+	}
+	if (errors.Is(evalError, context.DeadlineExceeded) || abandoned) && stuck != nil {
+		res["stacks"] = goroutinesToJS(stuck)
+	}
+	if abandoned {
+		s.rebuild()
+		res["recovered"] = true
+	}
+	if n := s.leakedGoroutines(goroutinesBefore); n > 0 {
+		// They keep running, and slowing down later evals.
+		res["leakedGoroutines"] = n
+	}
+	if dropped := limit.droppedBytes(); dropped > 0 {
+		res["outputTruncated"] = true
+		res["droppedBytes"] = dropped
+		if opts.abortOnOutputLimit {
+			delete(res, "cancelled")
+			res["success"] = false
+			res["error"] = "output limit exceeded"
+			res["errorCode"] = codeLimit
+		}
+	}
+	return res
+}
+
+// resultMap builds the JS result of an evaluation from its outcome and
+// captured streams, its value rendered with l.
+func resultMap(sourceCode string, result reflect.Value, evalError error, output, stderr string, l renderLimits) map[string]interface{} {
+	if errors.Is(evalError, context.DeadlineExceeded) {
+		return map[string]interface{}{
+			"success":  false,
+			"error":    "timeout",
+			"timedOut": true,
+			"output":   output,
+			"stderr":   stderr,
+		}
+	}
+
+	if errors.Is(evalError, context.Canceled) {
+		return cancelledResult(output, stderr)
+	}
+
+	if code, ok := exitCode(evalError); ok {
+		return exitResult(code, output, stderr)
+	}
+
+	if stackOverflowed(evalError) != nil {
+		return overflowResult(evalError, sourceCode, output, stderr)
+	}
+
+	if evalError != nil {
+		return map[string]interface{}{
+			"success":     false,
+			"error":       evalError.Error(),
+			"errorCode":   errorCode(evalError),
+			"diagnostics": errorDiagnostics(evalError, sourceCode, stderr),
+			"stack":       panicStack(evalError, sourceCode, stderr),
+			"output":      output,
+			"stderr":      stderr,
+		}
+	}
+
+	return map[string]interface{}{
+		"success":     true,
+		"output":      output,
+		"stderr":      stderr,
+		"value":       goValueToJS(result),
+		"valueType":   valueTypeName(result),
+		"valueString": valueString(result, l),
+		"error":       nil,
+	}
+}
+
+// exitResult reports an evaluation ended by os.Exit(code), which succeeds
+// for code 0. The frames yaegi writes to stderr while unwinding are left
+// out.
+func exitResult(code int, output, stderr string) map[string]interface{} {
+	res := map[string]interface{}{
+		"success":  code == 0,
+		"exited":   true,
+		"exitCode": code,
+		"output":   output,
+		"stderr":   panicFrameLine.ReplaceAllString(stderr, ""),
+		"error":    nil,
+	}
+	if code != 0 {
+		res["error"] = exitStatus(code).Error()
+		res["errorCode"] = codeExit
+	}
+	return res
+}
+
+// cancelledResult reports an evaluation stopped by yaegi.cancel().
+func cancelledResult(output, stderr string) map[string]interface{} {
+	return map[string]interface{}{
+		"success":   false,
+		"error":     "cancelled",
+		"cancelled": true,
+		"output":    output,
+		"stderr":    stderr,
+	}
+}
+
+// trackEval records the cancel function of a pending eval of s and
+// returns its id.
+func (s *session) trackEval(cancel context.CancelFunc) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cancelFuncs == nil {
+		s.cancelFuncs = map[int]context.CancelFunc{}
+	}
+	s.nextEvalID++
+	s.cancelFuncs[s.nextEvalID] = cancel
+	return s.nextEvalID
+}
+
+func (s *session) untrackEval(id int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.cancelFuncs, id)
+}
+
+// cancelEvals interrupts the running eval of s and the queued ones, and
+// returns how many there were.
+func (s *session) cancelEvals() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, cancel := range s.cancelFuncs {
+		cancel()
+	}
+	return len(s.cancelFuncs)
+}
+
+// cancelEval interrupts the running evaluations and any queued ones, of
+// every session or of the one of the option session.
+func cancelEval(this js.Value, args []js.Value) interface{} {
+	targets := allSessions()
+	if opts := optionArg(args, 0); opts.Type() == js.TypeObject && opts.Get("session").Type() == js.TypeNumber {
+		s := lookupSession(opts.Get("session").Int())
+		if s == nil {
+			return map[string]interface{}{
+				"success": false,
+				"error":   "session not found",
+			}
+		}
+		targets = []*session{s}
+	}
+
+	n := 0
+	for _, s := range targets {
+		n += s.cancelEvals()
+	}
+	if n == 0 {
+		return map[string]interface{}{
+			"success": false,
+			"error":   "no evaluation in progress",
+		}
+	}
+	return map[string]interface{}{
+		"success":   true,
+		"cancelled": n,
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+	"sync"
+	"syscall/js"
+)
+
+// defaultURLBytes is the size limit of a source fetched by evalURL, unless
+// set by its option maxBytes.
+const defaultURLBytes = 1 << 20
+
+// fetchError is a failed fetch of a source of evalURL, with the HTTP
+// status of the response if one came. Network failures and CORS
+// rejections have none.
+type fetchError struct {
+	url    string
+	status int
+	msg    string
+}
+
+func (e *fetchError) Error() string {
+	return "fetch " + e.url + ": " + e.msg
+}
+
+// evalURL fetches Go source from a URL with the fetch API of the host and
+// evaluates it as eval does, the URL naming the source in error positions
+// unless the option filename is set, or fetches at once the files of
+// {files: {"main.go": url, ...}} and evaluates them as evalFiles does. It
+// returns a Promise like evalAsync. A failed fetch, a response that is not
+// a source, such as an HTML page, or one over the option maxBytes (1 MiB
+// by default) rejects it with the code fetch_error and the HTTP status, if
+// a response came.
+func evalURL(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 || len(args) > 2 || (args[0].Type() != js.TypeString && args[0].Type() != js.TypeObject) {
+		return rejectedPromise(resultError(map[string]interface{}{
+			"success": false,
+			"error":   "evalURL requires a URL or {files: {name: url}}, and an optional options object",
+		}))
+	}
+
+	s := defaultSession()
+	target := args[0]
+	opts := parseEvalOptions(optionArg(args, 1))
+	opts.async = true
+	limit := defaultURLBytes
+	if v := optionArg(args, 1); v.Type() == js.TypeObject {
+		if n := optionInt(v, "maxBytes"); n > 0 {
+			limit = n
+		}
+	}
+
+	return newPromise(func(resolve, reject func(interface{})) {
+		result := runURL(s, target, opts, limit)
+		if result["success"] != true {
+			reject(resultError(result))
+			return
+		}
+		resolve(envelope(result))
+	})
+}
+
+// runURL fetches and evaluates in s the source of target, a URL or
+// {files}, as evalURL does.
+func runURL(s *session, target js.Value, opts evalOptions, limit int) map[string]interface{} {
+	if target.Type() == js.TypeString {
+		url := target.String()
+		src, err := fetchSource(url, limit)
+		if err != nil {
+			return fetchFailure(err)
+		}
+		if opts.filename == "" {
+			opts.filename = url
+		}
+		return runEval(s, src, opts)
+	}
+
+	files := target.Get("files")
+	if files.Type() != js.TypeObject {
+		return map[string]interface{}{
+			"success": false,
+			"error":   "evalURL: files must map file names to URLs",
+		}
+	}
+	keys := js.Global().Get("Object").Call("keys", files)
+	names := make([]string, keys.Length())
+	urls := make([]string, len(names))
+	for i := range names {
+		names[i] = keys.Index(i).String()
+		u := files.Get(names[i])
+		if u.Type() != js.TypeString {
+			return map[string]interface{}{
+				"success": false,
+				"error":   fmt.Sprintf("evalURL: URL of %s is not a string", names[i]),
+			}
+		}
+		urls[i] = u.String()
+	}
+
+	sources := make([]string, len(names))
+	errs := make([]error, len(names))
+	var wg sync.WaitGroup
+	for i := range urls {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sources[i], errs[i] = fetchSource(urls[i], limit)
+		}(i)
+	}
+	wg.Wait()
+	code := map[string]interface{}{}
+	for i, name := range names {
+		if errs[i] != nil {
+			return fetchFailure(errs[i])
+		}
+		code[name] = sources[i]
+	}
+	return runFiles(s, "evalURL", js.ValueOf(code), opts)
+}
+
+// fetchSource fetches the source at url, of at most limit bytes.
+func fetchSource(url string, limit int) (string, error) {
+	fetch := js.Global().Get("fetch")
+	if fetch.Type() != js.TypeFunction {
+		return "", &fetchError{url: url, msg: "fetch is not available"}
+	}
+	res, err := awaitPromise(fetch.Invoke(url))
+	if err != nil {
+		return "", &fetchError{url: url, msg: err.Error()}
+	}
+
+	status := res.Get("status").Int()
+	fail := func(format string, a ...interface{}) (string, error) {
+		return "", &fetchError{url: url, status: status, msg: fmt.Sprintf(format, a...)}
+	}
+	if !res.Get("ok").Bool() {
+		return fail("%d %s", status, res.Get("statusText").String())
+	}
+	if t := res.Get("headers").Call("get", "Content-Type"); t.Type() == js.TypeString && !sourceType(t.String()) {
+		return fail("content type %s is not a source", t.String())
+	}
+	if res.Get("body").Type() != js.TypeObject {
+		return "", nil
+	}
+	body := &fetchBody{reader: res.Get("body").Call("getReader"), transport: fetchTransport{detached: true}}
+	defer body.Close()
+	b, err := io.ReadAll(io.LimitReader(body, int64(limit)+1))
+	switch {
+	case err != nil:
+		return fail("%v", err)
+	case len(b) > limit:
+		return fail("source larger than %d bytes", limit)
+	}
+	return string(b), nil
+}
+
+// sourceType reports whether a response of the media type t can hold a Go
+// source: a text other than HTML, which is rather the page of a source
+// than its raw content, or bytes without a type of their own.
+func sourceType(t string) bool {
+	mt, _, err := mime.ParseMediaType(t)
+	if err != nil {
+		return false
+	}
+	switch {
+	case mt == "text/html":
+		return false
+	case strings.HasPrefix(mt, "text/"):
+		return true
+	}
+	return mt == "application/octet-stream" || mt == "application/x-go"
+}
+
+// fetchFailure returns the result of an evalURL whose fetch failed with
+// err.
+func fetchFailure(err error) map[string]interface{} {
+	res := map[string]interface{}{
+		"success":   false,
+		"error":     err.Error(),
+		"errorCode": errorCode(err),
+	}
+	if e, ok := err.(*fetchError); ok && e.status != 0 {
+		res["status"] = e.status
+	}
+	return res
+}
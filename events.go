@@ -0,0 +1,197 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"syscall/js"
+	"time"
+)
+
+// eventBuffer is the count of lifecycle events waiting for delivery past
+// which new ones are dropped.
+const eventBuffer = 1024
+
+// The types of the lifecycle events.
+const (
+	eventJobQueued    = "job-queued"
+	eventJobStarted   = "job-started"
+	eventJobFinished  = "job-finished"
+	eventRunOutput    = "run-output"
+	eventRunStopped   = "run-stopped"
+	eventSessionReset = "session-reset"
+)
+
+// subscriber receives the lifecycle events, through fn or, in worker mode
+// without one, as messages.
+type subscriber struct {
+	fn          js.Value
+	unsubscribe js.Func // handed to the host, released on unsubscribing
+}
+
+var (
+	subscribersMu sync.Mutex
+	subscribers   = map[int]*subscriber{}
+	nextSubID     int
+	// subscribed counts the subscribers, so that events are only built
+	// for someone.
+	subscribed atomic.Int32
+
+	// eventQueue holds the events until delivered by deliverLifecycle,
+	// which starts with the first subscriber.
+	eventQueue    = make(chan map[string]interface{}, eventBuffer)
+	startDelivery sync.Once
+	droppedEvents atomic.Int64
+)
+
+// emitEvent queues the lifecycle event typ of the session, and of the job
+// jobID unless 0, for the subscribers. It does not block: past
+// eventBuffer events waiting, the event is dropped and counted. Events are
+// delivered in the order emitted, so in order for a session.
+func emitEvent(typ string, sessionID, jobID int, payload map[string]interface{}) {
+	if subscribed.Load() == 0 {
+		return
+	}
+	var job interface{}
+	if jobID != 0 {
+		job = jobID
+	}
+	if payload == nil {
+		payload = map[string]interface{}{}
+	}
+	ev := map[string]interface{}{
+		"type":      typ,
+		"sessionId": sessionID,
+		"jobId":     job,
+		"tMs":       time.Now().UnixMilli(),
+		"payload":   payload,
+	}
+	select {
+	case eventQueue <- ev:
+	default:
+		droppedEvents.Add(1)
+	}
+}
+
+// deliverLifecycle hands the queued events to the subscribers, one at a
+// time, off the goroutines emitting them.
+func deliverLifecycle() {
+	for ev := range eventQueue {
+		subscribersMu.Lock()
+		ids := make([]int, 0, len(subscribers))
+		for id := range subscribers {
+			ids = append(ids, id)
+		}
+		sort.Ints(ids)
+		list := make([]*subscriber, len(ids))
+		for i, id := range ids {
+			list[i] = subscribers[id]
+		}
+		subscribersMu.Unlock()
+
+		for _, sub := range list {
+			func() {
+				// A throwing callback must not stop the delivery.
+				defer func() { recover() }()
+				if sub.fn.Type() == js.TypeFunction {
+					sub.fn.Invoke(ev)
+					return
+				}
+				js.Global().Call("postMessage", map[string]interface{}{
+					"event": "lifecycle",
+					"data":  ev,
+				})
+			}()
+		}
+	}
+}
+
+// onEvent subscribes the function given as argument to the lifecycle
+// events of the job queue, of the programs started with start and of
+// the sessions: {type, sessionId, jobId, tMs, payload}, type being one
+// of job-queued, job-started, job-finished, run-output, run-stopped and
+// session-reset. In worker mode, it takes no function and the events are
+// posted as {event: "lifecycle", data}. It returns {success, id,
+// unsubscribe}, unsubscribe being a function ending the subscription
+// (left out in worker mode, whose replies can't hold one) as offEvent
+// does with the id.
+func onEvent(this js.Value, args []js.Value) interface{} {
+	fn := optionArg(args, 0)
+	if fn.Type() != js.TypeFunction && !workerMode() {
+		return map[string]interface{}{
+			"success":   false,
+			"error":     "onEvent requires a function",
+			"errorCode": codeBadRequest,
+		}
+	}
+	startDelivery.Do(func() { go deliverLifecycle() })
+
+	sub := &subscriber{fn: fn}
+	subscribersMu.Lock()
+	nextSubID++
+	id := nextSubID
+	subscribers[id] = sub
+	subscribersMu.Unlock()
+	subscribed.Add(1)
+
+	res := map[string]interface{}{
+		"success": true,
+		"id":      id,
+	}
+	if !workerMode() {
+		sub.unsubscribe = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+			return unsubscribe(id)
+		})
+		res["unsubscribe"] = sub.unsubscribe
+	}
+	return res
+}
+
+// offEvent ends the subscription whose id, returned by onEvent, is given
+// as argument. It returns {success, dropped}, dropped counting the events
+// lost so far to a full buffer.
+func offEvent(this js.Value, args []js.Value) interface{} {
+	if len(args) != 1 || args[0].Type() != js.TypeNumber {
+		return map[string]interface{}{
+			"success":   false,
+			"error":     "offEvent requires a subscription id",
+			"errorCode": codeBadRequest,
+		}
+	}
+	return unsubscribe(args[0].Int())
+}
+
+// unsubscribe drops the subscriber id, releasing its unsubscribe function.
+func unsubscribe(id int) map[string]interface{} {
+	subscribersMu.Lock()
+	sub := subscribers[id]
+	delete(subscribers, id)
+	subscribersMu.Unlock()
+	if sub == nil {
+		return map[string]interface{}{
+			"success": false,
+			"error":   "subscription not found",
+		}
+	}
+	subscribed.Add(-1)
+	if sub.unsubscribe.Type() == js.TypeFunction {
+		sub.unsubscribe.Release()
+	}
+	return map[string]interface{}{
+		"success": true,
+		"dropped": droppedEvents.Load(),
+	}
+}
+
+// releaseSubscribers ends every subscription, for dispose.
+func releaseSubscribers() {
+	subscribersMu.Lock()
+	ids := make([]int, 0, len(subscribers))
+	for id := range subscribers {
+		ids = append(ids, id)
+	}
+	subscribersMu.Unlock()
+	for _, id := range ids {
+		unsubscribe(id)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go/ast"
+	godoc "go/doc"
+	"go/parser"
+	"go/token"
+	"io"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"syscall/js"
+	"time"
+)
+
+// defaultExampleTimeout is the time an example may run for by default.
+const defaultExampleTimeout = 5 * time.Second
+
+// exampleOutput is the stdout of the code run by yaegi.examples: that of
+// the example running goes to its own buffer, the rest to w.
+type exampleOutput struct {
+	mu  sync.Mutex
+	w   io.Writer
+	buf *bytes.Buffer // of the example running, nil for none
+}
+
+func (o *exampleOutput) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.buf != nil {
+		return o.buf.Write(p)
+	}
+	return o.w.Write(p)
+}
+
+// capture sends the output to a new buffer, returned, or back to w if
+// capturing is unset.
+func (o *exampleOutput) capture(capturing bool) *bytes.Buffer {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.buf = nil
+	if capturing {
+		o.buf = &bytes.Buffer{}
+	}
+	return o.buf
+}
+
+// exampleDocs returns the examples of the top-level files of the source
+// tree src, sorted by name as go test runs them.
+func exampleDocs(src *memFS) []*godoc.Example {
+	dir := path.Join("src", mainPackage)
+	var names []string
+	for _, name := range src.list() {
+		if path.Dir(name) == dir {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	fset := token.NewFileSet()
+	var files []*ast.File
+	for _, name := range names {
+		data, _ := src.readFile(name)
+		if f, err := parser.ParseFile(fset, name, data, parser.ParseComments); err == nil {
+			files = append(files, f)
+		}
+	}
+	return godoc.Examples(files...)
+}
+
+// runExample runs the example ex, f, on its own goroutine with its output
+// captured into out, and returns its result. A panic fails the example
+// without stopping the others.
+func runExample(ex *godoc.Example, f func(), out *exampleOutput) map[string]interface{} {
+	result := exampleResult(ex)
+	buf := out.capture(true)
+	start := time.Now()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer func() {
+			if p := recover(); p != nil {
+				result["error"] = fmt.Sprint("panic: ", p)
+			}
+		}()
+		f()
+	}()
+	<-done
+	result["durationMs"] = float64(time.Since(start).Microseconds()) / 1000
+	out.capture(false)
+
+	actual := buf.String()
+	result["actual"] = actual
+	if ex.Output != "" || ex.EmptyOutput {
+		result["expected"] = ex.Output
+		if ex.Unordered {
+			result["match"] = sortedLines(actual) == sortedLines(ex.Output)
+		} else {
+			result["match"] = strings.TrimSpace(actual) == strings.TrimSpace(ex.Output)
+		}
+	}
+	return result
+}
+
+// exampleResult returns the result of ex before it runs.
+func exampleResult(ex *godoc.Example) map[string]interface{} {
+	return map[string]interface{}{
+		"name":      "Example" + ex.Name,
+		"unordered": ex.Unordered,
+		"expected":  nil,
+		"actual":    "",
+		"match":     nil,
+	}
+}
+
+// sortedLines returns the lines of s, trimmed, in sorted order, to compare
+// unordered outputs as go test does.
+func sortedLines(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+// runExamples evaluates a package of test files like runTests and runs its
+// ExampleXxx functions, each with its stdout captured, comparing it with
+// their // Output: comment. Besides the eval options, exampleTimeoutMs sets
+// the time each example may run for, 5 seconds by default. The result
+// carries the examples, {name, expected, actual, match, unordered,
+// durationMs}, match being null for those without an output comment, and
+// comparing sorted lines after // Unordered output:. An example that
+// panics or runs out of time fails with an error, and the others still
+// run; its success tells whether none failed or mismatched.
+func runExamples(this js.Value, args []js.Value) interface{} {
+	src, excluded, opts, invalid := testSetup("examples", args)
+	if invalid != nil {
+		return invalid
+	}
+	timeout := defaultExampleTimeout
+	if v := optionArg(args, 1); v.Type() == js.TypeObject {
+		if ms := optionInt(v, "exampleTimeoutMs"); ms > 0 {
+			timeout = time.Duration(ms) * time.Millisecond
+		}
+	}
+
+	s := defaultSession()
+	var examples []interface{}
+	result := runEvalFunc(s, "", opts, func(ctx context.Context) (reflect.Value, error) {
+		out := &exampleOutput{w: s.stdout}
+		syms, d, err := loadTests(s.interpreterWith(src, opts.buildTags, s.stdin, out, s.stderr))
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		for _, ex := range exampleDocs(src) {
+			f, ok := syms["Example"+ex.Name].Interface().(func())
+			if !ok {
+				continue
+			}
+			var res map[string]interface{}
+			limit, cancel := context.WithTimeout(ctx, timeout)
+			err := d.call(limit, func() { res = runExample(ex, f, out) })
+			timedOut := limit.Err() != nil
+			cancel()
+			if err := ctx.Err(); err != nil {
+				return reflect.Value{}, err
+			}
+			if res == nil {
+				out.capture(false)
+				res = exampleResult(ex)
+			}
+			switch {
+			case timedOut:
+				res["error"] = fmt.Sprintf("timed out after %v", timeout)
+				res["match"] = nil
+			case err != nil:
+				res["error"] = err.Error()
+				res["match"] = nil
+			}
+			examples = append(examples, res)
+		}
+		return reflect.Value{}, nil
+	})
+	result = testResult(result)
+	result["excluded"] = excluded
+
+	for _, e := range examples {
+		r := e.(map[string]interface{})
+		if r["match"] == false || r["error"] != nil {
+			result["success"] = false
+		}
+	}
+	if examples == nil {
+		examples = []interface{}{}
+	}
+	result["examples"] = examples
+	return result
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"reflect"
+	"syscall/js"
+	"time"
+)
+
+// evalExpr evaluates a single Go expression in the scope of the default
+// session and returns its value. The results of a call are reported as by
+// resultFields, so that several values or a trailing error are told
+// apart. Output is not captured.
+func evalExpr(this js.Value, args []js.Value) interface{} {
+	if len(args) != 1 || args[0].Type() != js.TypeString {
+		return map[string]interface{}{
+			"success": false,
+			"error":   "evalExpr requires a Go expression",
+		}
+	}
+	expr := args[0].String()
+	x, err := parser.ParseExpr(expr)
+	if err != nil {
+		return map[string]interface{}{
+			"success":   false,
+			"error":     "not an expression: " + err.Error(),
+			"errorCode": codeParse,
+		}
+	}
+
+	if call, ok := ast.Unparen(x).(*ast.CallExpr); ok {
+		// Collect the results as echo does.
+		var types []reflect.Type
+		v, errRes := evalInScope(echoCall+expr+")", func(s *session) {
+			if namedFunc(call.Fun) {
+				types = s.echoTypes(expr[call.Fun.Pos()-1 : call.Fun.End()-1])
+			}
+		})
+		if errRes == nil {
+			res := echoFields(echoed(v), types, renderSettings())
+			res["success"] = true
+			res["error"] = nil
+			return res
+		}
+		if !noValue.MatchString(errRes["error"].(string)) {
+			return positionChain{insertMap{1: {{1, len(echoCall)}}}}.remap(errRes)
+		}
+		// A call returning nothing, whose value yaegi makes up.
+		if _, errRes := evalInScope(expr, nil); errRes != nil {
+			return errRes
+		}
+		return map[string]interface{}{
+			"success":     true,
+			"value":       nil,
+			"valueType":   nil,
+			"valueString": nil,
+			"error":       nil,
+		}
+	}
+
+	v, errRes := evalInScope(expr, nil)
+	if errRes != nil {
+		return errRes
+	}
+	return map[string]interface{}{
+		"success":     true,
+		"value":       goValueToJS(v),
+		"valueType":   valueTypeName(v),
+		"valueString": valueString(v, renderSettings()),
+		"error":       nil,
+	}
+}
+
+// evalInScope evaluates the expression expr in the scope of the default
+// session, or returns the failed result of evalExpr. After, if not nil, is
+// called on success while the session is still held.
+func evalInScope(expr string, after func(*session)) (reflect.Value, map[string]interface{}) {
+	s := defaultSession()
+	if res := s.admit(); res != nil {
+		return reflect.Value{}, res
+	}
+	if err := s.acquireEval(context.Background(), false); err != nil {
+		return reflect.Value{}, map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+			"busy":    true,
+		}
+	}
+	defer s.releaseEval()
+	s.describeEval(expr)
+	started := time.Now()
+	defer func() { s.chargeCPU(time.Since(started)) }()
+
+	var v reflect.Value
+	var err error
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("panic: %v", r)
+			}
+		}()
+		v, err = s.interpreter.Eval(expr)
+	}()
+	if err = s.config.sandboxError(err); err != nil {
+		return reflect.Value{}, map[string]interface{}{
+			"success":     false,
+			"error":       err.Error(),
+			"errorCode":   errorCode(err),
+			"diagnostics": errorDiagnostics(err, expr, ""),
+		}
+	}
+	if after != nil {
+		after(s)
+	}
+	return v, nil
+}
+
+// typeOf returns the type of a Go expression in the scope of the default
+// session: {success, type, kind, assignable}, with dynamicType for a value
+// of interface type. The type is read from the value yaegi returns, so the
+// expression is evaluated, which is rejected when it calls functions or
+// receives from a channel unless the option allowCalls is set. Conversions
+// and the builtins without side effects, such as len, are always allowed.
+func typeOf(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 || len(args) > 2 || args[0].Type() != js.TypeString {
+		return map[string]interface{}{
+			"success": false,
+			"error":   "typeOf requires a Go expression and an optional options object",
+		}
+	}
+	expr := args[0].String()
+	fset := token.NewFileSet()
+	x, err := parser.ParseExprFrom(fset, "", expr, 0)
+	if err != nil {
+		return map[string]interface{}{
+			"success":   false,
+			"error":     "not an expression: " + err.Error(),
+			"errorCode": codeParse,
+		}
+	}
+	if o := optionArg(args, 1); o.Type() != js.TypeObject || !o.Get("allowCalls").Truthy() {
+		if n := sideEffect(x); n != nil {
+			pos := fset.Position(n.Pos())
+			msg := "typeOf does not run function calls or channel receives unless allowCalls is set"
+			return map[string]interface{}{
+				"success": false,
+				"error":   fmt.Sprintf("%d:%d: %s", pos.Line, pos.Column, msg),
+				"diagnostics": []interface{}{
+					diagnostic{line: pos.Line, column: pos.Column, message: msg, severity: "error"}.toJS(),
+				},
+			}
+		}
+	}
+
+	v, errRes := evalInScope(expr, nil)
+	if errRes != nil {
+		return errRes
+	}
+	res := map[string]interface{}{
+		"success":    true,
+		"type":       valueTypeName(v),
+		"kind":       nil,
+		"assignable": assignable(x) && v.CanSet(),
+		"error":      nil,
+	}
+	if v.IsValid() {
+		res["kind"] = v.Kind().String()
+		if v.Kind() == reflect.Interface && !v.IsNil() {
+			res["dynamicType"] = v.Elem().Type().String()
+		}
+	}
+	return res
+}
+
+// pureFuncs are the predeclared functions that typeOf may run.
+var pureFuncs = map[string]bool{
+	"cap": true, "complex": true, "imag": true, "len": true, "max": true, "min": true, "real": true,
+}
+
+// sideEffect returns the first call or channel receive of x that typeOf
+// should not run, or nil. Function literals are not run, so their bodies
+// are skipped.
+func sideEffect(x ast.Expr) ast.Node {
+	var found ast.Node
+	ast.Inspect(x, func(n ast.Node) bool {
+		if found != nil {
+			return false
+		}
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.UnaryExpr:
+			if n.Op == token.ARROW {
+				found = n
+			}
+		case *ast.CallExpr:
+			if !conversionOrPure(n.Fun) {
+				found = n
+			}
+		}
+		return found == nil
+	})
+	return found
+}
+
+// conversionOrPure reports whether a call of fun converts to a type or is
+// a call of one of pureFuncs. Named types other than the predeclared ones
+// cannot be told from functions without type-checking.
+func conversionOrPure(fun ast.Expr) bool {
+	switch f := fun.(type) {
+	case *ast.ParenExpr:
+		return conversionOrPure(f.X)
+	case *ast.Ident:
+		return pureFuncs[f.Name] || isTypeName(f.Name)
+	case *ast.ArrayType, *ast.MapType, *ast.ChanType, *ast.FuncType, *ast.InterfaceType, *ast.StructType:
+		return true
+	}
+	return false
+}
+
+// isTypeName reports whether name is a predeclared type.
+func isTypeName(name string) bool {
+	_, ok := types.Universe.Lookup(name).(*types.TypeName)
+	return ok
+}
+
+// assignable reports whether x denotes a location as far as its syntax
+// tells: a variable, a field, an element or a pointer indirection. The
+// value yaegi returns for constants and functions is not settable, which
+// rules those out.
+func assignable(x ast.Expr) bool {
+	switch x := x.(type) {
+	case *ast.ParenExpr:
+		return assignable(x.X)
+	case *ast.Ident:
+		return x.Name != "_" && types.Universe.Lookup(x.Name) == nil
+	case *ast.IndexExpr, *ast.StarExpr, *ast.SelectorExpr:
+		return true
+	}
+	return false
+}
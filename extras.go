@@ -0,0 +1,15 @@
+package main
+
+import "github.com/traefik/yaegi/interp"
+
+//go:generate yaegi extract -name main -tag yaegi_uuid github.com/google/uuid
+
+// extraSymbols are the symbol sets of third-party packages, loaded after the
+// stdlib by every session. Optional files guarded by build tags add to it in
+// init, so that the bundles are only compiled in when asked for.
+var extraSymbols = []interp.Exports{Symbols}
+
+// Symbols receives the packages extracted into this package by
+// "yaegi extract -name main", such as github_com-google-uuid.go, built
+// with the yaegi_uuid tag.
+var Symbols = interp.Exports{}
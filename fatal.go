@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/traefik/yaegi/interp"
+)
+
+// errFatal fails the evals of a session left broken by a fatal error.
+var errFatal = errors.New("the interpreter is in a fatal state, reset required")
+
+// internalPanic is a panic raised by yaegi outside of interpreted code.
+type internalPanic struct {
+	value interface{}
+}
+
+func (p internalPanic) Error() string {
+	return fmt.Sprintf("panic: %v", p.value)
+}
+
+// isFatal reports whether err is a panic of yaegi itself rather than of
+// the interpreted code, after which the interpreter state can no longer
+// be trusted: one raised while compiling, or escaping yaegi recovery.
+func isFatal(err error) bool {
+	if errors.As(err, &internalPanic{}) {
+		return true
+	}
+	var p interp.Panic
+	if !errors.As(err, &p) {
+		return false
+	}
+	stack := string(p.Stack)
+	return strings.Contains(stack, "yaegi/interp.(*Interpreter).CompileAST") &&
+		!strings.Contains(stack, "yaegi/interp.runCfg")
+}
+
+// handleFatal deals with the fatal error of an eval of s reported in res:
+// with autoRecover set, the interpreter is rebuilt as by a reset keeping
+// files, environment and bindings, otherwise s stays broken until reset.
+func (s *session) handleFatal(res map[string]interface{}) {
+	s.mu.Lock()
+	s.fatalErrors++
+	s.mu.Unlock()
+
+	res["fatal"] = true
+	if autoRecover() {
+		s.rebuild()
+		res["recovered"] = true
+		return
+	}
+
+	s.mu.Lock()
+	s.broken = true
+	s.mu.Unlock()
+}
+
+// rebuild replaces the interpreter of s, as a reset keeping files,
+// environment and bindings.
+func (s *session) rebuild() {
+	bound := s.takeBindings()
+	s.reset()
+	s.rebind(bound)
+}
+
+// isBroken reports whether s needs a reset before running evals.
+func (s *session) isBroken() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.broken
+}
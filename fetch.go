@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall/js"
+
+	"github.com/traefik/yaegi/interp"
+)
+
+// fetchTransport is an http.RoundTripper sending requests with the fetch
+// API of the host, so that interpreted code reaches the network as the
+// page does, CORS included. A network failure, a CORS rejection or a host
+// the network setting denies fails the round trip, which http.Client
+// reports as a *url.Error. Like every wait for JS, it needs the eval to
+// run with evalAsync, unless the transport is detached. A request is
+// aborted once its context is done, or the eval of the session is
+// cancelled, times out or returns, and fails with the error of the
+// context, see fetchContext; the download of its response stops with it.
+type fetchTransport struct {
+	session  *session // whose evals use it, nil for the default of the host
+	detached bool     // used by code never running in a JS callback, see startRun
+}
+
+// canAwait is the check of canAwait for waits of the transport.
+func (t fetchTransport) canAwait() error {
+	if t.detached {
+		return nil
+	}
+	return canAwait(t.session)
+}
+
+func (t fetchTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	fetch := js.Global().Get("fetch")
+	if fetch.Type() != js.TypeFunction {
+		return nil, errors.New("fetch is not available")
+	}
+	if err := checkNetwork(t.session, req.URL); err != nil {
+		return nil, err
+	}
+	if err := t.canAwait(); err != nil {
+		return nil, err
+	}
+	ctx, stop := t.fetchContext(req.Context())
+
+	headers := js.Global().Get("Headers").New()
+	for key, values := range req.Header {
+		for _, v := range values {
+			headers.Call("append", key, v)
+		}
+	}
+	init := map[string]interface{}{
+		"method":  req.Method,
+		"headers": headers,
+	}
+	if !openNetwork(networkAllow()) {
+		// Hand redirects to http.Client, which checks each hop with the
+		// network setting through the transport.
+		init["redirect"] = "manual"
+	}
+	if req.Body != nil {
+		// Streaming request bodies are not widely supported, so the body
+		// is sent whole.
+		body, err := readBody(ctx, req.Body)
+		if err != nil {
+			stop()
+			return nil, err
+		}
+		if len(body) > 0 {
+			init["body"] = bytesToJS(body)
+		}
+	}
+
+	// Abort the fetch with the request, until its body is read or closed.
+	// An aborted fetch is done, whether its body is closed or not.
+	abort := js.Global().Get("AbortController").New()
+	init["signal"] = abort.Get("signal")
+	pendingFetches.Add(1)
+	release := sync.OnceFunc(func() {
+		stop()
+		pendingFetches.Add(-1)
+	})
+	unwatch := context.AfterFunc(ctx, func() {
+		abort.Call("abort")
+		release()
+	})
+	done := func() {
+		unwatch()
+		release()
+	}
+
+	res, err := awaitPromiseContext(ctx, fetch.Invoke(req.URL.String(), init))
+	if err != nil {
+		done()
+		if ctx.Err() != nil {
+			return nil, contextError(ctx)
+		}
+		return nil, err
+	}
+	if res.Get("type").String() == "opaqueredirect" {
+		done()
+		// Browsers hide the target of a redirect not followed.
+		return nil, fmt.Errorf("%w: %s redirects to a location the browser hides", errNetworkBlocked, req.URL.Host)
+	}
+	return t.response(req, res, ctx, done), nil
+}
+
+// pendingFetches counts the fetches of the transports in flight, from
+// their request to the end of the body of their response, see status.
+var pendingFetches atomic.Int32
+
+// fetchContext returns the context of a request of t sent with ctx, done
+// as well with the context of the eval of the session of t, with its
+// cause, and the function releasing it. Code past its eval, which is done,
+// can't fetch until the next one.
+func (t fetchTransport) fetchContext(ctx context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	if t.session == nil {
+		return ctx, func() { cancel(nil) }
+	}
+	eval := t.session.evalContext()
+	unwatch := context.AfterFunc(eval, func() { cancel(context.Cause(eval)) })
+	return ctx, func() {
+		unwatch()
+		cancel(nil)
+	}
+}
+
+// readBody reads body, a request body sent whole, and closes it, or fails
+// with the error of ctx once done, closing it at once for a writer
+// streaming it to stop.
+func readBody(ctx context.Context, body io.ReadCloser) ([]byte, error) {
+	type result struct {
+		data []byte
+		err  error
+	}
+	read := make(chan result, 1)
+	go func() {
+		data, err := io.ReadAll(body)
+		read <- result{data, err}
+	}()
+	select {
+	case r := <-read:
+		body.Close()
+		return r.data, r.err
+	case <-ctx.Done():
+		body.Close()
+		return nil, contextError(ctx)
+	}
+}
+
+// response converts the fetch Response res to the response to req, sent
+// with ctx; done is called once its body is read or closed.
+func (t fetchTransport) response(req *http.Request, res js.Value, ctx context.Context, done func()) *http.Response {
+	header := http.Header{}
+	forEach := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		header.Add(args[1].String(), args[0].String())
+		return nil
+	})
+	res.Get("headers").Call("forEach", forEach)
+	forEach.Release()
+
+	contentLength := int64(-1)
+	if n, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64); err == nil {
+		contentLength = n
+	}
+	var body io.ReadCloser = http.NoBody
+	if b := res.Get("body"); b.Type() == js.TypeObject {
+		body = &fetchBody{reader: b.Call("getReader"), transport: t, ctx: ctx, done: done}
+	} else {
+		done()
+	}
+
+	code := res.Get("status").Int()
+	status := res.Get("statusText").String()
+	if status == "" {
+		status = http.StatusText(code)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", code, status),
+		StatusCode:    code,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          body,
+		ContentLength: contentLength,
+		Request:       req,
+	}
+}
+
+// fetchBody reads the body of a fetch Response as it arrives. The chunks
+// arriving once ctx is done are dropped.
+type fetchBody struct {
+	reader    js.Value // ReadableStreamDefaultReader
+	transport fetchTransport
+	ctx       context.Context // of the request, nil for none
+	done      func()          // called once read or closed, if not nil
+	buf       []byte
+	err       error
+}
+
+func (b *fetchBody) Read(p []byte) (int, error) {
+	ctx := b.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	for len(b.buf) == 0 && b.err == nil {
+		if err := b.transport.canAwait(); err != nil {
+			return 0, err
+		}
+		chunk, err := awaitPromiseContext(ctx, b.reader.Call("read"))
+		switch {
+		case ctx.Err() != nil:
+			b.err = contextError(ctx)
+		case err != nil:
+			b.err = err
+		case chunk.Get("done").Bool():
+			b.err = io.EOF
+		default:
+			value := chunk.Get("value")
+			b.buf = make([]byte, value.Get("length").Int())
+			js.CopyBytesToGo(b.buf, value)
+		}
+	}
+	if len(b.buf) == 0 {
+		b.finish()
+		return 0, b.err
+	}
+	n := copy(p, b.buf)
+	b.buf = b.buf[n:]
+	return n, nil
+}
+
+func (b *fetchBody) Close() error {
+	if b.err == nil {
+		b.reader.Call("cancel")
+		b.err = errors.New("http: read on closed response body")
+	}
+	b.finish()
+	return nil
+}
+
+// finish calls done, once the body is read or closed.
+func (b *fetchBody) finish() {
+	if b.done != nil {
+		b.done()
+		b.done = nil
+	}
+}
+
+func init() {
+	// Clients made by interpreted code without a Transport use the default
+	// of the host.
+	http.DefaultTransport = fetchTransport{}
+}
+
+// httpSymbols returns the net/http defaults of interpreted code, using t:
+// DefaultTransport, DefaultClient and the functions going through it. They
+// are variables of the interpreter, so that a reset restores them.
+func httpSymbols(t fetchTransport) interp.Exports {
+	transport := http.RoundTripper(t)
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		// Follow assignments to http.DefaultTransport.
+		return transport.RoundTrip(req)
+	})}
+	return interp.Exports{"net/http/http": {
+		"DefaultTransport": reflect.ValueOf(&transport).Elem(),
+		"DefaultClient":    reflect.ValueOf(&client).Elem(),
+		"Get":              reflect.ValueOf(func(u string) (*http.Response, error) { return client.Get(u) }),
+		"Head":             reflect.ValueOf(func(u string) (*http.Response, error) { return client.Head(u) }),
+		"Post": reflect.ValueOf(func(u, contentType string, body io.Reader) (*http.Response, error) {
+			return client.Post(u, contentType, body)
+		}),
+		"PostForm": reflect.ValueOf(func(u string, data url.Values) (*http.Response, error) {
+			return client.PostForm(u, data)
+		}),
+	}}
+}
+
+// serveMuxSymbols returns the net/http functions registering handlers on
+// http.DefaultServeMux, which is mux for interpreted code.
+func serveMuxSymbols(mux *http.ServeMux) interp.Exports {
+	return interp.Exports{"net/http/http": {
+		"DefaultServeMux": reflect.ValueOf(&mux).Elem(),
+		"Handle":          reflect.ValueOf(func(pattern string, h http.Handler) { mux.Handle(pattern, h) }),
+		"HandleFunc": reflect.ValueOf(func(pattern string, f func(http.ResponseWriter, *http.Request)) {
+			mux.HandleFunc(pattern, f)
+		}),
+	}}
+}
+
+// roundTripFunc is an http.RoundTripper calling a function.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
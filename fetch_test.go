@@ -0,0 +1,79 @@
+package main
+
+import (
+	"strconv"
+	"syscall/js"
+	"testing"
+	"time"
+)
+
+// slowServer starts a Node HTTP server on 127.0.0.1, closed once t ends,
+// and returns its host: /slow never answers, /slowbody sends its headers
+// and a byte of a body it never ends.
+func slowServer(t *testing.T) string {
+	t.Helper()
+	listen := js.Global().Get("Function").New(`
+		const srv = require("http").createServer((req, res) => {
+			if (req.url === "/slowbody") {
+				res.writeHead(200);
+				res.write("a");
+			}
+		});
+		return new Promise((resolve) => srv.listen(0, "127.0.0.1", () => resolve(srv)));
+	`)
+	srv, err := awaitPromise(listen.Invoke())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		srv.Call("closeAllConnections")
+		srv.Call("close")
+	})
+	return "127.0.0.1:" + strconv.Itoa(srv.Call("address").Get("port").Int())
+}
+
+func TestFetchTimeout(t *testing.T) {
+	host := slowServer(t)
+	mustSucceed(t, callAPI(t, "configure", map[string]interface{}{"network": map[string]interface{}{"allow": []interface{}{host}}}))
+	t.Cleanup(func() {
+		callAPI(t, "configure", map[string]interface{}{"network": map[string]interface{}{"allow": []interface{}{}}})
+	})
+
+	for _, c := range []struct {
+		name, path, after string
+	}{
+		{"headers", "/slow", ""},
+		{"unclosed body", "/slowbody", "time.Sleep(time.Hour)"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			t.Cleanup(func() { callAPI(t, "reset") })
+			res := callAPI(t, "evalAsync", `package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+func main() {
+	resp, err := http.Get("http://`+host+c.path+`")
+	fmt.Println(resp != nil, err)
+	`+c.after+`
+	_ = time.Second
+}
+`, map[string]interface{}{"timeoutMs": 100})
+			if code := errorCodeOf(res); code != codeTimeout {
+				t.Fatalf("eval = %s, want code %s", jsonString(res), codeTimeout)
+			}
+			fetches := -1
+			for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+				if fetches = callAPI(t, "status").Get("fetches").Int(); fetches == 0 {
+					break
+				}
+			}
+			if fetches != 0 {
+				t.Errorf("%d fetches pending after the timeout, want 0", fetches)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"go/build"
+	"io"
+	"path"
+	"reflect"
+	"regexp"
+	"strings"
+	"syscall/js"
+)
+
+// mainPackage is the directory of the source tree holding the files given
+// at the top level of evalFiles, relative to src/.
+const mainPackage = "_main"
+
+// evalFiles evaluates a program made of several files, given as an object
+// mapping file names to source, and an optional options object:
+// yaegi.evalFiles({"main.go": "...", "util/util.go": "..."}). Top-level
+// files form the main package, and files in a directory form the package
+// imported by the directory path, as do the packages added with
+// addPackage. The program runs in a fresh interpreter built from the
+// default session configuration. Test files and files excluded by their
+// build constraints, for js/wasm and the option buildTags, are left out
+// and listed as excluded. A failed program with an import cycle or a
+// missing import has the package graph of deps as deps.
+func evalFiles(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 || len(args) > 2 || args[0].Type() != js.TypeObject {
+		return map[string]interface{}{
+			"success": false,
+			"error":   "evalFiles requires an object mapping file names to source and an optional options object",
+		}
+	}
+
+	opts := parseEvalOptions(optionArg(args, 1))
+	if opts.interactive && !opts.async {
+		return map[string]interface{}{
+			"success": false,
+			"error":   "interactiveStdin requires evalAsync or the async option",
+		}
+	}
+
+	return runFiles(defaultSession(), "evalFiles", args[0], opts)
+}
+
+// runFiles evaluates in a fresh interpreter of s the program made of the
+// files of v, as evalFiles does. cmd is the JS command used in error
+// messages.
+func runFiles(s *session, cmd string, v js.Value, opts evalOptions) map[string]interface{} {
+	src, excluded, err := sourceTree(v, s.files, opts.buildTags, false)
+	if err != nil {
+		return map[string]interface{}{
+			"success":  false,
+			"error":    cmd + ": " + err.Error(),
+			"excluded": excluded,
+		}
+	}
+
+	result := runEvalFunc(s, "", opts, func(ctx context.Context) (reflect.Value, error) {
+		return s.interpreterFor(src, opts.buildTags).EvalWithContext(ctx, `import _ "`+mainPackage+`"`)
+	})
+	result["excluded"] = excluded
+	if result["success"] != true {
+		// The graph gives an overview of what a failed import is about.
+		if g, err := depGraph(s, v, opts.buildTags); err == nil && hasDepIssues(g) {
+			delete(g, "excluded")
+			result["deps"] = g
+		}
+	}
+	return userPaths(result)
+}
+
+// sourceTree lays out the files of v as a GOPATH over a copy of base:
+// top-level files under src/_main and the other ones under src. The files
+// that would not be built for js/wasm with tags, and test files unless
+// tests, are left out and returned as excluded, {file, reason}.
+func sourceTree(v js.Value, base *memFS, tags []string, tests bool) (*memFS, []interface{}, error) {
+	src := base.clone()
+	hasMain := false
+	excluded := []interface{}{}
+	keys := js.Global().Get("Object").Call("keys", v)
+	for i := 0; i < keys.Length(); i++ {
+		name := keys.Index(i).String()
+		code := v.Get(name)
+		if code.Type() != js.TypeString {
+			return nil, excluded, fmt.Errorf("source of %s is not a string", name)
+		}
+		clean, err := treeFileName(name)
+		if err != nil {
+			return nil, excluded, err
+		}
+		if reason := excludedFile(clean, code.String(), tags, tests); reason != "" {
+			excluded = append(excluded, map[string]interface{}{"file": name, "reason": reason})
+			continue
+		}
+
+		if path.Dir(clean) == "." {
+			hasMain = true
+			clean = path.Join(mainPackage, clean)
+		}
+		src.writeFile(path.Join("src", clean), []byte(code.String()))
+	}
+	if !hasMain {
+		return nil, excluded, fmt.Errorf("no top-level file for the main package")
+	}
+	return src, excluded, nil
+}
+
+// treeFileName returns the file name given to evalFiles cleaned, or an
+// error if it is not that of a Go file of the source tree.
+func treeFileName(name string) (string, error) {
+	clean := path.Clean(name)
+	if !strings.HasSuffix(clean, ".go") || clean == ".go" {
+		return "", fmt.Errorf("%s is not a Go file name", name)
+	}
+	if path.IsAbs(clean) || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("%s is outside of the source tree", name)
+	}
+	return clean, nil
+}
+
+// excludedFile returns why the file name of source code is left out of a
+// source tree, or "" if it is not: a test file, unless tests, or a file
+// not built for js/wasm with the build tags tags, by its name or its
+// build constraints. yaegi reads only the +build ones.
+func excludedFile(name, code string, tags []string, tests bool) string {
+	if strings.HasSuffix(name, "_test.go") && !tests {
+		return "test file"
+	}
+	ctxt := build.Default
+	ctxt.GOOS, ctxt.GOARCH = "js", "wasm"
+	ctxt.BuildTags = tags
+	ctxt.CgoEnabled = false
+	ctxt.OpenFile = func(string) (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader(code)), nil
+	}
+	dir, file := path.Split(name)
+	if ok, err := ctxt.MatchFile(dir, file); err == nil && !ok {
+		return "build constraints"
+	}
+	return ""
+}
+
+// importError matches the prefix of the error reported for the import
+// statement used to load a source package.
+var importError = regexp.MustCompile(`^\d+:\d+: import "[^"]+" error: `)
+
+// userPaths rewrites the source tree paths reported in result as the file
+// names given to evalFiles or addPackage, and drops the value of the import
+// statement used to load the package.
+func userPaths(result map[string]interface{}) map[string]interface{} {
+	r := strings.NewReplacer("src/"+mainPackage+"/", "", "src/", "")
+	if msg, ok := result["error"].(string); ok {
+		result["error"] = r.Replace(importError.ReplaceAllString(msg, ""))
+	}
+	for _, key := range []string{"diagnostics", "stack"} {
+		items, _ := result[key].([]interface{})
+		for _, item := range items {
+			m := item.(map[string]interface{})
+			if file, ok := m["file"].(string); ok {
+				m["file"] = r.Replace(file)
+			}
+			if fn, ok := m["function"].(string); ok {
+				m["function"] = strings.Replace(fn, mainPackage+".", "main.", 1)
+			}
+		}
+	}
+	if result["success"] == true {
+		result["value"] = nil
+		result["valueType"] = nil
+	}
+	return result
+}
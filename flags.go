@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding"
+	"flag"
+	"fmt"
+	"io"
+	"reflect"
+	"time"
+
+	"github.com/traefik/yaegi/interp"
+)
+
+// commandLine is the os.Args and flag.CommandLine of interpreted code,
+// the flag sets writing to stderr.
+type commandLine struct {
+	args   []string
+	flags  *flag.FlagSet
+	usage  func() // flag.Usage
+	stderr io.Writer
+}
+
+// setArgs makes args the os.Args of the programs run by s, "main" alone
+// if empty, and starts a new flag.CommandLine parsing them.
+func (s *session) setArgs(args []string) {
+	s.cmd.set(args)
+}
+
+// set makes args the os.Args of c, "main" alone if empty, and starts a new
+// flag.CommandLine parsing them.
+func (c *commandLine) set(args []string) {
+	if len(args) == 0 {
+		args = []string{"main"}
+	}
+	c.args = args
+
+	c.flags = flag.NewFlagSet(args[0], flag.ContinueOnError)
+	c.flags.SetOutput(c.stderr)
+	c.flags.Usage = func() { c.usage() }
+	c.usage = func() {
+		fmt.Fprintf(c.flags.Output(), "Usage of %s:\n", c.flags.Name())
+		c.flags.PrintDefaults()
+	}
+}
+
+// parse stands for flag.Parse in interpreted code. It exits with status 2
+// on a bad flag or for -help, once the usage is printed to the stderr of
+// the eval, which a result then reports as its exitCode.
+func (c *commandLine) parse() {
+	if err := c.flags.Parse(c.args[1:]); err != nil {
+		interceptedExit(2)
+	}
+}
+
+// newFlagSet stands for flag.NewFlagSet in interpreted code. The flag
+// package would call the os.Exit of the module for a set exiting on error,
+// so its parse errors and -help end the eval with status 2 instead, like
+// those of parse, unless the code sets a Usage of its own. The output of
+// the sets goes to the stderr of c.
+func (c *commandLine) newFlagSet(name string, handling flag.ErrorHandling) *flag.FlagSet {
+	if handling != flag.ExitOnError {
+		f := flag.NewFlagSet(name, handling)
+		f.SetOutput(c.stderr)
+		return f
+	}
+	f := flag.NewFlagSet(name, flag.ContinueOnError)
+	f.SetOutput(c.stderr)
+	f.Usage = func() {
+		if name == "" {
+			fmt.Fprintf(f.Output(), "Usage:\n")
+		} else {
+			fmt.Fprintf(f.Output(), "Usage of %s:\n", name)
+		}
+		f.PrintDefaults()
+		interceptedExit(2)
+	}
+	return f
+}
+
+// argSymbols returns the os.Args and flag symbols of c, for the
+// interpreters of s, which follow the args of c rather than those of the
+// module.
+func (s *session) argSymbols(c *commandLine) interp.Exports {
+	syms := interp.Exports{}
+	if s.config.allows("os") {
+		syms["os/os"] = map[string]reflect.Value{"Args": reflect.ValueOf(&c.args).Elem()}
+	}
+	if !s.config.allows("flag") {
+		return syms
+	}
+
+	cl := func() *flag.FlagSet { return c.flags }
+	syms["flag/flag"] = map[string]reflect.Value{
+		"CommandLine":   reflect.ValueOf(&c.flags).Elem(),
+		"Usage":         reflect.ValueOf(&c.usage).Elem(),
+		"Parse":         reflect.ValueOf(c.parse),
+		"NewFlagSet":    reflect.ValueOf(c.newFlagSet),
+		"Parsed":        reflect.ValueOf(func() bool { return cl().Parsed() }),
+		"Arg":           reflect.ValueOf(func(i int) string { return cl().Arg(i) }),
+		"Args":          reflect.ValueOf(func() []string { return cl().Args() }),
+		"NArg":          reflect.ValueOf(func() int { return cl().NArg() }),
+		"NFlag":         reflect.ValueOf(func() int { return cl().NFlag() }),
+		"Lookup":        reflect.ValueOf(func(name string) *flag.Flag { return cl().Lookup(name) }),
+		"Set":           reflect.ValueOf(func(name, value string) error { return cl().Set(name, value) }),
+		"Visit":         reflect.ValueOf(func(fn func(*flag.Flag)) { cl().Visit(fn) }),
+		"VisitAll":      reflect.ValueOf(func(fn func(*flag.Flag)) { cl().VisitAll(fn) }),
+		"PrintDefaults": reflect.ValueOf(func() { cl().PrintDefaults() }),
+		"Var":           reflect.ValueOf(func(value flag.Value, name, usage string) { cl().Var(value, name, usage) }),
+		"Func":          reflect.ValueOf(func(name, usage string, fn func(string) error) { cl().Func(name, usage, fn) }),
+		"BoolFunc":      reflect.ValueOf(func(name, usage string, fn func(string) error) { cl().BoolFunc(name, usage, fn) }),
+		"TextVar": reflect.ValueOf(func(p encoding.TextUnmarshaler, name string, value encoding.TextMarshaler, usage string) {
+			cl().TextVar(p, name, value, usage)
+		}),
+
+		"Bool":    reflect.ValueOf(func(name string, value bool, usage string) *bool { return cl().Bool(name, value, usage) }),
+		"BoolVar": reflect.ValueOf(func(p *bool, name string, value bool, usage string) { cl().BoolVar(p, name, value, usage) }),
+		"Duration": reflect.ValueOf(func(name string, value time.Duration, usage string) *time.Duration {
+			return cl().Duration(name, value, usage)
+		}),
+		"DurationVar": reflect.ValueOf(func(p *time.Duration, name string, value time.Duration, usage string) {
+			cl().DurationVar(p, name, value, usage)
+		}),
+		"Float64":    reflect.ValueOf(func(name string, value float64, usage string) *float64 { return cl().Float64(name, value, usage) }),
+		"Float64Var": reflect.ValueOf(func(p *float64, name string, value float64, usage string) { cl().Float64Var(p, name, value, usage) }),
+		"Int":        reflect.ValueOf(func(name string, value int, usage string) *int { return cl().Int(name, value, usage) }),
+		"IntVar":     reflect.ValueOf(func(p *int, name string, value int, usage string) { cl().IntVar(p, name, value, usage) }),
+		"Int64":      reflect.ValueOf(func(name string, value int64, usage string) *int64 { return cl().Int64(name, value, usage) }),
+		"Int64Var":   reflect.ValueOf(func(p *int64, name string, value int64, usage string) { cl().Int64Var(p, name, value, usage) }),
+		"String":     reflect.ValueOf(func(name string, value string, usage string) *string { return cl().String(name, value, usage) }),
+		"StringVar":  reflect.ValueOf(func(p *string, name string, value string, usage string) { cl().StringVar(p, name, value, usage) }),
+		"Uint":       reflect.ValueOf(func(name string, value uint, usage string) *uint { return cl().Uint(name, value, usage) }),
+		"UintVar":    reflect.ValueOf(func(p *uint, name string, value uint, usage string) { cl().UintVar(p, name, value, usage) }),
+		"Uint64":     reflect.ValueOf(func(name string, value uint64, usage string) *uint64 { return cl().Uint64(name, value, usage) }),
+		"Uint64Var":  reflect.ValueOf(func(p *uint64, name string, value uint64, usage string) { cl().Uint64Var(p, name, value, usage) }),
+	}
+	return syms
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/printer"
+	"go/scanner"
+	"go/token"
+	"sort"
+	"strings"
+	"syscall/js"
+)
+
+// formatSource formats Go source code as gofmt does. With the option
+// simplify, it also applies the rewrites of gofmt -s. It accepts the same
+// sources as parseFragment. It does not use the interpreter, so it can run
+// while an evaluation is in flight.
+func formatSource(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 || args[0].Type() != js.TypeString {
+		return map[string]interface{}{
+			"success": false,
+			"error":   "format requires the Go source code",
+		}
+	}
+	src := args[0].String()
+
+	f, err := parseFragment(src)
+	if err != nil {
+		return formatError(err, src, f)
+	}
+	if opts := optionArg(args, 1); opts.Type() == js.TypeObject && opts.Get("simplify").Truthy() {
+		src = applyEdits(src, f.simplifications())
+	}
+
+	out, err := gofmt(src)
+	if err != nil {
+		return formatError(err, src, nil)
+	}
+	return map[string]interface{}{
+		"success":   true,
+		"formatted": out,
+	}
+}
+
+// funcHeader opens the function wrapping statements to parse them.
+const funcHeader = "func _() {"
+
+// fragment is a source parsed as a file after adding the text it lacks.
+type fragment struct {
+	fset *token.FileSet
+	file *ast.File
+	head int // bytes prepended to the source, before any funcHeader
+	body int // source offset of the statements wrapped in funcHeader, or -1
+}
+
+// parseFragment parses src as a file, a list of declarations or a list of
+// statements, as format.Source does, or as imports followed by statements,
+// as accepted by snippet mode. The returned fragment is non-nil even on
+// error.
+func parseFragment(src string) (*fragment, error) {
+	f := &fragment{fset: token.NewFileSet(), body: -1}
+	parse := func(text string) error {
+		var err error
+		f.file, err = parser.ParseFile(f.fset, "", text, parser.ParseComments)
+		return err
+	}
+
+	err := parse(src)
+	if err == nil || !strings.Contains(err.Error(), "expected 'package'") {
+		return f, err
+	}
+
+	f.head = len("package p;")
+	err = parse("package p;" + src)
+	if err == nil || !strings.Contains(err.Error(), "expected declaration") {
+		return f, err
+	}
+
+	f.body = leadingImports(src)
+	return f, parse("package p;" + src[:f.body] + funcHeader + src[f.body:] + "\n}")
+}
+
+// offset returns the offset in the source of a position in the parsed file.
+func (f *fragment) offset(p token.Pos) int {
+	return f.sourceOffset(f.fset.Position(p).Offset)
+}
+
+// sourceOffset translates an offset in the parsed text to the source.
+func (f *fragment) sourceOffset(o int) int {
+	o -= f.head
+	if f.body >= 0 && o >= f.body {
+		o = max(o-len(funcHeader), f.body)
+	}
+	return max(o, 0)
+}
+
+// leadingImports returns the offset in src following its leading import
+// declarations.
+func leadingImports(src string) int {
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(src))
+	var sc scanner.Scanner
+	sc.Init(file, []byte(src), nil, 0)
+
+	end, depth, inImport := 0, 0, false
+	for {
+		pos, tok, _ := sc.Scan()
+		switch {
+		case tok == token.EOF:
+			return end
+		case tok == token.IMPORT && !inImport:
+			inImport = true
+		case !inImport:
+			return end
+		case tok == token.LPAREN:
+			depth++
+		case tok == token.RPAREN:
+			depth--
+		case tok == token.SEMICOLON && depth == 0:
+			inImport = false
+			end = min(file.Offset(pos)+1, len(src))
+		}
+	}
+}
+
+// gofmt formats src with format.Source, formatting the imports and the
+// statements of a snippet separately.
+func gofmt(src string) (string, error) {
+	body := 0
+	if f, err := parseFragment(src); err == nil && f.body > 0 {
+		body = f.body
+	}
+	if body == 0 {
+		out, err := format.Source([]byte(src))
+		return string(out), err
+	}
+
+	imports, err := format.Source([]byte(src[:body]))
+	if err != nil {
+		return "", err
+	}
+	stmts, err := format.Source([]byte(strings.TrimLeft(src[body:], " \t\n")))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(imports), "\n") + "\n\n" + string(stmts), nil
+}
+
+// formatError reports a parse error of src. Positions are translated with
+// f when src was parsed as a fragment.
+func formatError(err error, src string, f *fragment) map[string]interface{} {
+	var list scanner.ErrorList
+	if !errors.As(err, &list) || len(list) == 0 {
+		return map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+		}
+	}
+
+	diags := make([]diagnostic, len(list))
+	for i, e := range list {
+		line, column := e.Pos.Line, e.Pos.Column
+		if f != nil {
+			o := min(f.sourceOffset(e.Pos.Offset), len(src))
+			line = strings.Count(src[:o], "\n") + 1
+			column = o - strings.LastIndex(src[:o], "\n")
+		}
+		diags[i] = diagnostic{line: line, column: column, message: e.Msg, severity: "error"}
+	}
+	out := make([]interface{}, len(diags))
+	for i, d := range diags {
+		out[i] = d.toJS()
+	}
+	return map[string]interface{}{
+		"success":     false,
+		"error":       fmt.Sprintf("%d:%d: %s", diags[0].line, diags[0].column, diags[0].message),
+		"errorCode":   codeParse,
+		"diagnostics": out,
+	}
+}
+
+// edit replaces the source between two byte offsets with text.
+type edit struct {
+	start, end int
+	text       string
+}
+
+// applyEdits returns src with edits applied. Edits overlapping a previous
+// one are dropped.
+func applyEdits(src string, edits []edit) string {
+	sort.SliceStable(edits, func(i, j int) bool { return edits[i].start < edits[j].start })
+
+	var b strings.Builder
+	last := 0
+	for _, e := range edits {
+		if e.start < last || e.end > len(src) {
+			continue
+		}
+		b.WriteString(src[last:e.start])
+		b.WriteString(e.text)
+		last = e.end
+	}
+	b.WriteString(src[last:])
+	return b.String()
+}
+
+// remove returns the edit removing the source from one position to another.
+func (f *fragment) remove(from, to token.Pos) edit {
+	return edit{start: f.offset(from), end: f.offset(to)}
+}
+
+// simplifications returns the edits of gofmt -s: removing composite literal
+// types implied by the enclosing literal, s[a:len(s)] upper bounds and blank
+// range variables.
+func (f *fragment) simplifications() []edit {
+	var edits []edit
+	text := func(e ast.Expr) string {
+		var b strings.Builder
+		printer.Fprint(&b, f.fset, e)
+		return b.String()
+	}
+
+	// Types of the literals whose type is, or will be, elided. Literals are
+	// visited after the enclosing ones.
+	implied := map[*ast.CompositeLit]ast.Expr{}
+	elide := func(x, typ ast.Expr) {
+		switch x := x.(type) {
+		case *ast.CompositeLit:
+			if x.Type != nil && text(x.Type) == text(typ) {
+				edits = append(edits, f.remove(x.Type.Pos(), x.Lbrace))
+				x.Type = nil
+			}
+			if x.Type == nil {
+				implied[x] = typ
+			}
+		case *ast.UnaryExpr:
+			ptr, ok := typ.(*ast.StarExpr)
+			lit, isLit := x.X.(*ast.CompositeLit)
+			if ok && isLit && x.Op == token.AND && lit.Type != nil && text(lit.Type) == text(ptr.X) {
+				edits = append(edits, f.remove(x.Pos(), lit.Lbrace))
+				lit.Type = nil
+				implied[lit] = ptr.X
+			}
+		}
+	}
+
+	ast.Inspect(f.file, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.CompositeLit:
+			typ := n.Type
+			if typ == nil {
+				typ = implied[n]
+			}
+			var keyType, eltType ast.Expr
+			switch t := typ.(type) {
+			case *ast.ArrayType:
+				eltType = t.Elt
+			case *ast.MapType:
+				keyType, eltType = t.Key, t.Value
+			default:
+				return true
+			}
+			for _, e := range n.Elts {
+				if kv, ok := e.(*ast.KeyValueExpr); ok {
+					if keyType != nil {
+						elide(kv.Key, keyType)
+					}
+					e = kv.Value
+				}
+				elide(e, eltType)
+			}
+
+		case *ast.SliceExpr:
+			s, ok := n.X.(*ast.Ident)
+			call, isCall := n.High.(*ast.CallExpr)
+			if !ok || !isCall || n.Max != nil || len(call.Args) != 1 || call.Ellipsis.IsValid() {
+				return true
+			}
+			fn, isIdent := call.Fun.(*ast.Ident)
+			arg, isArg := call.Args[0].(*ast.Ident)
+			if isIdent && isArg && fn.Name == "len" && fn.Obj == nil && arg.Name == s.Name {
+				edits = append(edits, f.remove(n.High.Pos(), n.High.End()))
+			}
+
+		case *ast.RangeStmt:
+			switch {
+			case isBlank(n.Key) && (n.Value == nil || isBlank(n.Value)):
+				edits = append(edits, f.remove(n.Key.Pos(), n.Range))
+			case isBlank(n.Value):
+				edits = append(edits, f.remove(n.Key.End(), n.Value.End()))
+			}
+		}
+		return true
+	})
+	return edits
+}
+
+func isBlank(e ast.Expr) bool {
+	id, ok := e.(*ast.Ident)
+	return ok && id.Name == "_"
+}
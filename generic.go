@@ -0,0 +1,61 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/traefik/yaegi/stdlib/generic"
+)
+
+// genericFuncs returns the signatures of the exported functions of the
+// stdlib packages yaegi compiles from source, as generic functions cannot
+// be registered as symbols, by import path and name.
+var genericFuncs = sync.OnceValue(func() map[string]map[string]string {
+	funcs := map[string]map[string]string{}
+	fset := token.NewFileSet()
+	for _, src := range generic.Sources {
+		f, err := parser.ParseFile(fset, "", src, parser.SkipObjectResolution)
+		if err != nil {
+			continue
+		}
+		for _, decl := range f.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Recv != nil || !fn.Name.IsExported() {
+				continue
+			}
+			fn.Body, fn.Doc = nil, nil
+			var b strings.Builder
+			printer.Fprint(&b, fset, fn)
+			if funcs[f.Name.Name] == nil {
+				funcs[f.Name.Name] = map[string]string{}
+			}
+			funcs[f.Name.Name][fn.Name.Name] = b.String()
+		}
+	}
+	return funcs
+})
+
+// packageSymbols returns the symbols of the package importPath known to
+// the interpreter of s, other than the generic functions.
+func (s *session) packageSymbols(importPath string) (syms map[string]reflect.Value) {
+	for _, cs := range s.importCandidates() {
+		for _, c := range cs {
+			if c.path == importPath && c.symbols != nil {
+				return c.symbols
+			}
+		}
+	}
+
+	defer func() {
+		// Listing the symbols of a broken declaration may panic.
+		if recover() != nil {
+			syms = nil
+		}
+	}()
+	return s.interpreter.Symbols(importPath)[importPath]
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"go/constant"
+	"reflect"
+	"sort"
+	"syscall/js"
+)
+
+// Limits on the values reported by globals, past which they are truncated.
+const (
+	maxGlobalItems  = 100  // elements of a slice, an array or a map
+	maxGlobalString = 1024 // bytes of a string
+)
+
+// globals lists the variables, constants, functions and types declared in
+// the main package of the default session, sorted by name: {name, kind,
+// type, value, valueString}. Functions report their signature as value,
+// and types have none. Values are converted as eval results, keeping only
+// their first elements when large, with truncated set, and rendered with
+// the render options given as argument. Only exported functions and types
+// are known to yaegi. The declarations of a prelude set with
+// hiddenFromGlobals are left out. Nothing is evaluated, but the call
+// fails as busy while an eval uses the interpreter.
+func globals(this js.Value, args []js.Value) interface{} {
+	s := defaultSession()
+	if err := s.acquireEval(context.Background(), false); err != nil {
+		return map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+			"busy":    true,
+		}
+	}
+	defer s.releaseEval()
+	limits := parseRenderLimits(optionArg(args, 0), renderSettings())
+
+	entries := map[string]map[string]interface{}{}
+	for name, v := range s.interpreter.Globals() {
+		if s.hiddenGlobal(name) {
+			continue
+		}
+		c := symbolCompletion(name, v, "main")
+		entry := map[string]interface{}{
+			"name": name,
+			"kind": c.kind,
+			"type": c.typ,
+		}
+		value, truncated := truncatedToJS(v)
+		entry["valueString"] = renderValue(v, limits)
+		if c.kind == "const" {
+			if cv, ok := v.Interface().(constant.Value); ok {
+				value = constantToJS(cv)
+				entry["valueString"] = cv.ExactString()
+			}
+		}
+		entry["value"] = value
+		if truncated {
+			entry["truncated"] = true
+		}
+		entries[name] = entry
+	}
+	for name, v := range s.mainDecls() {
+		if s.hiddenGlobal(name) {
+			continue
+		}
+		c := symbolCompletion(name, v, "main")
+		entry := map[string]interface{}{
+			"name":        name,
+			"kind":        c.kind,
+			"type":        c.typ,
+			"value":       nil,
+			"valueString": nil,
+		}
+		if c.kind == "func" {
+			entry["value"] = signature(c.kind, name, v)
+		}
+		entries[name] = entry
+	}
+
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	list := make([]interface{}, len(names))
+	for i, name := range names {
+		list[i] = entries[name]
+	}
+	return list
+}
+
+// mainDecls returns the exported functions and types of the main package
+// of s.
+func (s *session) mainDecls() (decls map[string]reflect.Value) {
+	defer func() {
+		// Listing the symbols of a broken declaration may panic.
+		if recover() != nil {
+			decls = nil
+		}
+	}()
+
+	decls = map[string]reflect.Value{}
+	for name, v := range s.interpreter.Symbols("main")["main"] {
+		if v.Kind() == reflect.Func || v.Kind() == reflect.Ptr && !v.CanAddr() {
+			decls[name] = v
+		}
+	}
+	return decls
+}
+
+// truncatedToJS converts v like goValueToJS, keeping only the first
+// elements of a large slice, array, map or string. It reports whether v
+// was truncated.
+func truncatedToJS(v reflect.Value) (interface{}, bool) {
+	switch v.Kind() {
+	case reflect.String:
+		if v.Len() > maxGlobalString {
+			return v.String()[:maxGlobalString], true
+		}
+	case reflect.Slice, reflect.Array:
+		if v.Len() > maxGlobalItems && v.Type().Elem().Kind() != reflect.Uint8 {
+			items := make([]interface{}, maxGlobalItems)
+			for i := range items {
+				items[i] = goValueToJS(v.Index(i))
+			}
+			return items, true
+		}
+		if v.Kind() == reflect.Slice && v.Len() > maxGlobalString {
+			return goValueToJS(v.Slice(0, maxGlobalString)), true
+		}
+	case reflect.Map:
+		if v.Len() > maxGlobalItems {
+			obj := make(map[string]interface{}, maxGlobalItems)
+			iter := v.MapRange()
+			for len(obj) < maxGlobalItems && iter.Next() {
+				obj[mapKeyString(iter.Key())] = goValueToJS(iter.Value())
+			}
+			return obj, true
+		}
+	}
+	return goValueToJS(v), false
+}
+
+// constantToJS converts an untyped constant to a JS number, string or
+// boolean, or to its Go representation if it has none, as for integers
+// beyond int64.
+func constantToJS(cv constant.Value) interface{} {
+	switch cv.Kind() {
+	case constant.Bool:
+		return constant.BoolVal(cv)
+	case constant.String:
+		return constant.StringVal(cv)
+	case constant.Int:
+		if i, exact := constant.Int64Val(cv); exact {
+			return i
+		}
+	case constant.Float:
+		f, _ := constant.Float64Val(cv)
+		return f
+	}
+	return cv.String()
+}
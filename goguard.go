@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"reflect"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall/js"
+	"time"
+
+	"github.com/traefik/yaegi/interp"
+)
+
+const (
+	// goPackage is the package of the calls guardGoroutines inserts.
+	goPackage = "yaegiwasm/goguard"
+	// guardDefer recovers the panic of a goroutine, inserted by
+	// guardGoroutines at the top of the function a go statement starts.
+	guardDefer = "defer func() { _yaegigo.Recovered(recover()) }(); "
+	// guardStart counts a goroutine started, inserted by guardGoroutines
+	// before a go statement; guardDefer counts it ended.
+	guardStart = "_yaegigo.Started(); "
+)
+
+// asyncErrors is the host callback of onAsyncError.
+var asyncErrors struct {
+	sync.Mutex
+	handler js.Value
+}
+
+// onAsyncError sets the function called with the async errors of every
+// session: the panics of goroutines started by interpreted code, which
+// would otherwise end the module. It is called with {session, value,
+// valueString, stack, atMs}, stack being the trace of the goroutine. null
+// unsets it; in worker mode, the errors are then posted as {event:
+// "asyncError", error}.
+func onAsyncError(this js.Value, args []js.Value) interface{} {
+	fn := optionArg(args, 0)
+	if fn.Type() != js.TypeFunction && !fn.IsNull() && !fn.IsUndefined() {
+		return map[string]interface{}{
+			"success":   false,
+			"error":     "onAsyncError requires a function or null",
+			"errorCode": codeBadRequest,
+		}
+	}
+	asyncErrors.Lock()
+	defer asyncErrors.Unlock()
+
+	asyncErrors.handler = fn
+	return map[string]interface{}{"success": true}
+}
+
+// goSymbols returns the package of the calls inserted by guardGoroutines,
+// reporting the panics they recover as async errors of s, and counting in
+// live the goroutines running.
+func (s *session) goSymbols(live *atomic.Int64) interp.Exports {
+	return interp.Exports{goPackage + "/goguard": {
+		"Started": reflect.ValueOf(func() { live.Add(1) }),
+		"Recovered": reflect.ValueOf(func(p interface{}) {
+			live.Add(-1)
+			if p != nil {
+				s.asyncPanic(p)
+			}
+		}),
+	}}
+}
+
+// asyncPanic records the panic p of a goroutine of s, whose stack is that
+// of the calling goroutine, and hands it to the onAsyncError callback.
+func (s *session) asyncPanic(p interface{}) {
+	// yaegi panics with the values of interpreted code.
+	v, ok := p.(reflect.Value)
+	if !ok {
+		v = reflect.ValueOf(p)
+	} else if v.IsValid() && v.CanInterface() {
+		p = v.Interface()
+	}
+	value := fmt.Sprint(p)
+	if e, ok := p.(error); ok {
+		value = e.Error()
+	}
+	event := map[string]interface{}{
+		"session":     s.id,
+		"value":       value,
+		"valueString": nil,
+		"stack":       string(debug.Stack()),
+		"atMs":        time.Now().UnixMilli(),
+	}
+	// Errors, those of the runtime included, are shown by their message.
+	if _, ok := p.(error); !ok {
+		event["valueString"] = valueString(v, renderSettings())
+	}
+
+	s.mu.Lock()
+	s.counters.asyncErrors++
+	s.counters.lastAsyncError = event
+	s.mu.Unlock()
+
+	asyncErrors.Lock()
+	handler := asyncErrors.handler
+	asyncErrors.Unlock()
+	switch {
+	case handler.Type() == js.TypeFunction:
+		func() {
+			defer func() { recover() }()
+			handler.Invoke(event)
+		}()
+	case workerMode():
+		js.Global().Call("postMessage", map[string]interface{}{
+			"event": "asyncError",
+			"error": event,
+		})
+	}
+}
+
+// startGuard imports the package of guardGoroutines in the interpreter of
+// s the first time it runs guarded code of the file name.
+func (s *session) startGuard(name string) error {
+	return s.importOnce(&s.guardInterp, "_yaegigo", goPackage, name)
+}
+
+// guardEdit is a source rewritten by guardGoroutines.
+type guardEdit struct {
+	src     string
+	moved   insertMap // the text inserted
+	guarded bool      // whether src has a go statement
+}
+
+// guardGoroutines rewrites the go statements of src so that a panic of the
+// goroutine they start is recovered and reported by asyncPanic, rather
+// than ending the module, and that goSymbols counts it. guardStart goes
+// before the statement, guardDefer at the top of a function literal
+// started, and a function declared in src is called through one taking
+// its parameters:
+//
+//	go work(i, s) // func work(n int, s string)
+//	_yaegigo.Started(); go func(_yaegi0 int, _yaegi1 string) { defer ...; work(_yaegi0, _yaegi1) }(i, s)
+//
+// so that the arguments are still evaluated at once. The other calls, to
+// methods, compiled functions or functions of earlier evals, are wrapped
+// into a function literal, their arguments then evaluated by the goroutine
+// as yaegi does for compiled functions anyway. Only text is inserted, the
+// columns shifted recorded as an insertMap. A source that does not parse
+// is returned as is, for the eval to report its errors.
+func guardGoroutines(src string) guardEdit {
+	edit := guardEdit{src: src}
+	if !strings.Contains(src, "go") {
+		return edit
+	}
+	f, err := parseFragment(src)
+	if err != nil {
+		return edit
+	}
+
+	type insert struct {
+		at   int
+		text string
+	}
+	var inserts []insert
+	ast.Inspect(f.file, func(n ast.Node) bool {
+		g, ok := n.(*ast.GoStmt)
+		if !ok {
+			return true
+		}
+		inserts = append(inserts, insert{f.offset(g.Pos()), guardStart})
+		call := g.Call
+		if lit, ok := ast.Unparen(call.Fun).(*ast.FuncLit); ok {
+			inserts = append(inserts, insert{f.offset(lit.Body.Lbrace) + 1, guardDefer})
+			return true
+		}
+		if params, args, ok := forwarding(f, src, call); ok {
+			inserts = append(inserts,
+				insert{f.offset(call.Pos()), "func(" + params + ") { " + guardDefer},
+				insert{f.offset(call.Lparen), "(" + args + ") }"})
+			return true
+		}
+		inserts = append(inserts,
+			insert{f.offset(call.Pos()), "func() { " + guardDefer},
+			insert{f.offset(call.End()), " }()"})
+		return true
+	})
+	if inserts == nil {
+		return edit
+	}
+	sort.SliceStable(inserts, func(i, j int) bool { return inserts[i].at < inserts[j].at })
+
+	var b strings.Builder
+	edit.moved = insertMap{}
+	last := 0
+	for _, in := range inserts {
+		b.WriteString(src[last:in.at])
+		b.WriteString(in.text)
+		line := strings.Count(src[:in.at], "\n") + 1
+		edit.moved[line] = append(edit.moved[line], [2]int{in.at - strings.LastIndex(src[:in.at], "\n"), len(in.text)})
+		last = in.at
+	}
+	b.WriteString(src[last:])
+	edit.src = b.String()
+	edit.guarded = true
+	return edit
+}
+
+// unname maps back to the go statements rewritten by e the name of the
+// guard package in the error text of result: an error about _yaegigo, say
+// in a package other than main, is one about the go statement.
+func (e guardEdit) unname(result map[string]interface{}) map[string]interface{} {
+	if !e.guarded {
+		return result
+	}
+	if msg, ok := result["error"].(string); ok {
+		result["error"] = strings.ReplaceAll(msg, "_yaegigo", "go")
+	}
+	items, _ := result["diagnostics"].([]interface{})
+	for _, item := range items {
+		d := item.(map[string]interface{})
+		if msg, ok := d["message"].(string); ok {
+			d["message"] = strings.ReplaceAll(msg, "_yaegigo", "go")
+		}
+	}
+	return result
+}
+
+// forwarding returns, if the function called by call is declared in the
+// fragment f of src and is not generic, the parameters of a function
+// literal forwarding its arguments to it and those arguments: "_yaegi0
+// int, _yaegi1 ...string" and "_yaegi0, _yaegi1...", the types written as
+// in the declaration.
+func forwarding(f *fragment, src string, call *ast.CallExpr) (params, args string, ok bool) {
+	id, _ := ast.Unparen(call.Fun).(*ast.Ident)
+	if id == nil || id.Obj == nil || id.Obj.Kind != ast.Fun {
+		return "", "", false
+	}
+	decl, _ := id.Obj.Decl.(*ast.FuncDecl)
+	if decl == nil || decl.Recv != nil || decl.Type.TypeParams != nil {
+		return "", "", false
+	}
+	var p, a []string
+	for _, field := range decl.Type.Params.List {
+		typ := src[f.offset(field.Type.Pos()):f.offset(field.Type.End())]
+		if strings.Contains(typ, "\n") {
+			// It would shift the lines that follow.
+			return "", "", false
+		}
+		for range max(len(field.Names), 1) {
+			name := "_yaegi" + strconv.Itoa(len(p))
+			p = append(p, name+" "+typ)
+			if _, variadic := field.Type.(*ast.Ellipsis); variadic {
+				name += "..."
+			}
+			a = append(a, name)
+		}
+	}
+	return strings.Join(p, ", "), strings.Join(a, ", "), true
+}
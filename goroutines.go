@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall/js"
+)
+
+// yaegiPackage prefixes the functions of yaegi in stack traces. The
+// goroutines it creates are those of the interpreted code.
+const yaegiPackage = "github.com/traefik/yaegi/"
+
+// goroutine is a goroutine of a stack dump.
+type goroutine struct {
+	id        int
+	state     string       // why it waits, e.g. "chan receive" or "sleep"
+	frames    []stackFrame // innermost first
+	createdBy *stackFrame  // nil for the main goroutine
+	parent    int          // the goroutine that created it, 0 if none
+}
+
+// stackFrame is a function call of a goroutine stack.
+type stackFrame struct {
+	fn   string
+	file string
+	line int
+}
+
+// interpreted reports whether g was started by interpreted code.
+func (g goroutine) interpreted() bool {
+	return g.createdBy != nil && strings.HasPrefix(g.createdBy.fn, yaegiPackage)
+}
+
+// toJS returns g as {id, state, interpreted, frames, createdBy}.
+func (g goroutine) toJS() map[string]interface{} {
+	var createdBy interface{}
+	if g.createdBy != nil {
+		createdBy = g.createdBy.toJS()
+	}
+	return map[string]interface{}{
+		"id":          g.id,
+		"state":       g.state,
+		"interpreted": g.interpreted(),
+		"frames":      framesToJS(g.frames),
+		"createdBy":   createdBy,
+	}
+}
+
+// toJS returns f as {func, file, line}.
+func (f stackFrame) toJS() map[string]interface{} {
+	return map[string]interface{}{
+		"func": f.fn,
+		"file": f.file,
+		"line": f.line,
+	}
+}
+
+func framesToJS(frames []stackFrame) []interface{} {
+	list := make([]interface{}, len(frames))
+	for i, f := range frames {
+		list[i] = f.toJS()
+	}
+	return list
+}
+
+// dumpGoroutines returns the goroutines but the calling one.
+func dumpGoroutines() []goroutine {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+
+	var list []goroutine
+	// The calling goroutine comes first.
+	for _, block := range strings.Split(string(buf), "\n\n")[1:] {
+		if g, ok := parseGoroutine(block); ok {
+			list = append(list, g)
+		}
+	}
+	return list
+}
+
+// parseGoroutine parses the dump of a goroutine by runtime.Stack:
+//
+//	goroutine 7 [chan receive, 2 minutes]:
+//	main.worker(0xc000012345)
+//		/src/main.go:12 +0x1d
+//	created by main.main in goroutine 1
+//		/src/main.go:5 +0x25
+func parseGoroutine(block string) (goroutine, bool) {
+	lines := strings.Split(strings.TrimSpace(block), "\n")
+	header, ok := strings.CutPrefix(lines[0], "goroutine ")
+	id, state, found := strings.Cut(header, " [")
+	if !ok || !found {
+		return goroutine{}, false
+	}
+	g := goroutine{state: strings.TrimSuffix(state, "]:")}
+	g.id, _ = strconv.Atoi(id)
+	g.state, _, _ = strings.Cut(g.state, ",")
+
+	for i := 1; i < len(lines); i += 2 {
+		f := stackFrame{fn: lines[i]}
+		if i+1 < len(lines) {
+			loc, _, _ := strings.Cut(strings.TrimSpace(lines[i+1]), " +0x")
+			if j := strings.LastIndex(loc, ":"); j > 0 {
+				f.file = loc[:j]
+				f.line, _ = strconv.Atoi(loc[j+1:])
+			}
+		}
+		if creator, ok := strings.CutPrefix(f.fn, "created by "); ok {
+			var parent string
+			f.fn, parent, _ = strings.Cut(creator, " in goroutine ")
+			g.parent, _ = strconv.Atoi(parent)
+			g.createdBy = &f
+			break
+		}
+		if j := strings.LastIndex(f.fn, "("); j > 0 && strings.HasSuffix(f.fn, ")") {
+			f.fn = f.fn[:j]
+		}
+		g.frames = append(g.frames, f)
+	}
+	return g, true
+}
+
+// leakedGoroutines returns how many more goroutines started by the
+// interpreted code of s are running than before, as counted by goSymbols.
+// Goroutines about to return are given the chance to.
+func (s *session) leakedGoroutines(before int64) int {
+	runtime.Gosched()
+	runtime.Gosched()
+
+	return int(max(s.goroutines.Load()-before, 0))
+}
+
+// goroutines reports the goroutines started by interpreted code, across
+// sessions and runs: {count, total}, total counting those of the module as
+// well. With the option stacks, stacks lists all of them grouped by
+// creation site and stack: [{count, ids, state, createdBy, interpreted,
+// frames}], the interpreted groups first.
+func goroutines(this js.Value, args []js.Value) interface{} {
+	list := dumpGoroutines()
+	count := 0
+	for _, g := range list {
+		if g.interpreted() {
+			count++
+		}
+	}
+	res := map[string]interface{}{
+		"success": true,
+		"count":   count,
+		"total":   len(list) + 1,
+	}
+	if opts := optionArg(args, 0); opts.Type() != js.TypeObject || !opts.Get("stacks").Truthy() {
+		return res
+	}
+
+	type group struct {
+		goroutine
+		ids []interface{}
+	}
+	var groups []*group
+	byKey := map[string]*group{}
+	for _, g := range list {
+		key := fmt.Sprint(g.state, g.createdBy, g.frames)
+		gr := byKey[key]
+		if gr == nil {
+			gr = &group{goroutine: g}
+			byKey[key] = gr
+			groups = append(groups, gr)
+		}
+		gr.ids = append(gr.ids, g.id)
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		return groups[i].interpreted() && !groups[j].interpreted()
+	})
+
+	stacks := make([]interface{}, len(groups))
+	for i, gr := range groups {
+		stack := gr.toJS()
+		delete(stack, "id")
+		stack["count"] = len(gr.ids)
+		stack["ids"] = gr.ids
+		stacks[i] = stack
+	}
+	res["stacks"] = stacks
+	return res
+}
+
+// dumpStacks returns the stacks of the goroutines of the module, but the
+// one answering: {success, goroutines: [{id, state, interpreted, frames,
+// createdBy}]}, frames being {func, file, line} innermost first.
+func dumpStacks(this js.Value, args []js.Value) interface{} {
+	return map[string]interface{}{
+		"success":    true,
+		"goroutines": goroutinesToJS(dumpGoroutines()),
+	}
+}
+
+func goroutinesToJS(list []goroutine) []interface{} {
+	out := make([]interface{}, len(list))
+	for i, g := range list {
+		out[i] = g.toJS()
+	}
+	return out
+}
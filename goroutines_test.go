@@ -0,0 +1,111 @@
+package main
+
+import (
+	"syscall/js"
+	"testing"
+)
+
+// spawner is a program starting a goroutine sleeping a few milliseconds
+// every millisecond, for ten seconds or until stopped.
+const spawner = `package main
+
+import "time"
+
+func main() {
+	for i := 0; i < 10000; i++ {
+		go func() { time.Sleep(5 * time.Millisecond) }()
+		time.Sleep(time.Millisecond)
+	}
+}
+`
+
+func TestLeakedGoroutines(t *testing.T) {
+	id := newTestSession(t, nil)
+	run := callAPI(t, "start", spawner, map[string]interface{}{"session": id})
+	mustSucceed(t, run)
+	defer callAPI(t, "stop", run.Get("id"))
+
+	// The goroutines the run starts meanwhile are not those of the eval.
+	mustSucceed(t, callAPI(t, "evalIn", id, `import "time"`))
+	res := callAPI(t, "evalIn", id, `time.Sleep(50 * time.Millisecond)`)
+	mustSucceed(t, res)
+	if n := res.Get("leakedGoroutines"); !n.IsUndefined() {
+		t.Errorf("leakedGoroutines = %s for an eval starting none", jsonString(n))
+	}
+
+	res = callAPI(t, "evalIn", id, `
+for i := 0; i < 2; i++ {
+	go func() {
+		for {
+			time.Sleep(time.Millisecond)
+		}
+	}()
+}
+go func() {}()
+`)
+	mustSucceed(t, res)
+	if n := res.Get("leakedGoroutines"); n.Type() != js.TypeNumber || n.Int() != 2 {
+		t.Errorf("leakedGoroutines = %s, want 2", jsonString(n))
+	}
+}
+
+func TestDeadlockBesideRun(t *testing.T) {
+	t.Cleanup(func() { callAPI(t, "reset") })
+	run := callAPI(t, "start", spawner)
+	mustSucceed(t, run)
+	defer callAPI(t, "stop", run.Get("id"))
+
+	// The goroutines of the run sleep, but only those of the eval count.
+	res := callAPI(t, "eval", `
+ch := make(chan int)
+go func() { ch <- 1 }()
+<-ch
+<-ch
+`)
+	if code := errorCodeOf(res); code != codeDeadlock {
+		t.Errorf("eval = %s, want code %s", jsonString(res), codeDeadlock)
+	}
+}
+
+func TestTimeoutRunningOn(t *testing.T) {
+	t.Cleanup(func() { callAPI(t, "reset") })
+	mustSucceed(t, callAPI(t, "eval", `import "time"`))
+	mustSucceed(t, callAPI(t, "eval", "var ticks int"))
+	timeout := map[string]interface{}{"timeoutMs": 100}
+
+	// A receive stops with the eval.
+	res := callAPI(t, "eval", "ch := make(chan int)\n<-ch", timeout)
+	if code := errorCodeOf(res); code != codeTimeout || res.Get("recovered").Truthy() {
+		t.Fatalf("eval waiting on a channel = %s, want code %s and the interpreter kept", jsonString(res), codeTimeout)
+	}
+	mustSucceed(t, callAPI(t, "eval", "ticks"))
+
+	// In a call of the host, the code outlives the timeout.
+	res = callAPI(t, "eval", "ticks++\ntime.Sleep(time.Hour)", timeout)
+	if code := errorCodeOf(res); code != codeTimeout || !res.Get("recovered").Truthy() {
+		t.Fatalf("eval sleeping = %s, want code %s and the interpreter rebuilt", jsonString(res), codeTimeout)
+	}
+	if res = callAPI(t, "eval", "ticks"); res.Get("success").Bool() {
+		t.Errorf("ticks after the rebuild = %s, want it undefined", jsonString(res))
+	}
+}
+
+func TestGoStatementError(t *testing.T) {
+	t.Cleanup(func() {
+		callAPI(t, "configure", map[string]interface{}{"maxCallDepth": defaultMaxCallDepth})
+		callAPI(t, "reset")
+	})
+	// Without the call depth watch, the guard package is the first name
+	// a package other than main lacks.
+	mustSucceed(t, callAPI(t, "configure", map[string]interface{}{"maxCallDepth": 0}))
+	res := callAPI(t, "eval", "package lib\n\nfunc F() { go func() {}() }\n")
+	if code := errorCodeOf(res); code != codeType {
+		t.Fatalf("eval = %s, want code %s", jsonString(res), codeType)
+	}
+	if got, want := res.Get("error").Get("message").String(), "3:12: undefined: go"; got != want {
+		t.Errorf("error = %q, want %q", got, want)
+	}
+	if got, want := res.Get("error").Get("diagnostics").Index(0).Get("message").String(), "undefined: go"; got != want {
+		t.Errorf("diagnostic = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"syscall/js"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/traefik/yaegi/interp"
+)
+
+// testT stands for testing.T in the code run by yaegi.test, which cannot
+// use the testing package runner. FailNow and SkipNow end the test
+// goroutine like their testing counterparts.
+type testT struct {
+	name    string
+	session *session
+	report  *testReport
+
+	mu       sync.Mutex
+	log      strings.Builder
+	failed   bool
+	skipped  bool
+	cleanups []func()
+}
+
+// testTB is the testing.TB interface of interpreted code, implemented by
+// testT.
+type testTB interface {
+	Cleanup(func())
+	Error(args ...interface{})
+	Errorf(format string, args ...interface{})
+	Fail()
+	FailNow()
+	Failed() bool
+	Fatal(args ...interface{})
+	Fatalf(format string, args ...interface{})
+	Helper()
+	Log(args ...interface{})
+	Logf(format string, args ...interface{})
+	Name() string
+	Setenv(key, value string)
+	Skip(args ...interface{})
+	SkipNow()
+	Skipf(format string, args ...interface{})
+	Skipped() bool
+	TempDir() string
+}
+
+func (t *testT) Log(args ...interface{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.log.WriteString(fmt.Sprintln(args...))
+}
+
+func (t *testT) Logf(format string, args ...interface{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.log.WriteString(fmt.Sprintf(format, args...))
+	if !strings.HasSuffix(format, "\n") {
+		t.log.WriteByte('\n')
+	}
+}
+
+func (t *testT) Fail() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.failed = true
+}
+
+func (t *testT) Failed() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.failed
+}
+
+func (t *testT) FailNow() {
+	t.Fail()
+	runtime.Goexit()
+}
+
+func (t *testT) Error(args ...interface{}) {
+	t.Log(args...)
+	t.Fail()
+}
+
+func (t *testT) Errorf(format string, args ...interface{}) {
+	t.Logf(format, args...)
+	t.Fail()
+}
+
+func (t *testT) Fatal(args ...interface{}) {
+	t.Log(args...)
+	t.FailNow()
+}
+
+func (t *testT) Fatalf(format string, args ...interface{}) {
+	t.Logf(format, args...)
+	t.FailNow()
+}
+
+func (t *testT) SkipNow() {
+	t.mu.Lock()
+	t.skipped = true
+	t.mu.Unlock()
+	runtime.Goexit()
+}
+
+func (t *testT) Skip(args ...interface{}) {
+	t.Log(args...)
+	t.SkipNow()
+}
+
+func (t *testT) Skipf(format string, args ...interface{}) {
+	t.Logf(format, args...)
+	t.SkipNow()
+}
+
+func (t *testT) Skipped() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.skipped
+}
+
+func (t *testT) Name() string { return t.name }
+func (t *testT) Helper()      {}
+func (t *testT) Parallel()    {}
+
+func (t *testT) Deadline() (time.Time, bool) { return time.Time{}, false }
+
+func (t *testT) Cleanup(f func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.cleanups = append(t.cleanups, f)
+}
+
+// Setenv sets an environment variable of the session for the duration of
+// the test.
+func (t *testT) Setenv(key, value string) {
+	old, ok := t.session.lookupEnv(key)
+	t.session.setEnv(key, value)
+	t.Cleanup(func() {
+		if ok {
+			t.session.setEnv(key, old)
+		} else {
+			t.session.unsetEnv(key)
+		}
+	})
+}
+
+// TempDir returns a directory of the session filesystem for the test.
+func (t *testT) TempDir() string {
+	return path.Join("tmp", strings.ReplaceAll(t.name, "/", "_"))
+}
+
+// Run runs f as the subtest name of t and reports whether it passed.
+func (t *testT) Run(name string, f func(*testT)) bool {
+	sub := t.report.run(t.name+"/"+name, t.session, f)
+	if sub.Failed() {
+		t.Fail()
+	}
+	return !sub.Failed()
+}
+
+// testReport collects the results of the tests run by yaegi.test, in the
+// order they start.
+type testReport struct {
+	mu      sync.Mutex
+	results []map[string]interface{}
+}
+
+// run runs f as the test name on its own goroutine, like the testing
+// package, and records its result. A panic fails the test without
+// stopping the others.
+func (r *testReport) run(name string, s *session, f func(*testT)) *testT {
+	t := &testT{name: name, session: s, report: r}
+	result := map[string]interface{}{"name": name}
+	r.mu.Lock()
+	r.results = append(r.results, result)
+	r.mu.Unlock()
+
+	start := time.Now()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer func() {
+			if p := recover(); p != nil {
+				t.Log("panic:", p)
+				t.Fail()
+			}
+		}()
+		defer t.runCleanups()
+		f(t)
+	}()
+	<-done
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	result["passed"] = !t.failed
+	result["skipped"] = t.skipped && !t.failed
+	result["durationMs"] = float64(time.Since(start).Microseconds()) / 1000
+	result["log"] = t.log.String()
+	return t
+}
+
+// runCleanups calls the functions registered with Cleanup, last first.
+func (t *testT) runCleanups() {
+	t.mu.Lock()
+	cleanups := t.cleanups
+	t.cleanups = nil
+	t.mu.Unlock()
+
+	for i := len(cleanups) - 1; i >= 0; i-- {
+		cleanups[i]()
+	}
+}
+
+// testSymbols returns the testing symbols replaced for yaegi.test.
+func testSymbols() interp.Exports {
+	return interp.Exports{"testing/testing": {
+		"T":       reflect.ValueOf((*testT)(nil)),
+		"B":       reflect.ValueOf((*testB)(nil)),
+		"TB":      reflect.ValueOf((*testTB)(nil)),
+		"Short":   reflect.ValueOf(func() bool { return false }),
+		"Verbose": reflect.ValueOf(func() bool { return true }),
+	}}
+}
+
+// isTestFunc reports whether name is that of a test function of the kind
+// named by prefix, Test or Benchmark: the prefix followed by anything but
+// a lowercase letter.
+func isTestFunc(name, prefix string) bool {
+	if !strings.HasPrefix(name, prefix) {
+		return false
+	}
+	r, _ := utf8.DecodeRuneInString(name[len(prefix):])
+	return !unicode.IsLower(r)
+}
+
+// testNames returns the functions of the kind named by prefix declared in
+// the top-level files of the source tree src, in declaration order.
+func testNames(src *memFS, prefix string) []string {
+	dir := path.Join("src", mainPackage)
+	var files []string
+	for _, name := range src.list() {
+		if path.Dir(name) == dir {
+			files = append(files, name)
+		}
+	}
+	sort.Strings(files)
+
+	var names []string
+	fset := token.NewFileSet()
+	for _, name := range files {
+		data, _ := src.readFile(name)
+		f, err := parser.ParseFile(fset, name, data, parser.SkipObjectResolution)
+		if err != nil {
+			continue
+		}
+		for _, decl := range f.Decls {
+			if fn, ok := decl.(*ast.FuncDecl); ok && fn.Recv == nil && isTestFunc(fn.Name.Name, prefix) {
+				names = append(names, fn.Name.Name)
+			}
+		}
+	}
+	return names
+}
+
+// driverPackage is the package through which a driver runs Go code as an
+// eval of the interpreter.
+const driverPackage = "yaegiwasm/driver"
+
+// driver runs the Go code calling into the interpreted functions of a test
+// package as evals of its interpreter, so that they stop like any eval
+// when its context is done.
+type driver struct {
+	i   *interp.Interpreter
+	run func()
+}
+
+// newDriver returns a driver for i, which holds an evaluated package.
+func newDriver(i *interp.Interpreter) (*driver, error) {
+	d := &driver{i: i}
+	i.Use(interp.Exports{driverPackage + "/driver": {
+		"Run": reflect.ValueOf(func() { d.run() }),
+	}})
+	_, err := i.Eval(`import _yaegidriver "` + driverPackage + `"`)
+	return d, err
+}
+
+// call runs f as an eval with ctx. When ctx is done, the interpreted code
+// stops and call returns the context error once f has returned.
+func (d *driver) call(ctx context.Context, f func()) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	d.run = func() {
+		defer close(done)
+		if ctx.Err() == nil {
+			f()
+		}
+	}
+	_, err := d.i.EvalWithContext(ctx, "_yaegidriver.Run()")
+	if err == nil || ctx.Err() != nil {
+		<-done
+	}
+	return err
+}
+
+// testSetup reads the arguments of the command cmd running a test
+// package: a single file, or an object mapping file names to source as for
+// evalFiles, followed by the eval options. It returns the source tree of
+// the package with the files excluded from it, or the result reporting
+// invalid arguments.
+func testSetup(cmd string, args []js.Value) (*memFS, []interface{}, evalOptions, map[string]interface{}) {
+	if len(args) < 1 || len(args) > 2 || (args[0].Type() != js.TypeString && args[0].Type() != js.TypeObject) {
+		return nil, nil, evalOptions{}, map[string]interface{}{
+			"success": false,
+			"error":   cmd + " requires the Go source code or an object mapping file names to source, and an optional options object",
+		}
+	}
+
+	files := args[0]
+	if files.Type() == js.TypeString {
+		files = js.ValueOf(map[string]interface{}{"main_test.go": files})
+	}
+	opts := parseEvalOptions(optionArg(args, 1))
+	if opts.interactive && !opts.async {
+		return nil, nil, opts, map[string]interface{}{
+			"success": false,
+			"error":   "interactiveStdin requires evalAsync or the async option",
+		}
+	}
+
+	src, excluded, err := sourceTree(files, defaultSession().files, opts.buildTags, true)
+	if err != nil {
+		return nil, nil, opts, map[string]interface{}{
+			"success":  false,
+			"error":    cmd + ": " + err.Error(),
+			"excluded": excluded,
+		}
+	}
+	return src, excluded, opts, nil
+}
+
+// loadTests evaluates the test package of the source tree of i, a fresh
+// interpreter, and returns its exported symbols, with a driver to call
+// them.
+func loadTests(i *interp.Interpreter) (map[string]reflect.Value, *driver, error) {
+	i.Use(testSymbols())
+	if err := i.EvalTest(mainPackage); err != nil {
+		return nil, nil, err
+	}
+	d, err := newDriver(i)
+	if err != nil {
+		return nil, nil, err
+	}
+	return i.Symbols(mainPackage)[mainPackage], d, nil
+}
+
+// testResult finishes the result of a test package run: paths are shown
+// relative to the package, and panics are left to the log of their test.
+func testResult(result map[string]interface{}) map[string]interface{} {
+	result = userPaths(result)
+	if stderr, ok := result["stderr"].(string); ok {
+		result["stderr"] = panicFrameLine.ReplaceAllString(stderr, "")
+	}
+	return result
+}
+
+// runTests evaluates a package of test files in a fresh interpreter built
+// from the default session configuration and runs its tests. The source is
+// a single file, or an object mapping file names to source as for
+// evalFiles, followed by the eval options. The result carries the tests in
+// the order they ran, {name, passed, skipped, durationMs, log}, subtests
+// named "Test/sub" like in go test, and its success tells whether they
+// all passed.
+func runTests(this js.Value, args []js.Value) interface{} {
+	src, excluded, opts, invalid := testSetup("test", args)
+	if invalid != nil {
+		return invalid
+	}
+
+	s := defaultSession()
+	report := &testReport{}
+	result := runEvalFunc(s, "", opts, func(ctx context.Context) (reflect.Value, error) {
+		syms, d, err := loadTests(s.interpreterFor(src, opts.buildTags))
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		for _, name := range testNames(src, "Test") {
+			if f, ok := syms[name].Interface().(func(*testT)); ok {
+				if err := d.call(ctx, func() { report.run(name, s, f) }); err != nil {
+					return reflect.Value{}, err
+				}
+			}
+		}
+		return reflect.Value{}, nil
+	})
+	result = testResult(result)
+	result["excluded"] = excluded
+
+	report.mu.Lock()
+	defer report.mu.Unlock()
+
+	tests := make([]interface{}, len(report.results))
+	for i, r := range report.results {
+		tests[i] = r
+		if r["passed"] != true {
+			result["success"] = false
+		}
+	}
+	result["tests"] = tests
+	return result
+}
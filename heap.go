@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// heapSampleInterval is how often heapWatch samples the heap.
+	heapSampleInterval = 250 * time.Millisecond
+	// heapCheckTicks is how many ticks of the instrumented code pass
+	// between two checks of the heap, which stop the world.
+	heapCheckTicks = 1 << 6
+)
+
+// errMemoryLimit fails the evals whose heap grew past maxHeapBytes.
+var errMemoryLimit = errors.New("memory limit exceeded")
+
+// heapWatch cancels an eval once the heap in use exceeds limit bytes. Its
+// goroutine samples the heap, but only gets to run when the code yields,
+// so the ticks of the instrumented code check it as well.
+type heapWatch struct {
+	limit    uint64
+	start    uint64             // heap in use when the eval started
+	cancel   context.CancelFunc // cancels the eval
+	ticks    atomic.Int64
+	exceeded atomic.Bool
+	observed atomic.Uint64 // heap in use when exceeded
+	done     chan struct{}
+}
+
+// watchHeap starts watching the heap for an eval cancelled by cancel.
+func watchHeap(limit int, cancel context.CancelFunc) *heapWatch {
+	w := &heapWatch{limit: uint64(limit), cancel: cancel, done: make(chan struct{})}
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	if m.HeapAlloc > w.limit {
+		// As for check, HeapAlloc counts the garbage.
+		runtime.GC()
+		runtime.ReadMemStats(&m)
+	}
+	w.start = m.HeapAlloc
+	go func() {
+		t := time.NewTicker(heapSampleInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				w.check()
+			case <-w.done:
+				return
+			}
+		}
+	}()
+	return w
+}
+
+// check cancels the eval if the heap in use exceeds the limit, and reports
+// whether it did.
+func (w *heapWatch) check() bool {
+	if w.exceeded.Load() {
+		return true
+	}
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	if m.HeapAlloc <= w.limit {
+		return false
+	}
+	// HeapAlloc counts the garbage not yet collected.
+	runtime.GC()
+	runtime.ReadMemStats(&m)
+	if m.HeapAlloc <= w.limit {
+		return false
+	}
+	w.observed.Store(m.HeapAlloc)
+	w.exceeded.Store(true)
+	w.cancel()
+	return true
+}
+
+// tick checks the heap every heapCheckTicks calls, yielding once it is
+// exceeded so that the cancellation stops the code, as stepBudget.tick.
+func (w *heapWatch) tick() {
+	if w.ticks.Add(1)%heapCheckTicks == 0 && w.check() {
+		runtime.Gosched()
+	}
+}
+
+func (w *heapWatch) stop() {
+	close(w.done)
+}
+
+// retained reports whether the heap still exceeds the limit after a
+// collection because of the memory the eval left the interpreter holding.
+// HeapAlloc counts the whole module, so a heap already past the limit when
+// the eval started is held by other sessions or runs instead.
+func (w *heapWatch) retained() bool {
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.HeapAlloc > w.limit && w.start <= w.limit
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"syscall/js"
+)
+
+// sessionStateVersion is the format version of exported sessions.
+const sessionStateVersion = 1
+
+// sessionState is a session exported by exportSession. Interpreter state
+// cannot be serialized, so it is rebuilt by replaying the history.
+type sessionState struct {
+	Version int               `json:"version"`
+	History []historyEntry    `json:"history"`
+	Env     map[string]string `json:"env"`
+	Files   map[string][]byte `json:"files"`
+}
+
+// historyEntry is a successful eval of a session.
+type historyEntry struct {
+	Source     string `json:"source"`
+	Snippet    bool   `json:"snippet,omitempty"`
+	AutoImport bool   `json:"autoImport,omitempty"`
+}
+
+// record appends a successful eval to the history of s.
+func (s *session) record(e historyEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.history = append(s.history, e)
+}
+
+// state returns the history, environment and files of s.
+func (s *session) state() sessionState {
+	st := sessionState{
+		Version: sessionStateVersion,
+		Files:   map[string][]byte{},
+	}
+	for _, name := range s.files.list() {
+		if data, ok := s.files.readFile(name); ok {
+			st.Files[name] = data
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st.History = append([]historyEntry{}, s.history...)
+	st.Env = make(map[string]string, len(s.env))
+	for key, value := range s.env {
+		st.Env[key] = value
+	}
+	return st
+}
+
+// exportSession returns the default session as a JSON string: its eval
+// history, environment and files, for importSession to restore.
+func exportSession(this js.Value, args []js.Value) interface{} {
+	b, err := json.Marshal(defaultSession().state())
+	if err != nil {
+		return map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+		}
+	}
+	return map[string]interface{}{
+		"success": true,
+		"state":   string(b),
+	}
+}
+
+// importSession resets the default session to the state exported as the
+// JSON string given as first argument: it restores the environment and
+// files, then replays the history. Replay stops at the first failing
+// entry unless the options set skipFailures, and the output of replayed
+// evals is left out unless they set captureOutput. The result lists the
+// outcome of each entry run.
+func importSession(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 || len(args) > 2 || args[0].Type() != js.TypeString {
+		return map[string]interface{}{
+			"success": false,
+			"error":   "importSession requires an exported session and an optional options object",
+		}
+	}
+	var st sessionState
+	if err := json.Unmarshal([]byte(args[0].String()), &st); err != nil {
+		return map[string]interface{}{
+			"success": false,
+			"error":   "importSession: " + err.Error(),
+		}
+	}
+	if st.Version != sessionStateVersion {
+		return map[string]interface{}{
+			"success": false,
+			"error":   fmt.Sprintf("importSession: unsupported version %d", st.Version),
+		}
+	}
+	opts := optionArg(args, 1)
+	skip := opts.Type() == js.TypeObject && opts.Get("skipFailures").Truthy()
+	capture := opts.Type() == js.TypeObject && opts.Get("captureOutput").Truthy()
+
+	s := defaultSession()
+	if err := s.acquireEval(context.Background(), false); err != nil {
+		return map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+			"busy":    true,
+		}
+	}
+	s.files.clear()
+	s.restorePackages()
+	for name, data := range st.Files {
+		s.files.writeFile(name, data)
+	}
+	bound := s.takeBindings()
+	s.reset()
+	s.rebind(bound)
+	s.mu.Lock()
+	s.env = st.Env
+	if s.env == nil {
+		s.env = map[string]string{}
+	}
+	s.mu.Unlock()
+	s.releaseEval()
+
+	success := true
+	entries := []interface{}{}
+	for _, e := range st.History {
+		eo := evalOptions{captureOutput: capture, snippet: e.Snippet, autoImport: e.AutoImport}
+		eo.maxOutput, eo.abortOnOutputLimit = outputLimits()
+		res := runEval(s, e.Source, eo)
+		entry := map[string]interface{}{
+			"success": res["success"],
+			"error":   res["error"],
+		}
+		if capture {
+			entry["output"] = res["output"]
+			entry["stderr"] = res["stderr"]
+		}
+		entries = append(entries, entry)
+		if res["success"] != true {
+			success = false
+			if !skip {
+				break
+			}
+		}
+	}
+	return map[string]interface{}{
+		"success": success,
+		"entries": entries,
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"syscall/js"
+
+	"github.com/traefik/yaegi/interp"
+)
+
+// hostioPackage lets interpreted code write to the sinks of createWriter
+// and read the streams of createReader.
+const hostioPackage = "hostio"
+
+// hostStream is a writer or a reader of a session, by id. A writer hands
+// what interpreted code writes to sink; a reader gives it what the host
+// pushes to pipe.
+type hostStream struct {
+	sink js.Value   // the callback of a writer
+	pipe *stdinPipe // the data of a reader
+}
+
+// hostWriter is the io.Writer of hostio.Writer.
+type hostWriter struct {
+	s  *session
+	id int
+}
+
+// Write hands a copy of b to the callback of the writer as a Uint8Array.
+// Once the writer is released, or if it never existed, it fails instead.
+func (w hostWriter) Write(b []byte) (n int, err error) {
+	w.s.mu.Lock()
+	st := w.s.hostIO[w.id]
+	w.s.mu.Unlock()
+	if st == nil || st.sink.IsUndefined() {
+		return 0, fmt.Errorf("hostio: no writer %d", w.id)
+	}
+	defer func() {
+		// A throwing callback fails the write.
+		if r := recover(); r != nil {
+			n, err = 0, fmt.Errorf("hostio: writer %d: %v", w.id, r)
+		}
+	}()
+	chunk := js.Global().Get("Uint8Array").New(len(b))
+	js.CopyBytesToJS(chunk, b)
+	st.sink.Invoke(chunk)
+	return len(b), nil
+}
+
+// hostioSymbols returns the package hostio of s: Writer(id) and Reader(id)
+// return the writer and the reader of s with that id. A read blocks until
+// the host pushes data, and reports io.EOF once the reader is closed and
+// drained, released or the eval is done.
+func (s *session) hostioSymbols() interp.Exports {
+	return interp.Exports{hostioPackage + "/" + hostioPackage: {
+		"Writer": reflect.ValueOf(func(id int) io.Writer {
+			return hostWriter{s: s, id: id}
+		}),
+		"Reader": reflect.ValueOf(func(id int) io.Reader {
+			s.mu.Lock()
+			st := s.hostIO[id]
+			s.mu.Unlock()
+			if st == nil || st.pipe == nil {
+				return errReader{fmt.Errorf("hostio: no reader %d", id)}
+			}
+			return st.pipe.reader(s.evalContext())
+		}),
+	}}
+}
+
+// errReader is a reader failing with err.
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
+
+// addHostStream registers st with s and returns its id.
+func (s *session) addHostStream(st *hostStream) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.hostIO == nil {
+		s.hostIO = map[int]*hostStream{}
+	}
+	s.nextHostIO++
+	s.hostIO[s.nextHostIO] = st
+	return s.nextHostIO
+}
+
+// hostStreamArg returns the stream of id of s created as a reader or not,
+// or the result reporting it missing.
+func (s *session) hostStreamArg(id int, reader bool) (*hostStream, map[string]interface{}) {
+	s.mu.Lock()
+	st := s.hostIO[id]
+	s.mu.Unlock()
+	if st == nil || (st.pipe != nil) != reader {
+		kind := "writer"
+		if reader {
+			kind = "reader"
+		}
+		return nil, map[string]interface{}{
+			"success": false,
+			"error":   fmt.Sprintf("%s %d not found", kind, id),
+		}
+	}
+	return st, nil
+}
+
+// releaseHostIO drops the writers and the readers of s, closing the
+// readers, and returns their count.
+func (s *session) releaseHostIO() int {
+	s.mu.Lock()
+	streams := s.hostIO
+	s.hostIO = nil
+	s.mu.Unlock()
+
+	for _, st := range streams {
+		if st.pipe != nil {
+			st.pipe.close()
+		}
+	}
+	return len(streams)
+}
+
+// streamSession returns the default session, or the session of the option
+// session of opts, or the result reporting it missing.
+func streamSession(opts js.Value) (*session, map[string]interface{}) {
+	s := defaultSession()
+	if opts.Type() == js.TypeObject && opts.Get("session").Type() == js.TypeNumber {
+		s = lookupSession(opts.Get("session").Int())
+	}
+	if s == nil {
+		return nil, map[string]interface{}{
+			"success": false,
+			"error":   "session not found",
+		}
+	}
+	return s, nil
+}
+
+// createWriter registers the function given as argument as a writer of
+// the default session, or of the session of the option session, and
+// returns {success, id}: interpreted code writes to it through
+// hostio.Writer(id), each write reaching the function as a Uint8Array.
+// The writer lasts until released by releaseWriter or a reset, writes
+// failing past that.
+func createWriter(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 || args[0].Type() != js.TypeFunction {
+		return map[string]interface{}{
+			"success":   false,
+			"error":     "createWriter requires a function",
+			"errorCode": codeBadRequest,
+		}
+	}
+	s, res := streamSession(optionArg(args, 1))
+	if res != nil {
+		return res
+	}
+	return map[string]interface{}{
+		"success": true,
+		"id":      s.addHostStream(&hostStream{sink: args[0]}),
+	}
+}
+
+// releaseWriter releases the writer whose id is given as argument.
+func releaseWriter(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 || args[0].Type() != js.TypeNumber {
+		return map[string]interface{}{
+			"success":   false,
+			"error":     "releaseWriter requires a writer id",
+			"errorCode": codeBadRequest,
+		}
+	}
+	s, res := streamSession(optionArg(args, 1))
+	if res != nil {
+		return res
+	}
+	if _, res := s.hostStreamArg(args[0].Int(), false); res != nil {
+		return res
+	}
+	s.mu.Lock()
+	delete(s.hostIO, args[0].Int())
+	s.mu.Unlock()
+	return map[string]interface{}{"success": true}
+}
+
+// createReader creates a reader of the default session, or of the session
+// of the option session, and returns {success, id}: interpreted code reads
+// through hostio.Reader(id) what pushToReader feeds it, until closeReader
+// or a reset.
+func createReader(this js.Value, args []js.Value) interface{} {
+	s, res := streamSession(optionArg(args, 0))
+	if res != nil {
+		return res
+	}
+	return map[string]interface{}{
+		"success": true,
+		"id":      s.addHostStream(&hostStream{pipe: newStdinPipe()}),
+	}
+}
+
+// pushToReader appends its second argument, a string or a Uint8Array, to
+// the reader whose id is given first.
+func pushToReader(this js.Value, args []js.Value) interface{} {
+	var data []byte
+	ok := len(args) >= 2 && args[0].Type() == js.TypeNumber
+	if ok {
+		data, ok = fileData(args[1])
+	}
+	if !ok {
+		return map[string]interface{}{
+			"success":   false,
+			"error":     "pushToReader requires a reader id and a string or a Uint8Array",
+			"errorCode": codeBadRequest,
+		}
+	}
+	s, res := streamSession(optionArg(args, 2))
+	if res != nil {
+		return res
+	}
+	st, res := s.hostStreamArg(args[0].Int(), true)
+	if res != nil {
+		return res
+	}
+	if st.pipe.isClosed() {
+		return map[string]interface{}{
+			"success": false,
+			"error":   fmt.Sprintf("reader %d is closed", args[0].Int()),
+		}
+	}
+	st.pipe.write(data)
+	return map[string]interface{}{"success": true}
+}
+
+// closeReader closes the reader whose id is given as argument: once what
+// was pushed is read, reads report io.EOF.
+func closeReader(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 || args[0].Type() != js.TypeNumber {
+		return map[string]interface{}{
+			"success":   false,
+			"error":     "closeReader requires a reader id",
+			"errorCode": codeBadRequest,
+		}
+	}
+	s, res := streamSession(optionArg(args, 1))
+	if res != nil {
+		return res
+	}
+	st, res := s.hostStreamArg(args[0].Int(), true)
+	if res != nil {
+		return res
+	}
+	st.pipe.close()
+	return map[string]interface{}{"success": true}
+}
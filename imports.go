@@ -0,0 +1,418 @@
+package main
+
+import (
+	"context"
+	"go/ast"
+	"go/token"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall/js"
+
+	"github.com/traefik/yaegi/interp"
+)
+
+// preferredImports resolves package names provided by several registered
+// packages when the selectors used do not tell them apart.
+var preferredImports = map[string]string{
+	"rand":     "math/rand",
+	"template": "text/template",
+	"scanner":  "text/scanner",
+	"pprof":    "runtime/pprof",
+}
+
+// importChange is an import added to or removed from a source.
+type importChange struct {
+	name         string
+	path         string
+	alternatives []string // other packages providing name, for additions
+}
+
+func (c importChange) toJS(action string) map[string]interface{} {
+	m := map[string]interface{}{
+		"action": action,
+		"name":   c.name,
+		"path":   c.path,
+	}
+	if c.alternatives != nil {
+		m["alternatives"] = stringsToJS(c.alternatives)
+	}
+	return m
+}
+
+// importFix lists the import changes a parsed source needs.
+type importFix struct {
+	*fragment
+	add    []importChange
+	unused []importChange
+	edits  []edit // removing the unused imports
+}
+
+// fixImports adds the imports of registered packages a source uses but
+// does not import, and removes those it imports but does not use. Names
+// defined in the default session, including the packages it imported, are
+// left alone. It returns {success, source, changes}.
+func fixImports(this js.Value, args []js.Value) interface{} {
+	if len(args) != 1 || args[0].Type() != js.TypeString {
+		return map[string]interface{}{
+			"success": false,
+			"error":   "fixImports requires the Go source code",
+		}
+	}
+	src := args[0].String()
+
+	s := defaultSession()
+	if err := s.acquireEval(context.Background(), false); err != nil {
+		return map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+			"busy":    true,
+		}
+	}
+	defer s.releaseEval()
+
+	fix, err := s.importFixes(src)
+	if err != nil {
+		return formatError(err, src, fix.fragment)
+	}
+
+	changes := []interface{}{}
+	for _, c := range fix.unused {
+		changes = append(changes, c.toJS("remove"))
+	}
+	for _, c := range fix.add {
+		changes = append(changes, c.toJS("add"))
+	}
+	if len(changes) > 0 {
+		src = fix.apply(src)
+	}
+	return map[string]interface{}{
+		"success": true,
+		"source":  src,
+		"changes": changes,
+	}
+}
+
+// importFixes parses src like format.Source and lists the import changes
+// it needs in session s. The returned fix is non-nil even on error.
+func (s *session) importFixes(src string) (*importFix, error) {
+	f, err := parseFragment(src)
+	fix := &importFix{fragment: f}
+	if err != nil {
+		return fix, err
+	}
+	candidates := s.importCandidates()
+
+	// Identifiers not declared in the source, used as package qualifiers.
+	used := map[string]map[string]bool{}
+	ast.Inspect(fix.file, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if id, ok := sel.X.(*ast.Ident); ok && id.Obj == nil {
+				if used[id.Name] == nil {
+					used[id.Name] = map[string]bool{}
+				}
+				used[id.Name][sel.Sel.Name] = true
+			}
+		}
+		return true
+	})
+
+	imported := map[string]bool{}
+	for _, decl := range fix.file.Decls {
+		d, ok := decl.(*ast.GenDecl)
+		if !ok || d.Tok != token.IMPORT {
+			continue
+		}
+		var unused []ast.Spec
+		for _, spec := range d.Specs {
+			spec := spec.(*ast.ImportSpec)
+			p, _ := strconv.Unquote(spec.Path.Value)
+			name := packageName(p, candidates)
+			if spec.Name != nil {
+				name = spec.Name.Name
+			}
+			imported[name] = true
+			if name == "_" || name == "." || used[name] != nil {
+				continue
+			}
+			unused = append(unused, spec)
+			fix.unused = append(fix.unused, importChange{name: name, path: p})
+		}
+		var removed []edit
+		switch {
+		case len(unused) == 0:
+		case len(unused) == len(d.Specs):
+			removed = append(removed, fix.remove(d.Pos(), d.End()))
+		default:
+			for _, spec := range unused {
+				removed = append(removed, fix.remove(spec.Pos(), spec.End()))
+			}
+		}
+		for _, e := range removed {
+			// Remove the line break too.
+			if e.end < len(src) && src[e.end] == '\n' {
+				e.end++
+			}
+			fix.edits = append(fix.edits, e)
+		}
+	}
+
+	names := make([]string, 0, len(used))
+	for name := range used {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if imported[name] || s.defined(name) {
+			continue
+		}
+		if c, ok := chooseImport(name, used[name], candidates[name]); ok {
+			fix.add = append(fix.add, c)
+		}
+	}
+	return fix, nil
+}
+
+// apply returns src with the unused imports removed and the missing ones
+// added, then formatted. Imports are added to the first parenthesized
+// import declaration left, or else in a new one following the imports left,
+// the package clause, or first in a fragment.
+func (f *importFix) apply(src string) string {
+	edits := f.edits
+	if len(f.add) > 0 {
+		var specs []string
+		for _, c := range f.add {
+			specs = append(specs, strconv.Quote(c.path))
+		}
+		edits = append(edits, f.insertImports(specs))
+	}
+	src = applyEdits(src, edits)
+	if f.head > 0 {
+		// Removed imports leave blank lines before a fragment.
+		src = strings.TrimLeft(src, "\n")
+	}
+
+	if out, err := gofmt(src); err == nil {
+		return out
+	}
+	return src
+}
+
+// insertImports returns the edit adding import specs.
+func (f *importFix) insertImports(specs []string) edit {
+	var last *ast.GenDecl
+	for _, decl := range f.file.Decls {
+		d, ok := decl.(*ast.GenDecl)
+		if !ok || d.Tok != token.IMPORT || f.removed(d) {
+			continue
+		}
+		if d.Lparen.IsValid() {
+			at := f.offset(d.Rparen)
+			return edit{start: at, end: at, text: "\t" + strings.Join(specs, "\n\t") + "\n"}
+		}
+		last = d
+	}
+
+	decl := "import " + specs[0]
+	if len(specs) > 1 {
+		decl = "import (\n\t" + strings.Join(specs, "\n\t") + "\n)"
+	}
+	switch {
+	case last != nil:
+		at := f.offset(last.End())
+		return edit{start: at, end: at, text: "\n" + decl}
+	case f.head == 0:
+		at := f.offset(f.file.Name.End())
+		return edit{start: at, end: at, text: "\n\n" + decl}
+	}
+	return edit{text: decl + "\n\n"}
+}
+
+// removed reports whether the whole declaration d is removed.
+func (f *importFix) removed(d *ast.GenDecl) bool {
+	start := f.offset(d.Pos())
+	for _, e := range f.edits {
+		if e.start == start && e.end >= f.offset(d.End()) {
+			return true
+		}
+	}
+	return false
+}
+
+// importCandidate is a registered package that can be imported.
+type importCandidate struct {
+	path    string
+	symbols map[string]reflect.Value // nil for source packages
+}
+
+// importCandidates returns the packages sessions using s can import, by
+// package name.
+func (s *session) importCandidates() map[string][]importCandidate {
+	byPath := map[string]*importCandidate{}
+	names := map[string]string{}
+	for _, set := range s.config.symbols() {
+		for key, values := range set {
+			// Keys are of the form "import/path/name".
+			p := path.Dir(key)
+			if p == "." {
+				continue
+			}
+			c := byPath[p]
+			if c == nil {
+				c = &importCandidate{path: p, symbols: map[string]reflect.Value{}}
+				byPath[p] = c
+				names[p] = path.Base(key)
+			}
+			for name, v := range values {
+				c.symbols[name] = v
+			}
+		}
+	}
+	s.mu.Lock()
+	for p := range s.packages {
+		if byPath[p] == nil {
+			byPath[p] = &importCandidate{path: p}
+			names[p] = path.Base(p)
+		}
+	}
+	s.mu.Unlock()
+
+	candidates := map[string][]importCandidate{}
+	for p, c := range byPath {
+		candidates[names[p]] = append(candidates[names[p]], *c)
+	}
+	return candidates
+}
+
+// packageName returns the name of the package imported as p.
+func packageName(p string, candidates map[string][]importCandidate) string {
+	for name, cs := range candidates {
+		for _, c := range cs {
+			if c.path == p {
+				return name
+			}
+		}
+	}
+	return path.Base(p)
+}
+
+// chooseImport picks the package to import for name among the candidates
+// exporting all the selectors used, preferring preferredImports and then
+// the shortest path.
+func chooseImport(name string, selectors map[string]bool, candidates []importCandidate) (importChange, bool) {
+	var paths []string
+	for _, c := range candidates {
+		ok := true
+		for sel := range selectors {
+			if _, found := c.symbols[sel]; c.symbols != nil && !found {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			paths = append(paths, c.path)
+		}
+	}
+	if len(paths) == 0 {
+		return importChange{}, false
+	}
+
+	sort.Slice(paths, func(i, j int) bool {
+		pi, pj := paths[i] == preferredImports[name], paths[j] == preferredImports[name]
+		if pi != pj {
+			return pi
+		}
+		if len(paths[i]) != len(paths[j]) {
+			return len(paths[i]) < len(paths[j])
+		}
+		return paths[i] < paths[j]
+	})
+	c := importChange{name: name, path: paths[0]}
+	if len(paths) > 1 {
+		c.alternatives = paths[1:]
+	}
+	return c, true
+}
+
+// evalImporting evaluates src in s, its positions in the file name if
+// any, see namedSource. With autoImport, the registered
+// packages src uses are imported first: after its package clause for a
+// file, the text inserted being recorded in moved, or by a separate
+// evaluation for a fragment. Unused imports are left, as yaegi accepts
+// them.
+func (s *session) evalImporting(ctx context.Context, src, name string, autoImport bool, moved insertMap) (reflect.Value, error) {
+	clear(moved)
+	if !autoImport {
+		return s.interpreter.EvalWithContext(ctx, namedSource(src, name))
+	}
+
+	// Parse errors are reported by the evaluation itself.
+	fix, err := s.importFixes(src)
+	if err != nil || len(fix.add) == 0 {
+		return s.interpreter.EvalWithContext(ctx, namedSource(src, name))
+	}
+	var imports []string
+	s.mu.Lock()
+	for _, c := range fix.add {
+		imports = append(imports, "import "+strconv.Quote(c.path))
+		if s.autoImports == nil {
+			s.autoImports = map[string]bool{}
+		}
+		s.autoImports[c.path] = true
+	}
+	s.mu.Unlock()
+	if fix.head == 0 {
+		at := fix.offset(fix.file.Name.End())
+		inserted := "; " + strings.Join(imports, "; ")
+		if moved != nil {
+			line := strings.Count(src[:at], "\n") + 1
+			moved[line] = [][2]int{{at - strings.LastIndex(src[:at], "\n"), len(inserted)}}
+		}
+		return s.interpreter.EvalWithContext(ctx, namedSource(src[:at]+inserted+src[at:], name))
+	}
+	if _, err := s.interpreter.EvalWithContext(ctx, namedSource(strings.Join(imports, "\n"), name)); err != nil {
+		return reflect.Value{}, err
+	}
+	return s.interpreter.EvalWithContext(ctx, namedSource(src, name))
+}
+
+// importOnce imports path as alias in the interpreter of s, in the file
+// name if any, see namedSource, unless it was already: as recorded by done
+// for the evaluated source, or in namedImports for a named file, imports
+// being by file.
+func (s *session) importOnce(done **interp.Interpreter, alias, path, name string) error {
+	key := alias + " " + name
+	imported := *done
+	if name != "" {
+		imported = s.namedImports[key]
+	}
+	if imported == s.interpreter {
+		return nil
+	}
+	if _, err := s.interpreter.Eval(namedSource("import "+alias+" "+strconv.Quote(path), name)); err != nil {
+		return err
+	}
+	if name == "" {
+		*done = s.interpreter
+		return nil
+	}
+	if s.namedImports == nil {
+		s.namedImports = map[string]*interp.Interpreter{}
+	}
+	s.namedImports[key] = s.interpreter
+	return nil
+}
+
+// defined reports whether name is declared in the scope of the session
+// interpreter, as a value or as an imported package.
+func (s *session) defined(name string) (ok bool) {
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+	_, err := s.interpreter.Eval(name)
+	return err == nil
+}
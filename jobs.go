@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"syscall/js"
+)
+
+// job is an eval submitted with submit. Its result is kept until it is
+// fetched with jobStatus or awaitJob, or evicted past maxJobResults.
+type job struct {
+	id      int
+	session int // id of the session it runs in
+	src     string
+	opts    evalOptions
+	cancel  context.CancelFunc
+	done    chan struct{} // closed once result is set
+
+	// Guarded by jobsMu.
+	state    string // "queued", "running" or "done"
+	result   map[string]interface{}
+	finished int // order of completion, for eviction
+}
+
+// Submitted jobs by id, and the queue of each session.
+var (
+	jobsMu       sync.Mutex
+	jobs         = map[int]*job{}
+	jobQueues    = map[*session][]*job{}
+	nextJobID    int
+	jobsFinished int
+)
+
+// submit queues the eval of the Go source given as first argument, and
+// returns {success, id} at once. The jobs of a session run one after the
+// other in submission order, each with an output capture of its own. The
+// options are those of eval, with session to run in a session other than
+// the default one.
+func submit(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 || len(args) > 2 || args[0].Type() != js.TypeString {
+		return map[string]interface{}{
+			"success": false,
+			"error":   "submit requires the Go source code and an optional options object",
+		}
+	}
+	o := optionArg(args, 1)
+	s := defaultSession()
+	if o.Type() == js.TypeObject && o.Get("session").Type() == js.TypeNumber {
+		if s = lookupSession(o.Get("session").Int()); s == nil {
+			return map[string]interface{}{
+				"success": false,
+				"error":   "session not found",
+			}
+		}
+	}
+
+	j := &job{session: s.id, src: args[0].String(), opts: parseEvalOptions(o), state: "queued", done: make(chan struct{})}
+	// Jobs wait for their turn, off the JS callbacks.
+	j.opts.async = true
+	j.opts.queue = true
+	j.opts.parent, j.cancel = context.WithCancel(context.Background())
+
+	jobsMu.Lock()
+	active := 0
+	for _, queued := range jobQueues[s] {
+		if queued.state != "done" {
+			active++
+		}
+	}
+	if res := s.admitJob(active); res != nil {
+		jobsMu.Unlock()
+		return res
+	}
+	nextJobID++
+	j.id = nextJobID
+	jobs[j.id] = j
+	jobQueues[s] = append(jobQueues[s], j)
+	first := len(jobQueues[s]) == 1
+	emitEvent(eventJobQueued, s.id, j.id, map[string]interface{}{
+		"source":   firstLine(j.src),
+		"position": len(jobQueues[s]) - 1,
+	})
+	jobsMu.Unlock()
+
+	if first {
+		go runJobs(s)
+	}
+	return map[string]interface{}{
+		"success": true,
+		"id":      j.id,
+	}
+}
+
+// runJobs runs the queue of s until it is empty.
+func runJobs(s *session) {
+	for {
+		jobsMu.Lock()
+		queue := jobQueues[s]
+		if len(queue) == 0 {
+			delete(jobQueues, s)
+			jobsMu.Unlock()
+			return
+		}
+		j := queue[0]
+		if j.state == "done" {
+			// Cancelled while queued.
+			jobQueues[s] = queue[1:]
+			jobsMu.Unlock()
+			continue
+		}
+		j.state = "running"
+		emitEvent(eventJobStarted, s.id, j.id, nil)
+		jobsMu.Unlock()
+
+		res := runEval(s, j.src, j.opts)
+		j.cancel()
+
+		jobsMu.Lock()
+		jobQueues[s] = jobQueues[s][1:]
+		j.finish(res)
+		jobsMu.Unlock()
+	}
+}
+
+// finish records the result of j and evicts the oldest results past
+// maxJobResults. jobsMu is held.
+func (j *job) finish(res map[string]interface{}) {
+	res["id"] = j.id
+	j.state = "done"
+	j.result = res
+	jobsFinished++
+	j.finished = jobsFinished
+	close(j.done)
+	emitEvent(eventJobFinished, j.session, j.id, map[string]interface{}{
+		"success":   res["success"] == true,
+		"errorCode": res["errorCode"],
+	})
+
+	limit := maxJobResults()
+	for limit > 0 {
+		var oldest *job
+		n := 0
+		for _, other := range jobs {
+			if other.state == "done" {
+				n++
+				if oldest == nil || other.finished < oldest.finished {
+					oldest = other
+				}
+			}
+		}
+		if n <= limit {
+			break
+		}
+		delete(jobs, oldest.id)
+	}
+}
+
+// take returns the result of j, which is then dropped, or nil if j is
+// not done.
+func (j *job) take() map[string]interface{} {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+
+	if j.state != "done" {
+		return nil
+	}
+	delete(jobs, j.id)
+	return j.result
+}
+
+func lookupJob(id int) *job {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+
+	return jobs[id]
+}
+
+// jobArg returns the job whose id is args[0], or the error result of the
+// command name.
+func jobArg(name string, args []js.Value) (*job, map[string]interface{}) {
+	if len(args) != 1 || args[0].Type() != js.TypeNumber {
+		return nil, map[string]interface{}{
+			"success": false,
+			"error":   name + " requires a job id",
+		}
+	}
+	j := lookupJob(args[0].Int())
+	if j == nil {
+		return nil, map[string]interface{}{
+			"success": false,
+			"error":   "job not found",
+		}
+	}
+	return j, nil
+}
+
+// jobStatus returns {success, id, state} for the job whose id is given as
+// argument, state being "queued", "running" or "done". Done jobs come with
+// their result, which is no longer kept.
+func jobStatus(this js.Value, args []js.Value) interface{} {
+	j, errRes := jobArg("jobStatus", args)
+	if j == nil {
+		return errRes
+	}
+	jobsMu.Lock()
+	state := j.state
+	jobsMu.Unlock()
+
+	res := map[string]interface{}{
+		"success": true,
+		"id":      j.id,
+		"state":   state,
+	}
+	if state == "done" {
+		res["result"] = j.take()
+	}
+	return res
+}
+
+// awaitJob returns a Promise resolved with the result of the job whose id
+// is given as argument once it is done, or rejected if the job failed or
+// there is no such job. The result is then no longer kept.
+func awaitJob(this js.Value, args []js.Value) interface{} {
+	j, errRes := jobArg("awaitJob", args)
+	if j == nil {
+		return rejectedPromise(resultError(errRes))
+	}
+	return newPromise(func(resolve, reject func(interface{})) {
+		<-j.done
+		res := j.take()
+		if res == nil {
+			reject(resultError(map[string]interface{}{"success": false, "error": "job not found"}))
+			return
+		}
+		settle(res, resolve, reject)
+	})
+}
+
+// cancelJob cancels the job whose id is given as argument: a queued job
+// is done at once with a cancelled result, and a running one is
+// interrupted.
+func cancelJob(this js.Value, args []js.Value) interface{} {
+	j, errRes := jobArg("cancelJob", args)
+	if j == nil {
+		return errRes
+	}
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+
+	switch j.state {
+	case "done":
+		return map[string]interface{}{
+			"success": false,
+			"error":   "job already done",
+		}
+	case "queued":
+		j.finish(cancelledResult("", ""))
+	}
+	j.cancel()
+	return map[string]interface{}{"success": true}
+}
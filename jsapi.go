@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"syscall/js"
+
+	"github.com/traefik/yaegi/interp"
+)
+
+// jsSymbols exposes syscall/js to interpreted code. yaegi does not extract
+// it, as it only builds for js/wasm. FuncOf is registered per session, see
+// funcOf.
+var jsSymbols = interp.Exports{
+	"syscall/js/js": {
+		// function, constant and variable definitions
+		"CopyBytesToGo": reflect.ValueOf(js.CopyBytesToGo),
+		"CopyBytesToJS": reflect.ValueOf(js.CopyBytesToJS),
+		"Global":        reflect.ValueOf(js.Global),
+		"Null":          reflect.ValueOf(js.Null),
+		"TypeBoolean":   reflect.ValueOf(js.TypeBoolean),
+		"TypeFunction":  reflect.ValueOf(js.TypeFunction),
+		"TypeNull":      reflect.ValueOf(js.TypeNull),
+		"TypeNumber":    reflect.ValueOf(js.TypeNumber),
+		"TypeObject":    reflect.ValueOf(js.TypeObject),
+		"TypeString":    reflect.ValueOf(js.TypeString),
+		"TypeSymbol":    reflect.ValueOf(js.TypeSymbol),
+		"TypeUndefined": reflect.ValueOf(js.TypeUndefined),
+		"Undefined":     reflect.ValueOf(js.Undefined),
+		"ValueOf":       reflect.ValueOf(js.ValueOf),
+
+		// type definitions
+		"Error":      reflect.ValueOf((*js.Error)(nil)),
+		"Func":       reflect.ValueOf((*js.Func)(nil)),
+		"Type":       reflect.ValueOf((*js.Type)(nil)),
+		"Value":      reflect.ValueOf((*js.Value)(nil)),
+		"ValueError": reflect.ValueOf((*js.ValueError)(nil)),
+	},
+}
+
+// sessionFunc is a function created by funcOf: the js.Func, and the
+// handler of the Proxy of it given to interpreted code.
+type sessionFunc struct {
+	fn      js.Func
+	handler js.Value
+}
+
+// releasedCall is the apply trap of the Proxy of a released sessionFunc,
+// which JS may still call, from an event listener left behind. Its this is
+// the handler of the Proxy.
+var releasedCall = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+	js.Global().Get("console").Call("warn", fmt.Sprintf("yaegi: ignored call to a js.FuncOf callback released by session %d", this.Get("session").Int()))
+	return nil
+})
+
+// funcOf stands for js.FuncOf in interpreted code. The returned function is
+// released when the session is reset or destroyed, or by releaseCallbacks,
+// and a panic in fn is reported on the console instead of crashing the
+// module. Its JS value is a Proxy, which once released warns on the
+// console instead of calling into the freed interpreter.
+func (s *session) funcOf(fn func(this js.Value, args []js.Value) interface{}) js.Func {
+	f := js.FuncOf(func(this js.Value, args []js.Value) (result interface{}) {
+		callbacks.Add(1)
+		defer callbacks.Add(-1)
+		defer func() {
+			if r := recover(); r != nil {
+				js.Global().Get("console").Call("error", fmt.Sprintf("panic in js.FuncOf callback: %v", r))
+				result = nil
+			}
+		}()
+		return fn(this, args)
+	})
+
+	handler := js.Global().Get("Object").New()
+	handler.Set("session", s.id)
+	f.Value = js.Global().Get("Proxy").New(f.Value, handler)
+
+	s.mu.Lock()
+	s.funcs = append(s.funcs, sessionFunc{f, handler})
+	s.mu.Unlock()
+	return f
+}
+
+// releaseFuncs releases the functions created through funcOf and returns
+// their count.
+func (s *session) releaseFuncs() int {
+	s.mu.Lock()
+	funcs := s.funcs
+	s.funcs = nil
+	s.mu.Unlock()
+
+	for _, f := range funcs {
+		f.handler.Set("apply", releasedCall)
+		f.fn.Release()
+	}
+	return len(funcs)
+}
+
+// listCallbacks returns the count of the functions created through
+// js.FuncOf by the code of each session and not released since, by id:
+// {success, sessions: [{id, callbacks}]}. The option session restricts it
+// to one session. Functions released by the code itself count until then.
+func listCallbacks(this js.Value, args []js.Value) interface{} {
+	opts := optionArg(args, 0)
+	list := allSessions()
+	if opts.Type() == js.TypeObject && opts.Get("session").Type() == js.TypeNumber {
+		s := lookupSession(opts.Get("session").Int())
+		if s == nil {
+			return map[string]interface{}{
+				"success": false,
+				"error":   "session not found",
+			}
+		}
+		list = []*session{s}
+	}
+
+	out := make([]interface{}, 0, len(list))
+	for _, s := range list {
+		s.mu.Lock()
+		n := len(s.funcs)
+		s.mu.Unlock()
+		out = append(out, map[string]interface{}{"id": s.id, "callbacks": n})
+	}
+	return map[string]interface{}{
+		"success":  true,
+		"sessions": out,
+	}
+}
+
+// releaseCallbacks releases the functions created through js.FuncOf by the
+// code of the default session, or of the session of the option session,
+// without resetting it: {success, released}.
+func releaseCallbacks(this js.Value, args []js.Value) interface{} {
+	opts := optionArg(args, 0)
+	s := defaultSession()
+	if opts.Type() == js.TypeObject && opts.Get("session").Type() == js.TypeNumber {
+		s = lookupSession(opts.Get("session").Int())
+	}
+	if s == nil {
+		return map[string]interface{}{
+			"success": false,
+			"error":   "session not found",
+		}
+	}
+	return map[string]interface{}{
+		"success":  true,
+		"released": s.releaseFuncs(),
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"syscall/js"
+	"testing"
+)
+
+func TestWindowTestValue(t *testing.T) {
+	// Node has no window: the host stands one in.
+	window := js.Global().Get("Object").New()
+	js.Global().Set("window", window)
+	defer js.Global().Delete("window")
+	id := newTestSession(t, nil)
+
+	res := callAPI(t, "evalIn", id, `package main
+
+import "syscall/js"
+
+func main() {
+	window := js.Global().Get("window")
+	window.Set("testValue", 42)
+	window.Set("double", js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		return args[0].Int() * 2
+	}))
+}
+`)
+	mustSucceed(t, res)
+	if got := window.Get("testValue"); got.Type() != js.TypeNumber || got.Int() != 42 {
+		t.Errorf("window.testValue = %s, want 42", jsonString(got))
+	}
+	if got := window.Call("double", 21); got.Int() != 42 {
+		t.Errorf("window.double(21) = %s, want 42", jsonString(got))
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"strings"
+	"syscall/js"
+	"time"
+)
+
+// evalLimits are the keys of the option limits, which override for one
+// eval the limits of the session, see parseLimits, and evalOptionLimits
+// those also given as options of their own.
+var (
+	evalLimits       = []string{"timeoutMs", "maxOutputBytes", "maxSteps", "maxHeapBytes"}
+	evalOptionLimits = []string{"timeoutMs", "maxOutputBytes", "maxSteps", "maxHeapBytes"}
+)
+
+// optionIssue is an eval option parseEvalOptions or checkOptions rejected.
+type optionIssue struct {
+	option string // as in the options, "limits.maxSteps" for a limit
+	reason string
+}
+
+// parseLimits applies to opts the option limits of v: {timeoutMs,
+// maxOutputBytes, maxSteps, maxHeapBytes}, each a count, 0 for none, in
+// place of the option of the same name or of the configured default.
+// A limit that is not a count, or unknown, is an issue of opts instead,
+// as is one given again outside limits with another value.
+func parseLimits(v js.Value, opts *evalOptions) {
+	limits := v.Get("limits")
+	if limits.IsUndefined() || limits.IsNull() {
+		return
+	}
+	if limits.Type() != js.TypeObject || limits.InstanceOf(js.Global().Get("Array")) {
+		opts.issues = append(opts.issues, optionIssue{"limits", "must be an object"})
+		return
+	}
+	keys := js.Global().Get("Object").Call("keys", limits)
+	for i := 0; i < keys.Length(); i++ {
+		key := keys.Index(i).String()
+		option := "limits." + key
+		if !slices.Contains(evalLimits, key) {
+			opts.issues = append(opts.issues, optionIssue{option, "unknown limit, expected one of " + strings.Join(evalLimits, ", ")})
+			continue
+		}
+		l := limits.Get(key)
+		if l.Type() != js.TypeNumber || l.Float() < 0 || l.Float() != math.Trunc(l.Float()) || math.IsInf(l.Float(), 0) {
+			opts.issues = append(opts.issues, optionIssue{option, "must be a non-negative integer, 0 for none"})
+			continue
+		}
+		if outer := v.Get(key); slices.Contains(evalOptionLimits, key) && outer.Type() == js.TypeNumber && outer.Float() != l.Float() {
+			opts.issues = append(opts.issues, optionIssue{option, "conflicts with the option " + key})
+			continue
+		}
+		n := l.Int()
+		switch key {
+		case "timeoutMs":
+			opts.timeout = time.Duration(n) * time.Millisecond
+		case "maxOutputBytes":
+			opts.maxOutput = n
+		case "maxSteps":
+			opts.maxSteps = n
+		case "maxHeapBytes":
+			opts.maxHeap = n
+		}
+	}
+}
+
+// parseEnvOverride reads the option env of v, the variables set for one
+// eval on top of the environment of the session, see overrideEnv: an
+// object mapping names to values, or an array of "KEY=value" entries.
+func parseEnvOverride(v js.Value, opts *evalOptions) {
+	env := v.Get("env")
+	if env.IsUndefined() || env.IsNull() {
+		return
+	}
+	if env.Type() != js.TypeObject {
+		opts.issues = append(opts.issues, optionIssue{"env", "must be an object or an array of KEY=value entries"})
+		return
+	}
+	opts.env = []string{}
+	for _, entry := range envEntries(v) {
+		if key, _, ok := strings.Cut(entry, "="); !ok || key == "" {
+			opts.issues = append(opts.issues, optionIssue{"env", "invalid entry " + entry})
+			continue
+		}
+		opts.env = append(opts.env, entry)
+	}
+}
+
+// checkOptions returns the result failing an eval with opts before it
+// runs, for the issues of its options and for the limits it can't apply:
+// maxSteps counts steps only in code instrumentSteps rewrote, which the
+// eval does if stepped. It returns nil for options that are fine.
+func checkOptions(opts evalOptions, stepped bool) map[string]interface{} {
+	issues := opts.issues
+	if opts.maxSteps > 0 && !stepped {
+		issues = append(issues, optionIssue{"maxSteps", "this command runs code without step counting"})
+	}
+	if len(issues) == 0 {
+		return nil
+	}
+	list := make([]interface{}, len(issues))
+	reasons := make([]string, len(issues))
+	for i, issue := range issues {
+		list[i] = map[string]interface{}{"option": issue.option, "reason": issue.reason}
+		reasons[i] = issue.option + ": " + issue.reason
+	}
+	return map[string]interface{}{
+		"success":        false,
+		"error":          "invalid options: " + strings.Join(reasons, "; "),
+		"errorCode":      codeBadRequest,
+		"invalidOptions": list,
+	}
+}
+
+// appliedLimits returns the limits an eval ran with, for stats.limits:
+// {timeoutMs, maxOutputBytes, abortOnOutputLimit, maxSteps, maxHeapBytes,
+// maxCallDepth, allowImports}, the counts 0 for none and allowImports null
+// for any package.
+func appliedLimits(opts evalOptions) map[string]interface{} {
+	var allow interface{}
+	if opts.allowImports != nil {
+		allow = stringsToJS(opts.allowImports)
+	}
+	return map[string]interface{}{
+		"timeoutMs":          opts.timeout.Milliseconds(),
+		"maxOutputBytes":     opts.maxOutput,
+		"abortOnOutputLimit": opts.abortOnOutputLimit,
+		"maxSteps":           opts.maxSteps,
+		"maxHeapBytes":       opts.maxHeap,
+		"maxCallDepth":       opts.maxCallDepth,
+		"allowImports":       allow,
+	}
+}
+
+// overrideEnv sets in the environment of s the "KEY=value" entries of env
+// and returns the function setting the variables back as they were, or
+// unset, whatever the code did with them in between.
+func (s *session) overrideEnv(env []string) (restore func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	type saved struct {
+		value string
+		set   bool
+	}
+	before := map[string]saved{}
+	for _, entry := range env {
+		key, value, _ := strings.Cut(entry, "=")
+		if _, ok := before[key]; !ok {
+			old, set := s.env[key]
+			before[key] = saved{old, set}
+		}
+		s.env[key] = value
+	}
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		for key, old := range before {
+			if old.set {
+				s.env[key] = old.value
+			} else {
+				delete(s.env, key)
+			}
+		}
+	}
+}
@@ -1,114 +1,184 @@
 package main
 
 import (
-	"bytes"
-	"fmt"
-	"io"
 	"os"
+	"sort"
 	"syscall/js"
-
-	"github.com/traefik/yaegi/interp"
-	"github.com/traefik/yaegi/stdlib"
 )
 
-var interpreter *interp.Interpreter
+// commands are the functions of the JavaScript API, by name. They are
+// installed on globalThis.yaegi and reachable through worker messages.
+var commands = map[string]func(this js.Value, args []js.Value) interface{}{
+	"eval":       nonBlocking(evalGo, 1),
+	"evalAsync":  evalAsync,
+	"evalFiles":  nonBlocking(evalFiles, 1),
+	"evalURL":    evalURL,
+	"test":       nonBlocking(runTests, 1),
+	"bench":      nonBlocking(runBenchmarks, 1),
+	"examples":   nonBlocking(runExamples, 1),
+	"evalExpr":   evalExpr,
+	"typeOf":     typeOf,
+	"isComplete": isComplete,
+	"check":      checkSource,
+	"ast":        syntaxTree,
+	"format":     formatSource,
+	"fixImports": fixImports,
+	"complete":   complete,
+	"doc":        doc,
+	"packages":   listPackages,
+	"globals":    globals,
+	"cancel":     cancelEval,
+	"writeStdin": writeStdin,
+	"closeStdin": closeStdin,
+	"setEnv":     setEnvVar,
+	"unsetEnv":   unsetEnvVar,
+	"setClock":   setClock,
+	"version":    getVersion,
+	"whenReady":  whenReady,
+	"reset":      resetInterpreter,
+	"configure":  configure,
+	"status":     status,
+	"goroutines": goroutines,
+	"dumpStacks": dumpStacks,
+	"memStats":   memStats,
+	"stats":      usageStats,
+	"gc":         collectGarbage,
+	"bind":       bindFunc,
+	"usePackage": usePackage,
+	"call":       callFunc,
+	"writeFile":  writeFile,
+	"readFile":   readFile,
+	"listFiles":  listFiles,
+	"addPackage": addPackage,
+
+	"start":    startRun,
+	"stop":     stopRun,
+	"listRuns": listRuns,
+
+	"submit":    submit,
+	"jobStatus": jobStatus,
+	"awaitJob":  awaitJob,
+	"cancelJob": cancelJob,
+
+	"registerHandler": registerHandler,
+	"serveHTTP":       serveHTTP,
+
+	"exportSession": exportSession,
+	"importSession": importSession,
+
+	"createSession":  createSession,
+	"evalIn":         nonBlocking(evalIn, 2),
+	"resetSession":   resetSession,
+	"destroySession": destroySession,
+	"listSessions":   listSessions,
+
+	"listCallbacks":    listCallbacks,
+	"releaseCallbacks": releaseCallbacks,
+
+	"compile":     compileProgram,
+	"run":         nonBlocking(runProgram, 1),
+	"freeProgram": freeProgram,
+
+	"encodeSource": encodeSource,
+
+	"dispose": dispose,
+
+	"capabilities": capabilities,
+
+	"debug":         debugProgram,
+	"debugContinue": debugContinue,
+	"debugStep":     debugStep,
+	"debugLocals":   debugLocals,
+	"debugStack":    debugStack,
+	"debugStop":     debugStop,
+
+	"beginUpload": beginUpload,
+	"uploadChunk": uploadChunk,
+	"readOutput":  readOutput,
+	"freeOutput":  freeOutput,
+
+	"clearCache": clearCache,
+
+	"loadTimezoneData": loadTimezoneData,
+
+	"onAsyncError": onAsyncError,
+
+	"entropyCheck": entropyCheck,
+
+	"onEvent":  onEvent,
+	"offEvent": offEvent,
+
+	"compare": nonBlocking(compareFuncs, 3),
+
+	"createTemplate":    createTemplate,
+	"spawnFromTemplate": spawnFromTemplate,
+	"listTemplates":     listTemplates,
+	"deleteTemplate":    deleteTemplate,
+
+	"createWriter":  createWriter,
+	"releaseWriter": releaseWriter,
+	"createReader":  createReader,
+	"pushToReader":  pushToReader,
+	"closeReader":   closeReader,
+
+	"setPrelude": setPrelude,
+
+	"addWatch":    addWatch,
+	"removeWatch": removeWatch,
+	"listWatches": listWatches,
+
+	"onFatal": onFatal,
+
+	"deps": deps,
+}
 
 func main() {
-	// Prevent the program from exiting
-	c := make(chan struct{}, 0)
-
-	// Fake `window` if missing (Node.js or non-browser runtime)
 	global := js.Global()
-	if global.Get("window").IsUndefined() {
-		global.Set("window", global)
-	}
 
-	// Initialize Yaegi interpreter
-	interpreter = interp.New(interp.Options{})
-	interpreter.Use(stdlib.Symbols)
+	// Let os.Stdout and os.Stderr in interpreted code follow the
+	// interpreter streams, which are not file descriptors here.
+	os.Setenv("YAEGI_SPECIAL_STDIO", "1")
 
-	// Expose JavaScript functions under `window.yaegi`
-	global.Get("window").Set("yaegi", map[string]interface{}{
-		"eval":    js.FuncOf(evalGo),
-		"version": js.FuncOf(getVersion),
-		"reset":   js.FuncOf(resetInterpreter),
-	})
+	// Initialize the default Yaegi session
+	newSession(sessionConfig{})
+
+	installAPI()
+
+	// Answer messages when running in a worker
+	if workerMode() {
+		serveMessages()
+	}
 
 	// Signal that Yaegi is ready
+	signalReady(global.Get("yaegi"))
 	global.Get("console").Call("log", "Yaegi WebAssembly initialized!")
 
-	<-c // Keep the program running
+	<-keepAlive // Keep the program running until dispose
 }
 
-func evalGo(this js.Value, args []js.Value) interface{} {
-	if len(args) != 1 {
-		return map[string]interface{}{
-			"success": false,
-			"error":   "eval requires exactly one argument (Go source code)",
-		}
-	}
+// installAPI installs the commands on globalThis.yaegi, as main does once
+// the default session exists.
+func installAPI() {
+	global := js.Global()
 
-	sourceCode := args[0].String()
-
-	// Capture stdout
-	oldStdout := os.Stdout
-	r, w, _ := os.Pipe()
-	os.Stdout = w
-
-	// Buffer to capture output
-	var outputBuffer bytes.Buffer
-	done := make(chan bool)
-
-	// Read from pipe in goroutine
-	go func() {
-		io.Copy(&outputBuffer, r)
-		done <- true
-	}()
-
-	var evalError error
-
-	// Execute the Go code
-	func() {
-		defer func() {
-			if r := recover(); r != nil {
-				evalError = fmt.Errorf("panic: %v", r)
-			}
-		}()
-		_, evalError = interpreter.Eval(sourceCode)
-	}()
-
-	// Restore stdout
-	w.Close()
-	os.Stdout = oldStdout
-	<-done
-
-	output := outputBuffer.String()
-
-	if evalError != nil {
-		return map[string]interface{}{
-			"success": false,
-			"error":   evalError.Error(),
-			"output":  output,
-		}
+	// Expose JavaScript functions under `globalThis.yaegi`, which is
+	// `window.yaegi` in browsers
+	api := map[string]interface{}{}
+	for name, fn := range commands {
+		// Worker messages reach the same wrapped commands.
+		commands[name] = enveloped(fn)
+		api[name] = apiFunc(commands[name])
+		installedCommands = append(installedCommands, name)
 	}
+	sort.Strings(installedCommands)
+	global.Set("yaegi", api)
 
-	return map[string]interface{}{
-		"success": true,
-		"output":  output,
-		"error":   nil,
+	// Mirror it on a browser window that is not the global object
+	if window := global.Get("window"); window.Type() == js.TypeObject && !window.Equal(global) {
+		window.Set("yaegi", global.Get("yaegi"))
 	}
-}
 
-func getVersion(this js.Value, args []js.Value) interface{} {
-	return "Yaegi WebAssembly v1.0"
-}
-
-func resetInterpreter(this js.Value, args []js.Value) interface{} {
-	interpreter = interp.New(interp.Options{})
-	interpreter.Use(stdlib.Symbols)
-
-	return map[string]interface{}{
-		"success": true,
-		"message": "Interpreter reset successfully",
-	}
+	// Report the exit of the module to the onFatal functions, the API
+	// then answering module_dead
+	installCrashShim()
 }
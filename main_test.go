@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall/js"
+	"testing"
+)
+
+// The tests run under Node, built with GOOS=js GOARCH=wasm and run by the
+// go_js_wasm_exec of the Go distribution, on the PATH:
+//
+//	PATH="$(go env GOROOT)/lib/wasm:$PATH" GOOS=js GOARCH=wasm go test
+//
+// They call the API on globalThis.yaegi, as a host does.
+func TestMain(m *testing.M) {
+	os.Setenv("YAEGI_SPECIAL_STDIO", "1")
+	newSession(sessionConfig{})
+	installAPI()
+	os.Exit(m.Run())
+}
+
+// callAPI calls the function name of globalThis.yaegi with args and returns
+// its result, the outcome of the Promise it returns once settled.
+func callAPI(t *testing.T, name string, args ...interface{}) js.Value {
+	t.Helper()
+	v := js.Global().Get("yaegi").Call(name, args...)
+	if !v.InstanceOf(js.Global().Get("Promise")) {
+		return v
+	}
+	res, err := awaitPromise(v)
+	if r := (jsRejection{}); errors.As(err, &r) {
+		// The async API rejects with an Error carrying the code.
+		return r.reason
+	}
+	return res
+}
+
+// newTestSession creates a session with the options config, destroyed
+// once t ends, and returns its id.
+func newTestSession(t *testing.T, config map[string]interface{}) int {
+	t.Helper()
+	res := callAPI(t, "createSession", config)
+	mustSucceed(t, res)
+	id := res.Get("id").Int()
+	t.Cleanup(func() { callAPI(t, "destroySession", id) })
+	return id
+}
+
+// mustSucceed fails t unless res is a successful result.
+func mustSucceed(t *testing.T, res js.Value) {
+	t.Helper()
+	if res.Type() != js.TypeObject || !res.Get("success").Truthy() {
+		t.Fatalf("result failed: %s", jsonString(res))
+	}
+}
+
+// errorCodeOf returns the code of the error of the result res, or of the
+// Error rejecting a Promise of the async API, or "".
+func errorCodeOf(res js.Value) string {
+	if e := res.Get("error"); e.Type() == js.TypeObject {
+		return e.Get("code").String()
+	}
+	if c := res.Get("code"); c.Type() == js.TypeString {
+		return c.String()
+	}
+	return ""
+}
+
+// jsonString returns v as JSON, for failure messages.
+func jsonString(v js.Value) string {
+	if v.IsUndefined() {
+		return "undefined"
+	}
+	return js.Global().Get("JSON").Call("stringify", v).String()
+}
+
+func TestEvalGlobalThis(t *testing.T) {
+	id := newTestSession(t, nil)
+	res := callAPI(t, "evalIn", id, `package main
+
+import "fmt"
+
+func main() { fmt.Println("hello", 6*7) }
+`)
+	mustSucceed(t, res)
+	if got := res.Get("output").String(); got != "hello 42\n" {
+		t.Errorf("output = %q, want %q", got, "hello 42\n")
+	}
+	if w := js.Global().Get("window"); !w.IsUndefined() {
+		t.Errorf("globalThis.window = %s in Node, want undefined", jsonString(w))
+	}
+}
+
+func TestEvalAsyncGlobalThis(t *testing.T) {
+	res := callAPI(t, "evalAsync", `package main
+
+import "fmt"
+
+func main() { fmt.Print("async") }
+`)
+	mustSucceed(t, res)
+	if got := res.Get("output").String(); got != "async" {
+		t.Errorf("output = %q, want %q", got, "async")
+	}
+	callAPI(t, "reset")
+
+	res = callAPI(t, "evalAsync", `package main
+
+func main() { panic("boom") }
+`)
+	if res.Get("success").Truthy() || errorCodeOf(res) != codePanic {
+		t.Errorf("panicking evalAsync = %s, want code %s", jsonString(res), codePanic)
+	}
+	callAPI(t, "reset")
+}
+
+func TestWindowUndefinedInNode(t *testing.T) {
+	id := newTestSession(t, nil)
+	res := callAPI(t, "evalIn", id, `package main
+
+import (
+	"fmt"
+	"syscall/js"
+)
+
+func main() {
+	fmt.Println(js.Global().Get("window").IsUndefined(), js.Global().Get("yaegi").Type() == js.TypeObject)
+}
+`)
+	mustSucceed(t, res)
+	if got := res.Get("output").String(); got != "true true\n" {
+		t.Errorf("output = %q, want %q", got, "true true\n")
+	}
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/traefik/yaegi/interp"
+)
+
+// mapsPackage is the package of the iterators orderMapRanges inserts.
+const mapsPackage = "yaegiwasm/maps"
+
+// mapIter iterates over the keys of a map in order, see sortedKeys.
+type mapIter struct {
+	m     reflect.Value
+	keys  []reflect.Value
+	next  int
+	key   reflect.Value
+	value reflect.Value
+}
+
+// Next moves to the next key still in the map, as a range of the map
+// skips the entries deleted before their turn, and reports whether there
+// is one.
+func (it *mapIter) Next() bool {
+	for it.next < len(it.keys) {
+		k := it.keys[it.next]
+		it.next++
+		if v := it.m.MapIndex(k); v.IsValid() {
+			it.key, it.value = k, v
+			return true
+		}
+	}
+	return false
+}
+
+// Key returns the key Next moved to.
+func (it *mapIter) Key() interface{} {
+	return it.key.Interface()
+}
+
+// Value returns the value of the key Next moved to, read then.
+func (it *mapIter) Value() interface{} {
+	return it.value.Interface()
+}
+
+// mapsSymbols returns the package of the iterators of orderMapRanges.
+func mapsSymbols() interp.Exports {
+	return interp.Exports{mapsPackage + "/maps": {
+		"Order": reflect.ValueOf(func(m interface{}) *mapIter {
+			v := reflect.ValueOf(m)
+			if v.Kind() != reflect.Map {
+				return &mapIter{}
+			}
+			return &mapIter{m: v, keys: sortedKeys(v)}
+		}),
+	}}
+}
+
+// startMapOrder imports the package of orderMapRanges in the interpreter
+// of s the first time it runs rewritten code of the file name.
+func (s *session) startMapOrder(name string) error {
+	return s.importOnce(&s.mapsInterp, "_yaegimaps", mapsPackage, name)
+}
+
+// sortedKeys returns the keys of the map m in order: numbers, strings and
+// booleans by value, NaNs first, and the keys of other types by their fmt
+// representation. The values of an interface key are ordered by kind
+// first, nil leading, so that the order is total.
+func sortedKeys(m reflect.Value) []reflect.Value {
+	keys := m.MapKeys()
+	sort.SliceStable(keys, func(i, j int) bool {
+		return keyLess(keys[i], keys[j])
+	})
+	return keys
+}
+
+func keyLess(a, b reflect.Value) bool {
+	if a.Kind() == reflect.Interface {
+		a, b = a.Elem(), b.Elem()
+	}
+	switch {
+	case !a.IsValid() || !b.IsValid():
+		return !a.IsValid() && b.IsValid()
+	case a.Kind() != b.Kind():
+		return a.Kind() < b.Kind()
+	}
+	switch a.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return a.Int() < b.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return a.Uint() < b.Uint()
+	case reflect.Float32, reflect.Float64:
+		x, y := a.Float(), b.Float()
+		return x < y || x != x && y == y
+	case reflect.String:
+		return a.String() < b.String()
+	case reflect.Bool:
+		return !a.Bool() && b.Bool()
+	}
+	return fmt.Sprint(a.Interface()) < fmt.Sprint(b.Interface())
+}
+
+// mapEdit is a source rewritten by orderMapRanges.
+type mapEdit struct {
+	src   string
+	moved spliceMap // the text replaced
+}
+
+// orderMapRanges rewrites the range statements of src over maps so that
+// they iterate in the order of sortedKeys, for the output of code printing
+// maps this way to be reproducible:
+//
+//	for k, v := range m {
+//	for _yaegimap0 := _yaegimaps.Order(m); _yaegimap0.Next(); { k := _yaegimap0.Key().(string); v := _yaegimap0.Value().(int); {
+//
+// the body of the statement closed by an extra brace. The loop has no
+// post statement, so break, continue and labels keep their meaning, and
+// the header stays on its line. Which ranges are over maps comes from
+// type-checking src alone: a map of an earlier eval, or whose type comes
+// from an imported package, is left alone, as are those of a key or value
+// type of such a package. A source that does not parse is returned as is,
+// for the eval to report its errors.
+func orderMapRanges(src string) mapEdit {
+	edit := mapEdit{src: src}
+	if !strings.Contains(src, "range") {
+		return edit
+	}
+	f, err := parseFragment(src)
+	if err != nil {
+		return edit
+	}
+	info := &types.Info{Types: map[ast.Expr]types.TypeAndValue{}}
+	conf := types.Config{
+		Importer: emptyImporter{},
+		Error:    func(error) {}, // the eval reports them
+	}
+	pkg, _ := conf.Check(f.file.Name.Name, f.fset, []*ast.File{f.file}, info)
+	qualifier := func(p *types.Package) string {
+		if p == pkg {
+			return ""
+		}
+		return p.Name()
+	}
+
+	var splices []splice
+	ast.Inspect(f.file, func(n ast.Node) bool {
+		r, ok := n.(*ast.RangeStmt)
+		if !ok {
+			return true
+		}
+		m, ok := info.Types[r.X].Type.(*types.Map)
+		if !ok {
+			if t := info.Types[r.X].Type; t != nil {
+				m, _ = t.Underlying().(*types.Map)
+			}
+		}
+		if m == nil || !importFree(m.Key(), pkg) || !importFree(m.Elem(), pkg) {
+			return true
+		}
+		// From the key, or the range keyword, to the range expression.
+		start := r.Range
+		if r.Key != nil {
+			start = r.Key.Pos()
+		}
+		from, to := f.offset(start), f.offset(r.X.Pos())
+		if strings.Contains(src[from:to], "\n") || strings.Contains(src[from:to], "/*") {
+			return true
+		}
+		it := "_yaegimap" + strconv.Itoa(len(splices)/4)
+		var prologue strings.Builder
+		prologue.WriteString(" ")
+		for _, v := range []struct {
+			expr   ast.Expr
+			method string
+			typ    types.Type
+		}{{r.Key, "Key", m.Key()}, {r.Value, "Value", m.Elem()}} {
+			if v.expr == nil || isBlank(v.expr) {
+				continue
+			}
+			lhs := src[f.offset(v.expr.Pos()):f.offset(v.expr.End())]
+			prologue.WriteString(rangeAssign(lhs, r.Tok == token.DEFINE, it+"."+v.method+"()", v.typ, types.TypeString(v.typ, qualifier)))
+		}
+		prologue.WriteString("{")
+		splices = append(splices,
+			splice{from, to - from, it + " := _yaegimaps.Order("},
+			splice{f.offset(r.X.End()), 0, "); " + it + ".Next();"},
+			splice{f.offset(r.Body.Lbrace) + 1, 0, prologue.String()},
+			splice{f.offset(r.Body.Rbrace), 0, "}"})
+		return true
+	})
+	if splices == nil {
+		return edit
+	}
+	sort.SliceStable(splices, func(i, j int) bool { return splices[i].at < splices[j].at })
+	edit.src, edit.moved = applySplices(src, splices)
+	return edit
+}
+
+// rangeAssign returns the statements giving lhs, declared if define, the
+// value of get, of type t named typ. yaegi fails the type assertions to
+// interface types of nil values, and all to interface{}, which these steer
+// clear of.
+func rangeAssign(lhs string, define bool, get string, t types.Type, typ string) string {
+	i, ok := t.Underlying().(*types.Interface)
+	switch {
+	case !ok && define:
+		return fmt.Sprintf("%s := %s.(%s); ", lhs, get, typ)
+	case !ok:
+		return fmt.Sprintf("%s = %s.(%s); ", lhs, get, typ)
+	case i.Empty() && define:
+		return fmt.Sprintf("var %s %s = %s; ", lhs, typ, get)
+	case i.Empty():
+		return fmt.Sprintf("%s = %s; ", lhs, get)
+	case define:
+		return fmt.Sprintf("var %s %s; if _yaegiv := %s; _yaegiv != nil { %[1]s = _yaegiv.(%[2]s) }; ", lhs, typ, get)
+	}
+	return fmt.Sprintf("if _yaegiv := %s; _yaegiv != nil { %s = _yaegiv.(%s) } else { %[2]s = nil }; ", get, lhs, typ)
+}
+
+// importFree reports whether the type t, as checked in pkg, is known and
+// refers to no type of another package: their names are not those the
+// source imports them by, and imported packages are not checked.
+func importFree(t types.Type, pkg *types.Package) bool {
+	ok := true
+	var visit func(t types.Type)
+	visit = func(t types.Type) {
+		switch t := t.(type) {
+		case *types.Basic:
+			ok = ok && t.Kind() != types.Invalid
+		case *types.Named:
+			if obj := t.Obj(); obj.Pkg() != nil && obj.Pkg() != pkg {
+				ok = false
+			}
+			if args := t.TypeArgs(); args != nil {
+				for i := 0; i < args.Len(); i++ {
+					visit(args.At(i))
+				}
+			}
+		case *types.Alias:
+			visit(types.Unalias(t))
+		case *types.Pointer:
+			visit(t.Elem())
+		case *types.Slice:
+			visit(t.Elem())
+		case *types.Array:
+			visit(t.Elem())
+		case *types.Chan:
+			visit(t.Elem())
+		case *types.Map:
+			visit(t.Key())
+			visit(t.Elem())
+		case *types.Struct:
+			for i := 0; i < t.NumFields(); i++ {
+				visit(t.Field(i).Type())
+			}
+		case *types.Signature:
+			for _, tuple := range []*types.Tuple{t.Params(), t.Results()} {
+				for i := 0; i < tuple.Len(); i++ {
+					visit(tuple.At(i).Type())
+				}
+			}
+		case *types.Interface:
+			for i := 0; i < t.NumMethods(); i++ {
+				visit(t.Method(i).Type())
+			}
+		case *types.TypeParam:
+		default:
+			ok = false
+		}
+	}
+	visit(t)
+	return ok
+}
+
+// emptyImporter imports packages declaring nothing, for orderMapRanges to
+// type-check a source without their symbols.
+type emptyImporter struct{}
+
+func (emptyImporter) Import(p string) (*types.Package, error) {
+	pkg := types.NewPackage(p, path.Base(p))
+	pkg.MarkComplete()
+	return pkg, nil
+}
+
+// splice replaces the n bytes of a source at offset at with text.
+type splice struct {
+	at   int
+	n    int
+	text string
+}
+
+// applySplices applies to src the splices, in increasing offsets, none
+// spanning lines, and returns the result with the map of its positions.
+func applySplices(src string, splices []splice) (string, spliceMap) {
+	var b strings.Builder
+	m := spliceMap{}
+	last := 0
+	for _, sp := range splices {
+		b.WriteString(src[last:sp.at])
+		b.WriteString(sp.text)
+		line := strings.Count(src[:sp.at], "\n") + 1
+		column := sp.at - strings.LastIndex(src[:sp.at], "\n")
+		m[line] = append(m[line], [3]int{column, sp.n, len(sp.text)})
+		last = sp.at + sp.n
+	}
+	b.WriteString(src[last:])
+	return b.String(), m
+}
+
+// spliceMap gives, by line, the columns of the source at which bytes were
+// replaced, in increasing order, with the count of bytes replaced and of
+// those replacing them. It generalizes insertMap to replacements.
+type spliceMap map[int][][3]int
+
+// position maps a line and column of the rewritten source to the source.
+// Columns within a replacement map to its start.
+func (m spliceMap) position(line, column int) (int, int) {
+	shift := 0
+	for _, c := range m[line] {
+		switch at := c[0] + shift; {
+		case column < at:
+			return line, column - shift
+		case column < at+c[2]:
+			return line, c[0]
+		}
+		shift += c[2] - c[1]
+	}
+	return line, column - shift
+}
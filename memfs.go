@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memFS is an in-memory filesystem. Directories are implied by the paths
+// of the files they contain.
+type memFS struct {
+	mu      sync.Mutex
+	files   map[string]*memFile // keyed by slash-separated path, see fs.ValidPath
+	changed func()              // called after each change, see setChanged
+}
+
+type memFile struct {
+	data    []byte
+	modTime time.Time
+}
+
+func newMemFS() *memFS {
+	return &memFS{files: map[string]*memFile{}}
+}
+
+// setChanged makes fn, unless nil, called after each change to the files
+// of m, outside of its lock.
+func (m *memFS) setChanged(fn func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.changed = fn
+}
+
+// notify calls the function of setChanged.
+func (m *memFS) notify() {
+	m.mu.Lock()
+	fn := m.changed
+	m.mu.Unlock()
+	if fn != nil {
+		fn()
+	}
+}
+
+// writeFile creates or replaces the file name with data.
+func (m *memFS) writeFile(name string, data []byte) {
+	defer m.notify()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.files[name] = &memFile{data: append([]byte(nil), data...), modTime: time.Now()}
+}
+
+// appendFile appends data to the file name, creating it if needed.
+func (m *memFS) appendFile(name string, data []byte) {
+	defer m.notify()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f := m.files[name]
+	if f == nil {
+		f = &memFile{}
+		m.files[name] = f
+	}
+	f.data = append(f.data, data...)
+	f.modTime = time.Now()
+}
+
+// readFile returns a copy of the content of the file name.
+func (m *memFS) readFile(name string) ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f := m.files[name]
+	if f == nil {
+		return nil, false
+	}
+	return append([]byte(nil), f.data...), true
+}
+
+// remove deletes the file name and reports whether it existed.
+func (m *memFS) remove(name string) bool {
+	m.mu.Lock()
+	_, ok := m.files[name]
+	delete(m.files, name)
+	m.mu.Unlock()
+	if ok {
+		m.notify()
+	}
+	return ok
+}
+
+// clone returns a copy of m.
+func (m *memFS) clone() *memFS {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := newMemFS()
+	for name, f := range m.files {
+		c.files[name] = &memFile{data: append([]byte(nil), f.data...), modTime: f.modTime}
+	}
+	return c
+}
+
+// snapshotFile is a file of a snapshot of a memFS.
+type snapshotFile struct {
+	name    string
+	data    []byte
+	modTime time.Time
+}
+
+// snapshot returns the files of m, the most recently modified first.
+func (m *memFS) snapshot() []snapshotFile {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	files := make([]snapshotFile, 0, len(m.files))
+	for name, f := range m.files {
+		files = append(files, snapshotFile{name, append([]byte(nil), f.data...), f.modTime})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if !files[i].modTime.Equal(files[j].modTime) {
+			return files[i].modTime.After(files[j].modTime)
+		}
+		return files[i].name < files[j].name
+	})
+	return files
+}
+
+// restore adds files to m, replacing those of the same names, without
+// calling the function of setChanged.
+func (m *memFS) restore(files []snapshotFile) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, f := range files {
+		m.files[f.name] = &memFile{data: f.data, modTime: f.modTime}
+	}
+}
+
+// clear removes all files.
+func (m *memFS) clear() {
+	defer m.notify()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.files = map[string]*memFile{}
+}
+
+// list returns the paths of all files, sorted.
+func (m *memFS) list() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := make([]string, 0, len(m.files))
+	for name := range m.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (m *memFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	m.mu.Lock()
+	f := m.files[name]
+	m.mu.Unlock()
+	if f != nil {
+		return &openFile{Reader: bytes.NewReader(f.data), info: fileInfo{path.Base(name), f}}, nil
+	}
+
+	entries, err := m.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	return &openDir{info: fileInfo{name: path.Base(name)}, entries: entries}, nil
+}
+
+func (m *memFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	prefix := ""
+	if name != "." {
+		prefix = name + "/"
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	children := map[string]*memFile{}
+	found := false
+	for p, f := range m.files {
+		rest, ok := strings.CutPrefix(p, prefix)
+		if !ok {
+			continue
+		}
+		found = true
+		if dir, _, isDir := strings.Cut(rest, "/"); isDir {
+			children[dir] = nil
+		} else {
+			children[rest] = f
+		}
+	}
+	if !found && name != "." {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+
+	entries := make([]fs.DirEntry, 0, len(children))
+	for child, f := range children {
+		entries = append(entries, fs.FileInfoToDirEntry(fileInfo{child, f}))
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+// fileInfo describes a file, or a directory when file is nil.
+type fileInfo struct {
+	name string
+	file *memFile
+}
+
+func (fi fileInfo) Name() string     { return fi.name }
+func (fi fileInfo) IsDir() bool      { return fi.file == nil }
+func (fi fileInfo) Sys() interface{} { return nil }
+
+func (fi fileInfo) Size() int64 {
+	if fi.file == nil {
+		return 0
+	}
+	return int64(len(fi.file.data))
+}
+
+func (fi fileInfo) Mode() fs.FileMode {
+	if fi.file == nil {
+		return fs.ModeDir | 0o755
+	}
+	return 0o644
+}
+
+func (fi fileInfo) ModTime() time.Time {
+	if fi.file == nil {
+		return time.Time{}
+	}
+	return fi.file.modTime
+}
+
+type openFile struct {
+	*bytes.Reader
+	info fileInfo
+}
+
+func (f *openFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *openFile) Close() error               { return nil }
+
+type openDir struct {
+	info    fileInfo
+	entries []fs.DirEntry
+}
+
+func (d *openDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *openDir) Close() error               { return nil }
+
+func (d *openDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: fs.ErrInvalid}
+}
+
+func (d *openDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(d.entries))
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
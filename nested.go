@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// defaultMaxEvalDepth is the default count of evals that may nest in the
+// eval of a session.
+const defaultMaxEvalDepth = 1
+
+// nestedEvals counts the nested evals running, see nestedSession.
+var nestedEvals atomic.Int32
+
+// nestedSession returns the session an eval command for s runs in. While
+// a synchronous eval of s runs, the event loop waits for it, so that the
+// command can only come from its code, through syscall/js: that eval holds
+// the interpreter and the output of s, and the nested one runs in a
+// scratch session built from the configuration of s instead, its output
+// captured into its own result. Nesting deeper than the setting
+// maxEvalDepth fails with the result returned. done is called once the
+// eval has returned.
+func nestedSession(s *session) (run *session, done func(), failure map[string]interface{}) {
+	if !s.syncEval.Load() {
+		return s, func() {}, nil
+	}
+	if limit := maxEvalDepth(); int(nestedEvals.Add(1)) > limit {
+		nestedEvals.Add(-1)
+		return nil, nil, map[string]interface{}{
+			"success":   false,
+			"error":     fmt.Sprintf("nested eval limit: evals nest %d deep at most (maxEvalDepth)", limit),
+			"errorCode": codeLimit,
+		}
+	}
+	scratch := buildSession(-1, s.config, false)
+	return scratch, func() {
+		scratch.releaseFuncs()
+		nestedEvals.Add(-1)
+	}, nil
+}
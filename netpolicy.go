@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"syscall/js"
+)
+
+// errNetworkBlocked fails the requests of interpreted code to hosts the
+// network setting does not allow. http.Client reports it in a *url.Error.
+var errNetworkBlocked = errors.New("blocked by policy")
+
+// errNetworkOption rejects a network option of the wrong shape.
+var errNetworkOption = errors.New(`network requires { allow: ["host", "*.domain", "host:port", "*"] } or null`)
+
+// networkOption returns the allow list of the network option v of
+// configure, null denying every host.
+func networkOption(v js.Value) ([]string, error) {
+	if v.Type() == js.TypeNull {
+		return []string{}, nil
+	}
+	if v.Type() != js.TypeObject {
+		return nil, errNetworkOption
+	}
+	allow := v.Get("allow")
+	if allow.IsUndefined() {
+		return []string{}, nil
+	}
+	if !allow.InstanceOf(js.Global().Get("Array")) {
+		return nil, errNetworkOption
+	}
+	list := make([]string, 0, allow.Length())
+	for i := 0; i < allow.Length(); i++ {
+		e := allow.Index(i)
+		if e.Type() != js.TypeString || strings.TrimSpace(e.String()) == "" {
+			return nil, errNetworkOption
+		}
+		list = append(list, strings.ToLower(strings.TrimSpace(e.String())))
+	}
+	return list, nil
+}
+
+// networkAllow returns the allow list of the network setting.
+func networkAllow() []string {
+	settings.Lock()
+	defer settings.Unlock()
+
+	return settings.networkAllow
+}
+
+// openNetwork reports whether allow lets requests reach any host, in which
+// case fetch may follow redirects itself.
+func openNetwork(allow []string) bool {
+	for _, p := range allow {
+		if p == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// hostAllowed reports whether allow lets requests reach the host of u. A
+// pattern is "*", a host name, or "*.domain" matching the subdomains of
+// domain but not domain itself, followed by a port the URL must use if
+// any; the port of a URL without one is that of its scheme.
+func hostAllowed(allow []string, u *url.URL) bool {
+	host := strings.ToLower(u.Hostname())
+	port := u.Port()
+	if port == "" {
+		switch u.Scheme {
+		case "http", "ws":
+			port = "80"
+		case "https", "wss":
+			port = "443"
+		}
+	}
+	for _, p := range allow {
+		if p == "*" {
+			return true
+		}
+		name, want := p, ""
+		if h, pt, err := net.SplitHostPort(p); err == nil {
+			name, want = h, pt
+		}
+		if want != "" && want != port {
+			continue
+		}
+		if domain, ok := strings.CutPrefix(name, "*."); ok {
+			if strings.HasSuffix(host, "."+domain) {
+				return true
+			}
+		} else if host == strings.Trim(name, "[]") {
+			return true
+		}
+	}
+	return false
+}
+
+// checkNetwork returns errNetworkBlocked, with the host, if the network
+// setting denies a request to u, counting it in the usage of s or, for
+// code of no known session, of the default session. A relative URL, which
+// fetch resolves against the page, has the host of the page. A pure session
+// reaches no host.
+func checkNetwork(s *session, u *url.URL) error {
+	if loc := js.Global().Get("location"); u.Host == "" && loc.Type() == js.TypeObject {
+		if base, err := url.Parse(loc.Get("href").String()); err == nil {
+			u = base.ResolveReference(u)
+		}
+	}
+	if (s == nil || !s.pure) && hostAllowed(networkAllow(), u) {
+		return nil
+	}
+	if s == nil {
+		s = defaultSession()
+	}
+	s.mu.Lock()
+	s.counters.netBlocked++
+	s.mu.Unlock()
+	return fmt.Errorf("%w: %s is not in the network allow list", errNetworkBlocked, u.Host)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"syscall/js"
+	"time"
+)
+
+// evalOptions holds the optional settings accepted by eval calls.
+type evalOptions struct {
+	timeout       time.Duration // zero means no deadline
+	onStdout      js.Value      // callback receiving stdout chunks
+	onStderr      js.Value      // callback receiving stderr chunks
+	captureOutput bool          // buffer output into the result
+	stdin         string        // data read by the program from os.Stdin
+	interactive   bool          // read os.Stdin from the writeStdin pipe
+	snippet       bool          // wrap the source in a main package, see wrapSnippet
+	autoImport    bool          // import the registered packages used, see evalImporting
+	args          []string      // os.Args for this eval, nil for those of the session
+	stats         bool          // report the evalStats in the result
+	binaryOutput  bool          // return and stream stdout as Uint8Array
+	async         bool          // run off the JS callback, which may wait for JS
+	profile       string        // profile to take, "cpu" or empty, see startProfile
+	maxSteps      int           // step budget, 0 for none, see instrumentSteps
+	events        bool          // report the output as events in write order, see outputEvents
+	filename      string        // name of the source in positions, see nameSource
+	allowImports  []string      // packages the source may import, nil for any, see checkImports
+	strictImports bool          // check the imports of the session as well
+	warnings      bool          // report the issues of the source, see sourceWarnings
+	echo          string        // what becomes of a trailing expression, see echoModes
+	trace         bool          // report the lines run, see instrumentTrace
+	buildTags     []string      // build tags of the files of evalFiles, see sourceTree
+	encoding      string        // of the source argument, see decodeSource
+	outputHandle  int           // output size past which it is held, 0 for never, see holdOutputs
+	cache         bool          // reuse the result of the same eval, see cachedEval
+	orderedMaps   bool          // range over maps in key order, see orderMapRanges
+	render        renderLimits  // of valueString and echoes, see renderValue
+	env           []string      // "KEY=value" entries set for this eval only, see overrideEnv
+	issues        []optionIssue // options rejected, see checkOptions
+	stepped       bool          // the code counts steps, see instrumentSteps
+	input         interface{}   // of playground.InputValue, see parseInput
+
+	queue  bool            // wait for the running eval to return, whatever queueEvals
+	parent context.Context // cancels the eval when done, nil for none
+	source string          // code the eval is reported running by status, if rewritten
+
+	maxOutput          int  // cap on stdout and stderr bytes, 0 for none
+	abortOnOutputLimit bool // abort the eval once maxOutput is exceeded
+	maxHeap            int  // cap on the heap in use, 0 for none
+	maxCallDepth       int  // cap on the interpreted frames of a goroutine, 0 for none
+}
+
+// parseEvalOptions reads eval options from a JS object. Missing or
+// non-object values yield the defaults.
+func parseEvalOptions(v js.Value) evalOptions {
+	opts := evalOptions{captureOutput: true, stats: true, warnings: true}
+	opts.maxOutput, opts.abortOnOutputLimit = outputLimits()
+	opts.maxHeap = maxHeapBytes()
+	opts.maxCallDepth = maxCallDepth()
+	opts.render = parseRenderLimits(v, renderSettings())
+	if v.Type() != js.TypeObject {
+		return opts
+	}
+
+	if ms := optionInt(v, "timeoutMs"); ms > 0 {
+		opts.timeout = time.Duration(ms) * time.Millisecond
+	}
+	opts.onStdout = v.Get("onStdout")
+	opts.onStderr = v.Get("onStderr")
+	if c := v.Get("captureOutput"); c.Type() == js.TypeBoolean {
+		opts.captureOutput = c.Bool()
+	}
+	if in := v.Get("stdin"); in.Type() == js.TypeString {
+		opts.stdin = in.String()
+	}
+	if in := v.Get("interactiveStdin"); in.Type() == js.TypeBoolean {
+		opts.interactive = in.Bool()
+	}
+	if mode := v.Get("mode"); mode.Type() == js.TypeString {
+		opts.snippet = mode.String() == "snippet"
+	}
+	if a := v.Get("autoImport"); a.Type() == js.TypeBoolean {
+		opts.autoImport = a.Bool()
+	}
+	if v.Get("maxOutputBytes").Type() == js.TypeNumber {
+		opts.maxOutput = max(optionInt(v, "maxOutputBytes"), 0)
+	}
+	if v.Get("maxHeapBytes").Type() == js.TypeNumber {
+		opts.maxHeap = max(optionInt(v, "maxHeapBytes"), 0)
+	}
+	if a := v.Get("abortOnOutputLimit"); a.Type() == js.TypeBoolean {
+		opts.abortOnOutputLimit = a.Bool()
+	}
+	if b := v.Get("binaryOutput"); b.Type() == js.TypeBoolean {
+		opts.binaryOutput = b.Bool()
+	}
+	if st := v.Get("stats"); st.Type() == js.TypeBoolean {
+		opts.stats = st.Bool()
+	}
+	if e := v.Get("events"); e.Type() == js.TypeBoolean {
+		opts.events = e.Bool()
+	}
+	if f := v.Get("filename"); f.Type() == js.TypeString {
+		opts.filename = f.String()
+	}
+	if a := v.Get("allowImports"); !a.IsUndefined() && !a.IsNull() {
+		opts.allowImports = optionStrings(v, "allowImports")
+	}
+	if st := v.Get("strict"); st.Type() == js.TypeBoolean {
+		opts.strictImports = st.Bool()
+	}
+	if e := v.Get("echo"); e.Type() == js.TypeString && slices.Contains(echoModes, e.String()) {
+		opts.echo = e.String()
+	}
+	if w := v.Get("warnings"); w.Type() == js.TypeBoolean {
+		opts.warnings = w.Bool()
+	}
+	// tolerant, from before warnings were reported by default.
+	if v.Get("tolerant").Truthy() {
+		opts.warnings = true
+	}
+	if t := v.Get("trace"); t.Type() == js.TypeBoolean {
+		opts.trace = t.Bool()
+	}
+	opts.maxSteps = max(optionInt(v, "maxSteps"), 0)
+	if p := v.Get("profile"); p.Type() == js.TypeString {
+		opts.profile = p.String()
+	}
+	// See nonBlocking.
+	opts.async = v.Get("async").Truthy() || v.Get("onComplete").Type() == js.TypeFunction
+	if a := v.Get("args"); !a.IsUndefined() {
+		opts.args = optionStrings(v, "args")
+	}
+	opts.buildTags = optionStrings(v, "buildTags")
+	if d := v.Get("deterministicMaps"); d.Type() == js.TypeBoolean {
+		opts.orderedMaps = d.Bool()
+	}
+	if e := v.Get("encoding"); e.Type() == js.TypeString {
+		opts.encoding = e.String()
+	}
+	opts.outputHandle = max(optionInt(v, "outputHandleBytes"), 0)
+	if c := v.Get("cache"); c.Type() == js.TypeBoolean {
+		opts.cache = c.Bool()
+	}
+	parseLimits(v, &opts)
+	parseEnvOverride(v, &opts)
+	parseInput(v, &opts)
+
+	return opts
+}
+
+// optionArg returns args[i] if present, or undefined.
+func optionArg(args []js.Value, i int) js.Value {
+	if i < len(args) {
+		return args[i]
+	}
+	return js.Undefined()
+}
+
+// optionInt returns the numeric property key of v, or 0 if it is not a number.
+func optionInt(v js.Value, key string) int {
+	p := v.Get(key)
+	if p.Type() != js.TypeNumber {
+		return 0
+	}
+	return p.Int()
+}
+
+// optionStrings returns the string elements of the array property key of v.
+func optionStrings(v js.Value, key string) []string {
+	p := v.Get(key)
+	if !p.InstanceOf(js.Global().Get("Array")) {
+		return nil
+	}
+	out := make([]string, 0, p.Length())
+	for i := 0; i < p.Length(); i++ {
+		if e := p.Index(i); e.Type() == js.TypeString {
+			out = append(out, e.String())
+		}
+	}
+	return out
+}
+
+// stringsToJS converts a string slice to a value accepted by js.ValueOf.
+func stringsToJS(s []string) []interface{} {
+	out := make([]interface{}, len(s))
+	for i, v := range s {
+		out[i] = v
+	}
+	return out
+}
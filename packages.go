@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"syscall/js"
+)
+
+// addPackage adds a source package to the default session, importable by
+// later evals: yaegi.addPackage("mylib", {"mylib.go": "package mylib..."}).
+// The files are stored under src/<path> in the session filesystem and kept
+// across resets. The package is compiled first, and not added on error.
+// Adding a path again replaces its files; evals see the new version once
+// the interpreter has been reset, if the previous one was imported.
+func addPackage(this js.Value, args []js.Value) interface{} {
+	if len(args) != 2 || args[0].Type() != js.TypeString || args[1].Type() != js.TypeObject {
+		return map[string]interface{}{
+			"success": false,
+			"error":   "addPackage requires an import path and an object mapping file names to source",
+		}
+	}
+	importPath := args[0].String()
+	if !validImportPath(importPath) {
+		return map[string]interface{}{
+			"success": false,
+			"error":   fmt.Sprintf("addPackage: invalid import path %q", importPath),
+		}
+	}
+
+	files := map[string][]byte{}
+	keys := js.Global().Get("Object").Call("keys", args[1])
+	for i := 0; i < keys.Length(); i++ {
+		name := keys.Index(i).String()
+		code := args[1].Get(name)
+		if code.Type() != js.TypeString {
+			return map[string]interface{}{
+				"success": false,
+				"error":   fmt.Sprintf("addPackage: source of %s is not a string", name),
+			}
+		}
+		if !goFileName(name) {
+			return map[string]interface{}{
+				"success": false,
+				"error":   fmt.Sprintf("addPackage: %s is not a Go file name", name),
+			}
+		}
+		files[name] = []byte(code.String())
+	}
+	if len(files) == 0 {
+		return map[string]interface{}{
+			"success": false,
+			"error":   "addPackage: no Go files",
+		}
+	}
+
+	// Checking the package runs its init functions, whose output must not
+	// mix with the one of a running eval.
+	s := defaultSession()
+	if err := s.acquireEval(context.Background(), false); err != nil {
+		return map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+			"busy":    true,
+		}
+	}
+	defer s.releaseEval()
+
+	src := s.files.clone()
+	removePackageFiles(src, importPath, s.packages[importPath])
+	writePackageFiles(src, importPath, files)
+	if err := s.checkPackage(src, importPath); err != nil {
+		return userPaths(map[string]interface{}{
+			"success":     false,
+			"error":       err.Error(),
+			"errorCode":   errorCode(err),
+			"diagnostics": errorDiagnostics(err, "", ""),
+		})
+	}
+
+	s.mu.Lock()
+	old := s.packages[importPath]
+	if s.packages == nil {
+		s.packages = map[string]map[string][]byte{}
+	}
+	s.packages[importPath] = files
+	s.mu.Unlock()
+
+	removePackageFiles(s.files, importPath, old)
+	writePackageFiles(s.files, importPath, files)
+	return map[string]interface{}{"success": true}
+}
+
+// listPackages returns the packages the default session can import, as
+// allowed by its configuration, sorted by import path: {path, name, symbols,
+// stdlib}, where symbols counts the exported symbols and stdlib is false for
+// the packages added with addPackage or bind.
+func listPackages(this js.Value, args []js.Value) interface{} {
+	s := defaultSession()
+
+	type pkgInfo struct {
+		name    string
+		symbols int
+		stdlib  bool
+	}
+	pkgs := map[string]pkgInfo{}
+	for name, cs := range s.importCandidates() {
+		for _, c := range cs {
+			if c.symbols != nil {
+				pkgs[c.path] = pkgInfo{name, exportedCount(c.symbols) + len(genericFuncs()[c.path]), true}
+			}
+		}
+	}
+	s.mu.Lock()
+	for importPath, files := range s.packages {
+		name, n := sourceExports(files)
+		pkgs[importPath] = pkgInfo{name, n, false}
+	}
+	for importPath, names := range s.bound {
+		if _, ok := pkgs[importPath]; !ok {
+			pkgs[importPath] = pkgInfo{path.Base(importPath), len(names), false}
+		}
+	}
+	s.mu.Unlock()
+
+	paths := make([]string, 0, len(pkgs))
+	for p := range pkgs {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	list := make([]interface{}, len(paths))
+	for i, p := range paths {
+		list[i] = map[string]interface{}{
+			"path":    p,
+			"name":    pkgs[p].name,
+			"symbols": pkgs[p].symbols,
+			"stdlib":  pkgs[p].stdlib,
+		}
+	}
+	return list
+}
+
+func exportedCount(syms map[string]reflect.Value) int {
+	n := 0
+	for name := range syms {
+		if token.IsExported(name) {
+			n++
+		}
+	}
+	return n
+}
+
+// sourceExports returns the package name of the source files and the number
+// of exported top-level identifiers they declare.
+func sourceExports(files map[string][]byte) (string, int) {
+	name, n := "", 0
+	fset := token.NewFileSet()
+	for fileName, data := range files {
+		f, err := parser.ParseFile(fset, fileName, data, parser.SkipObjectResolution)
+		if err != nil {
+			continue
+		}
+		name = f.Name.Name
+		for _, decl := range f.Decls {
+			switch d := decl.(type) {
+			case *ast.FuncDecl:
+				if d.Recv == nil && d.Name.IsExported() {
+					n++
+				}
+			case *ast.GenDecl:
+				for _, spec := range d.Specs {
+					switch spec := spec.(type) {
+					case *ast.TypeSpec:
+						if spec.Name.IsExported() {
+							n++
+						}
+					case *ast.ValueSpec:
+						for _, id := range spec.Names {
+							if id.IsExported() {
+								n++
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	return name, n
+}
+
+// checkPackage compiles the package importPath of src in a scratch
+// interpreter.
+func (s *session) checkPackage(src *memFS, importPath string) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	_, err = s.interpreterFor(src, nil).Eval(`import _ "` + importPath + `"`)
+	return err
+}
+
+// restorePackages writes the added packages back into the session
+// filesystem.
+func (s *session) restorePackages() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for importPath, files := range s.packages {
+		writePackageFiles(s.files, importPath, files)
+	}
+}
+
+func writePackageFiles(m *memFS, importPath string, files map[string][]byte) {
+	for name, data := range files {
+		m.writeFile(path.Join("src", importPath, name), data)
+	}
+}
+
+func removePackageFiles(m *memFS, importPath string, files map[string][]byte) {
+	for name := range files {
+		m.remove(path.Join("src", importPath, name))
+	}
+}
+
+// goFileName reports whether name is the name of a Go file of a package.
+func goFileName(name string) bool {
+	return !strings.Contains(name, "/") && strings.HasSuffix(name, ".go") && name != ".go"
+}
+
+// validImportPath reports whether p can name a source package.
+func validImportPath(p string) bool {
+	if p == "" || p != path.Clean(p) || path.IsAbs(p) || p == mainPackage {
+		return false
+	}
+	for _, elem := range strings.Split(p, "/") {
+		if elem == "." || elem == ".." || strings.ContainsAny(elem, ` "\`) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"syscall/js"
+	"time"
+)
+
+const (
+	// snapshotMagic starts the snapshots of encodeSnapshot.
+	snapshotMagic = "YGFS"
+	// snapshotVersion is the version of their format.
+	snapshotVersion = 1
+	// defaultPersistBytes is the default cap on the bytes of a snapshot,
+	// about what localStorage keeps for a page.
+	defaultPersistBytes = 4 << 20
+	// defaultPersistDelay is the default time a store waits for more writes.
+	defaultPersistDelay = 500 * time.Millisecond
+)
+
+// persistence holds the fsPersistence option of configure: the JS
+// functions loading and storing snapshots of the filesystem of the default
+// session, which the host keeps in localStorage, IndexedDB or elsewhere.
+var persistence = struct {
+	sync.Mutex
+	load, store js.Value // undefined when off
+	maxBytes    int
+	delay       time.Duration
+	timer       *time.Timer // pending store, if any
+	generation  int         // count of configurations, dropping the stores of earlier ones
+}{}
+
+// storeMu serializes the calls to store, which may wait for a Promise.
+var storeMu sync.Mutex
+
+// errPersistence rejects an fsPersistence option of the wrong shape.
+var errPersistence = errors.New("fsPersistence requires load and store functions, or null")
+
+// validPersistence reports whether v is an fsPersistence option.
+func validPersistence(v js.Value) bool {
+	return v.Type() == js.TypeNull ||
+		v.Type() == js.TypeObject && v.Get("load").Type() == js.TypeFunction && v.Get("store").Type() == js.TypeFunction
+}
+
+// configurePersistence applies the option v of configure, checked by
+// validPersistence: null turns persistence off, and {load, store,
+// maxBytes, debounceMs} turns it on, store being called debounceMs after
+// the last change to the filesystem of the default session with a
+// snapshot of at most maxBytes. The filesystem is first restored from what
+// load returns, and the Promise returned settles with the count of files
+// restored; a snapshot that fails to load turns persistence off, so that
+// no store overwrites it.
+func configurePersistence(v js.Value) js.Value {
+	s := defaultSession()
+	s.files.setChanged(nil)
+	persistence.Lock()
+	stopPersistence()
+	if v.Type() == js.TypeNull {
+		persistence.Unlock()
+		return js.Null()
+	}
+	persistence.load, persistence.store = v.Get("load"), v.Get("store")
+	persistence.maxBytes = defaultPersistBytes
+	if n := optionInt(v, "maxBytes"); n > 0 {
+		persistence.maxBytes = n
+	}
+	persistence.delay = defaultPersistDelay
+	if d := v.Get("debounceMs"); d.Type() == js.TypeNumber {
+		persistence.delay = time.Duration(max(d.Int(), 0)) * time.Millisecond
+	}
+	generation := persistence.generation
+	persistence.Unlock()
+
+	// Changes count once the snapshot is restored.
+	loaded := persistence.load.Invoke()
+	return newPromise(func(resolve, reject func(interface{})) {
+		n, err := restoreSnapshot(s.files, loaded)
+		persistence.Lock()
+		defer persistence.Unlock()
+
+		if persistence.generation != generation {
+			resolve(n)
+			return
+		}
+		if err != nil {
+			stopPersistence()
+			reject(newJSError(err.Error(), map[string]interface{}{"code": codeDecode}))
+			return
+		}
+		s.files.setChanged(func() { schedulePersist(generation) })
+		resolve(n)
+	})
+}
+
+// stopPersistence drops the pending store and the functions of
+// persistence, whose lock is held.
+func stopPersistence() {
+	if persistence.timer != nil {
+		persistence.timer.Stop()
+		persistence.timer = nil
+	}
+	persistence.load, persistence.store = js.Undefined(), js.Undefined()
+	persistence.generation++
+}
+
+// restoreSnapshot waits for loaded, the value returned by load, and adds the
+// files of the snapshot it holds to m. An undefined or null snapshot, as
+// on the first visit of a page, restores nothing.
+func restoreSnapshot(m *memFS, loaded js.Value) (int, error) {
+	if loaded.Type() == js.TypeObject && loaded.Get("then").Type() == js.TypeFunction {
+		var err error
+		if loaded, err = awaitPromise(loaded); err != nil {
+			return 0, fmt.Errorf("fsPersistence load: %w", err)
+		}
+	}
+	if loaded.IsUndefined() || loaded.IsNull() {
+		return 0, nil
+	}
+	if !loaded.InstanceOf(js.Global().Get("Uint8Array")) {
+		return 0, errors.New("fsPersistence load must return a Uint8Array, null or a Promise of one")
+	}
+	b := make([]byte, loaded.Length())
+	js.CopyBytesToGo(b, loaded)
+	files, err := decodeSnapshot(b)
+	if err != nil {
+		return 0, err
+	}
+	m.restore(files)
+	return len(files), nil
+}
+
+// schedulePersist stores the filesystem of the default session once no
+// change has been made to it for the delay of persistence.
+func schedulePersist(generation int) {
+	persistence.Lock()
+	defer persistence.Unlock()
+
+	if persistence.generation != generation {
+		return
+	}
+	if persistence.timer != nil {
+		persistence.timer.Stop()
+	}
+	persistence.timer = time.AfterFunc(persistence.delay, func() { persist(generation) })
+}
+
+// persist calls store with a snapshot of the filesystem of the default
+// session. Its failures, which no command sees, go to the console.
+func persist(generation int) {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+
+	persistence.Lock()
+	if persistence.generation != generation {
+		persistence.Unlock()
+		return
+	}
+	store, limit := persistence.store, persistence.maxBytes
+	persistence.timer = nil
+	persistence.Unlock()
+
+	snapshot := encodeSnapshot(defaultSession().files.snapshot(), limit)
+	r := store.Invoke(bytesToJS(snapshot))
+	if r.Type() == js.TypeObject && r.Get("then").Type() == js.TypeFunction {
+		if _, err := awaitPromise(r); err != nil {
+			js.Global().Get("console").Call("error", "fsPersistence store: "+err.Error())
+		}
+	}
+}
+
+// encodeSnapshot returns the snapshot of files, the most recently modified
+// first: snapshotMagic, the version byte and the count of files, then for
+// each its name, modification time in Unix milliseconds and data, with
+// lengths and counts as uvarints. Once the snapshot would exceed limit
+// bytes, the files left, the least recently modified, are evicted. A file
+// too large for any snapshot is left out alone.
+func encodeSnapshot(files []snapshotFile, limit int) []byte {
+	limit -= len(snapshotMagic) + 1 + binary.MaxVarintLen64
+	var entries bytes.Buffer
+	kept := 0
+	for _, f := range files {
+		var e []byte
+		e = binary.AppendUvarint(e, uint64(len(f.name)))
+		e = append(e, f.name...)
+		e = binary.AppendVarint(e, f.modTime.UnixMilli())
+		e = binary.AppendUvarint(e, uint64(len(f.data)))
+		e = append(e, f.data...)
+		if len(e) > limit {
+			continue
+		}
+		if entries.Len()+len(e) > limit {
+			break
+		}
+		entries.Write(e)
+		kept++
+	}
+
+	b := append([]byte(snapshotMagic), snapshotVersion)
+	b = binary.AppendUvarint(b, uint64(kept))
+	return append(b, entries.Bytes()...)
+}
+
+// decodeSnapshot returns the files of a snapshot of encodeSnapshot.
+func decodeSnapshot(b []byte) ([]snapshotFile, error) {
+	bad := func(what string) error {
+		return errors.New("fsPersistence snapshot: " + what)
+	}
+	if !bytes.HasPrefix(b, []byte(snapshotMagic)) || len(b) < len(snapshotMagic)+1 {
+		return nil, bad("not a snapshot")
+	}
+	if v := b[len(snapshotMagic)]; v != snapshotVersion {
+		return nil, bad(fmt.Sprintf("unsupported version %d", v))
+	}
+	r := bytes.NewReader(b[len(snapshotMagic)+1:])
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, bad("truncated")
+	}
+	chunk := func() ([]byte, error) {
+		n, err := binary.ReadUvarint(r)
+		if err != nil || n > uint64(r.Len()) {
+			return nil, bad("truncated")
+		}
+		p := make([]byte, n)
+		r.Read(p)
+		return p, nil
+	}
+
+	var files []snapshotFile
+	for i := uint64(0); i < count; i++ {
+		name, err := chunk()
+		if err != nil {
+			return nil, err
+		}
+		ms, err := binary.ReadVarint(r)
+		if err != nil {
+			return nil, bad("truncated")
+		}
+		data, err := chunk()
+		if err != nil {
+			return nil, err
+		}
+		if p := vfsPath(string(name)); p == "." || p != string(name) {
+			return nil, bad(fmt.Sprintf("invalid path %q", name))
+		}
+		files = append(files, snapshotFile{string(name), data, time.UnixMilli(ms)})
+	}
+	return files, nil
+}
+
+// persistenceConfig returns the fsPersistence entry of currentConfig.
+func persistenceConfig() interface{} {
+	persistence.Lock()
+	defer persistence.Unlock()
+
+	if persistence.store.IsUndefined() {
+		return nil
+	}
+	return map[string]interface{}{
+		"maxBytes":   persistence.maxBytes,
+		"debounceMs": persistence.delay.Milliseconds(),
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"sync"
+	"syscall/js"
+
+	"github.com/traefik/yaegi/interp"
+)
+
+// playgroundPackage hands interpreted code the option input of the eval
+// and takes the data of its result.
+const playgroundPackage = "playground"
+
+// playgroundIO is the input and the data of the running eval of a session.
+type playgroundIO struct {
+	input interface{}
+
+	mu   sync.Mutex
+	data []byte // JSON of the last SetOutput, nil for none
+}
+
+// playgroundSymbols returns the package playground of s: Input returns the
+// input of the eval as an object, nil if it is something else, and
+// InputValue as any JSON value; SetOutput marshals its argument with
+// encoding/json as the data of the result, failing for what has no JSON
+// form.
+func (s *session) playgroundSymbols() interp.Exports {
+	input := func() interface{} {
+		if pg := s.playground.Load(); pg != nil {
+			return pg.input
+		}
+		return nil
+	}
+	return interp.Exports{playgroundPackage + "/" + playgroundPackage: {
+		"Input": reflect.ValueOf(func() map[string]interface{} {
+			m, _ := input().(map[string]interface{})
+			return m
+		}),
+		"InputValue": reflect.ValueOf(input),
+		"SetOutput": reflect.ValueOf(func(v interface{}) error {
+			pg := s.playground.Load()
+			if pg == nil {
+				return errors.New("playground: no eval running")
+			}
+			data, err := json.Marshal(v)
+			if err != nil {
+				return fmt.Errorf("playground: %w", err)
+			}
+			pg.mu.Lock()
+			pg.data = data
+			pg.mu.Unlock()
+			return nil
+		}),
+	}}
+}
+
+// parseInput reads the option input of v, any JSON value, for the package
+// playground. A value JSON can't represent, a function, a symbol, a
+// bigint or a cycle, is an issue of opts instead.
+func parseInput(v js.Value, opts *evalOptions) {
+	in := v.Get("input")
+	if in.IsUndefined() {
+		return
+	}
+	value, err := jsonValue(in, "input", nil)
+	if err != nil {
+		opts.issues = append(opts.issues, optionIssue{"input", err.Error()})
+		return
+	}
+	opts.input = value
+}
+
+// jsonValue converts v, at path in the input, to the Go value decoding its
+// JSON would give, but for integers up to maxSafeInteger, which become
+// ints. As JSON.stringify does, it calls toJSON methods, drops undefined
+// properties and makes undefined elements null. parents are the objects
+// holding v.
+func jsonValue(v js.Value, path string, parents []js.Value) (interface{}, error) {
+	if isBigInt(v) {
+		return nil, fmt.Errorf("%s: a bigint has no JSON form", path)
+	}
+	switch v.Type() {
+	case js.TypeNull, js.TypeUndefined:
+		return nil, nil
+	case js.TypeBoolean:
+		return v.Bool(), nil
+	case js.TypeString:
+		return v.String(), nil
+	case js.TypeNumber:
+		f := v.Float()
+		switch {
+		case math.IsNaN(f) || math.IsInf(f, 0):
+			// As JSON.stringify does.
+			return nil, nil
+		case f == math.Trunc(f) && math.Abs(f) <= maxSafeInteger:
+			return int(f), nil
+		}
+		return f, nil
+	case js.TypeObject:
+	default:
+		return nil, fmt.Errorf("%s: a %s has no JSON form", path, v.Type())
+	}
+
+	if toJSON := v.Get("toJSON"); toJSON.Type() == js.TypeFunction {
+		return jsonValue(v.Call("toJSON"), path, parents)
+	}
+	for _, p := range parents {
+		if p.Equal(v) {
+			return nil, fmt.Errorf("%s: cycle", path)
+		}
+	}
+	parents = append(parents, v)
+	if js.Global().Get("Array").Call("isArray", v).Bool() {
+		items := make([]interface{}, v.Length())
+		for i := range items {
+			item, err := jsonValue(v.Index(i), path+"["+strconv.Itoa(i)+"]", parents)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = item
+		}
+		return items, nil
+	}
+	keys := js.Global().Get("Object").Call("keys", v)
+	obj := make(map[string]interface{}, keys.Length())
+	for i := 0; i < keys.Length(); i++ {
+		k := keys.Index(i).String()
+		p := v.Get(k)
+		if p.IsUndefined() {
+			continue
+		}
+		value, err := jsonValue(p, path+"."+k, parents)
+		if err != nil {
+			return nil, err
+		}
+		obj[k] = value
+	}
+	return obj, nil
+}
+
+// isBigInt reports whether v is a bigint, whose js.Value.Type panics.
+func isBigInt(v js.Value) bool {
+	toString := js.Global().Get("Object").Get("prototype").Get("toString")
+	return toString.Call("call", v).String() == "[object BigInt]"
+}
+
+// startPlayground gives the package playground of s the input of opts for
+// the eval starting, and returns the function ending it, which returns the
+// data the eval set, parsed, or undefined.
+func (s *session) startPlayground(opts evalOptions) (stop func() js.Value) {
+	s.playground.Store(&playgroundIO{input: opts.input})
+	return func() js.Value {
+		pg := s.playground.Swap(nil)
+		pg.mu.Lock()
+		defer pg.mu.Unlock()
+
+		if pg.data == nil {
+			return js.Undefined()
+		}
+		return js.Global().Get("JSON").Call("parse", string(pg.data))
+	}
+}
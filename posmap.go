@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// positionMap translates the positions of a rewritten source back to the
+// source it was rewritten from. The rewrites of evals record one each:
+// sourceMap for snippets, insertMap for those inserting text on a line,
+// such as replSource, instrumentTrace, echoSource, evalImporting and
+// instrumentSteps, and spliceMap for orderMapRanges.
+type positionMap interface {
+	position(line, column int) (int, int)
+}
+
+// positionChain composes the maps of the successive rewrites of a source,
+// the last rewrite first, so that it translates the positions of the code
+// evaluated to those of the user source. Nil entries are skipped.
+type positionChain []positionMap
+
+func (c positionChain) position(line, column int) (int, int) {
+	for _, m := range c {
+		if m != nil {
+			line, column = m.position(line, column)
+		}
+	}
+	return line, column
+}
+
+// remap translates through c the positions reported in result.
+func (c positionChain) remap(result map[string]interface{}) map[string]interface{} {
+	return remapPositions(result, c.position)
+}
+
+// remapPositions translates with position the positions in the evaluated
+// source reported in result: those of its error message, diagnostics and
+// stack.
+func remapPositions(result map[string]interface{}, position func(line, column int) (int, int)) map[string]interface{} {
+	if msg, ok := result["error"].(string); ok {
+		if p := positionedError.FindStringSubmatch(msg); p != nil && sourceName(p[1]) == "" {
+			line, _ := strconv.Atoi(p[2])
+			column, _ := strconv.Atoi(p[3])
+			line, column = position(line, column)
+			result["error"] = fmt.Sprintf("%d:%d: %s", line, column, p[4])
+		}
+	}
+	for _, key := range []string{"diagnostics", "stack"} {
+		items, _ := result[key].([]interface{})
+		for _, item := range items {
+			e := item.(map[string]interface{})
+			if file, _ := e["file"].(string); sourceName(file) != "" {
+				continue
+			}
+			line, column := position(e["line"].(int), e["column"].(int))
+			e["line"], e["column"] = line, column
+		}
+	}
+	return result
+}
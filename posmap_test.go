@@ -0,0 +1,137 @@
+package main
+
+import (
+	"cmp"
+	"strings"
+	"syscall/js"
+	"testing"
+)
+
+// The sources of the positions tests, each failing on its line 3: a type
+// error at column 16, or a panic in boom, declared there.
+const (
+	typeErrorProgram = `package main
+
+func main() { var s string = f(); _ = s }
+func f() int { for i := 0; i < 2; i++ { _ = i }; return 1 }
+`
+	typeErrorSnippet = `for i := 0; i < 2; i++ { _ = i }
+f := func() int { return 1 }
+var s string = f()
+_ = s
+`
+	importErrorProgram = `package main
+
+func main() { var n int = strings.ToUpper("x"); _ = n }
+`
+	panicProgram = `package main
+
+func boom() { for i := 0; i < 2; i++ { _ = i }; var a []int; _ = a[5] }
+func main() { boom() }
+`
+	panicSnippet = `for i := 0; i < 2; i++ { _ = i }
+n := 5
+func boom(n int) { var a []int; _ = a[n] }
+boom(n)
+`
+)
+
+func TestPositionsThroughRewrites(t *testing.T) {
+	steps := map[string]interface{}{"maxSteps": 10000}
+	wrap := map[string]interface{}{"mode": "snippet"}
+	all := map[string]interface{}{"mode": "snippet", "maxSteps": 10000, "tolerant": true}
+	for _, c := range []struct {
+		name    string
+		command string
+		src     string
+		opts    map[string]interface{}
+		column  int // of the type error, 0 for a panic in boom
+	}{
+		{"program", "eval", typeErrorProgram, nil, 30},
+		{"program instrumented", "eval", typeErrorProgram, steps, 30},
+		{"program instrumented tolerant", "eval", typeErrorProgram, map[string]interface{}{"maxSteps": 10000, "tolerant": true}, 30},
+		{"program auto-imported instrumented", "eval", importErrorProgram, map[string]interface{}{"autoImport": true, "maxSteps": 10000}, 27},
+		{"snippet", "eval", typeErrorSnippet, wrap, 16},
+		{"snippet instrumented", "eval", typeErrorSnippet, map[string]interface{}{"mode": "snippet", "maxSteps": 10000}, 16},
+		{"snippet instrumented tolerant", "eval", typeErrorSnippet, all, 16},
+		{"snippet traced", "eval", typeErrorSnippet, map[string]interface{}{"mode": "snippet", "maxSteps": 10000, "trace": true}, 16},
+		{"program panic instrumented", "eval", panicProgram, steps, 0},
+		{"snippet panic instrumented tolerant", "eval", panicSnippet, all, 0},
+		{"run program", "start", typeErrorProgram, nil, 30},
+		{"run snippet tolerant", "start", typeErrorSnippet, map[string]interface{}{"mode": "snippet", "tolerant": true}, 16},
+	} {
+		// Named, the positions are the same in the file.
+		for _, file := range []string{"", "scratch.go"} {
+			name, opts := c.name, map[string]interface{}{}
+			for k, v := range c.opts {
+				opts[k] = v
+			}
+			if file != "" {
+				name += " named"
+				opts["filename"] = file
+			}
+			t.Run(name, func(t *testing.T) {
+				t.Cleanup(func() { callAPI(t, "reset") })
+				res := callAPI(t, c.command, c.src, opts)
+				if res.Get("success").Truthy() {
+					t.Fatalf("%s succeeded: %s", c.command, jsonString(res))
+				}
+				if c.column == 0 {
+					f := frameOf(res.Get("stack"), "boom")
+					if f.IsUndefined() || f.Get("line").Int() != 3 {
+						t.Errorf("frame of boom = %s, want line 3 in %s", jsonString(f), jsonString(res))
+					} else if want := cmp.Or(file, "_.go"); f.Get("file").String() != want {
+						t.Errorf("frame of boom in %s, want %s", f.Get("file").String(), want)
+					}
+					return
+				}
+				d := res.Get("error").Get("diagnostics").Index(0)
+				if line, column := d.Get("line").Int(), d.Get("column").Int(); line != 3 || column != c.column {
+					t.Errorf("diagnostic at %d:%d, want 3:%d: %s", line, column, c.column, jsonString(d))
+				}
+				if got := d.Get("file"); file != "" && got.String() != file || file == "" && !got.IsUndefined() {
+					t.Errorf("diagnostic in %s, want %q", jsonString(got), file)
+				}
+			})
+		}
+	}
+}
+
+func TestFilenameAtRuntime(t *testing.T) {
+	t.Cleanup(func() { callAPI(t, "reset") })
+	named := map[string]interface{}{"filename": "scratch.go"}
+
+	res := callAPI(t, "eval", "package main\n\nfunc main() { _ = y }\n", named)
+	if got, want := res.Get("error").Get("message").String(), "scratch.go:3:19: undefined: y"; got != want {
+		t.Errorf("error = %q, want %q", got, want)
+	}
+
+	// The imports of a named file are its own, its declarations of the
+	// package.
+	mustSucceed(t, callAPI(t, "eval", `import "fmt"`, named))
+	mustSucceed(t, callAPI(t, "eval", `func boom() {
+	var a []int
+	fmt.Print(a[1])
+}`, named))
+	res = callAPI(t, "eval", "boom()")
+	if f := frameOf(res.Get("stack"), "boom"); f.Get("file").String() != "scratch.go" {
+		t.Errorf("frame of boom = %s, want it in scratch.go", jsonString(f))
+	}
+	if stderr := res.Get("stderr").String(); !strings.Contains(stderr, "scratch.go:") {
+		t.Errorf("stderr of the panic = %q, want the position in scratch.go", stderr)
+	}
+	if res = callAPI(t, "eval", "fmt.Sprint(1)"); res.Get("success").Bool() {
+		t.Errorf("fmt imported in scratch.go resolved in the default file: %s", jsonString(res))
+	}
+}
+
+// frameOf returns the frame of the function fn in the stack of a result,
+// or undefined.
+func frameOf(stack js.Value, fn string) js.Value {
+	for i := 0; stack.Type() == js.TypeObject && i < stack.Length(); i++ {
+		if f := stack.Index(i); f.Get("function").String() == "main."+fn || f.Get("function").String() == fn {
+			return f
+		}
+	}
+	return js.Undefined()
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"go/ast"
+	"go/token"
+	"reflect"
+	"strings"
+	"syscall/js"
+)
+
+// preludeFile names the positions of the prelude of a session, which the
+// results of its evals leave out, see panicStack.
+const preludeFile = "prelude.go"
+
+// prelude is the source a session evaluates before any eval, and again
+// after each reset.
+type prelude struct {
+	src    string
+	hidden map[string]bool // names left out of globals, for hiddenFromGlobals
+}
+
+// preludeSource returns src, with a package clause if it has none, placed
+// in preludeFile by a line directive, so that its positions are told apart
+// from those of the evals.
+func preludeSource(src string) string {
+	if f, err := parseFragment(src); err == nil && f.head == 0 {
+		return "//line " + preludeFile + ":1:1\n" + src
+	}
+	return "package main\n//line " + preludeFile + ":1:1\n" + src
+}
+
+// preludeNames returns the names src declares at the top level.
+func preludeNames(src string) map[string]bool {
+	names := map[string]bool{}
+	f, err := parseFragment(src)
+	if err != nil {
+		return names
+	}
+	for _, d := range f.file.Decls {
+		switch d := d.(type) {
+		case *ast.FuncDecl:
+			if d.Recv == nil {
+				names[d.Name.Name] = true
+			}
+		case *ast.GenDecl:
+			if d.Tok == token.IMPORT {
+				continue
+			}
+			for _, spec := range d.Specs {
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					names[spec.Name.Name] = true
+				case *ast.ValueSpec:
+					for _, n := range spec.Names {
+						names[n.Name] = true
+					}
+				}
+			}
+		}
+	}
+	return names
+}
+
+// applyPrelude evaluates the prelude of s, if any, in its interpreter, as
+// after a reset. It was checked when set, so that a failure is ignored.
+func (s *session) applyPrelude() {
+	s.mu.Lock()
+	p := s.prelude
+	s.mu.Unlock()
+	if p == nil {
+		return
+	}
+	defer func() { recover() }()
+	s.interpreter.Eval(preludeSource(p.src))
+}
+
+// dropPrelude clears the prelude of s, rebuilding its interpreter if it
+// had one. The caller holds the eval slot.
+func (s *session) dropPrelude() {
+	s.mu.Lock()
+	old := s.prelude
+	s.prelude = nil
+	s.mu.Unlock()
+	if old != nil {
+		s.rebuild()
+	}
+}
+
+// hiddenGlobal reports whether globals leaves out the name, declared by
+// the prelude of s with hiddenFromGlobals.
+func (s *session) hiddenGlobal(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.prelude != nil && s.prelude.hidden[name]
+}
+
+// setPrelude sets the source given as argument as the prelude of the
+// default session, or of the session of the option session: it is
+// evaluated at once, and again after every reset, for the helpers and the
+// data it declares to be there for the evals, whose positions stay their
+// own. With the option hiddenFromGlobals, globals leaves its declarations
+// out. The other options are those of eval; the result is that of the
+// eval of the prelude, whose positions are in prelude.go. A prelude that
+// fails is not kept. Replacing a prelude, or clearing it with an empty
+// source, rebuilds the interpreter first, as a reset keeping files,
+// environment and bindings; a session busy with an eval keeps both.
+func setPrelude(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 || args[0].Type() != js.TypeString {
+		return map[string]interface{}{
+			"success":   false,
+			"error":     "setPrelude requires the Go source of the prelude",
+			"errorCode": codeBadRequest,
+		}
+	}
+	o := optionArg(args, 1)
+	s, res := streamSession(o)
+	if res != nil {
+		return res
+	}
+	src := args[0].String()
+
+	// The old prelude goes with the eval slot, so that a busy session
+	// keeps it.
+	if strings.TrimSpace(src) == "" {
+		if err := s.acquireEval(context.Background(), false); err != nil {
+			return map[string]interface{}{
+				"success": false,
+				"error":   err.Error(),
+				"busy":    true,
+			}
+		}
+		defer s.releaseEval()
+		s.dropPrelude()
+		return map[string]interface{}{"success": true}
+	}
+
+	opts := parseEvalOptions(o)
+	res = runEvalFunc(s, src, opts, func(ctx context.Context) (reflect.Value, error) {
+		s.dropPrelude()
+		return s.interpreter.EvalWithContext(ctx, preludeSource(src))
+	})
+	if res["success"] != true {
+		return res
+	}
+	// yaegi makes up the value of declarations.
+	res["value"] = nil
+	delete(res, "valueType")
+	delete(res, "valueString")
+	p := &prelude{src: src}
+	if o.Type() == js.TypeObject && o.Get("hiddenFromGlobals").Truthy() {
+		p.hidden = preludeNames(src)
+	}
+	s.mu.Lock()
+	s.prelude = p
+	s.mu.Unlock()
+	return res
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"runtime/pprof"
+	"sort"
+)
+
+// profileTop is the number of functions in the summary of a profile.
+const profileTop = 20
+
+// startProfile starts the profile named kind for an eval. Only "cpu" is
+// known, and the runtime allows a single CPU profile at a time. The
+// returned function stops it and returns what is added to the result:
+// profileData, the gzipped pprof protobuf as a Uint8Array, and
+// profileSummary, the functions taking the most time as [{function,
+// flatMs, cumMs}]. The js/wasm port of the runtime has no profiling
+// timer, so profiles taken in browsers hold no samples so far.
+func startProfile(kind string) (func() map[string]interface{}, error) {
+	if kind != "cpu" {
+		return nil, fmt.Errorf("unknown profile %q, want \"cpu\"", kind)
+	}
+	buf := &bytes.Buffer{}
+	if err := pprof.StartCPUProfile(buf); err != nil {
+		return nil, err
+	}
+	return func() map[string]interface{} {
+		pprof.StopCPUProfile()
+		res := map[string]interface{}{
+			"profileData":    bytesToJS(buf.Bytes()),
+			"profileSummary": []interface{}{},
+		}
+		if summary, err := profileSummary(buf.Bytes()); err == nil {
+			res["profileSummary"] = summary
+		}
+		return res
+	}, nil
+}
+
+// profileSummary returns the profileTop functions of the CPU profile data
+// with the most flat time.
+func profileSummary(data []byte) ([]interface{}, error) {
+	p, err := parseProfile(data)
+	if err != nil {
+		return nil, err
+	}
+
+	type entry struct {
+		name      string
+		flat, cum int64
+	}
+	entries := map[uint64]*entry{}
+	get := func(fn uint64) *entry {
+		if entries[fn] == nil {
+			entries[fn] = &entry{name: p.str(p.functions[fn])}
+		}
+		return entries[fn]
+	}
+	for _, s := range p.samples {
+		// CPU profiles hold sample counts then nanoseconds.
+		if len(s.values) < 2 || len(s.locations) == 0 {
+			continue
+		}
+		ns := s.values[1]
+		seen := map[uint64]bool{}
+		for i, loc := range s.locations {
+			for j, fn := range p.locations[loc] {
+				if i == 0 && j == 0 {
+					get(fn).flat += ns
+				}
+				if !seen[fn] {
+					seen[fn] = true
+					get(fn).cum += ns
+				}
+			}
+		}
+	}
+
+	list := make([]*entry, 0, len(entries))
+	for _, e := range entries {
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].flat != list[j].flat {
+			return list[i].flat > list[j].flat
+		}
+		return list[i].cum > list[j].cum
+	})
+	summary := []interface{}{}
+	for _, e := range list[:min(len(list), profileTop)] {
+		summary = append(summary, map[string]interface{}{
+			"function": e.name,
+			"flatMs":   float64(e.flat) / 1e6,
+			"cumMs":    float64(e.cum) / 1e6,
+		})
+	}
+	return summary, nil
+}
+
+// cpuProfile holds the parts of a pprof profile used by profileSummary.
+type cpuProfile struct {
+	samples   []profileSample
+	locations map[uint64][]uint64 // function ids by location id, innermost first
+	functions map[uint64]int64    // name string index by function id
+	strings   []string
+}
+
+type profileSample struct {
+	locations []uint64 // leaf first
+	values    []int64
+}
+
+func (p *cpuProfile) str(i int64) string {
+	if i < 0 || i >= int64(len(p.strings)) {
+		return ""
+	}
+	return p.strings[i]
+}
+
+// parseProfile decodes the gzipped profile.proto message data.
+func parseProfile(data []byte) (*cpuProfile, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	raw, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &cpuProfile{locations: map[uint64][]uint64{}, functions: map[uint64]int64{}}
+	err = eachField(raw, func(field int, v uint64, b []byte) error {
+		switch field {
+		case 2: // sample
+			var s profileSample
+			err := eachField(b, func(field int, v uint64, b []byte) error {
+				switch field {
+				case 1:
+					return repeated(v, b, func(v uint64) { s.locations = append(s.locations, v) })
+				case 2:
+					return repeated(v, b, func(v uint64) { s.values = append(s.values, int64(v)) })
+				}
+				return nil
+			})
+			p.samples = append(p.samples, s)
+			return err
+		case 4: // location
+			var id uint64
+			var fns []uint64
+			err := eachField(b, func(field int, v uint64, b []byte) error {
+				switch field {
+				case 1:
+					id = v
+				case 4: // line
+					return eachField(b, func(field int, v uint64, _ []byte) error {
+						if field == 1 {
+							fns = append(fns, v)
+						}
+						return nil
+					})
+				}
+				return nil
+			})
+			p.locations[id] = fns
+			return err
+		case 5: // function
+			var id uint64
+			var name int64
+			err := eachField(b, func(field int, v uint64, _ []byte) error {
+				switch field {
+				case 1:
+					id = v
+				case 2:
+					name = int64(v)
+				}
+				return nil
+			})
+			p.functions[id] = name
+			return err
+		case 6: // string_table
+			p.strings = append(p.strings, string(b))
+		}
+		return nil
+	})
+	return p, err
+}
+
+var errBadProfile = errors.New("malformed profile")
+
+// eachField calls f with the fields of the protobuf message msg: varints
+// as v, length-delimited fields as b. Fixed-size fields are skipped.
+func eachField(msg []byte, f func(field int, v uint64, b []byte) error) error {
+	for len(msg) > 0 {
+		key, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return errBadProfile
+		}
+		msg = msg[n:]
+		var v uint64
+		var b []byte
+		switch key & 7 {
+		case 0:
+			if v, n = binary.Uvarint(msg); n <= 0 {
+				return errBadProfile
+			}
+			msg = msg[n:]
+		case 1:
+			if len(msg) < 8 {
+				return errBadProfile
+			}
+			msg = msg[8:]
+			continue
+		case 2:
+			size, n := binary.Uvarint(msg)
+			if n <= 0 || uint64(len(msg)-n) < size {
+				return errBadProfile
+			}
+			b = msg[n : n+int(size)]
+			msg = msg[n+int(size):]
+		case 5:
+			if len(msg) < 4 {
+				return errBadProfile
+			}
+			msg = msg[4:]
+			continue
+		default:
+			return errBadProfile
+		}
+		if err := f(int(key>>3), v, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// repeated calls f with the values of a repeated varint field, given
+// packed as b or one at a time as v.
+func repeated(v uint64, b []byte, f func(uint64)) error {
+	if b == nil {
+		f(v)
+		return nil
+	}
+	for len(b) > 0 {
+		v, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errBadProfile
+		}
+		f(v)
+		b = b[n:]
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"syscall/js"
+
+	"github.com/traefik/yaegi/interp"
+)
+
+// program is a compiled source held for yaegi.run.
+type program struct {
+	prog       *interp.Program
+	sourceCode string
+}
+
+var nextProgramID int
+
+// compileProgram compiles source code in the default session and returns a
+// handle to run it with yaegi.run. Handles stay valid until the session is
+// reset or the handle is freed. The stats of the result measure the
+// compilation, unless the options given as second argument disable them.
+func compileProgram(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 || len(args) > 2 || args[0].Type() != js.TypeString {
+		return map[string]interface{}{
+			"success": false,
+			"error":   "compile requires the Go source code and an optional options object",
+		}
+	}
+	sourceCode := args[0].String()
+	opts := parseEvalOptions(optionArg(args, 1))
+
+	s := defaultSession()
+	if err := s.acquireEval(context.Background(), false); err != nil {
+		return map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+			"busy":    true,
+		}
+	}
+	defer s.releaseEval()
+	s.describeEval(sourceCode)
+
+	guard := guardGoroutines(sourceCode)
+	var prog *interp.Program
+	var err error
+	var stats *evalStats
+	if opts.stats {
+		stats = startStats()
+	}
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("panic: %v", r)
+			}
+		}()
+		if guard.guarded {
+			if err = s.startGuard(""); err != nil {
+				return
+			}
+		}
+		prog, err = s.interpreter.Compile(guard.src)
+	}()
+	var res map[string]interface{}
+	if err = s.config.sandboxError(err); err != nil {
+		res = map[string]interface{}{
+			"success":     false,
+			"error":       err.Error(),
+			"errorCode":   errorCode(err),
+			"diagnostics": errorDiagnostics(err, guard.src, ""),
+		}
+		res = guard.unname(positionChain{guard.moved}.remap(res))
+	} else {
+		res = map[string]interface{}{
+			"success": true,
+			"handle":  s.addProgram(prog, sourceCode),
+		}
+	}
+	if stats != nil {
+		res["stats"] = stats.stop()
+	}
+	return res
+}
+
+// addProgram registers prog, compiled from sourceCode, and returns its
+// handle.
+func (s *session) addProgram(prog *interp.Program, sourceCode string) int {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	nextProgramID++
+	if s.programs == nil {
+		s.programs = map[int]*program{}
+	}
+	s.programs[nextProgramID] = &program{prog: prog, sourceCode: sourceCode}
+	return nextProgramID
+}
+
+// runProgram executes the program compiled under the handle given as first
+// argument, with the eval options as second argument.
+func runProgram(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 || len(args) > 2 || args[0].Type() != js.TypeNumber {
+		return map[string]interface{}{
+			"success": false,
+			"error":   "run requires a program handle and an optional options object",
+		}
+	}
+
+	opts := parseEvalOptions(optionArg(args, 1))
+	if opts.interactive && !opts.async {
+		return map[string]interface{}{
+			"success": false,
+			"error":   "interactiveStdin requires evalAsync or the async option",
+		}
+	}
+
+	s := defaultSession()
+	p := s.program(args[0].Int())
+	if p == nil {
+		return map[string]interface{}{
+			"success": false,
+			"error":   "program not found",
+		}
+	}
+	return runEvalFunc(s, p.sourceCode, opts, func(ctx context.Context) (reflect.Value, error) {
+		return s.interpreter.ExecuteWithContext(ctx, p.prog)
+	})
+}
+
+// freeProgram drops the program compiled under the handle given as first
+// argument.
+func freeProgram(this js.Value, args []js.Value) interface{} {
+	if len(args) != 1 || args[0].Type() != js.TypeNumber {
+		return map[string]interface{}{
+			"success": false,
+			"error":   "freeProgram requires a program handle",
+		}
+	}
+
+	s := defaultSession()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.programs[args[0].Int()] == nil {
+		return map[string]interface{}{
+			"success": false,
+			"error":   "program not found",
+		}
+	}
+	delete(s.programs, args[0].Int())
+	return map[string]interface{}{"success": true}
+}
+
+// program returns the program compiled under handle, or nil.
+func (s *session) program(handle int) *program {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.programs[handle]
+}
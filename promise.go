@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"syscall/js"
+)
+
+// Go code may only wait for a Promise while the JS event loop runs: in an
+// eval started by evalAsync, outside of the callbacks from JS to functions
+// made by js.FuncOf, which block the event loop until they return. The
+// event loop being the page's, callbacks counts those of all sessions.
+var (
+	asyncEvals atomic.Int32 // evals started by evalAsync running, see session.async
+	callbacks  atomic.Int32 // callbacks to js.FuncOf functions not returned
+)
+
+// errEventLoopBlocked fails the waits for a Promise that would deadlock.
+var errEventLoopBlocked = errors.New("cannot wait for JavaScript while the event loop is blocked: use evalAsync")
+
+// canAwait returns errEventLoopBlocked if waiting for a Promise in the
+// running eval of s would block the event loop that settles it. Code of
+// no known session, s being nil, may wait while any eval started by
+// evalAsync runs. Callers check it before starting the JS operation, whose
+// failure would otherwise go unhandled.
+func canAwait(s *session) error {
+	async := asyncEvals.Load() > 0
+	if s != nil {
+		async = s.async.Load()
+	}
+	if !async || callbacks.Load() > 0 {
+		return errEventLoopBlocked
+	}
+	return nil
+}
+
+// awaitPromise waits for the Promise p to settle and returns its value, or
+// a jsRejection with its reason.
+func awaitPromise(p js.Value) (js.Value, error) {
+	return awaitPromiseContext(context.Background(), p)
+}
+
+// awaitPromiseContext is awaitPromise, but returns the error of ctx once
+// done, see contextError, without waiting for p: its outcome is then
+// dropped, its callbacks released once it settles.
+func awaitPromiseContext(ctx context.Context, p js.Value) (js.Value, error) {
+	type outcome struct {
+		value js.Value
+		err   error
+	}
+	done := make(chan outcome, 1)
+	onResolve := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		done <- outcome{value: args[0]}
+		return nil
+	})
+	onReject := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		done <- outcome{err: jsRejection{args[0]}}
+		return nil
+	})
+	release := func() {
+		onResolve.Release()
+		onReject.Release()
+	}
+
+	p.Call("then", onResolve, onReject)
+	select {
+	case o := <-done:
+		release()
+		return o.value, o.err
+	case <-ctx.Done():
+		go func() {
+			<-done
+			release()
+		}()
+		return js.Undefined(), contextError(ctx)
+	}
+}
+
+// jsRejection is the reason a Promise was rejected with.
+type jsRejection struct {
+	reason js.Value
+}
+
+func (r jsRejection) Error() string {
+	if r.reason.Type() == js.TypeObject && r.reason.Get("message").Type() == js.TypeString {
+		return r.reason.Get("message").String()
+	}
+	return js.Global().Get("String").Invoke(r.reason).String()
+}
+
+// newPromise returns a JS Promise whose outcome is decided by run. run is
+// started on its own goroutine so the calling JS callback returns immediately.
+func newPromise(run func(resolve, reject func(interface{}))) js.Value {
+	executor := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		resolve, reject := args[0], args[1]
+		go run(
+			func(v interface{}) { resolve.Invoke(v) },
+			func(v interface{}) { reject.Invoke(v) },
+		)
+		return nil
+	})
+	// The Promise constructor calls the executor synchronously.
+	defer executor.Release()
+
+	return js.Global().Get("Promise").New(executor)
+}
+
+// rejectedPromise returns a Promise already rejected with reason.
+func rejectedPromise(reason interface{}) js.Value {
+	return js.Global().Get("Promise").Call("reject", reason)
+}
+
+// errorNames name the Errors of resultError by code, so that a catch block
+// can tell timeouts and cancellations apart. Others are YaegiError.
+var errorNames = map[string]string{
+	codeTimeout:   "YaegiTimeoutError",
+	codeCancelled: "YaegiCancelledError",
+}
+
+// resultError returns the Error rejecting a Promise of the async API for
+// the failed result res: its message is the error of res, and it carries
+// the name and code of the failure, diagnostics, output and stderr, and
+// the other fields of res.
+func resultError(res map[string]interface{}) js.Value {
+	props := map[string]interface{}{
+		"diagnostics": []interface{}{},
+		"output":      "",
+		"stderr":      "",
+	}
+	for k, v := range res {
+		if v != nil && k != "success" && k != "error" && k != "errorCode" {
+			props[k] = v
+		}
+	}
+	code := resultCode(res)
+	props["code"] = code
+	props["name"] = "YaegiError"
+	if name, ok := errorNames[code]; ok {
+		props["name"] = name
+	}
+	if !legacyCompat() {
+		props["apiVersion"] = apiVersion
+	}
+	msg, _ := res["error"].(string)
+	return newJSError(msg, props)
+}
+
+// settle settles a Promise of the async API with v, the result of a
+// command: a failed result rejects it with resultError, but resolves it
+// with legacyCompat, as before the envelope.
+func settle(v interface{}, resolve, reject func(interface{})) {
+	if res, ok := v.(map[string]interface{}); ok && res["success"] == false && !legacyCompat() {
+		reject(resultError(res))
+		return
+	}
+	resolve(envelopeValue(v))
+}
+
+// newJSError builds a JS Error with message and the given extra properties.
+func newJSError(message string, props map[string]interface{}) js.Value {
+	err := js.Global().Get("Error").New(message)
+	for k, v := range props {
+		err.Set(k, v)
+	}
+	return err
+}
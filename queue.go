@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errBusy = errors.New("interpreter busy")
+
+// evalSlot grants exclusive use of the interpreter of a session. Callers
+// that find it taken either fail with errBusy or, when queueEvals is
+// configured, wait in FIFO order for their turn. Each session has its own,
+// so that the evals of different sessions run side by side.
+type evalSlot struct {
+	mu     sync.Mutex
+	taken  bool
+	queue  []chan struct{}
+	holder evalInfo // while taken
+}
+
+// evalInfo describes what holds the eval slot, for status.
+type evalInfo struct {
+	started time.Time
+	source  string // first line of the code it runs, if any
+}
+
+// acquireEval takes the eval slot of s, waiting in line if queue is set.
+// It returns ctx.Err() if ctx is done before the slot is granted.
+func (s *session) acquireEval(ctx context.Context, queue bool) error {
+	slot := &s.slot
+	slot.mu.Lock()
+	if !slot.taken {
+		slot.taken = true
+		slot.holder = evalInfo{started: time.Now()}
+		slot.mu.Unlock()
+		return nil
+	}
+	if !queue {
+		slot.mu.Unlock()
+		return errBusy
+	}
+	turn := make(chan struct{})
+	slot.queue = append(slot.queue, turn)
+	slot.mu.Unlock()
+
+	select {
+	case <-turn:
+		return nil
+	case <-ctx.Done():
+	}
+
+	slot.mu.Lock()
+	for i, c := range slot.queue {
+		if c == turn {
+			slot.queue = append(slot.queue[:i], slot.queue[i+1:]...)
+			slot.mu.Unlock()
+			return ctx.Err()
+		}
+	}
+	slot.mu.Unlock()
+
+	// The slot was handed over while ctx was being cancelled: pass it on.
+	s.releaseEval()
+	return ctx.Err()
+}
+
+// releaseEval hands the eval slot of s to the next waiter, or frees it.
+func (s *session) releaseEval() {
+	slot := &s.slot
+	slot.mu.Lock()
+	defer slot.mu.Unlock()
+
+	if len(slot.queue) == 0 {
+		slot.taken = false
+		slot.holder = evalInfo{}
+		return
+	}
+	slot.holder = evalInfo{started: time.Now()}
+	next := slot.queue[0]
+	slot.queue = slot.queue[1:]
+	close(next)
+}
+
+// describeEval records source as the code run by the holder of the eval
+// slot of s, the caller.
+func (s *session) describeEval(source string) {
+	s.slot.mu.Lock()
+	defer s.slot.mu.Unlock()
+
+	s.slot.holder.source = firstLine(source)
+}
+
+// firstLine returns the first line of source that is not blank.
+func firstLine(source string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(source), "\n")
+	return strings.TrimSpace(line)
+}
+
+// evalState reports whether an eval of s is running, what it is, and how
+// many are waiting.
+func (s *session) evalState() (busy bool, current evalInfo, queued int) {
+	s.slot.mu.Lock()
+	defer s.slot.mu.Unlock()
+
+	return s.slot.taken, s.slot.holder, len(s.slot.queue)
+}
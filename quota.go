@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"syscall/js"
+	"time"
+)
+
+// quotaWindow is the period of the rates of the option quota of configure.
+const quotaWindow = time.Minute
+
+// quotaKeys are the keys of the option quota of configure.
+var quotaKeys = []string{"maxEvalsPerMinute", "maxConcurrentJobs", "maxTotalCpuMsPerMinute"}
+
+// quota bounds the use of each session, each bound 0 for none: the evals
+// it starts and the milliseconds they run per quotaWindow, and its jobs
+// queued or running.
+type quota struct {
+	evalsPerMinute int
+	concurrentJobs int
+	cpuMsPerMinute int
+}
+
+func (q quota) toJS() map[string]interface{} {
+	return map[string]interface{}{
+		"maxEvalsPerMinute":      q.evalsPerMinute,
+		"maxConcurrentJobs":      q.concurrentJobs,
+		"maxTotalCpuMsPerMinute": q.cpuMsPerMinute,
+	}
+}
+
+// parseQuota returns q with the bounds given by the option quota of
+// configure, v, or an error for one that is unknown or not a count.
+func parseQuota(v js.Value, q quota) (quota, error) {
+	if v.Type() != js.TypeObject || v.InstanceOf(js.Global().Get("Array")) {
+		return q, fmt.Errorf("quota must be an object with any of %s", strings.Join(quotaKeys, ", "))
+	}
+	keys := js.Global().Get("Object").Call("keys", v)
+	for i := 0; i < keys.Length(); i++ {
+		key := keys.Index(i).String()
+		if !slices.Contains(quotaKeys, key) {
+			return q, fmt.Errorf("quota.%s: unknown bound, expected one of %s", key, strings.Join(quotaKeys, ", "))
+		}
+		b := v.Get(key)
+		if b.Type() != js.TypeNumber || b.Float() < 0 || b.Float() != math.Trunc(b.Float()) || math.IsInf(b.Float(), 0) {
+			return q, fmt.Errorf("quota.%s: must be a non-negative integer, 0 for none", key)
+		}
+		switch key {
+		case "maxEvalsPerMinute":
+			q.evalsPerMinute = b.Int()
+		case "maxConcurrentJobs":
+			q.concurrentJobs = b.Int()
+		case "maxTotalCpuMsPerMinute":
+			q.cpuMsPerMinute = b.Int()
+		}
+	}
+	return q, nil
+}
+
+// currentQuota returns the quota configured.
+func currentQuota() quota {
+	settings.Lock()
+	defer settings.Unlock()
+
+	return settings.quota
+}
+
+// tokenBucket holds up to capacity tokens, given back at capacity per
+// quotaWindow.
+type tokenBucket struct {
+	capacity float64
+	tokens   float64
+	updated  time.Time
+}
+
+// fill gives b back the tokens due at now, for a bucket of capacity; one
+// whose capacity changed starts full.
+func (b *tokenBucket) fill(capacity int, now time.Time) {
+	c := float64(capacity)
+	if b.capacity != c {
+		b.capacity, b.tokens, b.updated = c, c, now
+		return
+	}
+	b.tokens = min(c, b.tokens+c*float64(now.Sub(b.updated))/float64(quotaWindow))
+	b.updated = now
+}
+
+// wait returns how long b takes to hold n tokens, 0 if it does.
+func (b *tokenBucket) wait(n float64) time.Duration {
+	if b.tokens >= n {
+		return 0
+	}
+	return time.Duration((n - b.tokens) / b.capacity * float64(quotaWindow))
+}
+
+// quotaState is the use of the quota by a session.
+type quotaState struct {
+	evals   tokenBucket
+	cpuMs   tokenBucket // may go below zero, charged once an eval ends
+	limited int         // calls refused
+}
+
+// admit spends an eval of the quota of s for a command about to run code
+// in it, or returns the result refusing it, see rateLimited. An eval
+// starts while a millisecond of CPU time is left, and may run past it.
+func (s *session) admit() map[string]interface{} {
+	q := currentQuota()
+	if q.evalsPerMinute == 0 && q.cpuMsPerMinute == 0 {
+		return nil
+	}
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var bound string
+	var wait time.Duration
+	if q.evalsPerMinute > 0 {
+		s.quota.evals.fill(q.evalsPerMinute, now)
+		if w := s.quota.evals.wait(1); w > wait {
+			bound, wait = "maxEvalsPerMinute", w
+		}
+	}
+	if q.cpuMsPerMinute > 0 {
+		s.quota.cpuMs.fill(q.cpuMsPerMinute, now)
+		if w := s.quota.cpuMs.wait(1); w > wait {
+			bound, wait = "maxTotalCpuMsPerMinute", w
+		}
+	}
+	if wait > 0 {
+		s.quota.limited++
+		return rateLimited(bound, wait)
+	}
+	if q.evalsPerMinute > 0 {
+		s.quota.evals.tokens--
+	}
+	return nil
+}
+
+// chargeCPU spends d, the time an eval of s ran, from its quota.
+func (s *session) chargeCPU(d time.Duration) {
+	q := currentQuota()
+	if q.cpuMsPerMinute == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.quota.cpuMs.fill(q.cpuMsPerMinute, time.Now())
+	s.quota.cpuMs.tokens -= float64(d) / float64(time.Millisecond)
+}
+
+// admitJob returns the result refusing a job of s, which has jobs queued
+// or running, if it has as many as the quota allows, or nil. The caller
+// holds jobsMu.
+func (s *session) admitJob(jobs int) map[string]interface{} {
+	q := currentQuota()
+	if q.concurrentJobs == 0 || jobs < q.concurrentJobs {
+		return nil
+	}
+	s.mu.Lock()
+	s.quota.limited++
+	s.mu.Unlock()
+	// A job ends when it does.
+	return rateLimited("maxConcurrentJobs", 0)
+}
+
+// rateLimited returns the result of a call refused for exceeding the bound
+// of the quota: {success: false, error, quota, retryAfterMs}, retryAfterMs
+// being when the call would be admitted, or null if that is unknown.
+func rateLimited(bound string, wait time.Duration) map[string]interface{} {
+	var retry interface{}
+	msg := "rate limited: " + bound + " exceeded"
+	if wait > 0 {
+		ms := int(math.Ceil(float64(wait) / float64(time.Millisecond)))
+		retry = ms
+		msg += fmt.Sprintf(", retry in %dms", ms)
+	}
+	return map[string]interface{}{
+		"success":      false,
+		"error":        msg,
+		"errorCode":    codeRateLimited,
+		"quota":        bound,
+		"retryAfterMs": retry,
+	}
+}
+
+// quotaInfo returns the use of the quota by s, for stats: {evalsLeft,
+// cpuMsLeft, jobs, limited}, the left counts null for no bound, limited
+// counting the calls refused.
+func (s *session) quotaInfo() map[string]interface{} {
+	q := currentQuota()
+	jobsMu.Lock()
+	jobs := 0
+	for _, j := range jobQueues[s] {
+		if j.state != "done" {
+			jobs++
+		}
+	}
+	jobsMu.Unlock()
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var evalsLeft, cpuLeft interface{}
+	if q.evalsPerMinute > 0 {
+		s.quota.evals.fill(q.evalsPerMinute, now)
+		evalsLeft = int(s.quota.evals.tokens)
+	}
+	if q.cpuMsPerMinute > 0 {
+		s.quota.cpuMs.fill(q.cpuMsPerMinute, now)
+		cpuLeft = int(s.quota.cpuMs.tokens)
+	}
+	return map[string]interface{}{
+		"evalsLeft": evalsLeft,
+		"cpuMsLeft": cpuLeft,
+		"jobs":      jobs,
+		"limited":   s.quota.limited,
+	}
+}
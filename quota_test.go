@@ -0,0 +1,44 @@
+package main
+
+import (
+	"syscall/js"
+	"testing"
+)
+
+func TestQuotaChargesProgramCommands(t *testing.T) {
+	mustSucceed(t, callAPI(t, "configure", map[string]interface{}{"quota": map[string]interface{}{"maxEvalsPerMinute": 3}}))
+	t.Cleanup(func() {
+		callAPI(t, "configure", map[string]interface{}{"quota": map[string]interface{}{"maxEvalsPerMinute": 0}})
+	})
+
+	const prog = "package main\n\nfunc F() int { return 1 }\n\nfunc main() {}\n"
+	commands := []struct {
+		name string
+		run  func() js.Value
+	}{
+		{"start", func() js.Value {
+			return callAPI(t, "start", prog)
+		}},
+		{"compare", func() js.Value {
+			return callAPI(t, "compare", prog, prog, "F", map[string]interface{}{"rounds": 1})
+		}},
+		{"debug", func() js.Value {
+			res := callAPI(t, "debug", prog)
+			if res.Get("success").Bool() {
+				callAPI(t, "debugStop")
+			}
+			return res
+		}},
+	}
+	// The three calls spend the quota, which then refuses each command.
+	for _, c := range commands {
+		if res := c.run(); !res.Get("success").Bool() {
+			t.Errorf("%s within the quota = %s, want success", c.name, jsonString(res))
+		}
+	}
+	for _, c := range commands {
+		if res := c.run(); errorCodeOf(res) != codeRateLimited {
+			t.Errorf("%s past the quota = %s, want code %s", c.name, jsonString(res), codeRateLimited)
+		}
+	}
+}
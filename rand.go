@@ -0,0 +1,75 @@
+package main
+
+import (
+	"math/rand"
+	"reflect"
+	"sync"
+
+	"github.com/traefik/yaegi/interp"
+)
+
+// lockedSource makes a math/rand source safe for the goroutines of the
+// interpreted code.
+type lockedSource struct {
+	mu  sync.Mutex
+	src rand.Source64
+}
+
+func (l *lockedSource) Int63() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.src.Int63()
+}
+
+func (l *lockedSource) Uint64() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.src.Uint64()
+}
+
+func (l *lockedSource) Seed(seed int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.src.Seed(seed)
+}
+
+// randSymbols returns the package-level functions of math/rand drawing
+// from r instead of the time-seeded source of the runtime. rand.New and
+// rand.NewSource are left as they are, as is crypto/rand.
+func randSymbols(r *rand.Rand) interp.Exports {
+	return interp.Exports{"math/rand/rand": {
+		"ExpFloat64":  reflect.ValueOf(r.ExpFloat64),
+		"Float32":     reflect.ValueOf(r.Float32),
+		"Float64":     reflect.ValueOf(r.Float64),
+		"Int":         reflect.ValueOf(r.Int),
+		"Int31":       reflect.ValueOf(r.Int31),
+		"Int31n":      reflect.ValueOf(r.Int31n),
+		"Int63":       reflect.ValueOf(r.Int63),
+		"Int63n":      reflect.ValueOf(r.Int63n),
+		"Intn":        reflect.ValueOf(r.Intn),
+		"NormFloat64": reflect.ValueOf(r.NormFloat64),
+		"Perm":        reflect.ValueOf(r.Perm),
+		"Read":        reflect.ValueOf(r.Read),
+		"Seed":        reflect.ValueOf(r.Seed),
+		"Shuffle":     reflect.ValueOf(r.Shuffle),
+		"Uint32":      reflect.ValueOf(r.Uint32),
+		"Uint64":      reflect.ValueOf(r.Uint64),
+	}}
+}
+
+// newSeededRand returns the source of the math/rand functions of a session
+// with the option randSeed.
+func newSeededRand(seed int64) *rand.Rand {
+	return rand.New(&lockedSource{src: rand.NewSource(seed).(rand.Source64)})
+}
+
+// reseedRand restarts the math/rand sequence of s, if it has a randSeed,
+// so that each eval draws the same numbers.
+func (s *session) reseedRand() {
+	if s.rand != nil {
+		s.rand.Seed(*s.config.randSeed)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"syscall/js"
+)
+
+// signalReady marks api as ready once it is installed: it sets api.ready,
+// dispatches a "yaegi-ready" CustomEvent on the global object when it is an
+// event target, and calls the global yaegiOnReady function if the host
+// defined one. The event detail and the callback argument are the version
+// object.
+func signalReady(api js.Value) {
+	global := js.Global()
+	version := versionInfo()
+	api.Set("ready", true)
+
+	if global.Get("dispatchEvent").Type() == js.TypeFunction && global.Get("CustomEvent").Type() == js.TypeFunction {
+		event := global.Get("CustomEvent").New("yaegi-ready", map[string]interface{}{"detail": version})
+		global.Call("dispatchEvent", event)
+	}
+	if onReady := global.Get("yaegiOnReady"); onReady.Type() == js.TypeFunction {
+		onReady.Invoke(version)
+	}
+}
+
+// whenReady returns a Promise resolved with the version object. The API is
+// only installed once initialized, so it is already resolved.
+func whenReady(this js.Value, args []js.Value) interface{} {
+	return js.Global().Get("Promise").Call("resolve", versionInfo())
+}
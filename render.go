@@ -0,0 +1,299 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall/js"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// renderWidth is the length up to which a composite value is rendered
+	// on one line.
+	renderWidth = 80
+	// renderIndent indents the elements of a value rendered on lines.
+	renderIndent = "  "
+)
+
+// renderLimits bounds the rendering of a value by renderValue.
+type renderLimits struct {
+	depth     int // of nested values shown, those past it elided as ...
+	maxItems  int // elements of a slice, an array, a map or a struct shown
+	maxString int // bytes of a string or a []byte shown
+}
+
+// defaultRender are the renderLimits unless configured.
+var defaultRender = renderLimits{depth: 6, maxItems: 100, maxString: 1024}
+
+// parseRenderLimits returns l with the options renderDepth,
+// renderMaxItems and renderMaxString of o set, those not positive left.
+func parseRenderLimits(o js.Value, l renderLimits) renderLimits {
+	if o.Type() != js.TypeObject {
+		return l
+	}
+	if n := optionInt(o, "renderDepth"); n > 0 {
+		l.depth = n
+	}
+	if n := optionInt(o, "renderMaxItems"); n > 0 {
+		l.maxItems = n
+	}
+	if n := optionInt(o, "renderMaxString"); n > 0 {
+		l.maxString = n
+	}
+	return l
+}
+
+// renderer renders a value, keeping the pointers, maps and slices on the
+// way to the one rendered to detect cycles.
+type renderer struct {
+	limits renderLimits
+	seen   map[visit]bool
+}
+
+// renderValue formats v for people: as Go composite literals, on one line
+// if short and indented otherwise, with the values past the depth of l
+// elided as ..., strings, byte slices and collections cut past the limits
+// of l with the count left out, a value reached again through itself shown
+// as <cycle> and the values of interfaces with their type.
+func renderValue(v reflect.Value, l renderLimits) string {
+	r := &renderer{limits: l, seen: map[visit]bool{}}
+	return r.render(v, 0, false)
+}
+
+// valueString returns renderValue of v, or nil if v is invalid.
+func valueString(v reflect.Value, l renderLimits) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	return renderValue(v, l)
+}
+
+// render renders v, at depth. With typed set, a value whose rendering does
+// not show its type, as a number, is shown converted to it.
+func (r *renderer) render(v reflect.Value, depth int, typed bool) string {
+	if !v.IsValid() {
+		return "nil"
+	}
+	t := v.Type()
+	if t == timeType && v.CanInterface() {
+		return "time.Time(" + v.Interface().(time.Time).Format(time.RFC3339Nano) + ")"
+	}
+
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.IsNil() {
+			return "nil"
+		}
+		return r.render(v.Elem(), depth, true)
+	case reflect.Ptr, reflect.Map, reflect.Slice:
+		if v.IsNil() {
+			return "(" + renderType(t) + ")(nil)"
+		}
+		// Pointers of interpreted types may have the placeholder type yaegi
+		// gives recursive fields.
+		key := visit{v.Pointer(), t}
+		if v.Kind() == reflect.Ptr {
+			key.typ = nil
+		}
+		if r.seen[key] {
+			return "<cycle " + renderType(t) + ">"
+		}
+		r.seen[key] = true
+		defer delete(r.seen, key)
+	}
+
+	switch v.Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128:
+		s := scalarString(v)
+		if typed && !defaultType(t) {
+			s = renderType(t) + "(" + s + ")"
+		}
+		return s
+	case reflect.String:
+		s := r.quote(v.String())
+		if typed && (t.Name() != "string" || t.PkgPath() != "") {
+			s = renderType(t) + "(" + s + ")"
+		}
+		return s
+	case reflect.Ptr:
+		if depth >= r.limits.depth {
+			return "&..."
+		}
+		elem := v.Elem()
+		switch elem.Kind() {
+		case reflect.Struct, reflect.Slice, reflect.Array, reflect.Map:
+			return "&" + r.render(elem, depth, false)
+		}
+		return "&" + r.render(elem, depth, true)
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return r.bytes(v)
+		}
+		if depth >= r.limits.depth {
+			return renderType(t) + "{...}"
+		}
+		n := min(v.Len(), r.limits.maxItems)
+		items := make([]string, n)
+		for i := range items {
+			items[i] = r.render(v.Index(i), depth+1, t.Elem().Kind() == reflect.Interface)
+		}
+		return composite(renderType(t), items, v.Len()-n)
+	case reflect.Map:
+		if depth >= r.limits.depth {
+			return renderType(t) + "{...}"
+		}
+		type entry struct{ key, value string }
+		var entries []entry
+		iter := v.MapRange()
+		for iter.Next() {
+			entries = append(entries, entry{
+				r.render(iter.Key(), depth+1, t.Key().Kind() == reflect.Interface),
+				r.render(iter.Value(), depth+1, t.Elem().Kind() == reflect.Interface),
+			})
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+		n := min(len(entries), r.limits.maxItems)
+		items := make([]string, n)
+		for i := range items {
+			items[i] = entries[i].key + ": " + entries[i].value
+		}
+		return composite(renderType(t), items, len(entries)-n)
+	case reflect.Struct:
+		name := ""
+		if t.Name() != "" {
+			name = renderType(t)
+		}
+		if depth >= r.limits.depth {
+			return name + "{...}"
+		}
+		n := min(v.NumField(), r.limits.maxItems)
+		items := make([]string, n)
+		for i := range items {
+			f := t.Field(i)
+			items[i] = fieldName(t, f) + ": " + r.render(v.Field(i), depth+1, f.Type.Kind() == reflect.Interface)
+		}
+		return composite(name, items, v.NumField()-n)
+	}
+
+	// Functions, channels and unsafe pointers have no literal.
+	if v.Kind() == reflect.Func || v.Kind() == reflect.Chan {
+		if v.IsNil() {
+			return "(" + renderType(t) + ")(nil)"
+		}
+	}
+	return "<" + renderType(t) + ">"
+}
+
+// composite renders the literal of type name with items, on one line if
+// it is short and no item spans lines, and the count of those left out.
+func composite(name string, items []string, omitted int) string {
+	if omitted > 0 {
+		items = append(items, fmt.Sprintf("... (%d more)", omitted))
+	}
+	line := name + "{" + strings.Join(items, ", ") + "}"
+	if len(line) <= renderWidth && !strings.Contains(line, "\n") {
+		return line
+	}
+	var b strings.Builder
+	b.WriteString(name + "{\n")
+	for _, item := range items {
+		b.WriteString(renderIndent + strings.ReplaceAll(item, "\n", "\n"+renderIndent) + ",\n")
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+// quote quotes s, cut past maxString bytes with the count left out.
+func (r *renderer) quote(s string) string {
+	if len(s) <= r.limits.maxString {
+		return strconv.Quote(s)
+	}
+	cut := r.limits.maxString
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return fmt.Sprintf("%s... (%d more bytes)", strconv.Quote(s[:cut]), len(s)-cut)
+}
+
+// bytes renders a byte slice or array as a conversion of its contents
+// quoted, cut past maxString bytes.
+func (r *renderer) bytes(v reflect.Value) string {
+	b := make([]byte, min(v.Len(), r.limits.maxString))
+	for i := range b {
+		b[i] = byte(v.Index(i).Uint())
+	}
+	name := renderType(v.Type())
+	if name == "[]uint8" {
+		name = "[]byte"
+	}
+	s := strconv.Quote(string(b))
+	if n := v.Len() - len(b); n > 0 {
+		s += fmt.Sprintf("... (%d more bytes)", n)
+	}
+	return name + "(" + s + ")"
+}
+
+// scalarString formats a boolean or a number.
+func scalarString(v reflect.Value) string {
+	switch v.Kind() {
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(v.Uint(), 10)
+	case reflect.Float32:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 32)
+	case reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 64)
+	}
+	return fmt.Sprint(v.Complex())
+}
+
+// defaultType reports whether t is the type of untyped constants of its
+// kind, which a number or a boolean is shown without.
+func defaultType(t reflect.Type) bool {
+	switch t.Name() {
+	case "bool", "int", "float64", "complex128":
+		return t.PkgPath() == ""
+	}
+	return false
+}
+
+// renderType names t, the struct types yaegi builds for interpreted types
+// abbreviated as struct{...}.
+func renderType(t reflect.Type) string {
+	if t.Name() != "" {
+		return t.String()
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return "*" + renderType(t.Elem())
+	case reflect.Slice:
+		return "[]" + renderType(t.Elem())
+	case reflect.Array:
+		return fmt.Sprintf("[%d]%s", t.Len(), renderType(t.Elem()))
+	case reflect.Map:
+		return "map[" + renderType(t.Key()) + "]" + renderType(t.Elem())
+	case reflect.Struct:
+		if t.NumField() > 0 {
+			return "struct{...}"
+		}
+	}
+	return t.String()
+}
+
+// fieldName returns the name of field of t in the source, without the "X"
+// yaegi exports the unexported fields of interpreted types with.
+func fieldName(t reflect.Type, field reflect.StructField) string {
+	if field.PkgPath == "" && !exportedField(t, field) {
+		return field.Name[1:]
+	}
+	return field.Name
+}
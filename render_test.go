@@ -0,0 +1,74 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files of the tests")
+
+// renderNode is a list, for cycles.
+type renderNode struct {
+	Value int
+	Next  *renderNode
+}
+
+// renderInner and renderOuter are nested structs.
+type renderInner struct {
+	Name string
+	Tags []string
+	Any  interface{}
+}
+
+type renderOuter struct {
+	ID    int
+	Inner renderInner
+	Ptr   *renderInner
+	Deep  [][]map[string][]int
+}
+
+func TestRenderGolden(t *testing.T) {
+	cyclic := &renderNode{Value: 1, Next: &renderNode{Value: 2}}
+	cyclic.Next.Next = cyclic
+	n := 7
+	many := make([]int, 150)
+	for i := range many {
+		many[i] = i
+	}
+	inner := renderInner{Name: "in", Tags: []string{"a", "b"}, Any: int8(3)}
+
+	for _, c := range []struct {
+		name   string
+		value  interface{}
+		limits renderLimits
+	}{
+		{"map", map[string]interface{}{"b": 2, "a": []int{1}, "c": nil, "d": uint(4)}, defaultRender},
+		{"struct", renderOuter{ID: 1, Inner: inner, Ptr: &inner, Deep: [][]map[string][]int{{{"k": {1, 2}}}}}, defaultRender},
+		{"struct_depth", renderOuter{ID: 1, Inner: inner, Deep: [][]map[string][]int{{{"k": {1, 2}}}}}, renderLimits{depth: 2, maxItems: 100, maxString: 1024}},
+		{"pointers", []interface{}{&n, &inner, (*int)(nil), new(*int)}, defaultRender},
+		{"cycle", cyclic, defaultRender},
+		{"bytes", [][]byte{[]byte("hi\x00\xff"), nil, []byte(strings.Repeat("x", 40))}, renderLimits{depth: 6, maxItems: 100, maxString: 16}},
+		{"long", []interface{}{strings.Repeat("é", 20), many}, renderLimits{depth: 6, maxItems: 10, maxString: 15}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			got := renderValue(reflect.ValueOf(c.value), c.limits) + "\n"
+			golden := filepath.Join("testdata", "render", c.name+".golden")
+			if *update {
+				if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != string(want) {
+				t.Errorf("renderValue =\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"sort"
+	"strings"
+)
+
+// replEdit is a change replSource made to a source, for session in replMode.
+type replEdit struct {
+	src   string    // rewritten source
+	types []string  // types declared for the first time, see recordReplTypes
+	moved insertMap // renames
+}
+
+// insertMap lists by line the columns at which a rewrite of a source
+// inserted text, with its length.
+type insertMap map[int][][2]int
+
+// position maps a line and column of the rewritten source to the source.
+func (m insertMap) position(line, column int) (int, int) {
+	shift := 0
+	for _, c := range m[line] {
+		switch at := c[0] + shift; {
+		case column < at:
+			return line, column - shift
+		case column < at+c[1]:
+			return line, c[0]
+		}
+		shift += c[1]
+	}
+	return line, column - shift
+}
+
+// replSource rewrites src so that it may declare again what the evals of
+// s declared, when s is in replMode. yaegi already lets a declaration
+// replace an earlier one, except for types, which keep their first
+// definition: a type declared again is given a fresh name, such as T_2,
+// and T made an alias of it, so that the code that follows sees the new
+// definition while values of the old type keep their methods. Within the
+// statements of src, a := declaring no new variable at top level becomes
+// an assignment.
+func (s *session) replSource(src string) replEdit {
+	edit := replEdit{src: src}
+	f, err := parseFragment(src)
+	if err != nil {
+		return edit
+	}
+	if f.body >= 0 {
+		edit.src = redefinedVars(f, src)
+		return edit
+	}
+
+	type rename struct {
+		at   int
+		name string
+	}
+	var renames []rename
+	var aliases []string
+	s.mu.Lock()
+	for _, d := range f.file.Decls {
+		g, ok := d.(*ast.GenDecl)
+		if !ok || g.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range g.Specs {
+			ts := spec.(*ast.TypeSpec)
+			name := ts.Name.Name
+			n := s.replTypes[name]
+			if n == 0 {
+				edit.types = append(edit.types, name)
+				continue
+			}
+			if ts.Assign.IsValid() {
+				continue
+			}
+			// Fresh names are not reused, even if the eval fails and leaves
+			// a partial definition behind.
+			n++
+			s.replTypes[name] = n
+			fresh := fmt.Sprintf("%s_%d", name, n)
+			for _, id := range typeNameUses(ts, name) {
+				renames = append(renames, rename{f.offset(id.End()), fresh[len(name):]})
+			}
+			aliases = append(aliases, fmt.Sprintf("type %s = %s", name, fresh))
+		}
+	}
+	s.mu.Unlock()
+	if renames == nil {
+		return edit
+	}
+
+	sort.Slice(renames, func(i, j int) bool { return renames[i].at < renames[j].at })
+	var b strings.Builder
+	edit.moved = insertMap{}
+	last := 0
+	for _, r := range renames {
+		b.WriteString(src[last:r.at])
+		b.WriteString(r.name)
+		last = r.at
+		line := strings.Count(src[:r.at], "\n") + 1
+		column := r.at - strings.LastIndex(src[:r.at], "\n")
+		edit.moved[line] = append(edit.moved[line], [2]int{column, len(r.name)})
+	}
+	b.WriteString(src[last:])
+	for _, a := range aliases {
+		b.WriteString("\n" + a)
+	}
+	edit.src = b.String()
+	return edit
+}
+
+// typeNameUses returns the identifiers naming the type name in its own
+// declaration ts, starting with the declared one. Field names and
+// selectors are other names.
+func typeNameUses(ts *ast.TypeSpec, name string) []*ast.Ident {
+	ids := []*ast.Ident{ts.Name}
+	var walk func(n ast.Node) bool
+	walk = func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.Ident:
+			if n.Name == name {
+				ids = append(ids, n)
+			}
+		case *ast.Field:
+			if n.Type != nil {
+				ast.Inspect(n.Type, walk)
+			}
+			return false
+		case *ast.SelectorExpr:
+			ast.Inspect(n.X, walk)
+			return false
+		}
+		return true
+	}
+	if ts.TypeParams != nil {
+		ast.Inspect(ts.TypeParams, walk)
+	}
+	ast.Inspect(ts.Type, walk)
+	return ids
+}
+
+// redefinedVars returns src, a list of statements parsed as f, with the
+// := at top level that declare no new variable turned into assignments.
+// The replacement keeps the columns of the source.
+func redefinedVars(f *fragment, src string) string {
+	var body *ast.BlockStmt
+	for _, d := range f.file.Decls {
+		if fn, ok := d.(*ast.FuncDecl); ok && fn.Body != nil {
+			body = fn.Body
+		}
+	}
+	if body == nil {
+		return src
+	}
+
+	b := []byte(src)
+	declared := map[string]bool{}
+	for _, st := range body.List {
+		if ds, ok := st.(*ast.DeclStmt); ok {
+			if g := ds.Decl.(*ast.GenDecl); g.Tok == token.VAR {
+				for _, spec := range g.Specs {
+					for _, id := range spec.(*ast.ValueSpec).Names {
+						declared[id.Name] = true
+					}
+				}
+			}
+		}
+		as, ok := st.(*ast.AssignStmt)
+		if !ok || as.Tok != token.DEFINE {
+			continue
+		}
+		fresh := false
+		for _, x := range as.Lhs {
+			if id, ok := x.(*ast.Ident); ok && id.Name != "_" && !declared[id.Name] {
+				declared[id.Name] = true
+				fresh = true
+			}
+		}
+		if !fresh {
+			o := f.offset(as.TokPos)
+			copy(b[o:], "= ")
+		}
+	}
+	return string(b)
+}
+
+// recordReplTypes notes the types a successful eval declared for the first
+// time, as returned by replSource.
+func (s *session) recordReplTypes(types []string) {
+	if len(types) == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.replTypes == nil {
+		s.replTypes = map[string]int{}
+	}
+	for _, name := range types {
+		s.replTypes[name] = max(s.replTypes[name], 1)
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+	"syscall/js"
+	"time"
+)
+
+const (
+	// resolveWait bounds the wait for a Promise of the import resolver.
+	resolveWait = 10 * time.Second
+	// maxResolveDepth caps the chain of imports of resolved packages.
+	maxResolveDepth = 8
+)
+
+// errResolver rejects an importResolver option that is not a function.
+var errResolver = errors.New("importResolver requires a function or null")
+
+// importResolver returns the importResolver setting, undefined if unset.
+func importResolver() js.Value {
+	settings.Lock()
+	defer settings.Unlock()
+
+	return settings.importResolver
+}
+
+// forgetResolved drops the imports resolved by every session, whose
+// resolver changed.
+func forgetResolved() {
+	for _, s := range allSessions() {
+		s.mu.Lock()
+		s.resolved = nil
+		s.mu.Unlock()
+	}
+}
+
+// pendingImport is an import resolveImports looks up.
+type pendingImport struct {
+	path  string
+	root  importUse // import of the source it comes from
+	depth int       // count of resolved packages it is imported through
+}
+
+// resolveImports hands the imports of src, and recursively those of the
+// packages it gets, that s knows neither from its symbols nor from the
+// GOPATH of its filesystem, to the importResolver setting, and adds the
+// package sources it returns as addPackage does. It returns the failed
+// result of an eval of src if the resolver fails, or nil. A resolver
+// returning null leaves the import to fail as usual; either outcome is
+// kept by s for later evals.
+func (s *session) resolveImports(src string, opts evalOptions) map[string]interface{} {
+	resolver := importResolver()
+	if resolver.Type() != js.TypeFunction {
+		return nil
+	}
+	known := map[string]bool{}
+	for _, syms := range s.config.symbols() {
+		for key := range syms {
+			known[path.Dir(key)] = true
+		}
+	}
+
+	var queue []pendingImport
+	for _, u := range sourceImports(src) {
+		queue = append(queue, pendingImport{path: u.path, root: u})
+	}
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		if known[p.path] {
+			continue
+		}
+		known[p.path] = true
+		if s.hasSourcePackage(p.path) {
+			// Its imports may have failed to resolve before.
+			s.mu.Lock()
+			files := s.packages[p.path]
+			s.mu.Unlock()
+			for _, imp := range fileImports(files) {
+				queue = append(queue, pendingImport{path: imp, root: p.root, depth: p.depth + 1})
+			}
+			continue
+		}
+		s.mu.Lock()
+		_, done := s.resolved[p.path]
+		s.mu.Unlock()
+		if done {
+			continue
+		}
+
+		var files map[string][]byte
+		err := errors.New("it is imported through too many resolved packages")
+		if p.depth < maxResolveDepth {
+			files, err = callResolver(resolver, p.path, opts.async)
+		}
+		if err != nil {
+			return resolveFailure(p, err)
+		}
+		s.mu.Lock()
+		if s.resolved == nil {
+			s.resolved = map[string]bool{}
+		}
+		s.resolved[p.path] = files != nil
+		if files != nil {
+			if s.packages == nil {
+				s.packages = map[string]map[string][]byte{}
+			}
+			s.packages[p.path] = files
+		}
+		s.mu.Unlock()
+		if files == nil {
+			continue
+		}
+
+		writePackageFiles(s.files, p.path, files)
+		for _, imp := range fileImports(files) {
+			queue = append(queue, pendingImport{path: imp, root: p.root, depth: p.depth + 1})
+		}
+	}
+	return nil
+}
+
+// hasSourcePackage reports whether the filesystem of s holds Go files of
+// the package importPath.
+func (s *session) hasSourcePackage(importPath string) bool {
+	entries, _ := fs.ReadDir(s.files, path.Join("src", importPath))
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".go") {
+			return true
+		}
+	}
+	return false
+}
+
+// callResolver calls resolver with importPath and returns the files of the
+// package it returns, {files: {"name.go": source}}, or nil for null. It
+// may wait for a Promise only if async is set, the eval being off the
+// event loop.
+func callResolver(resolver js.Value, importPath string, async bool) (files map[string][]byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("the importResolver threw: %v", r)
+		}
+	}()
+	v := resolver.Invoke(importPath)
+	if v.Type() == js.TypeObject && v.Get("then").Type() == js.TypeFunction {
+		if !async || callbacks.Load() > 0 {
+			return nil, errors.New("the importResolver returned a Promise, which requires evalAsync or the async option")
+		}
+		type outcome struct {
+			value js.Value
+			err   error
+		}
+		done := make(chan outcome, 1)
+		go func() {
+			value, err := awaitPromise(v)
+			done <- outcome{value, err}
+		}()
+		select {
+		case o := <-done:
+			if o.err != nil {
+				return nil, fmt.Errorf("the importResolver failed: %w", o.err)
+			}
+			v = o.value
+		case <-time.After(resolveWait):
+			return nil, fmt.Errorf("the importResolver did not settle within %v", resolveWait)
+		}
+	}
+
+	if v.IsNull() || v.IsUndefined() {
+		return nil, nil
+	}
+	if v.Type() != js.TypeObject || v.Get("files").Type() != js.TypeObject {
+		return nil, errors.New("the importResolver must return {files} or null")
+	}
+	filesValue := v.Get("files")
+	files = map[string][]byte{}
+	keys := js.Global().Get("Object").Call("keys", filesValue)
+	for i := 0; i < keys.Length(); i++ {
+		name := keys.Index(i).String()
+		code := filesValue.Get(name)
+		if code.Type() != js.TypeString {
+			return nil, fmt.Errorf("the importResolver gave a source of %s that is not a string", name)
+		}
+		if !goFileName(name) {
+			return nil, fmt.Errorf("the importResolver gave %s, which is not a Go file name", name)
+		}
+		files[name] = []byte(code.String())
+	}
+	if len(files) == 0 {
+		return nil, errors.New("the importResolver gave no Go files")
+	}
+	return files, nil
+}
+
+// fileImports returns the import paths of files.
+func fileImports(files map[string][]byte) []string {
+	var list []string
+	fset := token.NewFileSet()
+	for name, data := range files {
+		f, err := parser.ParseFile(fset, name, data, parser.ImportsOnly)
+		if err != nil {
+			continue
+		}
+		for _, spec := range f.Imports {
+			if p, err := strconv.Unquote(spec.Path.Value); err == nil {
+				list = append(list, p)
+			}
+		}
+	}
+	return list
+}
+
+// resolveFailure returns the failed result of an eval whose import p could
+// not be resolved for err, located at the import of the source it comes
+// from.
+func resolveFailure(p pendingImport, err error) map[string]interface{} {
+	msg := fmt.Sprintf("import %q: %v", p.path, err)
+	if p.path != p.root.path {
+		msg = fmt.Sprintf("import %q: resolving %q: %v", p.root.path, p.path, err)
+	}
+	diag := diagnostic{line: p.root.line, column: p.root.column, message: msg, severity: "error"}
+	if p.root.line > 0 {
+		msg = fmt.Sprintf("%d:%d: %s", p.root.line, p.root.column, msg)
+	}
+	return map[string]interface{}{
+		"success":     false,
+		"error":       msg,
+		"errorCode":   codeResolve,
+		"diagnostics": []interface{}{diag.toJS()},
+		"output":      "",
+		"stderr":      "",
+	}
+}
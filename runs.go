@@ -0,0 +1,438 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall/js"
+	"time"
+
+	"github.com/traefik/yaegi/interp"
+)
+
+// stopGrace is how long a stopped run has to return before it is reported
+// abandoned.
+const stopGrace = time.Second
+
+// run is a program started by startRun, running in the background on an
+// interpreter of its own, so that the session stays usable meanwhile.
+type run struct {
+	id      int
+	session *session
+	started time.Time
+	source  string // first line of the program, see status
+	cancel  context.CancelFunc
+	output  atomic.Int64 // stdout and stderr bytes written
+	// goroutines running, counted apart from those of the session evals
+	goroutines atomic.Int64
+	cmd        commandLine // its own os.Args and flag.CommandLine
+
+	mu        sync.Mutex
+	stopping  bool // stop was called
+	abandoned bool // it ignored stop for stopGrace
+}
+
+// Active runs by id, abandoned ones included until they return.
+var (
+	runsMu    sync.Mutex
+	runs      = map[int]*run{}
+	nextRunID int
+)
+
+// startRun compiles the Go program given as first argument and runs it in
+// the background, returning {success, id} at once. Its output goes to the
+// onStdout and onStderr callbacks of the options, and onExit is called with
+// its result when it returns. It runs in the default session, or in the
+// one of the option session, on an interpreter of its own: it sees the
+// session files and packages but none of its globals, and evals can go on
+// meanwhile. The options also take stdin, args, timeoutMs, binaryOutput,
+// mode and filename, and maxOutputBytes and abortOnOutputLimit as for
+// evals, the result then reporting outputTruncated and droppedBytes. A
+// session runs at most maxRuns programs at once, see configure.
+func startRun(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 || len(args) > 2 || args[0].Type() != js.TypeString {
+		return map[string]interface{}{
+			"success": false,
+			"error":   "start requires the Go source code and an optional options object",
+		}
+	}
+	sourceCode := args[0].String()
+	o := optionArg(args, 1)
+	opts := parseEvalOptions(o)
+
+	s := defaultSession()
+	if o.Type() == js.TypeObject && o.Get("session").Type() == js.TypeNumber {
+		if s = lookupSession(o.Get("session").Int()); s == nil {
+			return map[string]interface{}{
+				"success": false,
+				"error":   "session not found",
+			}
+		}
+	}
+	if res := s.admit(); res != nil {
+		return res
+	}
+	var onExit js.Value
+	if o.Type() == js.TypeObject && o.Get("onExit").Type() == js.TypeFunction {
+		onExit = o.Get("onExit")
+	}
+
+	code := sourceCode
+	var sn *snippet
+	if opts.snippet {
+		if sn = wrapSnippet(sourceCode); sn != nil {
+			code = strings.Join(sn.lines, "\n")
+		}
+	}
+	guard := guardGoroutines(code)
+	// The rewrites, from the last to the first.
+	chain := positionChain{guard.moved}
+	if sn != nil {
+		chain = append(chain, sn.srcMap)
+	}
+	file := sourceFile(code, opts.filename)
+	remap := func(res map[string]interface{}) map[string]interface{} {
+		res = guard.unname(chain.remap(unnameSource(res, file)))
+		if opts.filename != "" {
+			res = nameSource(res, opts.filename)
+		}
+		return res
+	}
+
+	r := &run{session: s, started: time.Now(), source: firstLine(sourceCode)}
+	var limit *outputLimit
+	if opts.maxOutput > 0 {
+		limit = &outputLimit{max: opts.maxOutput}
+		if opts.abortOnOutputLimit {
+			// Set before the program starts writing.
+			limit.exceed = func() { r.cancel() }
+		}
+	}
+	stdout, stderr := &captureWriter{}, &captureWriter{}
+	if opts.binaryOutput {
+		stdout.startBinary(false, jsByteStream(opts.onStdout), limit)
+	} else {
+		stdout.start(false, jsStream(opts.onStdout), limit)
+	}
+	stderr.start(false, jsStream(opts.onStderr), limit)
+	errOut := countingWriter{runEvents{stderr, r, "stderr"}, &r.output}
+	i := s.interpreterWith(s.files, nil, strings.NewReader(opts.stdin), countingWriter{runEvents{stdout, r, "stdout"}, &r.output}, errOut)
+	ctx := context.Background()
+	// Runs have their own context, set before they start.
+	i.Use(contextSymbols(func() context.Context { return ctx }))
+	if s.config.allows("net/http") {
+		// Runs are off the JS callbacks, so their requests may wait.
+		i.Use(httpSymbols(fetchTransport{detached: true}))
+	}
+
+	i.Use(s.goSymbols(&r.goroutines))
+	// Evals set the args of the session meanwhile.
+	r.cmd.stderr = errOut
+	if opts.args != nil {
+		r.cmd.set(opts.args)
+	} else {
+		r.cmd.set(s.config.args)
+	}
+	i.Use(s.argSymbols(&r.cmd))
+
+	d, call, prog, err := compileRun(i, guard, file)
+	if err = s.config.sandboxError(err); err != nil {
+		s.chargeCPU(time.Since(r.started))
+		return remap(map[string]interface{}{
+			"success":     false,
+			"error":       err.Error(),
+			"errorCode":   errorCode(err),
+			"diagnostics": errorDiagnostics(err, guard.src, ""),
+		})
+	}
+
+	if opts.timeout > 0 {
+		ctx, r.cancel = context.WithTimeout(context.Background(), opts.timeout)
+	} else {
+		ctx, r.cancel = context.WithCancel(context.Background())
+	}
+	if err := addRun(r); err != nil {
+		r.cancel()
+		return map[string]interface{}{
+			"success":   false,
+			"error":     err.Error(),
+			"errorCode": codeLimit,
+		}
+	}
+
+	go func() {
+		defer r.cancel()
+		out := r.execute(ctx, d, call, prog)
+		s.chargeCPU(time.Since(r.started))
+		stdout.stop()
+		stderr.stop()
+		removeRun(r.id)
+
+		res := resultMap(guard.src, out.value, out.err, "", "", renderSettings())
+		delete(res, "output")
+		delete(res, "stderr")
+		res["id"] = r.id
+		res["uptimeMs"] = time.Since(r.started).Milliseconds()
+		res["outputBytes"] = r.output.Load()
+		res["abandoned"] = r.isAbandoned()
+		if dropped := limit.droppedBytes(); dropped > 0 {
+			res["outputTruncated"] = true
+			res["droppedBytes"] = dropped
+			if opts.abortOnOutputLimit {
+				delete(res, "cancelled")
+				res["success"] = false
+				res["error"] = "output limit exceeded"
+				res["errorCode"] = codeLimit
+			}
+		}
+		emitEvent(eventRunStopped, s.id, 0, map[string]interface{}{
+			"runId":     r.id,
+			"success":   out.err == nil,
+			"abandoned": res["abandoned"],
+			"uptimeMs":  res["uptimeMs"],
+		})
+		if onExit.Type() == js.TypeFunction {
+			onExit.Invoke(envelope(remap(res)))
+		}
+	}()
+
+	return map[string]interface{}{
+		"success": true,
+		"id":      r.id,
+	}
+}
+
+// compileRun compiles the code of guard in i, named file if any, with the
+// call running it through the driver d. The call is compiled first, so that
+// it does not run main, which yaegi does when evaluating in the main
+// package.
+func compileRun(i *interp.Interpreter, guard guardEdit, file string) (d *driver, call, prog *interp.Program, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = internalPanic{r}
+		}
+	}()
+	if d, err = newDriver(i); err != nil {
+		return nil, nil, nil, err
+	}
+	if call, err = i.Compile("_yaegidriver.Run()"); err != nil {
+		return nil, nil, nil, err
+	}
+	if guard.guarded {
+		if _, err = i.Eval(`import _yaegigo "` + goPackage + `"`); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	prog, err = i.Compile(namedSource(guard.src, file))
+	return d, call, prog, err
+}
+
+// runOutcome is the value and error a program returned with.
+type runOutcome struct {
+	value reflect.Value
+	err   error
+}
+
+// execute runs prog until it returns or ctx is done. A program still
+// running stopGrace after ctx is done is marked abandoned, and execute
+// waits for it to return.
+func (r *run) execute(ctx context.Context, d *driver, call, prog *interp.Program) runOutcome {
+	var res runOutcome
+	done := make(chan struct{})
+	d.run = func() {
+		defer close(done)
+		res.value, res.err = d.i.Execute(prog)
+	}
+
+	_, err := d.i.ExecuteWithContext(ctx, call)
+	if ctx.Err() == nil {
+		if err != nil {
+			return runOutcome{err: err}
+		}
+		<-done
+		return res
+	}
+
+	select {
+	case <-done:
+	case <-time.After(stopGrace):
+		r.mu.Lock()
+		r.abandoned = true
+		r.mu.Unlock()
+		<-done
+	}
+	return runOutcome{err: ctx.Err()}
+}
+
+func (r *run) isAbandoned() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.abandoned
+}
+
+// stop cancels the context of r.
+func (r *run) stop() {
+	r.mu.Lock()
+	r.stopping = true
+	r.mu.Unlock()
+
+	r.cancel()
+}
+
+// state names the state of r for listRuns.
+func (r *run) state() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch {
+	case r.abandoned:
+		return "abandoned"
+	case r.stopping:
+		return "stopping"
+	}
+	return "running"
+}
+
+// addRun registers r under a new id, unless its session already has
+// maxRuns runs that are not abandoned.
+func addRun(r *run) error {
+	runsMu.Lock()
+	defer runsMu.Unlock()
+
+	if limit := maxRuns(); limit > 0 {
+		n := 0
+		for _, other := range runs {
+			if other.session == r.session && !other.isAbandoned() {
+				n++
+			}
+		}
+		if n >= limit {
+			return fmt.Errorf("too many runs: the session is at its limit of %d (maxRuns)", limit)
+		}
+	}
+	nextRunID++
+	r.id = nextRunID
+	runs[r.id] = r
+	return nil
+}
+
+func removeRun(id int) {
+	runsMu.Lock()
+	defer runsMu.Unlock()
+
+	delete(runs, id)
+}
+
+func lookupRun(id int) *run {
+	runsMu.Lock()
+	defer runsMu.Unlock()
+
+	return runs[id]
+}
+
+// stopRuns stops the runs of s.
+func (s *session) stopRuns() {
+	runsMu.Lock()
+	defer runsMu.Unlock()
+
+	for _, r := range runs {
+		if r.session == s {
+			r.stop()
+		}
+	}
+}
+
+// stopRun stops the run whose id is given as argument. It returns at once:
+// the onExit callback of the run tells when it has returned, cancelled or
+// abandoned if it ignored the stop.
+func stopRun(this js.Value, args []js.Value) interface{} {
+	if len(args) != 1 || args[0].Type() != js.TypeNumber {
+		return map[string]interface{}{
+			"success": false,
+			"error":   "stop requires a run id",
+		}
+	}
+	r := lookupRun(args[0].Int())
+	if r == nil {
+		return map[string]interface{}{
+			"success": false,
+			"error":   "run not found",
+		}
+	}
+	r.stop()
+	return map[string]interface{}{"success": true}
+}
+
+// listRuns returns the active runs by id: {id, session, state, startedAt,
+// uptimeMs, outputBytes}, with state "running", "stopping" or "abandoned".
+func listRuns(this js.Value, args []js.Value) interface{} {
+	runsMu.Lock()
+	active := make([]*run, 0, len(runs))
+	for _, r := range runs {
+		active = append(active, r)
+	}
+	runsMu.Unlock()
+	sort.Slice(active, func(i, j int) bool { return active[i].id < active[j].id })
+
+	list := make([]interface{}, len(active))
+	for i, r := range active {
+		list[i] = map[string]interface{}{
+			"id":          r.id,
+			"session":     r.session.id,
+			"state":       r.state(),
+			"startedAt":   r.started.UnixMilli(),
+			"uptimeMs":    time.Since(r.started).Milliseconds(),
+			"outputBytes": r.output.Load(),
+		}
+	}
+	return list
+}
+
+// oldestRun returns the earliest of the runs not abandoned, or nil.
+func oldestRun() *run {
+	runsMu.Lock()
+	defer runsMu.Unlock()
+
+	var oldest *run
+	for _, r := range runs {
+		if !r.isAbandoned() && (oldest == nil || r.started.Before(oldest.started)) {
+			oldest = r
+		}
+	}
+	return oldest
+}
+
+// countingWriter writes to w, adding the bytes written to n.
+type countingWriter struct {
+	w io.Writer
+	n *atomic.Int64
+}
+
+func (c countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// runEvents writes to w, emitting what is written as run-output events of
+// the run r.
+type runEvents struct {
+	w      io.Writer
+	r      *run
+	stream string
+}
+
+func (e runEvents) Write(p []byte) (int, error) {
+	emitEvent(eventRunOutput, e.r.session.id, 0, map[string]interface{}{
+		"runId":  e.r.id,
+		"stream": e.stream,
+		"data":   string(p),
+	})
+	return e.w.Write(p)
+}
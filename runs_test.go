@@ -0,0 +1,70 @@
+package main
+
+import (
+	"strings"
+	"syscall/js"
+	"testing"
+	"time"
+)
+
+func TestRunArgsAndOutputLimit(t *testing.T) {
+	t.Cleanup(func() { callAPI(t, "reset") })
+	var output strings.Builder
+	onStdout := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		output.WriteString(args[0].String())
+		return nil
+	})
+	defer onStdout.Release()
+	exited := make(chan js.Value, 1)
+	onExit := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		exited <- args[0]
+		return nil
+	})
+	defer onExit.Release()
+
+	run := callAPI(t, "start", `package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+func main() {
+	port := flag.Int("port", 0, "")
+	flag.Parse()
+	fmt.Println(*port)
+	fmt.Print(strings.Repeat("x", 100))
+}
+`, map[string]interface{}{
+		"args":           []interface{}{"server", "-port=8080"},
+		"maxOutputBytes": 10,
+		"onStdout":       onStdout,
+		"onExit":         onExit,
+	})
+	mustSucceed(t, run)
+	// An eval meanwhile sets the args of the session, not those of the run.
+	mustSucceed(t, callAPI(t, "eval", `package main
+
+import "flag"
+
+func main() {
+	flag.Bool("other", false, "")
+	flag.Parse()
+}
+`, map[string]interface{}{"args": []interface{}{"main", "-other"}}))
+
+	var res js.Value
+	select {
+	case res = <-exited:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the run did not exit")
+	}
+	mustSucceed(t, res)
+	if got, want := output.String(), "8080\nxxxxx"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+	if !res.Get("outputTruncated").Bool() || res.Get("droppedBytes").Int() != 95 {
+		t.Errorf("exit result = %s, want the output truncated by 95 bytes", jsonString(res))
+	}
+}
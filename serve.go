@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go/token"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"syscall/js"
+	"time"
+	"unicode/utf8"
+)
+
+var handlerFuncType = reflect.TypeOf((func(http.ResponseWriter, *http.Request))(nil))
+
+// defaultServeTimeout is the time serveHTTP waits for the handler unless
+// the request sets timeoutMs.
+const defaultServeTimeout = 30 * time.Second
+
+// registerHandler sets the handler serveHTTP dispatches to in the default
+// session: the function or the value with a ServeHTTP method defined by
+// evaluated code under the name given as argument. Without a name, requests
+// go back to the handlers registered on http.DefaultServeMux.
+func registerHandler(this js.Value, args []js.Value) interface{} {
+	if len(args) > 1 || len(args) == 1 && args[0].Type() != js.TypeString && !args[0].IsNull() && !args[0].IsUndefined() {
+		return map[string]interface{}{
+			"success": false,
+			"error":   "registerHandler requires the name of a handler, or none to use http.DefaultServeMux",
+		}
+	}
+
+	s := defaultSession()
+	if err := s.acquireEval(context.Background(), false); err != nil {
+		return map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+			"busy":    true,
+		}
+	}
+	defer s.releaseEval()
+
+	if len(args) == 0 || args[0].Type() != js.TypeString {
+		s.setHandler(nil)
+		return map[string]interface{}{"success": true}
+	}
+
+	h, err := s.lookupHandler(args[0].String())
+	if err != nil {
+		return map[string]interface{}{
+			"success": false,
+			"error":   "registerHandler: " + err.Error(),
+		}
+	}
+	s.setHandler(h)
+	return map[string]interface{}{"success": true}
+}
+
+// lookupHandler returns the handler defined under name in s.
+func (s *session) lookupHandler(name string) (http.Handler, error) {
+	for _, part := range strings.Split(name, ".") {
+		if !token.IsIdentifier(part) {
+			return nil, fmt.Errorf("invalid handler name %q", name)
+		}
+	}
+
+	v, err := s.interpreter.Eval(name)
+	if err != nil {
+		return nil, err
+	}
+	if v.IsValid() && v.CanInterface() {
+		if h, ok := v.Interface().(http.Handler); ok {
+			return h, nil
+		}
+	}
+	// Methods of interpreted types are only reachable as method values.
+	if !v.IsValid() || v.Kind() != reflect.Func {
+		if v, err = s.interpreter.Eval(name + ".ServeHTTP"); err != nil {
+			return nil, fmt.Errorf("%s is neither a handler function nor an http.Handler", name)
+		}
+	}
+	if !v.Type().ConvertibleTo(handlerFuncType) {
+		return nil, fmt.Errorf("%s has type %s, want func(http.ResponseWriter, *http.Request)", name, v.Type())
+	}
+	return http.HandlerFunc(v.Convert(handlerFuncType).Interface().(func(http.ResponseWriter, *http.Request))), nil
+}
+
+func (s *session) setHandler(h http.Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.handler = h
+}
+
+// httpHandler returns the handler of the requests of serveHTTP.
+func (s *session) httpHandler() http.Handler {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.handler != nil {
+		return s.handler
+	}
+	return s.serveMux
+}
+
+// serveHTTP serves a synthetic request {method, url, headers, body} with
+// the handler set by registerHandler, or else with http.DefaultServeMux as
+// seen by interpreted code, and returns the response: {success, status,
+// headers, body, output, stderr}. Request header values are arrays or
+// strings, those of the response arrays, and the request body a string or
+// a Uint8Array. The response body is a string, or a Uint8Array if it is not
+// valid UTF-8 or the request sets binaryBody. A panic of the handler fails
+// the call and leaves the session usable, as http.Server does. A handler
+// still running after the request timeoutMs, 30 seconds by default, fails
+// it with a timeout: its request context is cancelled and the interpreter,
+// which it may hold the state of, rebuilt.
+func serveHTTP(this js.Value, args []js.Value) interface{} {
+	if len(args) != 1 || args[0].Type() != js.TypeObject {
+		return map[string]interface{}{
+			"success": false,
+			"error":   "serveHTTP requires a request object {method, url, headers, body}",
+		}
+	}
+	req, err := requestFromJS(args[0])
+	if err != nil {
+		return map[string]interface{}{
+			"success": false,
+			"error":   "serveHTTP: " + err.Error(),
+		}
+	}
+
+	s := defaultSession()
+	if res := s.admit(); res != nil {
+		return res
+	}
+	if err := s.acquireEval(context.Background(), false); err != nil {
+		return map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+			"busy":    true,
+		}
+	}
+	defer s.releaseEval()
+	s.describeEval("serveHTTP " + req.Method + " " + req.URL.String())
+	started := time.Now()
+	defer func() { s.chargeCPU(time.Since(started)) }()
+
+	timeout := defaultServeTimeout
+	if ms := optionInt(args[0], "timeoutMs"); ms > 0 {
+		timeout = time.Duration(ms) * time.Millisecond
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	defer cancel()
+	req = req.WithContext(ctx)
+
+	rec := httptest.NewRecorder()
+	s.stdout.start(true, nil, nil)
+	s.stderr.start(true, nil, nil)
+	handler := s.httpHandler()
+	done := make(chan error, 1)
+	go func() {
+		var err error
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("panic: %v", r)
+				if e, ok := r.(exitStatus); ok {
+					err = e
+				}
+			}
+			done <- err
+		}()
+		handler.ServeHTTP(rec, req)
+	}()
+	timedOut := false
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		timedOut = true
+	}
+	output := s.stdout.stop()
+	stderr := s.stderr.stop()
+	if timedOut {
+		s.rebuild()
+		return map[string]interface{}{
+			"success":   false,
+			"error":     fmt.Sprintf("serveHTTP: timed out after %v", timeout),
+			"errorCode": codeTimeout,
+			"output":    output,
+			"stderr":    stderr,
+			"recovered": true,
+		}
+	}
+	if code, ok := exitCode(err); ok {
+		return exitResult(code, output, stderr)
+	}
+	if err != nil {
+		return map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+			"output":  output,
+			"stderr":  stderr,
+		}
+	}
+
+	res := rec.Result()
+	// Joining the values would merge those of Set-Cookie, which may
+	// hold commas.
+	headers := map[string]interface{}{}
+	for key, values := range res.Header {
+		list := make([]interface{}, len(values))
+		for i, value := range values {
+			list[i] = value
+		}
+		headers[key] = list
+	}
+	body := rec.Body.Bytes()
+	var jsBody interface{} = string(body)
+	if args[0].Get("binaryBody").Truthy() || !utf8.Valid(body) {
+		jsBody = bytesToJS(body)
+	}
+	return map[string]interface{}{
+		"success": true,
+		"status":  res.StatusCode,
+		"headers": headers,
+		"body":    jsBody,
+		"output":  output,
+		"stderr":  stderr,
+		"error":   nil,
+	}
+}
+
+// requestFromJS builds the request described by the JS object v.
+func requestFromJS(v js.Value) (req *http.Request, err error) {
+	method := http.MethodGet
+	if m := v.Get("method"); m.Type() == js.TypeString {
+		method = strings.ToUpper(m.String())
+	}
+	target := "/"
+	if u := v.Get("url"); u.Type() == js.TypeString {
+		target = u.String()
+	}
+	var body io.Reader
+	switch b := jsToGo(v.Get("body")).(type) {
+	case nil:
+	case string:
+		body = strings.NewReader(b)
+	case []byte:
+		body = bytes.NewReader(b)
+	default:
+		return nil, fmt.Errorf("body must be a string or a Uint8Array")
+	}
+
+	// httptest.NewRequest panics on malformed requests.
+	defer func() {
+		if r := recover(); r != nil {
+			req, err = nil, fmt.Errorf("%v", r)
+		}
+	}()
+	req = httptest.NewRequest(method, target, body)
+
+	headers := v.Get("headers")
+	if headers.Type() != js.TypeObject {
+		return req, nil
+	}
+	keys := js.Global().Get("Object").Call("keys", headers)
+	for i := 0; i < keys.Length(); i++ {
+		key := keys.Index(i).String()
+		switch value := jsToGo(headers.Get(key)).(type) {
+		case []interface{}:
+			for _, e := range value {
+				req.Header.Add(key, fmt.Sprint(e))
+			}
+		default:
+			req.Header.Add(key, fmt.Sprint(value))
+		}
+	}
+	return req, nil
+}
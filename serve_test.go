@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+func TestServeHTTP(t *testing.T) {
+	t.Cleanup(func() {
+		callAPI(t, "registerHandler")
+		callAPI(t, "reset")
+	})
+	mustSucceed(t, callAPI(t, "eval", `import (
+	"net/http"
+	"time"
+)`))
+	mustSucceed(t, callAPI(t, "eval", `func Cookies(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{Name: "a", Value: "1", Expires: time.Unix(0, 0)})
+	http.SetCookie(w, &http.Cookie{Name: "b", Value: "2"})
+}
+
+func Slow(w http.ResponseWriter, r *http.Request) {
+	<-r.Context().Done()
+	time.Sleep(time.Hour)
+}`))
+
+	mustSucceed(t, callAPI(t, "registerHandler", "Cookies"))
+	res := callAPI(t, "serveHTTP", map[string]interface{}{"url": "/"})
+	mustSucceed(t, res)
+	cookies := res.Get("headers").Get("Set-Cookie")
+	if cookies.Length() != 2 || cookies.Index(1).String() != "b=2" {
+		t.Errorf("Set-Cookie = %s, want the two cookies", jsonString(cookies))
+	}
+
+	mustSucceed(t, callAPI(t, "registerHandler", "Slow"))
+	res = callAPI(t, "serveHTTP", map[string]interface{}{"url": "/", "timeoutMs": 100})
+	if code := errorCodeOf(res); code != codeTimeout || !res.Get("recovered").Bool() {
+		t.Fatalf("serveHTTP of a stuck handler = %s, want code %s and the session recovered", jsonString(res), codeTimeout)
+	}
+	mustSucceed(t, callAPI(t, "eval", "1"))
+}
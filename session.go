@@ -0,0 +1,427 @@
+package main
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"math/rand"
+	"net/http"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"syscall/js"
+	"time"
+
+	"github.com/traefik/yaegi/interp"
+)
+
+// session is an independent interpreter with its own standard streams and
+// environment. Sessions share no mutable state but their registry, and
+// the evals of different sessions may run at the same time.
+type session struct {
+	id          int
+	interpreter *interp.Interpreter
+	slot        evalSlot    // use of the interpreter, see acquireEval
+	async       atomic.Bool // the running eval was started by evalAsync, see canAwait
+	syncEval    atomic.Bool // the running eval blocks the event loop, see nestedSession
+	stdin       *inputReader
+	stdout      *captureWriter
+	stderr      *captureWriter
+	config      sessionConfig
+	files       *memFS // seen by os functions of interpreted code
+	createdAt   time.Time
+	pure        bool // denied the filesystem and the network, see cachedEval
+
+	cmd commandLine // set for the running evaluation, see setArgs
+
+	serveMux     *http.ServeMux                 // http.DefaultServeMux of interpreted code
+	rand         *rand.Rand                     // math/rand source with a randSeed, see reseedRand
+	clock        atomic.Pointer[clock]          // time of interpreted code, nil for the real time
+	budget       atomic.Pointer[stepBudget]     // budget of the budgeted eval running, see evalSteps
+	budgets      sync.Map                       // by id, the budgets of evals that started goroutines, see tickBudget
+	budgetInterp *interp.Interpreter            // the interpreter importing the budget package
+	heap         atomic.Pointer[heapWatch]      // watch of the running eval, see runEvalFunc
+	trace        atomic.Pointer[lineTrace]      // trace of the running eval, see instrumentTrace
+	traceInterp  *interp.Interpreter            // the interpreter importing the trace package
+	guardInterp  *interp.Interpreter            // the interpreter importing the package of guardGoroutines
+	goroutines   atomic.Int64                   // goroutines of the session interpreters running, see goSymbols
+	mapsInterp   *interp.Interpreter            // the interpreter importing the package of orderMapRanges
+	namedImports map[string]*interp.Interpreter // by package and file, as the Interp fields for named files, see importOnce
+
+	goexit     atomic.Pointer[[]goroutine]  // stacks of a runtime.Goexit of the eval, see goexitSymbols
+	playground atomic.Pointer[playgroundIO] // input and data of the running eval, see playgroundSymbols
+
+	mu          sync.Mutex
+	feed        *stdinPipe                          // interactive stdin, see writeStdin
+	packages    map[string]map[string][]byte        // sources by import path, see addPackage
+	programs    map[int]*program                    // compiled by the interpreter, by handle
+	bound       map[string]map[string]reflect.Value // symbols registered with bind, by import path
+	funcs       []sessionFunc                       // created by interpreted code, see funcOf
+	hostIO      map[int]*hostStream                 // writers and readers, see createWriter
+	nextHostIO  int                                 // last id given by addHostStream
+	env         map[string]string                   // environment of interpreted code, see envSymbols
+	prelude     *prelude                            // evaluated after each reset, see setPrelude
+	watches     map[int]*watch                      // expressions of addWatch, by id
+	nextWatch   int                                 // last id given by addWatch
+	counters    evalCounters                        // of the evals, see recordEval
+	quota       quotaState                          // use of the quota of configure, see admit
+	history     []historyEntry                      // successful evals, see exportSession
+	fatalErrors int                                 // evaluations ended by a fatal error, see isFatal
+	broken      bool                                // a fatal error requires a reset, see handleFatal
+	handler     http.Handler                        // set by registerHandler, see serveHTTP
+	autoImports map[string]bool                     // packages added by autoImport, see importsSeen
+	resolved    map[string]bool                     // imports given to the importResolver, whether it had them
+	replTypes   map[string]int                      // definitions of the types declared in replMode, see replSource
+	cancelFuncs map[int]context.CancelFunc          // of the running and queued evals, by id, see trackEval
+	nextEvalID  int                                 // last id given by trackEval
+	evalCtx     context.Context                     // of the last eval, see evalContext
+}
+
+// defaultSessionID identifies the session used by eval and reset.
+const defaultSessionID = 0
+
+var (
+	sessionsMu    sync.Mutex
+	sessions      = map[int]*session{}
+	nextSessionID = defaultSessionID
+)
+
+// newSession creates and registers a session.
+func newSession(config sessionConfig) *session {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	s := buildSession(nextSessionID, config, false)
+	nextSessionID++
+	sessions[s.id] = s
+	return s
+}
+
+// buildSession creates a session, pure for the throwaway ones of
+// cachedEval, without registering it.
+func buildSession(id int, config sessionConfig, pure bool) *session {
+	s := &session{
+		id:        id,
+		stdin:     &inputReader{},
+		stdout:    &captureWriter{},
+		stderr:    &captureWriter{},
+		config:    config,
+		files:     newMemFS(),
+		createdAt: time.Now(),
+		pure:      pure,
+	}
+	s.cmd.stderr = s.stderr
+	s.resetEnv()
+	s.interpreter = s.newInterpreter()
+	return s
+}
+
+// newInterpreter builds an interpreter from the session configuration, with
+// its standard streams wired to the session capture writers.
+func (s *session) newInterpreter() *interp.Interpreter {
+	// Resolving source imports on the host filesystem would block on the JS
+	// event loop, so they come from the session filesystem.
+	s.setArgs(s.config.args)
+	s.serveMux = http.NewServeMux()
+	i := s.interpreterFor(s.files, nil)
+	if s.config.allows("net/http") {
+		// The handlers served by serveHTTP.
+		i.Use(serveMuxSymbols(s.serveMux))
+	}
+	s.rand = nil
+	if s.config.randSeed != nil && s.config.allows("math/rand") {
+		// The source of this interpreter, for reseedRand.
+		s.rand = newSeededRand(*s.config.randSeed)
+		i.Use(randSymbols(s.rand))
+	}
+	i.Use(s.budgetSymbols())
+	i.Use(s.traceSymbols())
+	i.Use(s.goSymbols(&s.goroutines))
+	i.Use(mapsSymbols())
+	return i
+}
+
+// interpreterFor builds a session interpreter resolving source imports
+// from src, laid out as a GOPATH, and building them with the build tags
+// tags besides js and wasm.
+func (s *session) interpreterFor(src fs.FS, tags []string) *interp.Interpreter {
+	return s.interpreterWith(src, tags, s.stdin, s.stdout, s.stderr)
+}
+
+// interpreterWith is like interpreterFor, with the given standard streams
+// instead of those of the session.
+func (s *session) interpreterWith(src fs.FS, tags []string, stdin io.Reader, stdout, stderr io.Writer) *interp.Interpreter {
+	i := interp.New(interp.Options{
+		GoPath:               ".",
+		BuildTags:            tags,
+		Stdin:                stdin,
+		Stdout:               stdout,
+		Stderr:               stderr,
+		Env:                  s.config.env,
+		Unrestricted:         s.config.unrestricted,
+		SourcecodeFilesystem: src,
+	})
+	for _, syms := range s.config.symbols() {
+		i.Use(syms)
+	}
+	i.Use(exitSymbols(i))
+	i.Use(s.argSymbols(&s.cmd))
+	if s.config.randSeed != nil && s.config.allows("math/rand") {
+		// Each interpreter draws the sequence from its start.
+		i.Use(randSymbols(newSeededRand(*s.config.randSeed)))
+	}
+	i.Use(contextSymbols(s.evalContext))
+	if s.config.allows("os") {
+		i.Use(s.envSymbols())
+	}
+	if s.config.allows("os") {
+		i.Use(s.files.osSymbols(s.fsAllows))
+	}
+	if s.config.allows("runtime") {
+		i.Use(s.goexitSymbols())
+	}
+	if s.config.allows("crypto/rand") {
+		i.Use(cryptoRandSymbols())
+	}
+	if s.config.allows("time") {
+		i.Use(s.clockSymbols())
+		i.Use(locationSymbols())
+	}
+	if s.config.allows("net/http") {
+		i.Use(httpSymbols(fetchTransport{session: s}))
+		i.Use(serveMuxSymbols(http.NewServeMux()))
+	}
+	if s.config.allows(hostioPackage) {
+		i.Use(s.hostioSymbols())
+	}
+	if s.config.allows(playgroundPackage) {
+		i.Use(s.playgroundSymbols())
+	}
+	if s.config.allows("syscall/js") {
+		i.Use(interp.Exports{"syscall/js/js": {"FuncOf": reflect.ValueOf(s.funcOf)}})
+	}
+	// The symbols of bind, as rebind registers them.
+	s.mu.Lock()
+	for pkg, syms := range s.bound {
+		i.Use(boundExports(pkg, syms))
+	}
+	s.mu.Unlock()
+	return i
+}
+
+// reset replaces the session interpreter with a fresh one, releasing the
+// JS functions, writers, readers, programs and bindings of the previous one,
+// and evaluates the prelude in it.
+func (s *session) reset() {
+	s.takeBindings()
+	s.interpreter = s.newInterpreter()
+	s.releaseFuncs()
+	s.releaseHostIO()
+
+	s.mu.Lock()
+	s.programs = nil
+	s.handler = nil
+	s.history = nil
+	s.autoImports = nil
+	s.replTypes = nil
+	s.broken = false
+	s.mu.Unlock()
+	s.applyPrelude()
+}
+
+// info describes the session for listSessions, with its usage.
+func (s *session) info() map[string]interface{} {
+	info := s.usageInfo(false)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info["id"] = s.id
+	info["fatalErrors"] = s.fatalErrors
+	info["createdAt"] = s.createdAt.UnixMilli()
+	return info
+}
+
+// lookupSession returns the session with the given id, or nil.
+func lookupSession(id int) *session {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	return sessions[id]
+}
+
+func defaultSession() *session {
+	return lookupSession(defaultSessionID)
+}
+
+// allSessions returns the registered sessions, by id.
+func allSessions() []*session {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	list := make([]*session, 0, len(sessions))
+	for _, s := range sessions {
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].id < list[j].id })
+	return list
+}
+
+// sessionArg resolves the session id passed as args[0].
+func sessionArg(args []js.Value) (*session, map[string]interface{}) {
+	if len(args) < 1 || args[0].Type() != js.TypeNumber {
+		return nil, map[string]interface{}{
+			"success": false,
+			"error":   "a session id is required",
+		}
+	}
+	s := lookupSession(args[0].Int())
+	if s == nil {
+		return nil, map[string]interface{}{
+			"success": false,
+			"error":   "session not found",
+		}
+	}
+	return s, nil
+}
+
+// createSession creates a session and returns its id.
+func createSession(this js.Value, args []js.Value) interface{} {
+	s := newSession(parseSessionConfig(optionArg(args, 0), sessionConfig{}))
+
+	return map[string]interface{}{
+		"success": true,
+		"id":      s.id,
+	}
+}
+
+// evalIn evaluates source code in the session given as first argument.
+func evalIn(this js.Value, args []js.Value) interface{} {
+	s, errResult := sessionArg(args)
+	if errResult != nil {
+		return errResult
+	}
+	return evalWith(s, "evalIn", args[1:])
+}
+
+// resetSession resets the session given as first argument, with the
+// options of reset as second argument.
+func resetSession(this js.Value, args []js.Value) interface{} {
+	s, errResult := sessionArg(args)
+	if errResult != nil {
+		return errResult
+	}
+	return resetWith(s, optionArg(args, 1))
+}
+
+// destroySession drops the session given as first argument, closes its
+// stdin pipe and stops its runs. Later calls using its id fail with
+// "session not found". It fails as busy while an eval of the session runs
+// or waits; cancel it first.
+func destroySession(this js.Value, args []js.Value) interface{} {
+	s, errResult := sessionArg(args)
+	if errResult != nil {
+		return errResult
+	}
+	if s.id == defaultSessionID {
+		return map[string]interface{}{
+			"success": false,
+			"error":   "the default session cannot be destroyed",
+		}
+	}
+	// The slot is never released: a call still holding s finds it busy
+	// rather than a nil interpreter.
+	if err := s.acquireEval(context.Background(), false); err != nil {
+		return map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+			"busy":    true,
+		}
+	}
+
+	s.destroy()
+	return map[string]interface{}{"success": true}
+}
+
+// destroy unregisters s, closes its stdin pipe and stops its runs.
+func (s *session) destroy() {
+	sessionsMu.Lock()
+	delete(sessions, s.id)
+	sessionsMu.Unlock()
+
+	s.closeFeed()
+	s.stopRuns()
+	stopDebug(s)
+	s.releaseFuncs()
+	s.releaseHostIO()
+	s.interpreter = nil
+}
+
+// listSessions returns the live sessions with their stats, by id.
+func listSessions(this js.Value, args []js.Value) interface{} {
+	sessionsMu.Lock()
+	ids := make([]int, 0, len(sessions))
+	for id := range sessions {
+		ids = append(ids, id)
+	}
+	sessionsMu.Unlock()
+	sort.Ints(ids)
+
+	list := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		if s := lookupSession(id); s != nil {
+			list = append(list, s.info())
+		}
+	}
+	return list
+}
+
+func resetInterpreter(this js.Value, args []js.Value) interface{} {
+	return resetWith(defaultSession(), optionArg(args, 0))
+}
+
+// resetWith resets s, clearing its filesystem but for added packages, its
+// bindings and the environment set since it was configured. The options
+// object opts may keep them with keepFiles, keepBindings and keepEnv;
+// clearEnv, which reset took before keepEnv, clears the environment
+// whatever keepEnv. os.Args and flag.CommandLine go back to the configured
+// args, which no option keeps, as each eval sets them anew. It fails as
+// busy while an eval runs, rather than swap the interpreter under it.
+func resetWith(s *session, opts js.Value) map[string]interface{} {
+	if err := s.acquireEval(context.Background(), false); err != nil {
+		return map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+			"busy":    true,
+		}
+	}
+	defer s.releaseEval()
+
+	keep := func(key string) bool {
+		return opts.Type() == js.TypeObject && opts.Get(key).Truthy()
+	}
+	keepEnv := keep("keepEnv") && !keep("clearEnv")
+
+	if !keep("keepFiles") {
+		s.files.clear()
+		s.restorePackages()
+	}
+	if !keepEnv {
+		s.resetEnv()
+	}
+	bound := s.takeBindings()
+	s.reset()
+	if keep("keepBindings") {
+		s.rebind(bound)
+	}
+	emitEvent(eventSessionReset, s.id, 0, map[string]interface{}{
+		"keepFiles":    keep("keepFiles"),
+		"keepBindings": keep("keepBindings"),
+		"keepEnv":      keepEnv,
+	})
+
+	return map[string]interface{}{
+		"success": true,
+		"message": "Interpreter reset successfully",
+	}
+}
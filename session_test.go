@@ -0,0 +1,162 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"syscall/js"
+	"testing"
+	"time"
+)
+
+// jobSource returns a program printing n numbered lines of label to
+// stdout, then one to stderr, recording label in globalThis.jobOrder each
+// time before it sleeps, for other jobs to run.
+func jobSource(label string, n int) string {
+	return `package main
+
+import (
+	"fmt"
+	"os"
+	"syscall/js"
+	"time"
+)
+
+func main() {
+	for i := 0; i < ` + strconv.Itoa(n) + `; i++ {
+		fmt.Printf("%s%d\n", ` + strconv.Quote(label) + `, i)
+		js.Global().Get("jobOrder").Call("push", ` + strconv.Quote(label) + `)
+		time.Sleep(5 * time.Millisecond)
+	}
+	fmt.Fprintln(os.Stderr, ` + strconv.Quote(label+" done") + `)
+}
+`
+}
+
+func TestSessionsInterleavedJobs(t *testing.T) {
+	js.Global().Set("jobOrder", js.Global().Get("Array").New())
+	defer js.Global().Delete("jobOrder")
+	a := newTestSession(t, nil)
+	b := newTestSession(t, nil)
+
+	ja := callAPI(t, "submit", jobSource("a", 5), map[string]interface{}{"session": a})
+	mustSucceed(t, ja)
+	jb := callAPI(t, "submit", jobSource("bb", 3), map[string]interface{}{"session": b})
+	mustSucceed(t, jb)
+	ra := callAPI(t, "awaitJob", ja.Get("id"))
+	rb := callAPI(t, "awaitJob", jb.Get("id"))
+	mustSucceed(t, ra)
+	mustSucceed(t, rb)
+
+	order := js.Global().Get("Array").Get("prototype").Get("join").Call("call", js.Global().Get("jobOrder"), " ").String()
+	if strings.LastIndex(order, "a") < strings.Index(order, "bb") {
+		t.Fatalf("the jobs ran one after the other (%s), want them interleaved", order)
+	}
+	if got, want := ra.Get("output").String(), "a0\na1\na2\na3\na4\n"; got != want {
+		t.Errorf("output of session a = %q, want %q", got, want)
+	}
+	if got, want := ra.Get("stderr").String(), "a done\n"; got != want {
+		t.Errorf("stderr of session a = %q, want %q", got, want)
+	}
+	if got, want := rb.Get("output").String(), "bb0\nbb1\nbb2\n"; got != want {
+		t.Errorf("output of session b = %q, want %q", got, want)
+	}
+	if got, want := rb.Get("stderr").String(), "bb done\n"; got != want {
+		t.Errorf("stderr of session b = %q, want %q", got, want)
+	}
+
+	for _, c := range []struct {
+		id          int
+		outputBytes int
+	}{
+		{a, len("a0\na1\na2\na3\na4\n") + len("a done\n")},
+		{b, len("bb0\nbb1\nbb2\n") + len("bb done\n")},
+	} {
+		res := callAPI(t, "stats", map[string]interface{}{"session": c.id})
+		mustSucceed(t, res)
+		st := res.Get("sessions").Index(0)
+		if st.Get("evals").Int() != 1 || st.Get("successes").Int() != 1 {
+			t.Errorf("session %d: %d evals, %d successes, want 1 and 1", c.id, st.Get("evals").Int(), st.Get("successes").Int())
+		}
+		if got := st.Get("outputBytes").Int(); got != c.outputBytes {
+			t.Errorf("session %d: outputBytes = %d, want %d", c.id, got, c.outputBytes)
+		}
+	}
+}
+
+func TestResetEnv(t *testing.T) {
+	t.Cleanup(func() { callAPI(t, "reset") })
+	const getenv = "package main\n\nimport (\n\t\"fmt\"\n\t\"os\"\n)\n\nfunc main() { fmt.Print(os.Getenv(\"RESET_TEST\")) }\n"
+	for _, c := range []struct {
+		name string
+		opts map[string]interface{}
+		want string
+	}{
+		{"plain", nil, ""},
+		{"keepEnv", map[string]interface{}{"keepEnv": true}, "set"},
+		{"clearEnv", map[string]interface{}{"clearEnv": true}, ""},
+		{"clearEnv over keepEnv", map[string]interface{}{"keepEnv": true, "clearEnv": true}, ""},
+	} {
+		mustSucceed(t, callAPI(t, "setEnv", "RESET_TEST", "set"))
+		mustSucceed(t, callAPI(t, "reset", c.opts))
+		res := callAPI(t, "eval", getenv)
+		mustSucceed(t, res)
+		if got := res.Get("output").String(); got != c.want {
+			t.Errorf("%s: RESET_TEST = %q after the reset, want %q", c.name, got, c.want)
+		}
+		callAPI(t, "reset")
+	}
+}
+
+// holdSession starts an eval in session id that runs until the returned
+// func is called, which then waits for it to end. The global flag names
+// the JS global the eval polls.
+func holdSession(t *testing.T, id int, flag string) func() {
+	t.Helper()
+	mustSucceed(t, callAPI(t, "evalIn", id, `import (
+	"syscall/js"
+	"time"
+)`))
+	done := make(chan js.Value, 1)
+	onComplete := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		done <- args[0]
+		return nil
+	})
+	js.Global().Set(flag, false)
+	callAPI(t, "evalIn", id, `for !js.Global().Get(`+strconv.Quote(flag)+`).Bool() {
+	time.Sleep(time.Millisecond)
+}`, map[string]interface{}{"onComplete": onComplete})
+	for !callAPI(t, "status").Get("busy").Bool() {
+		time.Sleep(time.Millisecond)
+	}
+	return func() {
+		js.Global().Set(flag, true)
+		mustSucceed(t, <-done)
+		onComplete.Release()
+		js.Global().Delete(flag)
+	}
+}
+
+func TestResetSessionWhileBusy(t *testing.T) {
+	id := newTestSession(t, nil)
+	mustSucceed(t, callAPI(t, "evalIn", id, "kept := 1"))
+	release := holdSession(t, id, "resetRelease")
+	res := callAPI(t, "resetSession", id)
+	release()
+	if !res.Get("busy").Truthy() {
+		t.Errorf("resetSession during an eval = %s, want busy", jsonString(res))
+	}
+	// The reset refused dropped nothing.
+	mustSucceed(t, callAPI(t, "evalIn", id, "kept"))
+}
+
+func TestDestroySessionWhileBusy(t *testing.T) {
+	id := newTestSession(t, nil)
+	release := holdSession(t, id, "destroyRelease")
+	res := callAPI(t, "destroySession", id)
+	release()
+	if !res.Get("busy").Truthy() {
+		t.Errorf("destroySession during an eval = %s, want busy", jsonString(res))
+	}
+	mustSucceed(t, callAPI(t, "evalIn", id, "1"))
+	mustSucceed(t, callAPI(t, "destroySession", id))
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"go/scanner"
+	"go/token"
+	"path"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// snippet is a source rewritten as a main package: imports first, then
+// function and type declarations, then the remaining statements as the body
+// of an init function. Unlike main, which yaegi runs again after each eval
+// defining it, init runs once.
+type snippet struct {
+	lines   []string
+	imports map[int]string // package name imported on each import line
+	srcMap  sourceMap
+}
+
+// sourceMap translates positions in a rewritten source back to the
+// original one.
+type sourceMap []lineRange
+
+// lineRange maps the lines from gen to gen+count-1 of the rewritten source
+// to the original lines from line on. Columns of the first line are shifted
+// by column.
+type lineRange struct {
+	gen, count int
+	line       int
+	column     int
+}
+
+// scanned is a token of the original source.
+type scanned struct {
+	tok        token.Token
+	start, end int // byte offsets
+	lit        string
+}
+
+// wrapSnippet rewrites src as a main package, or returns nil if src already
+// has a package clause or does not scan.
+func wrapSnippet(src string) *snippet {
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(src))
+	failed := false
+	var sc scanner.Scanner
+	sc.Init(file, []byte(src), func(token.Position, string) { failed = true }, 0)
+
+	// Split the source in top-level declarations and statements.
+	var chunks [][]scanned
+	var cur []scanned
+	depth := 0
+	header := false // in the header of a for, if or switch statement
+	for {
+		pos, tok, lit := sc.Scan()
+		if tok == token.EOF {
+			break
+		}
+		switch tok {
+		case token.FOR, token.IF, token.SWITCH, token.SELECT:
+			header = header || depth == 0
+		case token.LBRACE:
+			if depth == 0 {
+				header = false
+			}
+			depth++
+		case token.LPAREN, token.LBRACK:
+			depth++
+		case token.RPAREN, token.RBRACE, token.RBRACK:
+			depth--
+		}
+		if tok == token.SEMICOLON && depth == 0 && !header {
+			if len(cur) > 0 {
+				chunks = append(chunks, cur)
+				cur = nil
+			}
+			continue
+		}
+		n := len(lit)
+		if n == 0 || tok == token.SEMICOLON {
+			n = len(tok.String())
+		}
+		off := file.Offset(pos)
+		cur = append(cur, scanned{tok, off, min(off+n, len(src)), lit})
+	}
+	if len(cur) > 0 {
+		chunks = append(chunks, cur)
+	}
+	if failed || len(chunks) == 0 || chunks[0][0].tok == token.PACKAGE {
+		return nil
+	}
+
+	s := &snippet{lines: []string{"package main"}, imports: map[int]string{}}
+	emit := func(text string, start, column int) {
+		pos := file.Position(file.Pos(start))
+		lines := strings.Split(text, "\n")
+		s.srcMap = append(s.srcMap, lineRange{len(s.lines) + 1, len(lines), pos.Line, pos.Column - column})
+		s.lines = append(s.lines, lines...)
+	}
+
+	var decls, stmts [][]scanned
+	for _, c := range chunks {
+		switch {
+		case c[0].tok == token.IMPORT:
+			for _, spec := range importSpecs(c) {
+				s.imports[len(s.lines)+1] = importName(spec)
+				emit("import "+src[spec[0].start:spec[len(spec)-1].end], spec[0].start, len("import ")+1)
+			}
+		case isDecl(c):
+			decls = append(decls, c)
+		default:
+			stmts = append(stmts, c)
+		}
+	}
+	for _, c := range decls {
+		emit(src[c[0].start:c[len(c)-1].end], c[0].start, 1)
+	}
+	s.lines = append(s.lines, "func init() {")
+	for _, c := range stmts {
+		emit(src[c[0].start:c[len(c)-1].end], c[0].start, 1)
+	}
+	s.lines = append(s.lines, "}")
+	return s
+}
+
+// text returns the rewritten source, leaving out the imports for which
+// imported returns true.
+func (s *snippet) text(imported func(name string) bool) string {
+	lines := append([]string(nil), s.lines...)
+	for line, name := range s.imports {
+		if name != "_" && name != "." && imported(name) {
+			lines[line-1] = ""
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// importSpecs returns the import specs of an import declaration.
+func importSpecs(c []scanned) [][]scanned {
+	if len(c) < 2 || c[1].tok != token.LPAREN {
+		return [][]scanned{c[1:]}
+	}
+	var specs [][]scanned
+	var spec []scanned
+	for _, t := range c[2:] {
+		if t.tok == token.SEMICOLON || t.tok == token.RPAREN {
+			if len(spec) > 0 {
+				specs = append(specs, spec)
+				spec = nil
+			}
+			continue
+		}
+		spec = append(spec, t)
+	}
+	return specs
+}
+
+// importName returns the name under which an import spec is visible.
+func importName(spec []scanned) string {
+	if spec[0].tok != token.STRING {
+		return spec[0].lit
+	}
+	p, err := strconv.Unquote(spec[0].lit)
+	if err != nil {
+		return ""
+	}
+	return path.Base(p)
+}
+
+// isDecl reports whether a top-level chunk declares a type, a function or a
+// method, which cannot go in a function body.
+func isDecl(c []scanned) bool {
+	switch {
+	case c[0].tok == token.TYPE:
+		return true
+	case c[0].tok != token.FUNC || len(c) < 2:
+		return false
+	case c[1].tok == token.IDENT:
+		return true
+	case c[1].tok != token.LPAREN:
+		return false
+	}
+
+	// A method has a receiver followed by its name and its parameters, a
+	// function literal has only parameters.
+	depth := 0
+	for i, t := range c[1:] {
+		switch t.tok {
+		case token.LPAREN:
+			depth++
+		case token.RPAREN:
+			depth--
+			if depth == 0 {
+				rest := c[i+2:]
+				return len(rest) >= 2 && rest[0].tok == token.IDENT && rest[1].tok == token.LPAREN
+			}
+		}
+	}
+	return false
+}
+
+// position maps a line and column of the rewritten source to the original
+// source. Lines added by the rewrite map to the end of the preceding
+// original line, or to the start of the source.
+func (m sourceMap) position(line, column int) (int, int) {
+	origLine, origColumn := 1, 1
+	for _, r := range m {
+		switch {
+		case line < r.gen:
+			return origLine, origColumn
+		case line < r.gen+r.count:
+			if line == r.gen {
+				column += r.column
+			}
+			return r.line + line - r.gen, column
+		}
+		origLine, origColumn = r.line+r.count-1, 1
+	}
+	return origLine, origColumn
+}
+
+// runSnippet evaluates the snippet sn in session s.
+func runSnippet(s *session, sn *snippet, opts evalOptions) map[string]interface{} {
+	code := strings.Join(sn.lines, "\n")
+	var ticks insertMap
+	var budget *stepBudget
+	if opts.maxSteps > 0 || opts.maxHeap > 0 || opts.maxCallDepth > 0 {
+		budget = newStepBudget(opts.maxSteps, opts.maxCallDepth)
+		code, _ = instrumentSteps(code, budget)
+	}
+	var echo *echoEdit
+	var values []interface{}
+	var types []reflect.Type
+	var guard guardEdit
+	var tr traceEdit
+	var trace *lineTrace
+	if opts.trace {
+		trace = newLineTrace(len(sn.lines))
+	}
+	imports := insertMap{}
+	opts.stepped = budget != nil
+	file := sourceFile(code, opts.filename)
+	result := runEvalFunc(s, code, opts, func(ctx context.Context) (reflect.Value, error) {
+		run := func(text string) (reflect.Value, error) {
+			if budget == nil {
+				return s.evalImporting(ctx, text, file, opts.autoImport, imports)
+			}
+			text, ticks = instrumentSteps(text, budget)
+			return s.evalSteps(ctx, text, file, opts.autoImport, imports, budget)
+		}
+		// Importing a package twice in a session is an error.
+		text := sn.text(s.imported)
+		if trace != nil {
+			tr = instrumentTrace(text, trace)
+			text = tr.src
+		}
+		if guard = guardGoroutines(text); guard.guarded {
+			if budget != nil {
+				budget.goroutines = true
+			}
+			if err := s.startGuard(file); err != nil {
+				return reflect.Value{}, err
+			}
+			text = guard.src
+		}
+		if opts.orderedMaps {
+			if err := s.startMapOrder(file); err != nil {
+				return reflect.Value{}, err
+			}
+		}
+		if err := s.startTrace(trace, file); err != nil {
+			return reflect.Value{}, err
+		}
+		defer s.trace.Store(nil)
+		// Echoing as eval does, the value of the wrapper aside.
+		if opts.echo == "off" {
+			_, err := run(text)
+			return reflect.Value{}, err
+		}
+		if echo = echoProgram(text); echo == nil {
+			_, err := run(text)
+			return reflect.Value{}, err
+		}
+		v, err := run(echo.src)
+		if err != nil && noValue.MatchString(err.Error()) {
+			echo = nil
+			_, err = run(text)
+			return reflect.Value{}, err
+		}
+		if err == nil {
+			v, err = s.interpreter.Eval(echoVar)
+			types = s.echoTypes(echo.fun)
+		}
+		v, values = s.echo(v, opts.echo, opts.render)
+		return v, err
+	})
+	// The rewrites, from the last to the first, those instrumentTrace
+	// sees last.
+	var echoed positionMap
+	if echo != nil {
+		echoed = echo.moved
+	}
+	traced := positionChain{sn.srcMap}
+	chain := append(positionChain{imports, ticks, echoed, guard.moved, tr.moved}, traced...)
+	result = guard.unname(chain.remap(unnameSource(result, file)))
+	if trace != nil {
+		result["coverage"] = trace.coverage(tr.lines, traced.position)
+	}
+	if opts.maxSteps > 0 {
+		result["steps"] = budget.used()
+	}
+	echoResult(result, opts.echo, values, types, opts.render)
+	return result
+}
+
+// imported reports whether name is a package imported by the session
+// interpreter.
+func (s *session) imported(name string) (ok bool) {
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+	// A package has no symbol _. A package name is not a value, but yaegi
+	// gives it that of the last eval.
+	_, err := s.interpreter.Eval(name + "._")
+	return err != nil && noPackageSymbol.MatchString(err.Error())
+}
+
+// noPackageSymbol matches the error of yaegi for the symbol _ of a binary
+// or a source package.
+var noPackageSymbol = regexp.MustCompile(`(has no symbol |undefined selector: \S+\.)_$`)
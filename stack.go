@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+
+	"github.com/traefik/yaegi/interp"
+)
+
+const (
+	// depthCheckInterval is how many ticks of a budget go between two
+	// checks of the call depth, which walk the stack.
+	depthCheckInterval = 64
+	// interpFrame is the function of yaegi running the body of each
+	// interpreted call, whose frames checkDepth counts.
+	interpFrame = "github.com/traefik/yaegi/interp.runCfg"
+	// framesPerCall is the Go frames a goroutine may have by interpreted
+	// call allowed. A call of a function declared takes two, but one
+	// through a function value takes six, with bigger frames: closures
+	// overflow the JS stack at a fifth of the depth.
+	framesPerCall = 3
+	// overflowFrames is how many of the innermost frames the result of a
+	// stack overflow lists.
+	overflowFrames = 20
+)
+
+// stackOverflow is the panic of code recursing past limit interpreted
+// frames in a goroutine. Deeper, the JS stack the frames of wasm run on
+// overflows, which the module does not survive.
+type stackOverflow struct {
+	limit int
+}
+
+func (e stackOverflow) Error() string {
+	return fmt.Sprintf("stack overflow: calls nest deeper than %d (maxCallDepth)", e.limit)
+}
+
+// callStack keeps the stack walked by checkDepth and, by program counter,
+// whether it is in interpFrame.
+var callStack struct {
+	sync.Mutex
+	pcs    []uintptr
+	interp map[uintptr]bool
+}
+
+// checkDepth panics with stackOverflow if the calling goroutine runs more
+// than limit interpreted calls, or more than framesPerCall Go frames each.
+// Counting the frames of yaegi, rather than the calls as they enter and
+// return, needs no deferred call in each function and tells the goroutines
+// apart. Mutual recursion and calls of closures count as any other.
+func checkDepth(limit int) {
+	if interpretedDepth(limit) > limit {
+		panic(stackOverflow{limit})
+	}
+}
+
+// interpretedDepth returns the interpreted calls the calling goroutine
+// runs, or limit+1 if it has more than limit*framesPerCall Go frames.
+func interpretedDepth(limit int) int {
+	callStack.Lock()
+	defer callStack.Unlock()
+
+	if len(callStack.pcs) != limit*framesPerCall+1 {
+		callStack.pcs = make([]uintptr, limit*framesPerCall+1)
+	}
+	if callStack.interp == nil {
+		callStack.interp = map[uintptr]bool{}
+	}
+	n := runtime.Callers(3, callStack.pcs)
+	if n == len(callStack.pcs) {
+		return limit + 1
+	}
+	depth := 0
+	for _, pc := range callStack.pcs[:n] {
+		in, ok := callStack.interp[pc]
+		if !ok {
+			f := runtime.FuncForPC(pc - 1)
+			in = f != nil && f.Name() == interpFrame
+			callStack.interp[pc] = in
+		}
+		if in {
+			depth++
+		}
+	}
+	return depth
+}
+
+// stackOverflowed returns the stackOverflow err results from, or nil.
+func stackOverflowed(err error) *stackOverflow {
+	var p interp.Panic
+	if errors.As(err, &p) {
+		if e, ok := p.Value.(stackOverflow); ok {
+			return &e
+		}
+	}
+	return nil
+}
+
+// overflowResult reports an eval ended by a stack overflow, with the
+// innermost overflowFrames frames as stack and the count of frames left
+// out as stackOmitted. The frames yaegi writes to stderr while unwinding,
+// thousands, are left out of stderr.
+func overflowResult(err error, sourceCode, output, stderr string) map[string]interface{} {
+	frames := panicStack(err, sourceCode, stderr)
+	omitted := 0
+	if len(frames) > overflowFrames {
+		omitted = len(frames) - overflowFrames
+		frames = frames[:overflowFrames]
+	}
+	return map[string]interface{}{
+		"success":      false,
+		"error":        err.Error(),
+		"errorCode":    codeOverflow,
+		"diagnostics":  errorDiagnostics(err, sourceCode, stderr),
+		"stack":        frames,
+		"stackOmitted": omitted,
+		"output":       output,
+		"stderr":       panicFrameLine.ReplaceAllString(stderr, ""),
+	}
+}
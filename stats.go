@@ -0,0 +1,241 @@
+package main
+
+import (
+	"runtime"
+	"sort"
+	"strconv"
+	"syscall/js"
+	"time"
+)
+
+// evalStats measures the wall time and memory use of an evaluation.
+type evalStats struct {
+	start  time.Time
+	before runtime.MemStats
+}
+
+// startStats starts measuring.
+func startStats() *evalStats {
+	st := &evalStats{}
+	runtime.ReadMemStats(&st.before)
+	st.start = time.Now()
+	return st
+}
+
+// stop ends the measure and returns it as the stats of a result:
+// {durationMs, allocBytes, heapBytes, numGC}, where allocBytes and numGC
+// count the allocations and collections since startStats, and heapBytes
+// is the heap in use at the end.
+func (st *evalStats) stop() map[string]interface{} {
+	duration := time.Since(st.start)
+	var after runtime.MemStats
+	runtime.ReadMemStats(&after)
+
+	return map[string]interface{}{
+		"durationMs": float64(duration.Microseconds()) / 1000,
+		"allocBytes": after.TotalAlloc - st.before.TotalAlloc,
+		"heapBytes":  after.HeapAlloc,
+		"numGC":      after.NumGC - st.before.NumGC,
+	}
+}
+
+// evalCounters count the evals of a session since it was created, or
+// since stats reset them.
+type evalCounters struct {
+	evals       int
+	successes   int
+	failures    map[string]int // by code, see resultCode
+	wallTime    time.Duration  // spent running code
+	outputBytes int            // stdout and stderr bytes captured
+	peakHeap    uint64         // highest heap in use seen at the end of an eval
+	lastError   map[string]interface{}
+	fsDenied    int // file operations denied by the fs setting, see fsAllows
+	netBlocked  int // requests denied by the network setting, see checkNetwork
+
+	asyncErrors    int                    // panics of goroutines, see asyncPanic
+	lastAsyncError map[string]interface{} // as given to onAsyncError
+}
+
+// recordEval counts in the usage of s an eval that ended with res, having
+// written outputBytes and run for wall, which the quota of s is charged.
+func (s *session) recordEval(res map[string]interface{}, outputBytes int, wall time.Duration) {
+	s.chargeCPU(wall)
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	heap := m.HeapAlloc
+	if h, ok := res["heapBytes"].(uint64); ok {
+		// Observed past maxHeapBytes, before the eval was stopped.
+		heap = max(heap, h)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u := &s.counters
+	u.evals++
+	u.wallTime += wall
+	u.outputBytes += outputBytes
+	u.peakHeap = max(u.peakHeap, heap)
+	if res["success"] == true {
+		u.successes++
+		return
+	}
+	code := resultCode(res)
+	if u.failures == nil {
+		u.failures = map[string]int{}
+	}
+	u.failures[code]++
+	u.lastError = map[string]interface{}{
+		"atMs":    time.Now().UnixMilli(),
+		"code":    code,
+		"message": res["error"],
+	}
+}
+
+// usageInfo returns the usage of s: {evals, successes, failures,
+// wallTimeMs, outputBytes, peakHeapBytes, lastError, fsDenied,
+// networkBlocked, asyncErrors, lastAsyncError, uptimeMs}, failures
+// counting the failed evals by code, lastError being {atMs, code, message}
+// or null, fsDenied and networkBlocked the file operations and requests
+// that the fs and network settings denied, and asyncErrors the panics of
+// goroutines, the last as given to onAsyncError or null. With reset, the counters are zeroed at once.
+func (s *session) usageInfo(reset bool) map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u := s.counters
+	failures := map[string]interface{}{}
+	for code, n := range u.failures {
+		failures[code] = n
+	}
+	var lastError, lastAsyncError interface{}
+	if u.lastError != nil {
+		lastError = u.lastError
+	}
+	if u.lastAsyncError != nil {
+		lastAsyncError = u.lastAsyncError
+	}
+	if reset {
+		s.counters = evalCounters{}
+	}
+	return map[string]interface{}{
+		"evals":          u.evals,
+		"successes":      u.successes,
+		"failures":       failures,
+		"wallTimeMs":     float64(u.wallTime.Microseconds()) / 1000,
+		"outputBytes":    u.outputBytes,
+		"peakHeapBytes":  safeNumber(u.peakHeap),
+		"lastError":      lastError,
+		"fsDenied":       u.fsDenied,
+		"networkBlocked": u.netBlocked,
+		"asyncErrors":    u.asyncErrors,
+		"lastAsyncError": lastAsyncError,
+		"uptimeMs":       time.Since(s.createdAt).Milliseconds(),
+	}
+}
+
+// usageStats reports the usage of each session, by id: {success, sessions:
+// [{id, quota, ...usageInfo}]}, quota from quotaInfo. The option session
+// restricts it to one session, and reset zeroes the counters reported,
+// the use of the quota aside.
+func usageStats(this js.Value, args []js.Value) interface{} {
+	opts := optionArg(args, 0)
+	reset := opts.Type() == js.TypeObject && opts.Get("reset").Truthy()
+
+	var ids []int
+	if opts.Type() == js.TypeObject && opts.Get("session").Type() == js.TypeNumber {
+		ids = []int{opts.Get("session").Int()}
+	} else {
+		sessionsMu.Lock()
+		for id := range sessions {
+			ids = append(ids, id)
+		}
+		sessionsMu.Unlock()
+		sort.Ints(ids)
+	}
+
+	list := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		s := lookupSession(id)
+		if s == nil {
+			return map[string]interface{}{
+				"success": false,
+				"error":   "session not found",
+			}
+		}
+		info := s.usageInfo(reset)
+		info["id"] = id
+		info["quota"] = s.quotaInfo()
+		list = append(list, info)
+	}
+	return map[string]interface{}{
+		"success":  true,
+		"sessions": list,
+	}
+}
+
+// maxSafeInteger is the largest integer a JS number holds exactly.
+const maxSafeInteger = 1<<53 - 1
+
+// safeNumber returns n as a JS number, or as a decimal string if it is
+// too large to be exact.
+func safeNumber(n uint64) interface{} {
+	if n > maxSafeInteger {
+		return strconv.FormatUint(n, 10)
+	}
+	return n
+}
+
+// memStats reports the memory use of the module: {heapAlloc, heapSys,
+// heapObjects, numGC, pauseTotalNs, sys} from runtime.MemStats, with the
+// evals run and output bytes captured by each session in sessions.
+func memStats(this js.Value, args []js.Value) interface{} {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	sessionsMu.Lock()
+	ids := make([]int, 0, len(sessions))
+	for id := range sessions {
+		ids = append(ids, id)
+	}
+	sessionsMu.Unlock()
+	sort.Ints(ids)
+
+	counters := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		if s := lookupSession(id); s != nil {
+			info := s.info()
+			counters = append(counters, map[string]interface{}{
+				"id":          id,
+				"evals":       info["evals"],
+				"outputBytes": info["outputBytes"],
+			})
+		}
+	}
+
+	return map[string]interface{}{
+		"success":      true,
+		"heapAlloc":    safeNumber(m.HeapAlloc),
+		"heapSys":      safeNumber(m.HeapSys),
+		"heapObjects":  safeNumber(m.HeapObjects),
+		"numGC":        m.NumGC,
+		"pauseTotalNs": safeNumber(m.PauseTotalNs),
+		"sys":          safeNumber(m.Sys),
+		"sessions":     counters,
+	}
+}
+
+// collectGarbage runs a garbage collection and returns the heap in use
+// before and after it: {heapAllocBefore, heapAllocAfter}.
+func collectGarbage(this js.Value, args []js.Value) interface{} {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+
+	return map[string]interface{}{
+		"success":         true,
+		"heapAllocBefore": safeNumber(before.HeapAlloc),
+		"heapAllocAfter":  safeNumber(after.HeapAlloc),
+	}
+}
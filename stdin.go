@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"syscall/js"
+)
+
+// stdinPipe is an in-memory pipe fed from JS with yaegi.writeStdin and read
+// by evaluations started with the interactiveStdin option. Readers park
+// their goroutine until data arrives, which lets the JS event loop run.
+type stdinPipe struct {
+	mu     sync.Mutex
+	buf    bytes.Buffer
+	closed bool
+	ready  chan struct{} // signalled when data arrives or the pipe closes
+}
+
+func newStdinPipe() *stdinPipe {
+	return &stdinPipe{ready: make(chan struct{}, 1)}
+}
+
+// openFeed returns the session interactive stdin pipe. A closed pipe is
+// replaced by a fresh one when written to or read from again.
+func (s *session) openFeed() *stdinPipe {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.feed == nil || s.feed.isClosed() {
+		s.feed = newStdinPipe()
+	}
+	return s.feed
+}
+
+// closeFeed closes the session interactive stdin pipe, if any.
+func (s *session) closeFeed() {
+	s.mu.Lock()
+	feed := s.feed
+	s.mu.Unlock()
+
+	if feed != nil {
+		feed.close()
+	}
+}
+
+func (p *stdinPipe) write(b []byte) {
+	p.mu.Lock()
+	p.buf.Write(b)
+	p.mu.Unlock()
+	p.signal()
+}
+
+func (p *stdinPipe) close() {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+	p.signal()
+}
+
+func (p *stdinPipe) isClosed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.closed
+}
+
+func (p *stdinPipe) signal() {
+	select {
+	case p.ready <- struct{}{}:
+	default:
+	}
+}
+
+// reader returns an io.Reader on the pipe that reports io.EOF once the pipe
+// is closed and drained, or once ctx is done.
+func (p *stdinPipe) reader(ctx context.Context) io.Reader {
+	return &pipeReader{pipe: p, ctx: ctx}
+}
+
+type pipeReader struct {
+	pipe *stdinPipe
+	ctx  context.Context
+}
+
+func (r *pipeReader) Read(b []byte) (int, error) {
+	p := r.pipe
+	for {
+		p.mu.Lock()
+		if p.buf.Len() > 0 {
+			n, err := p.buf.Read(b)
+			p.mu.Unlock()
+			return n, err
+		}
+		closed := p.closed
+		p.mu.Unlock()
+
+		if closed {
+			// Let other readers observe the close as well.
+			p.signal()
+			return 0, io.EOF
+		}
+
+		select {
+		case <-p.ready:
+		case <-r.ctx.Done():
+			return 0, io.EOF
+		}
+	}
+}
+
+// writeStdin appends text to the interactive stdin pipe.
+func writeStdin(this js.Value, args []js.Value) interface{} {
+	if len(args) != 1 || args[0].Type() != js.TypeString {
+		return map[string]interface{}{
+			"success": false,
+			"error":   "writeStdin requires exactly one string argument",
+		}
+	}
+
+	defaultSession().openFeed().write([]byte(args[0].String()))
+
+	return map[string]interface{}{"success": true}
+}
+
+// closeStdin closes the interactive stdin pipe; pending reads get io.EOF.
+func closeStdin(this js.Value, args []js.Value) interface{} {
+	defaultSession().closeFeed()
+
+	return map[string]interface{}{"success": true}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+	"syscall/js"
+	"time"
+	"unicode/utf8"
+)
+
+// captureWriter is handed to the interpreter as a standard stream and
+// forwards writes to the buffer of the evaluation currently running, and to
+// its streaming callback if one was given. Writes made while no evaluation
+// is capturing are dropped.
+type captureWriter struct {
+	mu      sync.Mutex
+	active  bool
+	buf     *bytes.Buffer // nil when output is streamed only
+	stream  func(string)  // receives chunks as soon as they are written
+	pending []byte        // incomplete UTF-8 sequence held back from stream
+	limit   *outputLimit  // shared with the other stream, nil for no limit
+	binary  bool          // bytes cut and streamed regardless of UTF-8
+}
+
+func (w *captureWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	n := len(p)
+	if !w.active {
+		return n, nil
+	}
+	if w.limit != nil {
+		p = p[:w.limit.take(p, !w.binary)]
+	}
+	if w.buf != nil {
+		w.buf.Write(p)
+	}
+	if w.stream != nil && w.binary {
+		if len(p) > 0 {
+			w.stream(string(p))
+		}
+	} else if w.stream != nil {
+		chunk, rest := splitUTF8(append(w.pending, p...))
+		w.pending = append([]byte(nil), rest...)
+		if len(chunk) > 0 {
+			w.stream(string(chunk))
+		}
+	}
+	// Dropped bytes count as written, so programs keep running.
+	return n, nil
+}
+
+// start begins capturing into a fresh buffer if capture is set, and
+// delivering chunks to stream if it is not nil. Writes past limit are
+// dropped.
+func (w *captureWriter) start(capture bool, stream func(string), limit *outputLimit) {
+	w.begin(capture, stream, limit, false)
+}
+
+// startBinary is like start for binary output, whose bytes are neither
+// held back nor cut at UTF-8 boundaries.
+func (w *captureWriter) startBinary(capture bool, stream func(string), limit *outputLimit) {
+	w.begin(capture, stream, limit, true)
+}
+
+func (w *captureWriter) begin(capture bool, stream func(string), limit *outputLimit, binary bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.active = true
+	w.limit = limit
+	w.buf = nil
+	if capture {
+		w.buf = &bytes.Buffer{}
+	}
+	w.stream = stream
+	w.pending = nil
+	w.binary = binary
+}
+
+// stop ends capturing and returns what was buffered since start.
+func (w *captureWriter) stop() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.stream != nil && len(w.pending) > 0 {
+		w.stream(string(w.pending))
+	}
+
+	var s string
+	if w.buf != nil {
+		s = w.buf.String()
+	}
+	w.active = false
+	w.buf = nil
+	w.stream = nil
+	w.pending = nil
+	w.limit = nil
+	return s
+}
+
+// outputLimit caps the bytes written to the standard streams by an
+// evaluation.
+type outputLimit struct {
+	mu      sync.Mutex
+	max     int    // bytes allowed
+	written int    // bytes kept so far
+	dropped int    // bytes discarded past max
+	exceed  func() // called once max is exceeded, may be nil
+}
+
+// take returns how many leading bytes of p fit within the limit, cut at a
+// UTF-8 boundary with runes set, and counts the rest as dropped.
+func (l *outputLimit) take(p []byte, runes bool) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	n := len(p)
+	if l.written+n > l.max {
+		n = l.max - l.written
+		for runes && n > 0 && !utf8.RuneStart(p[n]) {
+			n--
+		}
+	}
+	l.written += n
+	if n < len(p) {
+		if l.dropped == 0 && l.exceed != nil {
+			l.exceed()
+		}
+		l.dropped += len(p) - n
+	}
+	return n
+}
+
+// droppedBytes returns the number of bytes discarded so far, 0 for no
+// limit.
+func (l *outputLimit) droppedBytes() int {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.dropped
+}
+
+// inputReader is handed to the interpreter as standard input and reads from
+// the input of the evaluation currently running. It reports io.EOF when no
+// input was provided, so programs reading stdin never hang.
+type inputReader struct {
+	mu  sync.Mutex
+	src io.Reader
+}
+
+func (r *inputReader) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	src := r.src
+	r.mu.Unlock()
+
+	if src == nil {
+		return 0, io.EOF
+	}
+	return src.Read(p)
+}
+
+// set replaces the input source; nil makes reads return io.EOF.
+func (r *inputReader) set(src io.Reader) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.src = src
+}
+
+// splitUTF8 splits p into a prefix ending on a rune boundary and the
+// incomplete UTF-8 sequence that follows it, if any.
+func splitUTF8(p []byte) (complete, rest []byte) {
+	// A rune is at most utf8.UTFMax bytes long, so only the tail needs checking.
+	for i := len(p) - 1; i >= 0 && i >= len(p)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(p[i]) {
+			if !utf8.FullRune(p[i:]) {
+				return p[:i], p[i:]
+			}
+			break
+		}
+	}
+	return p, nil
+}
+
+// consoleLines writes the lines of a stream to the browser console with
+// method, "log" or "error", holding an incomplete last line until flush.
+type consoleLines struct {
+	method  string
+	pending string
+}
+
+func (c *consoleLines) write(s string) {
+	lines := strings.Split(c.pending+s, "\n")
+	for _, line := range lines[:len(lines)-1] {
+		js.Global().Get("console").Call(c.method, line)
+	}
+	c.pending = lines[len(lines)-1]
+}
+
+// flush writes the incomplete last line, if any.
+func (c *consoleLines) flush() {
+	if c.pending != "" {
+		js.Global().Get("console").Call(c.method, c.pending)
+		c.pending = ""
+	}
+}
+
+// outputEvents records the chunks written to the standard streams of an
+// eval, in the order they were written.
+type outputEvents struct {
+	mu     sync.Mutex
+	start  time.Time
+	events []interface{}
+}
+
+func newOutputEvents() *outputEvents {
+	return &outputEvents{start: time.Now(), events: []interface{}{}}
+}
+
+// stream returns the stream recording chunks as {stream: name, data, tMs},
+// tMs being the time since the eval started.
+func (e *outputEvents) stream(name string) func(string) {
+	return func(s string) {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+
+		e.events = append(e.events, map[string]interface{}{
+			"stream": name,
+			"data":   s,
+			"tMs":    float64(time.Since(e.start).Microseconds()) / 1000,
+		})
+	}
+}
+
+func (e *outputEvents) list() []interface{} {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.events
+}
+
+// teeStreams returns a stream writing to each of streams not nil, or nil
+// if there are none.
+func teeStreams(streams ...func(string)) func(string) {
+	var list []func(string)
+	for _, stream := range streams {
+		if stream != nil {
+			list = append(list, stream)
+		}
+	}
+	switch len(list) {
+	case 0:
+		return nil
+	case 1:
+		return list[0]
+	}
+	return func(s string) {
+		for _, stream := range list {
+			stream(s)
+		}
+	}
+}
+
+// jsStream adapts a JS callback to a stream function, or returns nil if fn
+// is not a function.
+func jsStream(fn js.Value) func(string) {
+	if fn.Type() != js.TypeFunction {
+		return nil
+	}
+	return func(s string) { fn.Invoke(s) }
+}
+
+// jsByteStream is like jsStream, passing chunks as Uint8Array.
+func jsByteStream(fn js.Value) func(string) {
+	if fn.Type() != js.TypeFunction {
+		return nil
+	}
+	return func(s string) { fn.Invoke(bytesToJS([]byte(s))) }
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/hex"
+	"strings"
+	"syscall/js"
+	"testing"
+)
+
+func TestGoroutinePrintingIntoNextEval(t *testing.T) {
+	id := newTestSession(t, nil)
+	mustSucceed(t, callAPI(t, "evalIn", id, `import (
+	"fmt"
+	"time"
+)`))
+	res := callAPI(t, "evalIn", id, `
+fmt.Println("first")
+go func() {
+	time.Sleep(50 * time.Millisecond)
+	fmt.Println("background")
+}()
+`)
+	mustSucceed(t, res)
+	if got := res.Get("output").String(); got != "first\n" {
+		t.Errorf("output of the first eval = %q, want %q", got, "first\n")
+	}
+
+	res = callAPI(t, "evalIn", id, `
+time.Sleep(150 * time.Millisecond)
+fmt.Println("second")
+`)
+	mustSucceed(t, res)
+	// The goroutine prints while the second eval runs, whose output has it
+	// whole, before its own.
+	if got, want := res.Get("output").String(), "background\nsecond\n"; got != want {
+		t.Errorf("output of the second eval = %q, want %q", got, want)
+	}
+
+	res = callAPI(t, "evalIn", id, `fmt.Println("third")`)
+	mustSucceed(t, res)
+	if got := res.Get("output").String(); got != "third\n" {
+		t.Errorf("output of the third eval = %q, want %q", got, "third\n")
+	}
+	if strings.Contains(res.Get("stderr").String(), "background") {
+		t.Errorf("stderr of the third eval = %q", res.Get("stderr").String())
+	}
+}
+
+func TestBinaryOutputBytes(t *testing.T) {
+	t.Cleanup(func() { callAPI(t, "reset") })
+	var chunks []string
+	onStdout := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		chunks = append(chunks, hex.EncodeToString(uint8Bytes(args[0])))
+		return nil
+	})
+	defer onStdout.Release()
+
+	// The halves of a rune are streamed as written, and the limit cuts
+	// within the third.
+	res := callAPI(t, "eval", `package main
+
+import "os"
+
+func main() {
+	os.Stdout.Write([]byte{0xe2, 0x82})
+	os.Stdout.Write([]byte{0xac, 0xff})
+	os.Stdout.Write([]byte{'a', 0xe2, 0x82, 0xac})
+}
+`, map[string]interface{}{"binaryOutput": true, "onStdout": onStdout, "maxOutputBytes": 7})
+	mustSucceed(t, res)
+	if got, want := strings.Join(chunks, " "), "e282 acff 61e282"; got != want {
+		t.Errorf("chunks = %s, want %s", got, want)
+	}
+	if got, want := hex.EncodeToString(uint8Bytes(res.Get("output"))), "e282acff61e282"; got != want {
+		t.Errorf("output = %s, want %s", got, want)
+	}
+	if got := res.Get("droppedBytes"); got.Type() != js.TypeNumber || got.Int() != 1 {
+		t.Errorf("droppedBytes = %s, want 1", jsonString(got))
+	}
+}
+
+// uint8Bytes returns the bytes of the Uint8Array v.
+func uint8Bytes(v js.Value) []byte {
+	b := make([]byte, v.Length())
+	js.CopyBytesToGo(b, v)
+	return b
+}
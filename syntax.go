@@ -0,0 +1,245 @@
+package main
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall/js"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// defaultASTDepth and defaultASTNodes bound the trees built by
+	// syntaxTree unless its options set maxDepth and maxNodes.
+	defaultASTDepth = 100
+	defaultASTNodes = 20000
+)
+
+// syntaxTree parses Go source code with its comments and returns its syntax
+// tree as nested objects: {type, pos, end} with pos and end as {line,
+// column}, the names, literal values and operators of the node, and its
+// child nodes under the names of their go/ast fields in lower camel case,
+// with the Type fields as typeExpr.
+// It accepts the same sources as format, wrapped in a file when they lack a
+// package clause. With the option resolve, identifiers tell the kind of
+// object they denote and where it is declared. Nodes beyond maxDepth
+// levels, or past the first maxNodes, are replaced by {type, truncated}.
+// It does not use the interpreter, so it can run while an evaluation is in
+// flight.
+func syntaxTree(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 || len(args) > 2 || args[0].Type() != js.TypeString {
+		return map[string]interface{}{
+			"success": false,
+			"error":   "ast requires the Go source code and an optional options object",
+		}
+	}
+	src := args[0].String()
+
+	f, err := parseFragment(src)
+	if err != nil {
+		return formatError(err, src, f)
+	}
+
+	t := &treeBuilder{src: src, frag: f, maxDepth: defaultASTDepth, maxNodes: defaultASTNodes}
+	if opts := optionArg(args, 1); opts.Type() == js.TypeObject {
+		t.resolve = opts.Get("resolve").Truthy()
+		if n := optionInt(opts, "maxDepth"); n > 0 {
+			t.maxDepth = n
+		}
+		if n := optionInt(opts, "maxNodes"); n > 0 {
+			t.maxNodes = n
+		}
+	}
+	if t.resolve {
+		t.imports = map[string]bool{}
+		for _, spec := range f.file.Imports {
+			p, _ := strconv.Unquote(spec.Path.Value)
+			name := p[strings.LastIndex(p, "/")+1:]
+			if spec.Name != nil {
+				name = spec.Name.Name
+			}
+			t.imports[name] = true
+		}
+	}
+
+	return map[string]interface{}{
+		"success":   true,
+		"ast":       t.node(reflect.ValueOf(f.file), 0),
+		"truncated": t.truncated,
+		"nodes":     t.nodes,
+	}
+}
+
+// treeBuilder converts a parsed fragment to the tree of syntaxTree.
+type treeBuilder struct {
+	src      string
+	frag     *fragment
+	resolve  bool
+	imports  map[string]bool // names of the imported packages
+	maxDepth int
+	maxNodes int
+
+	nodes     int
+	truncated bool
+}
+
+var (
+	nodeType  = reflect.TypeOf((*ast.Node)(nil)).Elem()
+	posType   = reflect.TypeOf(token.NoPos)
+	tokenType = reflect.TypeOf(token.ILLEGAL)
+)
+
+// node converts the ast.Node n at the given depth.
+func (t *treeBuilder) node(n reflect.Value, depth int) interface{} {
+	if n.Kind() == reflect.Interface {
+		n = n.Elem()
+	}
+	if !n.IsValid() || n.IsNil() {
+		return nil
+	}
+	typ := n.Elem().Type().Name()
+	if depth >= t.maxDepth || t.nodes >= t.maxNodes {
+		t.truncated = true
+		return map[string]interface{}{"type": typ, "truncated": true}
+	}
+	t.nodes++
+
+	an := n.Interface().(ast.Node)
+	m := map[string]interface{}{
+		"type": typ,
+		"pos":  t.position(an.Pos()),
+		"end":  t.position(an.End()),
+	}
+	if id, ok := an.(*ast.Ident); ok && t.resolve {
+		t.resolveIdent(m, id)
+	}
+
+	v := n.Elem()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if skippedField(typ, field.Name) {
+			continue
+		}
+		if value := t.field(v.Field(i), depth); value != nil {
+			m[fieldKey(field.Name)] = value
+		}
+	}
+	return m
+}
+
+// field converts the value of a node field, or returns nil for fields left
+// out: positions, empty strings and missing children.
+func (t *treeBuilder) field(f reflect.Value, depth int) interface{} {
+	switch {
+	case f.Type() == posType:
+		return nil
+	case f.Type() == tokenType:
+		return token.Token(f.Int()).String()
+	case f.Type().Implements(nodeType):
+		if f.IsNil() {
+			return nil
+		}
+		return t.node(f, depth+1)
+	case f.Kind() == reflect.Slice && f.Type().Elem().Implements(nodeType):
+		if f.Len() == 0 {
+			return nil
+		}
+		list := make([]interface{}, f.Len())
+		for i := range list {
+			list[i] = t.node(f.Index(i), depth+1)
+		}
+		return list
+	}
+
+	switch f.Kind() {
+	case reflect.String:
+		if f.Len() == 0 {
+			return nil
+		}
+		return f.String()
+	case reflect.Bool:
+		return f.Bool()
+	}
+	if dir, ok := f.Interface().(ast.ChanDir); ok {
+		return chanDir(dir)
+	}
+	return nil
+}
+
+// skippedField reports whether the field name of nodes of type typ is left
+// out of the tree, as resolution data or a copy of other fields.
+func skippedField(typ, name string) bool {
+	switch name {
+	case "Obj", "Scope", "Unresolved":
+		return true
+	case "Imports":
+		return typ == "File"
+	}
+	return false
+}
+
+// resolveIdent annotates m, the node of id, with the kind of object id
+// denotes: {kind, decl} for an object declared in the source, kind "package"
+// for an imported package or "builtin" for a predeclared identifier.
+func (t *treeBuilder) resolveIdent(m map[string]interface{}, id *ast.Ident) {
+	switch {
+	case id.Obj != nil:
+		m["kind"] = id.Obj.Kind.String()
+		if decl, ok := id.Obj.Decl.(ast.Node); ok {
+			m["decl"] = t.position(declName(decl, id.Name))
+		}
+	case t.imports[id.Name]:
+		m["kind"] = "package"
+	case types.Universe.Lookup(id.Name) != nil:
+		m["kind"] = "builtin"
+	}
+}
+
+// declName returns the position of name in its declaration decl.
+func declName(decl ast.Node, name string) token.Pos {
+	pos, found := decl.Pos(), false
+	ast.Inspect(decl, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok && !found && id.Name == name {
+			pos, found = id.Pos(), true
+		}
+		return !found
+	})
+	return pos
+}
+
+// position returns p as {line, column} in the source.
+func (t *treeBuilder) position(p token.Pos) interface{} {
+	if !p.IsValid() {
+		return nil
+	}
+	o := min(t.frag.offset(p), len(t.src))
+	return map[string]interface{}{
+		"line":   strings.Count(t.src[:o], "\n") + 1,
+		"column": o - strings.LastIndex(t.src[:o], "\n"),
+	}
+}
+
+// chanDir names a channel direction.
+func chanDir(dir ast.ChanDir) string {
+	switch dir {
+	case ast.SEND:
+		return "send"
+	case ast.RECV:
+		return "recv"
+	}
+	return "both"
+}
+
+// fieldKey returns the key of the node field name: name in lower camel
+// case, and typeExpr for the Type field, as type names the node type.
+func fieldKey(name string) string {
+	if name == "Type" {
+		return "typeExpr"
+	}
+	r, size := utf8.DecodeRuneInString(name)
+	return string(unicode.ToLower(r)) + name[size:]
+}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"fmt"
+	"go/token"
+	"path"
+	"reflect"
+	"sort"
+	"sync"
+	"syscall/js"
+	"time"
+)
+
+// sessionTemplate is a recorded session setup, replayed by
+// spawnFromTemplate against the fresh interpreter of each session spawned.
+type sessionTemplate struct {
+	id        int
+	config    sessionConfig
+	steps     []templateStep
+	createdAt time.Time
+	spawned   int // sessions spawned, see spawnFromTemplate
+}
+
+// templateStep is a step of a template setup: writing a file, adding a
+// package, binding host symbols or evaluating a prelude source.
+type templateStep struct {
+	kind string // "file", "package", "binding" or "prelude"
+	name string // path, import path or prelude index
+	// run replays the step in s, returning nil or the result reporting
+	// its failure. validating is set for the first replay, by
+	// createTemplate, which checks what later ones may take for granted.
+	run func(s *session, validating bool) map[string]interface{}
+}
+
+// Templates by id.
+var (
+	templatesMu    sync.Mutex
+	templates      = map[int]*sessionTemplate{}
+	nextTemplateID int
+)
+
+// createTemplate records the session setup given as argument, for
+// spawnFromTemplate: {config, files, packages, bindings, prelude}. config
+// takes the options of createSession, files maps paths of the session
+// filesystem to a string or a Uint8Array, packages maps import paths to
+// source files as for addPackage, bindings maps package paths to objects
+// as for usePackage and prelude lists sources evaluated in order, for the
+// helpers they declare. The setup is checked by replaying it a first time,
+// and a step failing is reported with its index, kind and name as
+// failedStep. It returns {success, id, steps, validateMs}.
+func createTemplate(this js.Value, args []js.Value) interface{} {
+	if len(args) != 1 || args[0].Type() != js.TypeObject {
+		return map[string]interface{}{
+			"success":   false,
+			"error":     "createTemplate requires a setup object",
+			"errorCode": codeBadRequest,
+		}
+	}
+	setup := args[0]
+	steps, err := templateSteps(setup)
+	if err != nil {
+		return map[string]interface{}{
+			"success":   false,
+			"error":     "createTemplate: " + err.Error(),
+			"errorCode": codeBadRequest,
+		}
+	}
+	t := &sessionTemplate{
+		config:    parseSessionConfig(setup.Get("config"), sessionConfig{}),
+		steps:     steps,
+		createdAt: time.Now(),
+	}
+
+	// The setup runs once on a session of its own, left unregistered.
+	started := time.Now()
+	s := buildSession(-1, t.config, false)
+	_, failed := t.replay(s, true)
+	s.releaseFuncs()
+	if failed != nil {
+		failed["error"] = fmt.Sprintf("createTemplate: %v", failed["error"])
+		return failed
+	}
+
+	templatesMu.Lock()
+	nextTemplateID++
+	t.id = nextTemplateID
+	templates[t.id] = t
+	templatesMu.Unlock()
+
+	return map[string]interface{}{
+		"success":    true,
+		"id":         t.id,
+		"steps":      len(t.steps),
+		"validateMs": time.Since(started).Milliseconds(),
+	}
+}
+
+// spawnFromTemplate creates a session from the template whose id is given
+// as argument, replaying its setup on a fresh interpreter. It returns
+// {success, id, templateId, replayMs, steps}, id being the one of the
+// session and steps the {kind, name, ms} of each step. If a step fails,
+// the session is dropped and the result reports the step as failedStep.
+func spawnFromTemplate(this js.Value, args []js.Value) interface{} {
+	if len(args) != 1 || args[0].Type() != js.TypeNumber {
+		return map[string]interface{}{
+			"success":   false,
+			"error":     "spawnFromTemplate requires a template id",
+			"errorCode": codeBadRequest,
+		}
+	}
+	templatesMu.Lock()
+	t := templates[args[0].Int()]
+	templatesMu.Unlock()
+	if t == nil {
+		return map[string]interface{}{
+			"success":   false,
+			"error":     "template not found",
+			"errorCode": codeBadRequest,
+		}
+	}
+
+	started := time.Now()
+	s := newSession(t.config)
+	timings, failed := t.replay(s, false)
+	if failed != nil {
+		s.destroy()
+		failed["error"] = fmt.Sprintf("spawnFromTemplate: %v", failed["error"])
+		failed["templateId"] = t.id
+		return failed
+	}
+
+	templatesMu.Lock()
+	t.spawned++
+	templatesMu.Unlock()
+
+	return map[string]interface{}{
+		"success":    true,
+		"id":         s.id,
+		"templateId": t.id,
+		"replayMs":   time.Since(started).Milliseconds(),
+		"steps":      timings,
+	}
+}
+
+// listTemplates returns the templates by id: {id, steps, createdAt,
+// spawned}, steps listing the {kind, name} of each.
+func listTemplates(this js.Value, args []js.Value) interface{} {
+	templatesMu.Lock()
+	defer templatesMu.Unlock()
+
+	ids := make([]int, 0, len(templates))
+	for id := range templates {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	list := make([]interface{}, len(ids))
+	for i, id := range ids {
+		t := templates[id]
+		steps := make([]interface{}, len(t.steps))
+		for j, step := range t.steps {
+			steps[j] = map[string]interface{}{"kind": step.kind, "name": step.name}
+		}
+		list[i] = map[string]interface{}{
+			"id":        t.id,
+			"steps":     steps,
+			"createdAt": t.createdAt.UnixMilli(),
+			"spawned":   t.spawned,
+		}
+	}
+	return list
+}
+
+// deleteTemplate drops the template whose id is given as argument. The
+// sessions spawned from it live on.
+func deleteTemplate(this js.Value, args []js.Value) interface{} {
+	if len(args) != 1 || args[0].Type() != js.TypeNumber {
+		return map[string]interface{}{
+			"success":   false,
+			"error":     "deleteTemplate requires a template id",
+			"errorCode": codeBadRequest,
+		}
+	}
+	templatesMu.Lock()
+	defer templatesMu.Unlock()
+
+	if templates[args[0].Int()] == nil {
+		return map[string]interface{}{
+			"success": false,
+			"error":   "template not found",
+		}
+	}
+	delete(templates, args[0].Int())
+	return map[string]interface{}{"success": true}
+}
+
+// replay runs the steps of t in s, returning the {kind, name, ms} of each
+// or, at the first failing, the result reporting it.
+func (t *sessionTemplate) replay(s *session, validating bool) ([]interface{}, map[string]interface{}) {
+	timings := make([]interface{}, 0, len(t.steps))
+	for i, step := range t.steps {
+		started := time.Now()
+		if res := step.run(s, validating); res != nil {
+			res["success"] = false
+			res["error"] = fmt.Sprintf("step %d (%s %s): %v", i, step.kind, step.name, res["error"])
+			res["failedStep"] = map[string]interface{}{
+				"index": i,
+				"kind":  step.kind,
+				"name":  step.name,
+			}
+			return nil, res
+		}
+		timings = append(timings, map[string]interface{}{
+			"kind": step.kind,
+			"name": step.name,
+			"ms":   float64(time.Since(started).Microseconds()) / 1000,
+		})
+	}
+	return timings, nil
+}
+
+// templateSteps reads the steps of the setup of createTemplate: the files,
+// then the packages, the bindings and the prelude.
+func templateSteps(setup js.Value) ([]templateStep, error) {
+	var steps []templateStep
+	files, err := setupObject(setup, "files")
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range objectKeys(files) {
+		data, ok := fileData(files.Get(name))
+		if !ok {
+			return nil, fmt.Errorf("file %s is not a string or a Uint8Array", name)
+		}
+		steps = append(steps, templateStep{kind: "file", name: name, run: func(s *session, validating bool) map[string]interface{} {
+			if err := s.files.osWriteFile(name, data, 0o666); err != nil {
+				return map[string]interface{}{"error": err.Error()}
+			}
+			return nil
+		}})
+	}
+
+	packages, err := setupObject(setup, "packages")
+	if err != nil {
+		return nil, err
+	}
+	for _, importPath := range objectKeys(packages) {
+		step, err := packageStep(importPath, packages.Get(importPath))
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, step)
+	}
+
+	bindings, err := setupObject(setup, "bindings")
+	if err != nil {
+		return nil, err
+	}
+	for _, pkg := range objectKeys(bindings) {
+		step, err := bindingStep(pkg, bindings.Get(pkg))
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, step)
+	}
+
+	prelude := setup.Get("prelude")
+	if prelude.IsUndefined() || prelude.IsNull() {
+		return steps, nil
+	}
+	if !js.Global().Get("Array").Call("isArray", prelude).Bool() {
+		return nil, fmt.Errorf("prelude must be an array of sources")
+	}
+	for i := 0; i < prelude.Length(); i++ {
+		if prelude.Index(i).Type() != js.TypeString {
+			return nil, fmt.Errorf("prelude source %d is not a string", i)
+		}
+		src := prelude.Index(i).String()
+		steps = append(steps, templateStep{kind: "prelude", name: fmt.Sprint(i), run: func(s *session, validating bool) map[string]interface{} {
+			opts := parseEvalOptions(js.Undefined())
+			opts.warnings = false
+			opts.stats = false
+			if res := runEval(s, src, opts); res["success"] != true {
+				return res
+			}
+			return nil
+		}})
+	}
+	return steps, nil
+}
+
+// packageStep returns the step adding the package importPath, whose files
+// are the properties of files.
+func packageStep(importPath string, files js.Value) (templateStep, error) {
+	if !validImportPath(importPath) {
+		return templateStep{}, fmt.Errorf("invalid import path %q", importPath)
+	}
+	if files.Type() != js.TypeObject {
+		return templateStep{}, fmt.Errorf("package %s is not an object mapping file names to source", importPath)
+	}
+	sources := map[string][]byte{}
+	for _, name := range objectKeys(files) {
+		code := files.Get(name)
+		if code.Type() != js.TypeString {
+			return templateStep{}, fmt.Errorf("source of %s/%s is not a string", importPath, name)
+		}
+		if !goFileName(name) {
+			return templateStep{}, fmt.Errorf("%s/%s is not a Go file name", importPath, name)
+		}
+		sources[name] = []byte(code.String())
+	}
+	if len(sources) == 0 {
+		return templateStep{}, fmt.Errorf("package %s has no Go files", importPath)
+	}
+	return templateStep{kind: "package", name: importPath, run: func(s *session, validating bool) map[string]interface{} {
+		s.mu.Lock()
+		if s.packages == nil {
+			s.packages = map[string]map[string][]byte{}
+		}
+		s.packages[importPath] = sources
+		s.mu.Unlock()
+		writePackageFiles(s.files, importPath, sources)
+		if !validating {
+			return nil
+		}
+		if err := s.checkPackage(s.files, importPath); err != nil {
+			return userPaths(map[string]interface{}{
+				"error":       err.Error(),
+				"errorCode":   errorCode(err),
+				"diagnostics": errorDiagnostics(err, "", ""),
+			})
+		}
+		return nil
+	}}, nil
+}
+
+// bindingStep returns the step registering the properties of obj as the
+// package pkg, as usePackage does. The symbols are converted for each
+// session, so that the vars of a session are its own.
+func bindingStep(pkg string, obj js.Value) (templateStep, error) {
+	if pkg == "" || !token.IsIdentifier(path.Base(pkg)) {
+		return templateStep{}, fmt.Errorf("invalid package path %q", pkg)
+	}
+	if symbolPackage(pkg) {
+		return templateStep{}, fmt.Errorf("%q is a package of the stdlib", pkg)
+	}
+	if obj.Type() != js.TypeObject {
+		return templateStep{}, fmt.Errorf("binding %s is not an object", pkg)
+	}
+	names := objectKeys(obj)
+	for _, name := range names {
+		if !token.IsExported(name) {
+			return templateStep{}, fmt.Errorf("%q is not an exported Go identifier", name)
+		}
+	}
+	return templateStep{kind: "binding", name: pkg, run: func(s *session, validating bool) map[string]interface{} {
+		syms := make(map[string]reflect.Value, len(names))
+		for _, name := range names {
+			syms[name] = jsSymbol(obj.Get(name))
+		}
+		if err := s.bind(pkg, syms); err != nil {
+			return map[string]interface{}{"error": err.Error()}
+		}
+		return nil
+	}}, nil
+}
+
+// setupObject returns the property key of setup, undefined if unset, or
+// an error if it is not an object.
+func setupObject(setup js.Value, key string) (js.Value, error) {
+	v := setup.Get(key)
+	if v.IsUndefined() || v.IsNull() {
+		return js.Undefined(), nil
+	}
+	if v.Type() != js.TypeObject {
+		return js.Undefined(), fmt.Errorf("%s must be an object", key)
+	}
+	return v, nil
+}
+
+// objectKeys returns the sorted keys of the object v, none if undefined.
+func objectKeys(v js.Value) []string {
+	if v.IsUndefined() {
+		return nil
+	}
+	keys := js.Global().Get("Object").Call("keys", v)
+	names := make([]string, keys.Length())
+	for i := range names {
+		names[i] = keys.Index(i).String()
+	}
+	sort.Strings(names)
+	return names
+}
+
+// fileData returns the content of a file given as a string or a
+// Uint8Array.
+func fileData(v js.Value) ([]byte, bool) {
+	switch {
+	case v.Type() == js.TypeString:
+		return []byte(v.String()), true
+	case v.InstanceOf(js.Global().Get("Uint8Array")):
+		data := make([]byte, v.Length())
+		js.CopyBytesToGo(data, v)
+		return data, true
+	}
+	return nil, false
+}
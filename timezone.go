@@ -0,0 +1,118 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+	"syscall/js"
+	"time"
+
+	"github.com/traefik/yaegi/interp"
+)
+
+// tzdataEmbedded reports whether the build embeds time/tzdata, with the
+// yaegi_tzdata tag.
+var tzdataEmbedded bool
+
+// zoneinfo holds the timezone database given to loadTimezoneData.
+var zoneinfo = struct {
+	sync.Mutex
+	zones map[string]*zip.File // by name, such as "Europe/Berlin"
+}{}
+
+// tzdataAvailable reports whether time.LoadLocation finds zones other than
+// those of the host, which a browser has none of.
+func tzdataAvailable() bool {
+	zoneinfo.Lock()
+	defer zoneinfo.Unlock()
+
+	return tzdataEmbedded || zoneinfo.zones != nil
+}
+
+// loadLocation is time.LoadLocation for interpreted code: it looks up the
+// database of loadTimezoneData first, then the zones of the host and the
+// tzdata embedded, hinting at the ways to provide a database when neither
+// is there. The zones of the host may be looked up by evalAsync only: in
+// Node.js, waiting for its fs blocks the event loop a synchronous eval
+// holds.
+func loadLocation(name string) (*time.Location, error) {
+	zoneinfo.Lock()
+	zones := zoneinfo.zones
+	zoneinfo.Unlock()
+	if f := zones[name]; f != nil {
+		if data, err := readZone(f); err == nil {
+			return time.LoadLocationFromTZData(name, data)
+		}
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil && zones == nil && !tzdataEmbedded {
+		err = fmt.Errorf("%w (no timezone database: build with -tags yaegi_tzdata, or pass zoneinfo.zip to yaegi.loadTimezoneData)", err)
+	}
+	return loc, err
+}
+
+// readZone returns the contents of f.
+func readZone(f *zip.File) ([]byte, error) {
+	r, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// locationSymbols returns the time.LoadLocation of interpreted code.
+func locationSymbols() interp.Exports {
+	return interp.Exports{"time/time": {
+		"LoadLocation": reflect.ValueOf(loadLocation),
+	}}
+}
+
+// loadTimezoneData takes the contents of a zoneinfo.zip, as in
+// $GOROOT/lib/time, given as a Uint8Array, for the time.LoadLocation of
+// every session: {success, zones}, the count of zones. It replaces the
+// database given before.
+func loadTimezoneData(this js.Value, args []js.Value) interface{} {
+	if len(args) != 1 || !args[0].InstanceOf(js.Global().Get("Uint8Array")) {
+		return map[string]interface{}{
+			"success": false,
+			"error":   "loadTimezoneData requires the zoneinfo.zip contents as a Uint8Array",
+		}
+	}
+	data := make([]byte, args[0].Length())
+	js.CopyBytesToGo(data, args[0])
+
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return map[string]interface{}{
+			"success":   false,
+			"error":     "loadTimezoneData: " + err.Error(),
+			"errorCode": codeBadRequest,
+		}
+	}
+	zones := map[string]*zip.File{}
+	for _, f := range r.File {
+		if !f.FileInfo().IsDir() {
+			zones[f.Name] = f
+		}
+	}
+	if len(zones) == 0 {
+		return map[string]interface{}{
+			"success":   false,
+			"error":     "loadTimezoneData: the archive holds no zones",
+			"errorCode": codeBadRequest,
+		}
+	}
+
+	zoneinfo.Lock()
+	zoneinfo.zones = zones
+	zoneinfo.Unlock()
+	return map[string]interface{}{
+		"success": true,
+		"zones":   len(zones),
+	}
+}
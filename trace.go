@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/traefik/yaegi/interp"
+)
+
+const (
+	// tracePackage is the package of the hit traced code calls.
+	tracePackage = "yaegiwasm/trace"
+	// hitCall, given the id of the trace and a line, is inserted by
+	// instrumentTrace before the statements of the line.
+	hitCall = "_yaegitrace.Hit(%d, %d);"
+	// maxHits caps the count of a line, which a hot loop could overflow.
+	maxHits = 1 << 30
+)
+
+// lineTrace counts, by line of the evaluated source, the statements run by
+// an eval with the option trace. The lines are known beforehand, so a hot
+// loop only increments their counts.
+type lineTrace struct {
+	id   int
+	hits []atomic.Int64 // by line, from 1
+}
+
+// nextTraceID identifies the traces, so that the functions declared by a
+// traced eval do not count in the traces of later evals.
+var nextTraceID atomic.Int64
+
+// newLineTrace returns a trace of a source of the given number of lines.
+func newLineTrace(lines int) *lineTrace {
+	return &lineTrace{id: int(nextTraceID.Add(1)), hits: make([]atomic.Int64, lines+1)}
+}
+
+// hit counts a statement run on line, up to maxHits.
+func (t *lineTrace) hit(line int) {
+	if line < 1 || line >= len(t.hits) {
+		return
+	}
+	if t.hits[line].Load() < maxHits {
+		t.hits[line].Add(1)
+	}
+}
+
+// coverage returns the coverage of a result: {lines, executable}, lines
+// giving the hits of the lines run and executable the lines holding
+// statements, as translated by position to the lines of the user source.
+func (t *lineTrace) coverage(executable []int, position func(line, column int) (int, int)) map[string]interface{} {
+	hits := map[int]int64{}
+	seen := map[int]bool{}
+	var lines []interface{}
+	for _, l := range executable {
+		line, _ := position(l, 1)
+		if n := t.hits[l].Load(); n > 0 {
+			hits[line] = min(hits[line]+n, maxHits)
+		}
+		if !seen[line] {
+			seen[line] = true
+			lines = append(lines, line)
+		}
+	}
+	counts := map[string]interface{}{}
+	for line, n := range hits {
+		counts[strconv.Itoa(line)] = n
+	}
+	sort.Slice(lines, func(i, j int) bool { return lines[i].(int) < lines[j].(int) })
+	if lines == nil {
+		lines = []interface{}{}
+	}
+	return map[string]interface{}{
+		"lines":      counts,
+		"executable": lines,
+	}
+}
+
+// traceSymbols returns the package of the hit, counting in the running
+// trace of s. Hits of other traces are dropped.
+func (s *session) traceSymbols() interp.Exports {
+	return interp.Exports{tracePackage + "/trace": {
+		"Hit": reflect.ValueOf(func(id, line int) {
+			if t := s.trace.Load(); t != nil && t.id == id {
+				t.hit(line)
+			}
+		}),
+	}}
+}
+
+// startTrace makes t, unless nil, the running trace of s, importing the
+// package of the hit in the interpreter the first time it runs traced code
+// of the file name.
+func (s *session) startTrace(t *lineTrace, name string) error {
+	if t == nil {
+		return nil
+	}
+	if err := s.importOnce(&s.traceInterp, "_yaegitrace", tracePackage, name); err != nil {
+		return err
+	}
+	s.trace.Store(t)
+	return nil
+}
+
+// traceEdit is a source instrumented by instrumentTrace.
+type traceEdit struct {
+	src   string
+	moved insertMap // the calls inserted
+	lines []int     // lines holding statements, in increasing order
+}
+
+// instrumentTrace inserts hitCall for the trace t before the statements of
+// src, on their line, and returns it with the lines instrumented. The
+// declarations without code to run, such as types and variables without
+// values, are left out. A source that does not parse is returned as is,
+// for the eval to report its errors.
+func instrumentTrace(src string, t *lineTrace) traceEdit {
+	edit := traceEdit{src: src}
+	f, err := parseFragment(src)
+	if err != nil {
+		return edit
+	}
+
+	var offsets []int
+	add := func(list []ast.Stmt) {
+		for _, st := range list {
+			if executable(st) {
+				offsets = append(offsets, f.offset(st.Pos()))
+			}
+		}
+	}
+	ast.Inspect(f.file, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.BlockStmt:
+			add(n.List)
+		case *ast.CaseClause:
+			add(n.Body)
+		case *ast.CommClause:
+			add(n.Body)
+		}
+		return true
+	})
+	sort.Ints(offsets)
+
+	var b strings.Builder
+	edit.moved = insertMap{}
+	last := 0
+	for _, o := range offsets {
+		line := strings.Count(src[:o], "\n") + 1
+		call := fmt.Sprintf(hitCall, t.id, line)
+		b.WriteString(src[last:o])
+		b.WriteString(call)
+		edit.moved[line] = append(edit.moved[line], [2]int{o - strings.LastIndex(src[:o], "\n"), len(call)})
+		if n := len(edit.lines); n == 0 || edit.lines[n-1] != line {
+			edit.lines = append(edit.lines, line)
+		}
+		last = o
+	}
+	b.WriteString(src[last:])
+	edit.src = b.String()
+	return edit
+}
+
+// executable reports whether the statement st runs code.
+func executable(st ast.Stmt) bool {
+	switch st := st.(type) {
+	case *ast.EmptyStmt, *ast.CaseClause, *ast.CommClause:
+		// Clauses are statements of the body of a switch or select.
+		return false
+	case *ast.DeclStmt:
+		g := st.Decl.(*ast.GenDecl)
+		if g.Tok != token.VAR {
+			return false
+		}
+		for _, spec := range g.Specs {
+			if len(spec.(*ast.ValueSpec).Values) > 0 {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
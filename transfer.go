@@ -0,0 +1,230 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"syscall/js"
+	"time"
+)
+
+const (
+	// maxTransferBytes caps an upload, or an output held by handle.
+	maxTransferBytes = 64 << 20
+	// maxTransfersBytes caps the bytes of all uploads and outputs held.
+	maxTransfersBytes = 256 << 20
+	// transferTTL is how long an upload or output unused is kept.
+	transferTTL = 2 * time.Minute
+)
+
+// transfer is a source uploaded by chunks, or an output held for
+// readOutput, each under an id of its own.
+type transfer struct {
+	data   []byte
+	max    int  // cap on the bytes of an upload
+	output bool // an output rather than an upload
+	timer  *time.Timer
+}
+
+// transfers holds the uploads and outputs by id.
+var transfers = struct {
+	sync.Mutex
+	byID  map[int]*transfer
+	next  int
+	bytes int // of all transfers
+}{byID: map[int]*transfer{}}
+
+var errTransfersFull = fmt.Errorf("uploads and held outputs exceed %d bytes; finish or free some first", maxTransfersBytes)
+
+// addTransfer registers t under a new id, dropped after transferTTL unused.
+// The lock of transfers is held.
+func addTransfer(t *transfer) int {
+	transfers.next++
+	id := transfers.next
+	transfers.byID[id] = t
+	transfers.bytes += len(t.data)
+	t.timer = time.AfterFunc(transferTTL, func() { takeTransfer(id, t.output) })
+	return id
+}
+
+// takeTransfer drops the upload id, or the output id if output is set, and
+// returns it, or nil if there is none.
+func takeTransfer(id int, output bool) *transfer {
+	transfers.Lock()
+	defer transfers.Unlock()
+
+	t := transfers.byID[id]
+	if t == nil || t.output != output {
+		return nil
+	}
+	t.timer.Stop()
+	delete(transfers.byID, id)
+	transfers.bytes -= len(t.data)
+	return t
+}
+
+// beginUpload starts a source upload, for sources too large to pass as a
+// string: {success, uploadId}. uploadChunk appends to it, and an eval
+// given {uploadId} as source takes it. The option maxBytes lowers the cap
+// of maxTransferBytes on its size. An upload untouched for transferTTL is
+// dropped.
+func beginUpload(this js.Value, args []js.Value) interface{} {
+	limit := maxTransferBytes
+	if o := optionArg(args, 0); o.Type() == js.TypeObject {
+		if n := optionInt(o, "maxBytes"); n > 0 && n < limit {
+			limit = n
+		}
+	}
+
+	transfers.Lock()
+	defer transfers.Unlock()
+
+	return map[string]interface{}{
+		"success":  true,
+		"uploadId": addTransfer(&transfer{max: limit}),
+	}
+}
+
+// uploadChunk appends the bytes of the Uint8Array given as second argument
+// to the upload given as first: {success, bytes}, bytes being the size of
+// the upload so far. A chunk past the caps fails the upload, which is
+// dropped.
+func uploadChunk(this js.Value, args []js.Value) interface{} {
+	if len(args) != 2 || args[0].Type() != js.TypeNumber || !args[1].InstanceOf(js.Global().Get("Uint8Array")) {
+		return map[string]interface{}{
+			"success": false,
+			"error":   "uploadChunk requires an upload id and a Uint8Array",
+		}
+	}
+	id := args[0].Int()
+
+	transfers.Lock()
+	t := transfers.byID[id]
+	if t == nil || t.output {
+		transfers.Unlock()
+		return map[string]interface{}{
+			"success": false,
+			"error":   "upload not found",
+		}
+	}
+	n := args[1].Length()
+	var err error
+	switch {
+	case len(t.data)+n > t.max:
+		err = fmt.Errorf("upload larger than %d bytes", t.max)
+	case transfers.bytes+n > maxTransfersBytes:
+		err = errTransfersFull
+	}
+	if err == nil {
+		t.data = slices.Grow(t.data, n)
+		js.CopyBytesToGo(t.data[len(t.data):len(t.data)+n], args[1])
+		t.data = t.data[:len(t.data)+n]
+		transfers.bytes += n
+		t.timer.Reset(transferTTL)
+	}
+	size := len(t.data)
+	transfers.Unlock()
+
+	if err != nil {
+		takeTransfer(id, false)
+		return map[string]interface{}{
+			"success":   false,
+			"error":     err.Error(),
+			"errorCode": codeLimit,
+		}
+	}
+	return map[string]interface{}{
+		"success": true,
+		"bytes":   size,
+	}
+}
+
+// sourceArg returns the source given as argument v of an eval, decoded
+// with encoding: a string, or {uploadId} naming an upload, which it takes.
+func sourceArg(v js.Value, encoding string) (string, error) {
+	if v.Type() != js.TypeObject || v.Get("uploadId").Type() != js.TypeNumber {
+		return decodeSource(v.String(), encoding)
+	}
+
+	t := takeTransfer(v.Get("uploadId").Int(), false)
+	if t == nil {
+		return "", errors.New("upload not found")
+	}
+	return decodeSource(string(t.data), encoding)
+}
+
+// holdOutputs replaces in res the output and stderr larger than limit
+// bytes, the option outputHandleBytes, by an empty one, and their bytes by
+// outputHandle and stderrHandle to read them with readOutput, along with
+// their size as outputBytes and stderrBytes. Those past the caps stay in
+// res.
+func holdOutputs(res map[string]interface{}, output, stderr string, limit int) {
+	transfers.Lock()
+	defer transfers.Unlock()
+
+	for _, o := range []struct{ key, data string }{{"output", output}, {"stderr", stderr}} {
+		n := len(o.data)
+		if n <= limit || n > maxTransferBytes || transfers.bytes+n > maxTransfersBytes {
+			continue
+		}
+		if _, binary := res[o.key].(js.Value); binary {
+			res[o.key] = bytesToJS(nil)
+		} else {
+			res[o.key] = ""
+		}
+		res[o.key+"Handle"] = addTransfer(&transfer{data: []byte(o.data), output: true})
+		res[o.key+"Bytes"] = n
+	}
+}
+
+// readOutput returns up to length bytes of the held output given as first
+// argument, from offset: {success, data, bytes, eof}, data being a
+// Uint8Array and bytes the size of the output. The output stays held until
+// freeOutput, or transferTTL after the last read.
+func readOutput(this js.Value, args []js.Value) interface{} {
+	if len(args) != 3 || args[0].Type() != js.TypeNumber || args[1].Type() != js.TypeNumber || args[2].Type() != js.TypeNumber ||
+		args[1].Int() < 0 || args[2].Int() < 0 {
+		return map[string]interface{}{
+			"success": false,
+			"error":   "readOutput requires an output handle, an offset and a length",
+		}
+	}
+
+	transfers.Lock()
+	defer transfers.Unlock()
+
+	t := transfers.byID[args[0].Int()]
+	if t == nil || !t.output {
+		return map[string]interface{}{
+			"success": false,
+			"error":   "output not found",
+		}
+	}
+	t.timer.Reset(transferTTL)
+	start := min(args[1].Int(), len(t.data))
+	end := start + min(args[2].Int(), len(t.data)-start)
+	return map[string]interface{}{
+		"success": true,
+		"data":    bytesToJS(t.data[start:end]),
+		"bytes":   len(t.data),
+		"eof":     end == len(t.data),
+	}
+}
+
+// freeOutput drops the held output given as argument.
+func freeOutput(this js.Value, args []js.Value) interface{} {
+	if len(args) != 1 || args[0].Type() != js.TypeNumber {
+		return map[string]interface{}{
+			"success": false,
+			"error":   "freeOutput requires an output handle",
+		}
+	}
+	if takeTransfer(args[0].Int(), true) == nil {
+		return map[string]interface{}{
+			"success": false,
+			"error":   "output not found",
+		}
+	}
+	return map[string]interface{}{"success": true}
+}
@@ -0,0 +1,10 @@
+//go:build yaegi_tzdata
+
+package main
+
+// Embeds the timezone database, some 450kB, for time.LoadLocation.
+import _ "time/tzdata"
+
+func init() {
+	tzdataEmbedded = true
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"go/ast"
+	"go/token"
+)
+
+// unusedVars returns the variables declared in body that are never used,
+// in source order. Being assigned to is not a use. With top set, those
+// declared by the statements of body itself are left out.
+func unusedVars(body *ast.BlockStmt, top bool) []*ast.Ident {
+	skip := map[ast.Stmt]bool{}
+	if top {
+		for _, st := range body.List {
+			skip[st] = true
+		}
+	}
+
+	var declared []*ast.Ident
+	declare := func(st ast.Stmt, ids ...ast.Expr) {
+		if skip[st] {
+			return
+		}
+		for _, x := range ids {
+			if id, ok := x.(*ast.Ident); ok && id.Name != "_" && id.Obj != nil && id.Obj.Decl != nil {
+				if pos := id.Obj.Pos(); pos == id.Pos() {
+					declared = append(declared, id)
+				}
+			}
+		}
+	}
+	assigned := map[*ast.Ident]bool{}
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.AssignStmt:
+			switch n.Tok {
+			case token.DEFINE:
+				declare(n, n.Lhs...)
+			case token.ASSIGN:
+				for _, x := range n.Lhs {
+					if id, ok := x.(*ast.Ident); ok {
+						assigned[id] = true
+					}
+				}
+			}
+		case *ast.RangeStmt:
+			if n.Tok == token.DEFINE {
+				declare(n, n.Key, n.Value)
+			}
+		case *ast.DeclStmt:
+			if g := n.Decl.(*ast.GenDecl); g.Tok == token.VAR {
+				for _, spec := range g.Specs {
+					for _, id := range spec.(*ast.ValueSpec).Names {
+						declare(n, id)
+					}
+				}
+			}
+		}
+		return true
+	})
+
+	used := map[*ast.Object]bool{}
+	ast.Inspect(body, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok && id.Obj != nil && id.Obj.Pos() != id.Pos() && !assigned[id] {
+			used[id.Obj] = true
+		}
+		return true
+	})
+
+	var unused []*ast.Ident
+	for _, id := range declared {
+		if !used[id.Obj] {
+			unused = append(unused, id)
+		}
+	}
+	return unused
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"syscall/js"
+	"time"
+	"unicode"
+	"unicode/utf8"
+	"unsafe"
+)
+
+// maxBridgeBytes caps the bytes of a typed array or an ArrayBuffer copied
+// into Go, and of a []byte copied to JS, against copies of a buffer passed
+// by mistake.
+const maxBridgeBytes = 64 << 20
+
+// typedArrays are the JS typed arrays converted to Go slices, by the type
+// of their elements.
+var typedArrays = []struct {
+	name string
+	elem reflect.Type
+}{
+	{"Uint8Array", reflect.TypeOf(byte(0))},
+	{"Uint8ClampedArray", reflect.TypeOf(byte(0))},
+	{"Int8Array", reflect.TypeOf(int8(0))},
+	{"Int16Array", reflect.TypeOf(int16(0))},
+	{"Uint16Array", reflect.TypeOf(uint16(0))},
+	{"Int32Array", reflect.TypeOf(int32(0))},
+	{"Uint32Array", reflect.TypeOf(uint32(0))},
+	{"Float32Array", reflect.TypeOf(float32(0))},
+	{"Float64Array", reflect.TypeOf(float64(0))},
+	{"BigInt64Array", reflect.TypeOf(int64(0))},
+	{"BigUint64Array", reflect.TypeOf(uint64(0))},
+}
+
+// goValueToJS converts a value returned by the interpreter into a
+// representation accepted by js.ValueOf. Structs become objects of their
+// exported fields, maps and slices become objects and arrays, []byte a
+// Uint8Array, copied at once up to maxBridgeBytes, and time.Time an ISO
+// 8601 string. A function or channel is
+// null at the top level and a type-name placeholder inside another value,
+// and a value reached again through itself is rendered as "[Circular]".
+func goValueToJS(v reflect.Value) interface{} {
+	if v.IsValid() && (v.Kind() == reflect.Func || v.Kind() == reflect.Chan) {
+		return nil
+	}
+	return marshalJS(v, map[visit]bool{})
+}
+
+// visit identifies a reference value being converted, to detect cycles.
+type visit struct {
+	ptr uintptr
+	typ reflect.Type
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func marshalJS(v reflect.Value, seen map[visit]bool) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	if v.Type() == timeType {
+		return v.Interface().(time.Time).Format(time.RFC3339Nano)
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice:
+		if v.IsNil() {
+			return nil
+		}
+		key := visit{v.Pointer(), v.Type()}
+		if seen[key] {
+			return "[Circular]"
+		}
+		seen[key] = true
+		defer delete(seen, key)
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint()
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	case reflect.Complex64, reflect.Complex128:
+		return fmt.Sprint(v.Complex())
+	case reflect.String:
+		return v.String()
+	case reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return marshalJS(v.Elem(), seen)
+	case reflect.Ptr:
+		return marshalJS(v.Elem(), seen)
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+			if v.Len() > maxBridgeBytes {
+				return fmt.Sprintf("<%s of %d bytes>", v.Type(), v.Len())
+			}
+			return bytesToJS(v.Bytes())
+		}
+		items := make([]interface{}, v.Len())
+		for i := range items {
+			items[i] = marshalJS(v.Index(i), seen)
+		}
+		return items
+	case reflect.Map:
+		obj := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			obj[mapKeyString(iter.Key())] = marshalJS(iter.Value(), seen)
+		}
+		return obj
+	case reflect.Struct:
+		obj := make(map[string]interface{}, v.NumField())
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			field := t.Field(i)
+			if !exportedField(t, field) {
+				continue
+			}
+			obj[field.Name] = marshalJS(v.Field(i), seen)
+		}
+		return obj
+	}
+
+	// Channels, functions and unsafe pointers have no JS counterpart.
+	return "<" + v.Type().String() + ">"
+}
+
+// exportedField reports whether field of struct type t is exported in the
+// source. yaegi builds interpreted struct types with reflect.StructOf, which
+// cannot have unexported fields, so it exports them with an "X" prefix.
+func exportedField(t reflect.Type, field reflect.StructField) bool {
+	if field.PkgPath != "" {
+		return false
+	}
+	if t.Name() != "" || !strings.HasPrefix(field.Name, "X") || field.Name == "X" {
+		return true
+	}
+	r, _ := utf8.DecodeRuneInString(field.Name[1:])
+	return unicode.IsUpper(r)
+}
+
+// valueTypeName returns the Go type name of v, or nil when v is invalid.
+func valueTypeName(v reflect.Value) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	return v.Type().String()
+}
+
+// mapKeyString renders a map key as a JS object property name.
+func mapKeyString(k reflect.Value) string {
+	if k.Kind() == reflect.String {
+		return k.String()
+	}
+	return fmt.Sprint(k.Interface())
+}
+
+// jsToGo converts a JS value to its natural Go representation: nil, bool,
+// float64, string, []byte for a Uint8Array or an ArrayBuffer, the slice of
+// the element type for other typed arrays, as []float64 for a
+// Float64Array, []interface{} for an array and map[string]interface{} for
+// other objects. Functions, symbols and typed arrays past maxBridgeBytes
+// map to nil.
+func jsToGo(v js.Value) interface{} {
+	switch v.Type() {
+	case js.TypeBoolean:
+		return v.Bool()
+	case js.TypeNumber:
+		return v.Float()
+	case js.TypeString:
+		return v.String()
+	case js.TypeObject:
+		if b, ok, err := jsBinary(v); ok {
+			if err != nil {
+				return nil
+			}
+			return b.Interface()
+		}
+		if js.Global().Get("Array").Call("isArray", v).Bool() {
+			items := make([]interface{}, v.Length())
+			for i := range items {
+				items[i] = jsToGo(v.Index(i))
+			}
+			return items
+		}
+		keys := js.Global().Get("Object").Call("keys", v)
+		obj := make(map[string]interface{}, keys.Length())
+		for i := 0; i < keys.Length(); i++ {
+			k := keys.Index(i).String()
+			obj[k] = jsToGo(v.Get(k))
+		}
+		return obj
+	}
+	return nil
+}
+
+// jsToValue converts a JS value to a Go value of type t, failing when the
+// JS value has no natural conversion.
+func jsToValue(v js.Value, t reflect.Type) (reflect.Value, error) {
+	if t == reflect.TypeOf(js.Value{}) {
+		return reflect.ValueOf(v), nil
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		if v.Type() == js.TypeBoolean {
+			return reflect.ValueOf(v.Bool()).Convert(t), nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Type() == js.TypeNumber {
+			f := v.Float()
+			if f != math.Trunc(f) {
+				return reflect.Value{}, fmt.Errorf("cannot use %v as %s", f, t)
+			}
+			return reflect.ValueOf(f).Convert(t), nil
+		}
+	case reflect.Float32, reflect.Float64:
+		if v.Type() == js.TypeNumber {
+			return reflect.ValueOf(v.Float()).Convert(t), nil
+		}
+	case reflect.String:
+		if v.Type() == js.TypeString {
+			return reflect.ValueOf(v.String()).Convert(t), nil
+		}
+	case reflect.Interface:
+		if _, ok, err := jsBinary(v); ok && err != nil {
+			return reflect.Value{}, err
+		}
+		g := jsToGo(v)
+		if g == nil {
+			return reflect.Zero(t), nil
+		}
+		if rv := reflect.ValueOf(g); rv.Type().Implements(t) {
+			return rv, nil
+		}
+	case reflect.Ptr:
+		if v.IsNull() || v.IsUndefined() {
+			return reflect.Zero(t), nil
+		}
+		elem, err := jsToValue(v, t.Elem())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		p := reflect.New(t.Elem())
+		p.Elem().Set(elem)
+		return p, nil
+	case reflect.Slice:
+		if v.IsNull() || v.IsUndefined() {
+			return reflect.Zero(t), nil
+		}
+		if b, ok, err := jsBinary(v); ok {
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			if b.Type().Elem().Kind() == t.Elem().Kind() {
+				return b.Convert(t), nil
+			}
+			return reflect.Value{}, fmt.Errorf("cannot use JS %s as %s", v.Get("constructor").Get("name"), t)
+		}
+		if js.Global().Get("Array").Call("isArray", v).Bool() {
+			s := reflect.MakeSlice(t, v.Length(), v.Length())
+			for i := 0; i < v.Length(); i++ {
+				elem, err := jsToValue(v.Index(i), t.Elem())
+				if err != nil {
+					return reflect.Value{}, err
+				}
+				s.Index(i).Set(elem)
+			}
+			return s, nil
+		}
+	case reflect.Map:
+		if v.IsNull() || v.IsUndefined() {
+			return reflect.Zero(t), nil
+		}
+		if t.Key().Kind() == reflect.String && v.Type() == js.TypeObject {
+			keys := js.Global().Get("Object").Call("keys", v)
+			m := reflect.MakeMapWithSize(t, keys.Length())
+			for i := 0; i < keys.Length(); i++ {
+				k := keys.Index(i).String()
+				elem, err := jsToValue(v.Get(k), t.Elem())
+				if err != nil {
+					return reflect.Value{}, err
+				}
+				m.SetMapIndex(reflect.ValueOf(k).Convert(t.Key()), elem)
+			}
+			return m, nil
+		}
+	case reflect.Struct:
+		if v.Type() == js.TypeObject {
+			s := reflect.New(t).Elem()
+			for i := 0; i < t.NumField(); i++ {
+				field := t.Field(i)
+				if !exportedField(t, field) {
+					continue
+				}
+				fv := v.Get(field.Name)
+				if fv.IsUndefined() {
+					continue
+				}
+				elem, err := jsToValue(fv, field.Type)
+				if err != nil {
+					return reflect.Value{}, fmt.Errorf("field %s: %w", field.Name, err)
+				}
+				s.Field(i).Set(elem)
+			}
+			return s, nil
+		}
+	}
+	return reflect.Value{}, fmt.Errorf("cannot use JS %s as %s", v.Type(), t)
+}
+
+// jsBinary returns the contents of v, a typed array or an ArrayBuffer, as
+// a slice of its element type, copied at once, []byte for an ArrayBuffer.
+// ok is false if v is neither, and err set if it holds more than
+// maxBridgeBytes.
+func jsBinary(v js.Value) (s reflect.Value, ok bool, err error) {
+	if v.Type() != js.TypeObject {
+		return reflect.Value{}, false, nil
+	}
+	uint8Array := js.Global().Get("Uint8Array")
+	var view js.Value
+	var elem reflect.Type
+	if v.InstanceOf(js.Global().Get("ArrayBuffer")) {
+		view, elem = uint8Array.New(v), typedArrays[0].elem
+	} else {
+		for _, a := range typedArrays {
+			if c := js.Global().Get(a.name); c.Type() == js.TypeFunction && v.InstanceOf(c) {
+				view = uint8Array.New(v.Get("buffer"), v.Get("byteOffset"), v.Get("byteLength"))
+				elem = a.elem
+				break
+			}
+		}
+		if elem == nil {
+			return reflect.Value{}, false, nil
+		}
+	}
+
+	n := view.Length()
+	if n > maxBridgeBytes {
+		return reflect.Value{}, true, fmt.Errorf("%s of %d bytes exceeds the %d bytes passed to Go at most", v.Get("constructor").Get("name"), n, maxBridgeBytes)
+	}
+	count := n / int(elem.Size())
+	s = reflect.MakeSlice(reflect.SliceOf(elem), count, count)
+	if n > 0 {
+		// WebAssembly is little-endian, as typed arrays are on its hosts.
+		js.CopyBytesToGo(unsafe.Slice((*byte)(s.UnsafePointer()), n), view)
+	}
+	return s, true, nil
+}
+
+// bytesToJS copies b into a new Uint8Array.
+func bytesToJS(b []byte) js.Value {
+	arr := js.Global().Get("Uint8Array").New(len(b))
+	js.CopyBytesToJS(arr, b)
+	return arr
+}
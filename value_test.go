@@ -0,0 +1,113 @@
+package main
+
+import (
+	"syscall/js"
+	"testing"
+)
+
+func TestTypedArrayRoundTrip(t *testing.T) {
+	t.Cleanup(func() { callAPI(t, "reset") })
+	mustSucceed(t, callAPI(t, "eval", `package main
+
+func reverse(b []byte) []byte {
+	out := make([]byte, len(b))
+	for i, c := range b {
+		out[len(b)-1-i] = c
+	}
+	return out
+}
+
+func size(v interface{}) int { return len(v.([]byte)) }
+
+func sum(xs []float64) float64 {
+	s := 0.0
+	for _, x := range xs {
+		s += x
+	}
+	return s
+}
+
+func main() {}
+`))
+	uint8Array := js.Global().Get("Uint8Array")
+	bytesOf := func(v js.Value) []byte {
+		b := make([]byte, v.Get("length").Int())
+		js.CopyBytesToGo(b, v)
+		return b
+	}
+
+	for _, c := range []struct {
+		name string
+		arg  js.Value
+		want []byte
+	}{
+		{"Uint8Array", uint8Array.Call("of", 1, 2, 3), []byte{3, 2, 1}},
+		{"ArrayBuffer", uint8Array.Call("of", 4, 5).Get("buffer"), []byte{5, 4}},
+		{"empty", uint8Array.New(0), []byte{}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			res := callAPI(t, "call", "reverse", c.arg)
+			mustSucceed(t, res)
+			v := res.Get("value")
+			if !v.InstanceOf(uint8Array) {
+				t.Fatalf("reverse returned %s, want a Uint8Array", jsonString(v))
+			}
+			if got := bytesOf(v); string(got) != string(c.want) {
+				t.Errorf("reverse = %v, want %v", got, c.want)
+			}
+		})
+	}
+
+	big := make([]byte, 64<<10)
+	for i := range big {
+		big[i] = byte(i * 7)
+	}
+	arg := uint8Array.New(len(big))
+	js.CopyBytesToJS(arg, big)
+	res := callAPI(t, "call", "reverse", arg)
+	mustSucceed(t, res)
+	got := bytesOf(res.Get("value"))
+	for i := range big {
+		if got[len(got)-1-i] != big[i] {
+			t.Fatalf("reverse of 64KiB differs at byte %d", i)
+		}
+	}
+
+	if res := callAPI(t, "call", "size", uint8Array.New(5)); res.Get("value").Int() != 5 {
+		t.Errorf("size of a Uint8Array as interface{} = %s, want 5", jsonString(res))
+	}
+	floats := js.Global().Get("Float64Array").Call("of", 1.5, 2.5)
+	if res := callAPI(t, "call", "sum", floats); res.Get("value").Float() != 4 {
+		t.Errorf("sum of a Float64Array = %s, want 4", jsonString(res))
+	}
+	if res := callAPI(t, "call", "reverse", uint8Array.New(maxBridgeBytes+1)); res.Get("success").Truthy() {
+		t.Errorf("reverse of a buffer past maxBridgeBytes succeeded")
+	}
+}
+
+func TestResultValue(t *testing.T) {
+	t.Cleanup(func() { callAPI(t, "reset") })
+	snippet := map[string]interface{}{"mode": "snippet"}
+	for _, c := range []struct {
+		name      string
+		src       string
+		opts      map[string]interface{}
+		valueType interface{}
+		value     interface{}
+	}{
+		{"expression", "x := 2\nx * 3", nil, "int", "6"},
+		{"program", "package main\n\nfunc main() {}\n", nil, nil, nil},
+		{"snippet expression", "y := 2\ny * 3", snippet, "int", "6"},
+		{"snippet statement", "z := 2\n_ = z", snippet, nil, nil},
+		{"snippet echo off", "w := 2\nw * 3", map[string]interface{}{"mode": "snippet", "echo": "off"}, nil, nil},
+	} {
+		res := callAPI(t, "eval", c.src, c.opts)
+		mustSucceed(t, res)
+		if got, want := res.Get("valueType"), js.ValueOf(c.valueType); !got.Equal(want) {
+			t.Errorf("%s: valueType = %v, want %v", c.name, got, want)
+		}
+		if got, want := res.Get("valueString"), js.ValueOf(c.value); !got.Equal(want) {
+			t.Errorf("%s: valueString = %v, want %v", c.name, got, want)
+		}
+	}
+}
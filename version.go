@@ -0,0 +1,58 @@
+package main
+
+import (
+	"runtime"
+	"runtime/debug"
+	"syscall/js"
+)
+
+// Build details, used when the binary carries no module build info. They
+// can be set with -ldflags "-X main.yaegiVersion=v0.16.1 ...".
+var (
+	wrapperVersion = "v1.0"
+	yaegiVersion   = "unknown"
+	revision       = ""
+	buildTime      = ""
+)
+
+const yaegiModule = "github.com/traefik/yaegi"
+
+// getVersion describes the build: {wrapper, yaegi, go, revision,
+// buildTime}.
+func getVersion(this js.Value, args []js.Value) interface{} {
+	return versionInfo()
+}
+
+func versionInfo() map[string]interface{} {
+	v := map[string]interface{}{
+		"wrapper":   wrapperVersion,
+		"yaegi":     yaegiVersion,
+		"go":        runtime.Version(),
+		"revision":  revision,
+		"buildTime": buildTime,
+	}
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return v
+	}
+	v["go"] = info.GoVersion
+	for _, dep := range info.Deps {
+		if dep.Path != yaegiModule {
+			continue
+		}
+		v["yaegi"] = dep.Version
+		if dep.Replace != nil && dep.Replace.Version != "" {
+			v["yaegi"] = dep.Replace.Version
+		}
+	}
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			v["revision"] = setting.Value
+		case "vcs.time":
+			v["buildTime"] = setting.Value
+		}
+	}
+	return v
+}
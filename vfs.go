@@ -0,0 +1,287 @@
+package main
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"reflect"
+	"syscall/js"
+
+	"github.com/traefik/yaegi/interp"
+)
+
+// osSymbols returns the os functions of interpreted code that operate on
+// the filesystem m instead of the host one, once allow lets them: it
+// returns the error of an operation op on name, writing or not, that the
+// fs setting denies.
+func (m *memFS) osSymbols(allow func(op, name string, write bool) error) interp.Exports {
+	return interp.Exports{
+		"os/os": {
+			"Create": reflect.ValueOf(func(name string) (*vfsFile, error) {
+				if err := allow("open", name, true); err != nil {
+					return nil, err
+				}
+				return m.osCreate(name)
+			}),
+			"Open": reflect.ValueOf(func(name string) (*vfsFile, error) {
+				if err := allow("open", name, false); err != nil {
+					return nil, err
+				}
+				return m.osOpen(name)
+			}),
+			"ReadDir": reflect.ValueOf(func(name string) ([]os.DirEntry, error) {
+				if err := allow("open", name, false); err != nil {
+					return nil, err
+				}
+				return m.osReadDir(name)
+			}),
+			"ReadFile": reflect.ValueOf(func(name string) ([]byte, error) {
+				if err := allow("open", name, false); err != nil {
+					return nil, err
+				}
+				return m.osReadFile(name)
+			}),
+			"Remove": reflect.ValueOf(func(name string) error {
+				if err := allow("remove", name, true); err != nil {
+					return err
+				}
+				return m.osRemove(name)
+			}),
+			"Stat": reflect.ValueOf(func(name string) (os.FileInfo, error) {
+				if err := allow("stat", name, false); err != nil {
+					return nil, err
+				}
+				return m.osStat(name)
+			}),
+			"WriteFile": reflect.ValueOf(func(name string, data []byte, perm os.FileMode) error {
+				if err := allow("open", name, true); err != nil {
+					return err
+				}
+				return m.osWriteFile(name, data, perm)
+			}),
+		},
+	}
+}
+
+// fsAllows returns the error of osSymbols for an operation of interpreted
+// code that the fs setting denies, counting it in the usage of s.
+func (s *session) fsAllows(op, name string, write bool) error {
+	mode := fsMode()
+	if s.pure {
+		mode = "none"
+	}
+	switch mode {
+	case "virtual":
+		return nil
+	case "virtual-readonly":
+		if !write {
+			return nil
+		}
+	}
+	s.mu.Lock()
+	s.counters.fsDenied++
+	s.mu.Unlock()
+	return &fs.PathError{Op: op, Path: name, Err: fs.ErrPermission}
+}
+
+// vfsPath maps a path of interpreted code to a memFS path. The working
+// directory is the root, and paths cannot climb above it.
+func vfsPath(name string) string {
+	p := path.Clean("/" + name)[1:]
+	if p == "" {
+		return "."
+	}
+	return p
+}
+
+func (m *memFS) osReadFile(name string) ([]byte, error) {
+	if b, ok := m.readFile(vfsPath(name)); ok {
+		return b, nil
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+func (m *memFS) osWriteFile(name string, data []byte, perm os.FileMode) error {
+	p := vfsPath(name)
+	if p == "." {
+		return &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	m.writeFile(p, data)
+	return nil
+}
+
+func (m *memFS) osReadDir(name string) ([]os.DirEntry, error) {
+	entries, err := m.ReadDir(vfsPath(name))
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return entries, nil
+}
+
+func (m *memFS) osStat(name string) (os.FileInfo, error) {
+	fi, err := fs.Stat(m, vfsPath(name))
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return fi, nil
+}
+
+func (m *memFS) osRemove(name string) error {
+	if !m.remove(vfsPath(name)) {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	return nil
+}
+
+func (m *memFS) osOpen(name string) (*vfsFile, error) {
+	f, err := m.Open(vfsPath(name))
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return &vfsFile{fsys: m, name: name, file: f}, nil
+}
+
+func (m *memFS) osCreate(name string) (*vfsFile, error) {
+	if err := m.osWriteFile(name, nil, 0o666); err != nil {
+		return nil, err
+	}
+	return &vfsFile{fsys: m, name: name}, nil
+}
+
+// vfsFile stands for *os.File in interpreted code. It is opened either for
+// reading by os.Open or for writing by os.Create, and writes go straight to
+// the filesystem.
+type vfsFile struct {
+	fsys   *memFS
+	name   string
+	file   fs.File // nil when opened for writing
+	closed bool
+}
+
+func (f *vfsFile) Name() string { return f.name }
+
+func (f *vfsFile) check(op string, write bool) error {
+	switch {
+	case f.closed:
+		return &fs.PathError{Op: op, Path: f.name, Err: os.ErrClosed}
+	case write != (f.file == nil):
+		return &fs.PathError{Op: op, Path: f.name, Err: fs.ErrPermission}
+	}
+	return nil
+}
+
+func (f *vfsFile) Read(b []byte) (int, error) {
+	if err := f.check("read", false); err != nil {
+		return 0, err
+	}
+	return f.file.Read(b)
+}
+
+func (f *vfsFile) Seek(offset int64, whence int) (int64, error) {
+	if err := f.check("seek", false); err != nil {
+		return 0, err
+	}
+	s, ok := f.file.(io.Seeker)
+	if !ok {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+	return s.Seek(offset, whence)
+}
+
+func (f *vfsFile) ReadDir(n int) ([]os.DirEntry, error) {
+	if err := f.check("readdirent", false); err != nil {
+		return nil, err
+	}
+	d, ok := f.file.(fs.ReadDirFile)
+	if !ok {
+		return nil, &fs.PathError{Op: "readdirent", Path: f.name, Err: fs.ErrInvalid}
+	}
+	return d.ReadDir(n)
+}
+
+func (f *vfsFile) Write(b []byte) (int, error) {
+	if err := f.check("write", true); err != nil {
+		return 0, err
+	}
+	f.fsys.appendFile(vfsPath(f.name), b)
+	return len(b), nil
+}
+
+func (f *vfsFile) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
+
+func (f *vfsFile) Stat() (os.FileInfo, error) {
+	if f.closed {
+		return nil, &fs.PathError{Op: "stat", Path: f.name, Err: os.ErrClosed}
+	}
+	return f.fsys.osStat(f.name)
+}
+
+func (f *vfsFile) Close() error {
+	if f.closed {
+		return &fs.PathError{Op: "close", Path: f.name, Err: os.ErrClosed}
+	}
+	f.closed = true
+	return nil
+}
+
+// writeFile stores a string or Uint8Array in the filesystem of the default
+// session: yaegi.writeFile(path, data).
+func writeFile(this js.Value, args []js.Value) interface{} {
+	if len(args) != 2 || args[0].Type() != js.TypeString {
+		return map[string]interface{}{
+			"success": false,
+			"error":   "writeFile requires a path and a string or Uint8Array",
+		}
+	}
+
+	var data []byte
+	switch v := args[1]; {
+	case v.Type() == js.TypeString:
+		data = []byte(v.String())
+	case v.InstanceOf(js.Global().Get("Uint8Array")):
+		data = make([]byte, v.Length())
+		js.CopyBytesToGo(data, v)
+	default:
+		return map[string]interface{}{
+			"success": false,
+			"error":   "writeFile requires a path and a string or Uint8Array",
+		}
+	}
+
+	if err := defaultSession().files.osWriteFile(args[0].String(), data, 0o666); err != nil {
+		return map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+		}
+	}
+	return map[string]interface{}{"success": true}
+}
+
+// readFile returns the content of a file of the default session filesystem
+// as a Uint8Array.
+func readFile(this js.Value, args []js.Value) interface{} {
+	if len(args) != 1 || args[0].Type() != js.TypeString {
+		return map[string]interface{}{
+			"success": false,
+			"error":   "readFile requires a path",
+		}
+	}
+
+	b, err := defaultSession().files.osReadFile(args[0].String())
+	if err != nil {
+		return map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+		}
+	}
+	return bytesToJS(b)
+}
+
+// listFiles returns the paths of the files of the default session
+// filesystem, sorted.
+func listFiles(this js.Value, args []js.Value) interface{} {
+	return stringsToJS(defaultSession().files.list())
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// sourceWarnings returns as warning diagnostics, in source order, the
+// issues of src that a best-effort look at its syntax finds, each with a
+// code:
+//
+//   - "unused-import": an import not used, which go build rejects;
+//   - "unused-var": a variable declared and never used, which go build
+//     rejects as well, while yaegi compiles both;
+//   - "shadow": a variable with the name of one of an enclosing block of
+//     the same function, as go vet -shadow reports;
+//   - "blank-assign": a value assigned to _ that has no effect, see
+//     blankAssigns.
+//
+// Nothing is run or type-checked, so that the warnings come whether src
+// compiles or not. Unless program is set, src is evaluated incrementally:
+// its top-level statements and imports belong to the session, which may
+// use them later, so only the variables local to blocks and functions are
+// checked for use.
+func (s *session) sourceWarnings(src string, program bool) []interface{} {
+	f, err := parseFragment(src)
+	if err != nil {
+		return []interface{}{}
+	}
+	// A package clause of its own makes src a whole program.
+	program = program || f.head == 0
+
+	line := func(pos token.Pos) (int, int) {
+		o := f.offset(pos)
+		return strings.Count(src[:o], "\n") + 1, o - strings.LastIndex(src[:o], "\n")
+	}
+	var diags []diagnostic
+	warn := func(pos token.Pos, code, msg string) {
+		d := diagnostic{message: msg, severity: "warning", code: code}
+		d.line, d.column = line(pos)
+		diags = append(diags, d)
+	}
+
+	if program {
+		if fix, err := s.importFixes(src); err == nil {
+			for _, c := range fix.unused {
+				for _, spec := range f.file.Imports {
+					if p, _ := strconv.Unquote(spec.Path.Value); p == c.path {
+						warn(spec.Pos(), "unused-import", fmt.Sprintf("%q imported and not used", c.path))
+					}
+				}
+			}
+		}
+	}
+
+	for _, d := range f.file.Decls {
+		fn, ok := d.(*ast.FuncDecl)
+		if !ok || fn.Body == nil {
+			continue
+		}
+		// The statements wrapped by parseFragment are top level.
+		top := f.body >= 0 && fn.Name.Name == "_" && !program
+		for _, id := range unusedVars(fn.Body, top) {
+			warn(id.Pos(), "unused-var", "declared and not used: "+id.Name)
+		}
+		for _, sh := range shadowedVars(fn) {
+			outer, _ := line(sh.outer.Pos())
+			warn(sh.id.Pos(), "shadow", fmt.Sprintf("declaration of %s shadows the one at line %d", sh.id.Name, outer))
+		}
+		for _, a := range blankAssigns(fn.Body) {
+			msg := "value assigned to _ has no effect"
+			if _, ok := ast.Unparen(a.Rhs[0]).(*ast.FuncLit); ok {
+				msg = "function literal assigned to _ is never called"
+			}
+			warn(a.Pos(), "blank-assign", msg)
+		}
+	}
+
+	slices.SortStableFunc(diags, func(a, b diagnostic) int {
+		return cmp.Or(cmp.Compare(a.line, b.line), cmp.Compare(a.column, b.column))
+	})
+	warnings := make([]interface{}, len(diags))
+	for i, d := range diags {
+		warnings[i] = d.toJS()
+	}
+	return warnings
+}
+
+// shadowing is a variable declared with the name of an outer one.
+type shadowing struct {
+	id, outer *ast.Ident
+}
+
+// shadowedVars returns the variables declared in fn, its parameters and
+// those of the function literals in it included, that shadow a variable of
+// an enclosing block of fn. The copy of a variable into one of the same
+// name, x := x or switch x := x.(type), is idiomatic and left out.
+func shadowedVars(fn *ast.FuncDecl) []shadowing {
+	var found []shadowing
+	scopes := []map[string]*ast.Ident{{}}
+	declare := func(id *ast.Ident, copied bool) {
+		if id == nil || id.Name == "_" {
+			return
+		}
+		inner := scopes[len(scopes)-1]
+		if inner[id.Name] != nil {
+			// Assigned again by :=.
+			return
+		}
+		for i := len(scopes) - 2; i >= 0 && !copied; i-- {
+			if outer := scopes[i][id.Name]; outer != nil {
+				found = append(found, shadowing{id, outer})
+				break
+			}
+		}
+		inner[id.Name] = id
+	}
+	params := func(lists ...*ast.FieldList) {
+		for _, l := range lists {
+			if l == nil {
+				continue
+			}
+			for _, field := range l.List {
+				for _, id := range field.Names {
+					declare(id, false)
+				}
+			}
+		}
+	}
+	params(fn.Recv, fn.Type.Params, fn.Type.Results)
+
+	// Blocks open a scope, and so do the statements declaring variables
+	// for their body; the body of a function literal shares that of its
+	// parameters.
+	opens := func(n, parent ast.Node) bool {
+		switch n.(type) {
+		case *ast.FuncLit, *ast.IfStmt, *ast.ForStmt, *ast.RangeStmt, *ast.SwitchStmt,
+			*ast.TypeSwitchStmt, *ast.CaseClause, *ast.CommClause:
+			return true
+		case *ast.BlockStmt:
+			_, lit := parent.(*ast.FuncLit)
+			return parent != nil && !lit
+		}
+		return false
+	}
+	var stack []ast.Node
+	ast.Inspect(fn.Body, func(n ast.Node) bool {
+		if n == nil {
+			if opens(stack[len(stack)-1], parentNode(stack)) {
+				scopes = scopes[:len(scopes)-1]
+			}
+			stack = stack[:len(stack)-1]
+			return true
+		}
+		if opens(n, lastNode(stack)) {
+			scopes = append(scopes, map[string]*ast.Ident{})
+		}
+		stack = append(stack, n)
+
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			params(n.Type.Params, n.Type.Results)
+		case *ast.AssignStmt:
+			if n.Tok != token.DEFINE {
+				break
+			}
+			for i, x := range n.Lhs {
+				id, _ := x.(*ast.Ident)
+				copied := false
+				if id != nil && len(n.Rhs) == len(n.Lhs) {
+					copied = copiesName(n.Rhs[i], id.Name)
+				}
+				declare(id, copied)
+			}
+		case *ast.RangeStmt:
+			if n.Tok == token.DEFINE {
+				for _, x := range []ast.Expr{n.Key, n.Value} {
+					id, _ := x.(*ast.Ident)
+					declare(id, false)
+				}
+			}
+		case *ast.DeclStmt:
+			if g := n.Decl.(*ast.GenDecl); g.Tok == token.VAR {
+				for _, spec := range g.Specs {
+					for _, id := range spec.(*ast.ValueSpec).Names {
+						declare(id, false)
+					}
+				}
+			}
+		}
+		return true
+	})
+	return found
+}
+
+// lastNode returns the last node of stack, or nil.
+func lastNode(stack []ast.Node) ast.Node {
+	if len(stack) == 0 {
+		return nil
+	}
+	return stack[len(stack)-1]
+}
+
+// parentNode returns the node before the last of stack, or nil.
+func parentNode(stack []ast.Node) ast.Node {
+	return lastNode(stack[:len(stack)-1])
+}
+
+// copiesName reports whether x is the variable name, or its type switch.
+func copiesName(x ast.Expr, name string) bool {
+	if t, ok := x.(*ast.TypeAssertExpr); ok && t.Type == nil {
+		x = t.X
+	}
+	id, ok := x.(*ast.Ident)
+	return ok && id.Name == name
+}
+
+// blankAssigns returns the assignments in body to _ alone of a value that
+// has no effect and is not the idiom marking a name used: a literal, an
+// operation on names and literals such as _ = x == y, or a function
+// literal, which is then never called. Calls, receives, indexing (a bounds
+// check hint) and type assertions are left out.
+func blankAssigns(body *ast.BlockStmt) []*ast.AssignStmt {
+	var found []*ast.AssignStmt
+	ast.Inspect(body, func(n ast.Node) bool {
+		a, ok := n.(*ast.AssignStmt)
+		if !ok || a.Tok != token.ASSIGN || len(a.Lhs) != len(a.Rhs) {
+			return true
+		}
+		for _, x := range a.Lhs {
+			if id, ok := x.(*ast.Ident); !ok || id.Name != "_" {
+				return true
+			}
+		}
+		for _, x := range a.Rhs {
+			if !noEffect(x) {
+				return true
+			}
+		}
+		found = append(found, a)
+		return true
+	})
+	return found
+}
+
+// noEffect reports whether evaluating x has no effect, and x is more than
+// a name, see blankAssigns.
+func noEffect(x ast.Expr) bool {
+	switch x := ast.Unparen(x).(type) {
+	case *ast.FuncLit:
+		return true
+	case *ast.BasicLit, *ast.CompositeLit, *ast.BinaryExpr:
+	case *ast.UnaryExpr:
+		if x.Op == token.ARROW || x.Op == token.AND {
+			return false
+		}
+	default:
+		return false
+	}
+	pure := true
+	ast.Inspect(x, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.CallExpr, *ast.IndexExpr, *ast.IndexListExpr, *ast.SliceExpr, *ast.TypeAssertExpr, *ast.FuncLit:
+			pure = false
+		case *ast.UnaryExpr:
+			pure = pure && n.Op != token.ARROW
+		}
+		return pure
+	})
+	return pure
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"go/parser"
+	"go/token"
+	"reflect"
+	"sort"
+	"syscall/js"
+)
+
+// watch is an expression of addWatch.
+type watch struct {
+	id   int
+	expr string
+}
+
+// evalWatches evaluates the watches of s in its interpreter, after an eval
+// that succeeded, and returns them as the watches of its result: {id,
+// expr, value, valueType, valueString, error}, by id, error null or the
+// message of the expression failing, which fails nothing else. The caller
+// holds the eval of s. It returns nil for a session without watches.
+func (s *session) evalWatches(l renderLimits) []interface{} {
+	s.mu.Lock()
+	list := make([]watch, 0, len(s.watches))
+	for _, w := range s.watches {
+		list = append(list, *w)
+	}
+	s.mu.Unlock()
+	if len(list) == 0 {
+		return nil
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].id < list[j].id })
+
+	out := make([]interface{}, len(list))
+	for i, w := range list {
+		entry := map[string]interface{}{
+			"id":          w.id,
+			"expr":        w.expr,
+			"value":       nil,
+			"valueType":   nil,
+			"valueString": nil,
+			"error":       nil,
+		}
+		out[i] = entry
+		v, err := func() (v reflect.Value, err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("panic: %v", r)
+				}
+			}()
+			return s.interpreter.Eval(w.expr)
+		}()
+		if err = s.config.sandboxError(err); err != nil {
+			entry["error"] = err.Error()
+			if diags := errorDiagnostics(err, w.expr, ""); len(diags) == 1 {
+				entry["error"] = diags[0].(map[string]interface{})["message"]
+			}
+			continue
+		}
+		entry["value"] = goValueToJS(v)
+		entry["valueType"] = valueTypeName(v)
+		entry["valueString"] = valueString(v, l)
+	}
+	return out
+}
+
+// watchArg returns the default session, or the session of the option
+// session of args[1], and the id of the watch of it given as args[0], or
+// the result of the command name reporting either missing.
+func watchArg(name string, args []js.Value) (*session, int, map[string]interface{}) {
+	if len(args) < 1 || args[0].Type() != js.TypeNumber {
+		return nil, 0, map[string]interface{}{
+			"success":   false,
+			"error":     name + " requires a watch id",
+			"errorCode": codeBadRequest,
+		}
+	}
+	s, res := streamSession(optionArg(args, 1))
+	if res != nil {
+		return nil, 0, res
+	}
+	id := args[0].Int()
+	s.mu.Lock()
+	_, ok := s.watches[id]
+	s.mu.Unlock()
+	if !ok {
+		return nil, 0, map[string]interface{}{
+			"success": false,
+			"error":   fmt.Sprintf("watch %d not found", id),
+		}
+	}
+	return s, id, nil
+}
+
+// addWatch adds the Go expression given as argument to the watches of the
+// default session, or of the session of the option session, and returns
+// {success, id}: each eval of the session that succeeds then reports its
+// value in watches, see evalWatches, across resets. As for typeOf, an
+// expression calling functions or receiving from a channel is rejected,
+// conversions and the builtins without side effects aside.
+func addWatch(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 || args[0].Type() != js.TypeString {
+		return map[string]interface{}{
+			"success":   false,
+			"error":     "addWatch requires a Go expression",
+			"errorCode": codeBadRequest,
+		}
+	}
+	expr := args[0].String()
+	fset := token.NewFileSet()
+	x, err := parser.ParseExprFrom(fset, "", expr, 0)
+	if err != nil {
+		return map[string]interface{}{
+			"success":   false,
+			"error":     "not an expression: " + err.Error(),
+			"errorCode": codeParse,
+		}
+	}
+	if n := sideEffect(x); n != nil {
+		pos := fset.Position(n.Pos())
+		msg := "a watch may not call functions or receive from channels"
+		return map[string]interface{}{
+			"success":   false,
+			"error":     fmt.Sprintf("%d:%d: %s", pos.Line, pos.Column, msg),
+			"errorCode": codeBadRequest,
+			"diagnostics": []interface{}{
+				diagnostic{line: pos.Line, column: pos.Column, message: msg, severity: "error"}.toJS(),
+			},
+		}
+	}
+	s, res := streamSession(optionArg(args, 1))
+	if res != nil {
+		return res
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.watches == nil {
+		s.watches = map[int]*watch{}
+	}
+	s.nextWatch++
+	s.watches[s.nextWatch] = &watch{id: s.nextWatch, expr: expr}
+	return map[string]interface{}{
+		"success": true,
+		"id":      s.nextWatch,
+	}
+}
+
+// removeWatch removes the watch whose id is given as argument.
+func removeWatch(this js.Value, args []js.Value) interface{} {
+	s, id, res := watchArg("removeWatch", args)
+	if res != nil {
+		return res
+	}
+	s.mu.Lock()
+	delete(s.watches, id)
+	s.mu.Unlock()
+	return map[string]interface{}{"success": true}
+}
+
+// listWatches returns the watches of the default session, or of the
+// session of the option session, by id: [{id, expr}].
+func listWatches(this js.Value, args []js.Value) interface{} {
+	s, res := streamSession(optionArg(args, 0))
+	if res != nil {
+		return res
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := make([]int, 0, len(s.watches))
+	for id := range s.watches {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	list := make([]interface{}, len(ids))
+	for i, id := range ids {
+		list[i] = map[string]interface{}{"id": id, "expr": s.watches[id].expr}
+	}
+	return list
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"syscall/js"
+)
+
+// streamedOptions gives, for the commands writing output, the index of
+// their options argument, in which worker mode sets the stream callbacks.
+var streamedOptions = map[string]int{
+	"eval":      1,
+	"evalAsync": 1,
+	"evalFiles": 1,
+	"evalURL":   1,
+	"evalIn":    2,
+	"run":       1,
+}
+
+// workerMode reports whether the module talks to its host through
+// messages: it runs without a window but with postMessage, as in a Web
+// Worker, or the host set yaegiWorkerMode.
+func workerMode() bool {
+	global := js.Global()
+	if forced := global.Get("yaegiWorkerMode"); forced.Type() == js.TypeBoolean {
+		return forced.Bool()
+	}
+	return global.Get("window").IsUndefined() && global.Get("postMessage").Type() == js.TypeFunction
+}
+
+// serveMessages answers the messages {id, cmd, payload} posted to the
+// module. cmd names one of the commands and payload holds its arguments,
+// as an array or as a single value. The reply is {id, result}, or {id,
+// error} for a malformed message or a rejected promise. Output of the
+// eval commands also arrives as {id, event: "stdout" or "stderr", data}
+// messages while they run.
+func serveMessages() {
+	messageFunc = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		handleMessage(args[0].Get("data"))
+		return nil
+	})
+	js.Global().Call("addEventListener", "message", messageFunc)
+}
+
+func handleMessage(msg js.Value) {
+	if msg.Type() != js.TypeObject {
+		postReply(js.Null(), "error", "malformed message: expected {id, cmd, payload}")
+		return
+	}
+	id := msg.Get("id")
+	cmd := msg.Get("cmd")
+	if cmd.Type() != js.TypeString {
+		postReply(id, "error", "malformed message: cmd must be a string")
+		return
+	}
+	fn, ok := commands[cmd.String()]
+	if !ok {
+		postReply(id, "error", fmt.Sprintf("unknown command %q", cmd.String()))
+		return
+	}
+
+	var args []js.Value
+	switch payload := msg.Get("payload"); {
+	case payload.InstanceOf(js.Global().Get("Array")):
+		for i := 0; i < payload.Length(); i++ {
+			args = append(args, payload.Index(i))
+		}
+	case !payload.IsUndefined():
+		args = []js.Value{payload}
+	}
+
+	release := func() {}
+	if i, ok := streamedOptions[cmd.String()]; ok && len(args) > 0 {
+		args, release = withStreams(id, args, i)
+	}
+
+	result := fn(js.Undefined(), args)
+	promise, ok := result.(js.Value)
+	if !ok || promise.Type() != js.TypeObject || promise.Get("then").Type() != js.TypeFunction {
+		release()
+		postReply(id, "result", result)
+		return
+	}
+
+	// Wait for promises to settle.
+	var onResolve, onReject js.Func
+	settle := func() {
+		onResolve.Release()
+		onReject.Release()
+		release()
+	}
+	onResolve = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		settle()
+		postReply(id, "result", optionArg(args, 0))
+		return nil
+	})
+	onReject = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		settle()
+		reason := optionArg(args, 0)
+		// Errors are sent as their message and properties.
+		details := js.Global().Get("Object").Call("assign", map[string]interface{}{}, reason)
+		js.Global().Call("postMessage", map[string]interface{}{
+			"id":      id,
+			"error":   js.Global().Get("String").Invoke(reason.Get("message")),
+			"details": details,
+		})
+		return nil
+	})
+	promise.Call("then", onResolve, onReject)
+}
+
+// withStreams returns args with stream callbacks posting the output of
+// message id set in the options object args[i], and a function releasing
+// them.
+func withStreams(id js.Value, args []js.Value, i int) ([]js.Value, func()) {
+	for len(args) <= i {
+		args = append(args, js.Undefined())
+	}
+	opts := args[i]
+	if opts.Type() != js.TypeObject {
+		opts = js.Global().Get("Object").New()
+		args[i] = opts
+	}
+
+	var funcs []js.Func
+	for _, stream := range []string{"stdout", "stderr"} {
+		stream := stream
+		f := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+			js.Global().Call("postMessage", map[string]interface{}{
+				"id":    id,
+				"event": stream,
+				"data":  optionArg(args, 0),
+			})
+			return nil
+		})
+		funcs = append(funcs, f)
+		key := "onStdout"
+		if stream == "stderr" {
+			key = "onStderr"
+		}
+		opts.Set(key, f)
+	}
+	return args, func() {
+		for _, f := range funcs {
+			f.Release()
+		}
+	}
+}
+
+// postReply posts {id, key: value} to the host.
+func postReply(id js.Value, key string, value interface{}) {
+	js.Global().Call("postMessage", map[string]interface{}{
+		"id": id,
+		key:  value,
+	})
+}